@@ -70,9 +70,14 @@ type Call struct {
 	Name       token.Token
 	Parameters []*Parameter
 	Definition *ProcedureDefinition
+	// Type conversion only, ie. rawhtml("<b>Bold</b>")
+	TypeConversion TypeInfo
+	// Built-in procedure only, the return type. ie. darken(bg_color, 10%), upper(title)
+	Builtin TypeInfo
 	// HTMLNode only
 	HTMLDefinition *HTMLComponentDefinition // optional
 	IfExpression   Expression               // optional
+	ElseNodes      []Node                   // optional, ie. "div if isBlue { } else { }"
 	Base
 }
 
@@ -92,6 +97,12 @@ func (node *Call) Kind() CallKind {
 	return node.kind
 }
 
+// SetProcedureCall is used when a call that was parsed as an HTML node,
+// ie. formatPrice(amount = 5), turns out to be a procedure.
+func (node *Call) SetProcedureCall() {
+	node.kind = CallProcedure
+}
+
 //type Block struct {
 //	Base
 //}
@@ -103,14 +114,22 @@ type TypeInfo interface {
 
 type TypeIdent struct {
 	Name       token.Token
-	ArrayDepth int // [] = 1, [][] = 2, [][][] = 3
+	ArrayDepth int        // [] = 1, [][] = 2, [][][] = 3
+	MapValue   *TypeIdent // ie. "int" in "map[string]int", keys are always strings
+	Optional   bool       // ie. "?string", the value can be nil
 }
 
 func (node *TypeIdent) String() string {
 	result := ""
+	if node.Optional {
+		result += "?"
+	}
 	for i := 0; i < node.ArrayDepth; i++ {
 		result += "[]"
 	}
+	if node.MapValue != nil {
+		return result + "map[string]" + node.MapValue.String()
+	}
 	result += node.Name.String()
 	return result
 }
@@ -156,6 +175,58 @@ type ArrayLiteral struct {
 	Base
 }
 
+// MapLiteral is ie. map[string]string{"home": "icon-home"}
+type MapLiteral struct {
+	TypeInfo       TypeInfo
+	TypeIdentifier TypeIdent
+	Entries        []MapEntry
+}
+
+type MapEntry struct {
+	Key   Expression
+	Value Expression
+}
+
+func (node *MapLiteral) Nodes() []Node {
+	return nil
+}
+
+// Index is ie. icons["home"]
+type Index struct {
+	Bracket  token.Token
+	Value    Expression
+	Index    Expression
+	TypeInfo TypeInfo
+}
+
+func (node *Index) Nodes() []Node {
+	return nil
+}
+
+// Slice is ie. items[1:3], either side can be left out, ie. items[1:]
+type Slice struct {
+	Bracket  token.Token
+	Value    Expression
+	Low      Expression
+	High     Expression
+	TypeInfo TypeInfo
+}
+
+func (node *Slice) Nodes() []Node {
+	return nil
+}
+
+// Selector is a field on a value that isn't a variable, ie. items[i].title
+type Selector struct {
+	Value    Expression
+	Fields   []token.Token
+	TypeInfo TypeInfo
+}
+
+func (node *Selector) Nodes() []Node {
+	return nil
+}
+
 type StructLiteral struct {
 	Name     token.Token
 	Fields   []Parameter
@@ -220,6 +291,12 @@ type ArrayAppendStatement struct {
 	Expression
 }
 
+// IndexAssignStatement is ie. items[i] = "x"
+type IndexAssignStatement struct {
+	Index *Index
+	Expression
+}
+
 type DeclareStatement struct {
 	Name token.Token
 	Expression
@@ -227,6 +304,7 @@ type DeclareStatement struct {
 
 type Token struct {
 	token.Token
+	TypeInfo TypeInfo // determined at typecheck time, the operand type for operators and numbers (2018-05-08)
 }
 
 func (node *Token) Nodes() []Node {
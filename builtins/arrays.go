@@ -0,0 +1,112 @@
+package builtins
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/silbinarywolf/compiler-fel/data"
+)
+
+// Arrays are stored as []string, []int64, []float64 or []*data.Struct
+// in the VM, so each is handled separately.
+
+func length(args []interface{}) (interface{}, error) {
+	switch items := args[0].(type) {
+	case []string:
+		return int64(len(items)), nil
+	case []int64:
+		return int64(len(items)), nil
+	case []float64:
+		return int64(len(items)), nil
+	case []*data.Struct:
+		return int64(len(items)), nil
+	}
+	return nil, unhandledArray("len", args[0])
+}
+
+func first(args []interface{}) (interface{}, error) {
+	return element("first", args[0], 0)
+}
+
+func last(args []interface{}) (interface{}, error) {
+	items, err := length([]interface{}{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	return element("last", args[0], int(items.(int64))-1)
+}
+
+func element(name string, array interface{}, index int) (interface{}, error) {
+	items, err := length([]interface{}{array})
+	if err != nil {
+		return nil, err
+	}
+	if items.(int64) == 0 {
+		return nil, fmt.Errorf("Cannot call %s() on an empty array.", name)
+	}
+	switch array := array.(type) {
+	case []string:
+		return array[index], nil
+	case []int64:
+		return array[index], nil
+	case []float64:
+		return array[index], nil
+	case []*data.Struct:
+		return array[index], nil
+	}
+	return nil, unhandledArray(name, array)
+}
+
+// reverse returns a reversed copy, the array passed in is unchanged.
+func reverse(args []interface{}) (interface{}, error) {
+	switch items := args[0].(type) {
+	case []string:
+		result := make([]string, len(items))
+		for i, item := range items {
+			result[len(items)-1-i] = item
+		}
+		return result, nil
+	case []int64:
+		result := make([]int64, len(items))
+		for i, item := range items {
+			result[len(items)-1-i] = item
+		}
+		return result, nil
+	case []float64:
+		result := make([]float64, len(items))
+		for i, item := range items {
+			result[len(items)-1-i] = item
+		}
+		return result, nil
+	case []*data.Struct:
+		result := make([]*data.Struct, len(items))
+		for i, item := range items {
+			result[len(items)-1-i] = item
+		}
+		return result, nil
+	}
+	return nil, unhandledArray("reverse", args[0])
+}
+
+// sortArray returns a sorted copy, the array passed in is unchanged.
+func sortArray(args []interface{}) (interface{}, error) {
+	switch items := args[0].(type) {
+	case []string:
+		result := append([]string(nil), items...)
+		sort.Strings(result)
+		return result, nil
+	case []int64:
+		result := append([]int64(nil), items...)
+		sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+		return result, nil
+	case []float64:
+		result := append([]float64(nil), items...)
+		sort.Float64s(result)
+		return result, nil
+	}
+	return nil, unhandledArray("sort", args[0])
+}
+
+func unhandledArray(name string, value interface{}) error {
+	return fmt.Errorf("Cannot call %s() with %T. This should be caught in the typechecker.", name, value)
+}
@@ -0,0 +1,108 @@
+package builtins
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Procedure is a procedure implemented in Go that can be called
+// without being declared, ie. upper(title)
+type Procedure struct {
+	Name       string
+	Parameters []Parameter
+	// Returns is the type name of the result, see Parameter.Type
+	Returns string
+	call    func(args []interface{}) (interface{}, error)
+}
+
+// Parameter has a type name, ie. "string" or "[]string". Single letter
+// names are type parameters, the first value they match decides their
+// type for the rest of the call.
+// - "T" is any type
+// - "N" is int or float
+// - "S" is int, float or string, ie. anything that can be sorted
+type Parameter struct {
+	Name string
+	Type string
+}
+
+var procedures = newProcedureMap([]*Procedure{
+	// Strings
+	{Name: "upper", Parameters: []Parameter{{"text", "string"}}, Returns: "string", call: upper},
+	{Name: "lower", Parameters: []Parameter{{"text", "string"}}, Returns: "string", call: lower},
+	{Name: "trim", Parameters: []Parameter{{"text", "string"}}, Returns: "string", call: trim},
+	{Name: "replace", Parameters: []Parameter{{"text", "string"}, {"old", "string"}, {"new", "string"}}, Returns: "string", call: replace},
+	{Name: "split", Parameters: []Parameter{{"text", "string"}, {"separator", "string"}}, Returns: "[]string", call: split},
+	{Name: "join", Parameters: []Parameter{{"items", "[]string"}, {"separator", "string"}}, Returns: "string", call: join},
+	{Name: "contains", Parameters: []Parameter{{"text", "string"}, {"substring", "string"}}, Returns: "bool", call: contains},
+	{Name: "slug", Parameters: []Parameter{{"text", "string"}}, Returns: "string", call: slug},
+
+	// Arrays
+	{Name: "len", Parameters: []Parameter{{"items", "[]T"}}, Returns: "int", call: length},
+	{Name: "first", Parameters: []Parameter{{"items", "[]T"}}, Returns: "T", call: first},
+	{Name: "last", Parameters: []Parameter{{"items", "[]T"}}, Returns: "T", call: last},
+	{Name: "reverse", Parameters: []Parameter{{"items", "[]T"}}, Returns: "[]T", call: reverse},
+	{Name: "sort", Parameters: []Parameter{{"items", "[]S"}}, Returns: "[]S", call: sortArray},
+
+	// Math
+	{Name: "min", Parameters: []Parameter{{"a", "N"}, {"b", "N"}}, Returns: "N", call: minimum},
+	{Name: "max", Parameters: []Parameter{{"a", "N"}, {"b", "N"}}, Returns: "N", call: maximum},
+	{Name: "round", Parameters: []Parameter{{"value", "float"}}, Returns: "int", call: round},
+	{Name: "floor", Parameters: []Parameter{{"value", "float"}}, Returns: "int", call: floor},
+})
+
+func newProcedureMap(list []*Procedure) map[string]*Procedure {
+	result := make(map[string]*Procedure, len(list))
+	for _, procedure := range list {
+		if _, ok := result[procedure.Name]; ok {
+			panic(fmt.Sprintf("Already registered \"%s\" built-in.", procedure.Name))
+		}
+		result[procedure.Name] = procedure
+	}
+	return result
+}
+
+// Lookup gets a built-in procedure by name
+func Lookup(name string) (*Procedure, bool) {
+	procedure, ok := procedures[name]
+	return procedure, ok
+}
+
+// All gets every built-in procedure, sorted by name
+func All() []*Procedure {
+	result := make([]*Procedure, 0, len(procedures))
+	for _, procedure := range procedures {
+		result = append(result, procedure)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// IsTypeParameter is true for type names that match more than one type, ie. "T"
+func IsTypeParameter(typeName string) bool {
+	switch typeName {
+	case "T", "N", "S":
+		return true
+	}
+	return false
+}
+
+// Call runs the procedure, the arguments must match the parameter types
+func (procedure *Procedure) Call(args []interface{}) (interface{}, error) {
+	if len(args) != len(procedure.Parameters) {
+		return nil, fmt.Errorf("Expected %d parameters for %s(), instead got %d. This should be caught in the typechecker.", len(procedure.Parameters), procedure.Name, len(args))
+	}
+	return procedure.call(args)
+}
+
+// String gets the signature, ie. "upper(text string) string"
+func (procedure *Procedure) String() string {
+	parameters := make([]string, 0, len(procedure.Parameters))
+	for _, parameter := range procedure.Parameters {
+		parameters = append(parameters, parameter.Name+" "+parameter.Type)
+	}
+	return fmt.Sprintf("%s(%s) %s", procedure.Name, strings.Join(parameters, ", "), procedure.Returns)
+}
@@ -0,0 +1,44 @@
+package builtins
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCall(t *testing.T) {
+	items := []int64{3, 1, 2}
+	tests := []struct {
+		name     string
+		args     []interface{}
+		expected interface{}
+	}{
+		{"slug", []interface{}{"  Hello, World! 2018 "}, "hello-world-2018"},
+		{"split", []interface{}{"", ","}, []string{}},
+		{"sort", []interface{}{items}, []int64{1, 2, 3}},
+		{"reverse", []interface{}{items}, []int64{2, 1, 3}},
+		{"last", []interface{}{[]string{"a", "b"}}, "b"},
+		{"min", []interface{}{int64(2), int64(-1)}, int64(-1)},
+		{"round", []interface{}{-2.5}, int64(-3)},
+		{"floor", []interface{}{-2.5}, int64(-3)},
+	}
+	for _, test := range tests {
+		procedure, ok := Lookup(test.name)
+		if !ok {
+			t.Fatalf("Cannot find built-in \"%s\".", test.name)
+		}
+		result, err := procedure.Call(test.args)
+		if err != nil {
+			t.Errorf("%s(): %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s(): Expected %#v, instead got %#v", test.name, test.expected, result)
+		}
+	}
+	if !reflect.DeepEqual(items, []int64{3, 1, 2}) {
+		t.Errorf("Expected sort() and reverse() to not modify the array, instead got %v", items)
+	}
+	if _, err := procedures["first"].Call([]interface{}{[]string{}}); err == nil {
+		t.Errorf("Expected an error calling first() on an empty array.")
+	}
+}
@@ -0,0 +1,45 @@
+package builtins
+
+import (
+	"fmt"
+	"math"
+)
+
+func minimum(args []interface{}) (interface{}, error) {
+	switch a := args[0].(type) {
+	case int64:
+		if b := args[1].(int64); b < a {
+			return b, nil
+		}
+		return a, nil
+	case float64:
+		return math.Min(a, args[1].(float64)), nil
+	}
+	return nil, unhandledNumber("min", args[0])
+}
+
+func maximum(args []interface{}) (interface{}, error) {
+	switch a := args[0].(type) {
+	case int64:
+		if b := args[1].(int64); b > a {
+			return b, nil
+		}
+		return a, nil
+	case float64:
+		return math.Max(a, args[1].(float64)), nil
+	}
+	return nil, unhandledNumber("max", args[0])
+}
+
+// round rounds half away from zero, ie. round(2.5) is 3
+func round(args []interface{}) (interface{}, error) {
+	return int64(math.Round(args[0].(float64))), nil
+}
+
+func floor(args []interface{}) (interface{}, error) {
+	return int64(math.Floor(args[0].(float64))), nil
+}
+
+func unhandledNumber(name string, value interface{}) error {
+	return fmt.Errorf("Cannot call %s() with %T. This should be caught in the typechecker.", name, value)
+}
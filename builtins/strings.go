@@ -0,0 +1,59 @@
+package builtins
+
+import (
+	"strings"
+	"unicode"
+)
+
+func upper(args []interface{}) (interface{}, error) {
+	return strings.ToUpper(args[0].(string)), nil
+}
+
+func lower(args []interface{}) (interface{}, error) {
+	return strings.ToLower(args[0].(string)), nil
+}
+
+func trim(args []interface{}) (interface{}, error) {
+	return strings.TrimSpace(args[0].(string)), nil
+}
+
+func replace(args []interface{}) (interface{}, error) {
+	return strings.Replace(args[0].(string), args[1].(string), args[2].(string), -1), nil
+}
+
+func split(args []interface{}) (interface{}, error) {
+	text := args[0].(string)
+	if text == "" {
+		// Otherwise strings.Split gives []string{""}
+		return []string{}, nil
+	}
+	return strings.Split(text, args[1].(string)), nil
+}
+
+func join(args []interface{}) (interface{}, error) {
+	return strings.Join(args[0].([]string), args[1].(string)), nil
+}
+
+func contains(args []interface{}) (interface{}, error) {
+	return strings.Contains(args[0].(string), args[1].(string)), nil
+}
+
+// slug makes text safe to use in a URL or id, ie. "Hello, World!" becomes "hello-world"
+func slug(args []interface{}) (interface{}, error) {
+	text := args[0].(string)
+	var result strings.Builder
+	result.Grow(len(text))
+	needsSeparator := false
+	for _, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			needsSeparator = result.Len() > 0
+			continue
+		}
+		if needsSeparator {
+			result.WriteByte('-')
+			needsSeparator = false
+		}
+		result.WriteRune(unicode.ToLower(r))
+	}
+	return result.String(), nil
+}
@@ -2,6 +2,9 @@ package bytecode
 
 import (
 	"fmt"
+	"sort"
+
+	"github.com/silbinarywolf/compiler-fel/token"
 )
 
 type Kind int
@@ -17,9 +20,15 @@ const (
 	AppendPopHTMLNodeReturn
 	StoreInternalStructField
 	AppendPopArrayString
+	AppendPopArrayInt
+	AppendPopArrayFloat
+	AppendPopArrayStruct
 	AppendPopHTMLElementToHTMLElement
 	AppendCSSPropertyToCSSRule
 	CastToHTMLText
+	CastToRawHTML
+	CastToCSSString
+	ReplaceCSSClassNames
 	Push
 
 	// Array Structures
@@ -29,12 +38,24 @@ const (
 	PushAllocArrayStruct
 	PushAllocHTMLFragment
 
+	// Map Structures
+	PushAllocMap
+	StorePopMapElement // ie. m["key"] = value, in a map literal
+	PushMapElement     // ie. m["key"], or the default value if it's not set
+	PushMapKey         // the key at an index, for iterating over maps
+
 	// CSS Structure
 	PushAllocCSSDefinition
 	//PushAllocCSSSelector
 	//PushAllocCSSSelectorPart
 	PushAllocCSSRule
 
+	// Array Iteration
+	PushArrayLength
+	PushArrayElement
+	PushArraySlice       // ie. items[1:3], Value is true if the high index is set
+	StorePopArrayElement // ie. items[i] = value
+
 	PushStackVar
 	PushStructFieldVar
 	PushReturnHTMLNodeArray
@@ -43,12 +64,40 @@ const (
 	PushAllocInternalStruct
 	PushAllocHTMLNode
 	ConditionalEqual
+	ConditionalNotEqual
+	LessThan
+	GreaterThan
+	LessThanOrEqual
+	GreaterThanOrEqual
+	Not
 	Add
+	Subtract
+	Multiply
+	Divide
+	Modulo
+	Negate
+	AddFloat
+	SubtractFloat
+	MultiplyFloat
+	DivideFloat
+	ModuloFloat
+	NegateFloat
 	AddString
+
+	// CSS Values
+	CSSAdd
+	CSSSubtract
+	CSSMultiply
+	CSSDivide
+	CallCSSBuiltin
+
 	Jump
 	JumpIfFalse
+	JumpIfFalseOrPop // ie. "&&", keeps false on the stack if jumping
+	JumpIfTrueOrPop  // ie. "||", keeps true on the stack if jumping
 	Call
 	CallHTML
+	CallNative // ie. upper(title), a procedure from the builtins package
 	Return
 )
 
@@ -70,9 +119,15 @@ var kindToString = []string{
 	AppendPopHTMLNodeReturn:           "AppendPopHTMLNodeReturn",
 	StoreInternalStructField:          "StoreInternalStructField",
 	AppendPopArrayString:              "AppendPopArrayString",
+	AppendPopArrayInt:                 "AppendPopArrayInt",
+	AppendPopArrayFloat:               "AppendPopArrayFloat",
+	AppendPopArrayStruct:              "AppendPopArrayStruct",
 	AppendPopHTMLElementToHTMLElement: "AppendPopHTMLElementToHTMLElement",
 	AppendCSSPropertyToCSSRule:        "AppendCSSPropertyToCSSRule",
 	CastToHTMLText:                    "CastToHTMLText",
+	CastToRawHTML:                     "CastToRawHTML",
+	CastToCSSString:                   "CastToCSSString",
+	ReplaceCSSClassNames:              "ReplaceCSSClassNames",
 	Push:                              "Push",
 	// Array Structures
 	PushAllocArrayString:  "PushAllocArrayString",
@@ -80,9 +135,19 @@ var kindToString = []string{
 	PushAllocArrayFloat:   "PushAllocArrayFloat",
 	PushAllocArrayStruct:  "PushAllocArrayStruct",
 	PushAllocHTMLFragment: "PushAllocHTMLFragment",
+	// Map Structures
+	PushAllocMap:       "PushAllocMap",
+	StorePopMapElement: "StorePopMapElement",
+	PushMapElement:     "PushMapElement",
+	PushMapKey:         "PushMapKey",
 	// CSS Structures
 	PushAllocCSSDefinition:  "PushAllocCSSDefinition",
 	PushAllocCSSRule:        "PushAllocCSSRule",
+	// Array Iteration
+	PushArrayLength:         "PushArrayLength",
+	PushArrayElement:        "PushArrayElement",
+	PushArraySlice:          "PushArraySlice",
+	StorePopArrayElement:    "StorePopArrayElement",
 	PushReturnHTMLNodeArray: "PushReturnHTMLNodeArray",
 	PushStackVar:            "PushStackVar",
 	PushStructFieldVar:      "PushStructFieldVar",
@@ -91,12 +156,38 @@ var kindToString = []string{
 	PushAllocInternalStruct: "PushAllocInternalStruct",
 	PushAllocHTMLNode:       "PushAllocHTMLNode",
 	ConditionalEqual:        "ConditionalEqual",
+	ConditionalNotEqual:     "ConditionalNotEqual",
+	LessThan:                "LessThan",
+	GreaterThan:             "GreaterThan",
+	LessThanOrEqual:         "LessThanOrEqual",
+	GreaterThanOrEqual:      "GreaterThanOrEqual",
+	Not:                     "Not",
 	Add:                     "Add",
+	Subtract:                "Subtract",
+	Multiply:                "Multiply",
+	Divide:                  "Divide",
+	Modulo:                  "Modulo",
+	Negate:                  "Negate",
+	AddFloat:                "AddFloat",
+	SubtractFloat:           "SubtractFloat",
+	MultiplyFloat:           "MultiplyFloat",
+	DivideFloat:             "DivideFloat",
+	ModuloFloat:             "ModuloFloat",
+	NegateFloat:             "NegateFloat",
 	AddString:               "AddString",
+	// CSS Values
+	CSSAdd:                  "CSSAdd",
+	CSSSubtract:             "CSSSubtract",
+	CSSMultiply:             "CSSMultiply",
+	CSSDivide:               "CSSDivide",
+	CallCSSBuiltin:          "CallCSSBuiltin",
 	Jump:                    "Jump",
 	JumpIfFalse:             "JumpIfFalse",
+	JumpIfFalseOrPop:        "JumpIfFalseOrPop",
+	JumpIfTrueOrPop:         "JumpIfTrueOrPop",
 	Call:                    "Call",
 	CallHTML:                "CallHTML",
+	CallNative:              "CallNative",
 	Return:                  "Return",
 }
 
@@ -142,10 +233,31 @@ type Block struct {
 	Opcodes        []Code
 	StackSize      int
 	HasReturnValue bool
+	Token          token.Token // where the block was declared, ie. the component name
+	Positions      []Position  // line table, sorted by offset
+}
+
+// Position is where the opcodes from Offset onwards came from in the source
+type Position struct {
+	Offset int
+	Token  token.Token
 }
 
 func (block *Block) Name() string { return block.name }
 
+// TokenAt gets the source position of the opcode at offset, falling back
+// to where the block was declared.
+func (block *Block) TokenAt(offset int) token.Token {
+	// Find the last position that starts at or before offset
+	i := sort.Search(len(block.Positions), func(i int) bool {
+		return block.Positions[i].Offset > offset
+	})
+	if i == 0 {
+		return block.Token
+	}
+	return block.Positions[i-1].Token
+}
+
 func NewBlock(name string, kind BlockKind) *Block {
 	block := new(Block)
 	block.name = name
@@ -164,16 +276,3 @@ func NewUnresolvedBlock(name string, kind BlockKind) *Block {
 func (block *Block) Kind() BlockKind {
 	return block.kind
 }
-
-/*func (block *Block) DebugOpcodes(offset int) {
-	fmt.Printf("Opcode Debug:\n-----------\n")
-	for i, code := range opcodes {
-		if offset == i {
-			fmt.Printf("**%d** - %s\n", i, code.String())
-			continue
-		}
-		fmt.Printf("%d - %s\n", i, code.String())
-	}
-	fmt.Printf("-----------\n")
-}
-*/
@@ -0,0 +1,151 @@
+package bytecode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var blockKindToString = map[BlockKind]string{
+	BlockDefault:                 "block",
+	BlockUnresolved:              "unresolved",
+	BlockTemplate:                "template",
+	BlockProcedure:               "procedure",
+	BlockHTMLComponentDefinition: "component",
+	BlockWorkspaceDefinition:     "workspace",
+	BlockCSSDefinition:           "css",
+}
+
+func (kind BlockKind) String() string {
+	return blockKindToString[kind]
+}
+
+// Disassemble writes a readable listing of the blocks, followed by
+// every block they call, ie.
+//
+//	component "Header" (includes/Header.fel:3, stack size: 3)
+//	0000  Label          "htmldefinition:Header"  ; line 3
+//	0001  JumpIfFalse    L0
+//	0002  Push           "active"                 ; line 5
+//	L0:
+//	0003  Return
+func Disassemble(w io.Writer, blocks ...*Block) error {
+	var buffer bytes.Buffer
+	seen := make(map[*Block]bool)
+	queue := append([]*Block(nil), blocks...)
+	for len(queue) > 0 {
+		block := queue[0]
+		queue = queue[1:]
+		if seen[block] {
+			continue
+		}
+		seen[block] = true
+		if buffer.Len() > 0 {
+			buffer.WriteByte('\n')
+		}
+		queue = append(queue, disassembleBlock(&buffer, block)...)
+	}
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+// disassembleBlock writes a single block and returns the blocks it calls
+func disassembleBlock(buffer *bytes.Buffer, block *Block) []*Block {
+	fmt.Fprintf(buffer, "%s \"%s\"", block.Kind(), block.Name())
+	if block.isUnresolved {
+		buffer.WriteString(" (unresolved)\n")
+		return nil
+	}
+	buffer.WriteString(" (")
+	if t := block.Token; t.Filepath != "" {
+		fmt.Fprintf(buffer, "%s:%d, ", t.Filepath, t.Line)
+	}
+	fmt.Fprintf(buffer, "stack size: %d", block.StackSize)
+	if block.HasReturnValue {
+		buffer.WriteString(", returns value")
+	}
+	buffer.WriteString(")\n")
+
+	// Name jump targets in the order they appear
+	labels := make(map[int]string)
+	{
+		var targets []int
+		for _, code := range block.Opcodes {
+			if offset, ok := jumpTarget(code); ok {
+				if _, ok := labels[offset]; !ok {
+					labels[offset] = ""
+					targets = append(targets, offset)
+				}
+			}
+		}
+		sort.Ints(targets)
+		for i, offset := range targets {
+			labels[offset] = "L" + strconv.Itoa(i)
+		}
+	}
+
+	var callees []*Block
+	lastPosition := ""
+	for offset, code := range block.Opcodes {
+		if label, ok := labels[offset]; ok {
+			fmt.Fprintf(buffer, "%s:\n", label)
+		}
+		operand := ""
+		if target, ok := jumpTarget(code); ok {
+			operand = labels[target]
+		} else {
+			operand = formatOperand(code.Value)
+		}
+		if callee, ok := code.Value.(*Block); ok {
+			callees = append(callees, callee)
+		}
+		line := fmt.Sprintf("%04d  %-34s %s", offset, code.Kind.String(), operand)
+		if t := block.TokenAt(offset); t.Filepath != "" {
+			position := fmt.Sprintf("line %d", t.Line)
+			if t.Filepath != block.Token.Filepath {
+				position = fmt.Sprintf("%s:%d", t.Filepath, t.Line)
+			}
+			if position != lastPosition {
+				line = fmt.Sprintf("%-80s ; %s", line, position)
+				lastPosition = position
+			}
+		}
+		buffer.WriteString(strings.TrimRight(line, " "))
+		buffer.WriteByte('\n')
+	}
+	// Jumps to the end of the block
+	if label, ok := labels[len(block.Opcodes)]; ok {
+		fmt.Fprintf(buffer, "%s:\n", label)
+	}
+	return callees
+}
+
+func jumpTarget(code Code) (int, bool) {
+	switch code.Kind {
+	case Jump, JumpIfFalse, JumpIfFalseOrPop, JumpIfTrueOrPop:
+		offset, ok := code.Value.(int)
+		return offset, ok
+	}
+	return 0, false
+}
+
+func formatOperand(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strconv.Quote(value)
+	case *Block:
+		return strconv.Quote(value.Name())
+	case fmt.Stringer:
+		return value.String()
+	case int, int64, float64, bool, map[string]string:
+		return fmt.Sprintf("%v", value)
+	}
+	// Pointers to runtime data (ie. *data.CSSRule) print their
+	// address with %v, so just show the type.
+	return fmt.Sprintf("(%T)", value)
+}
@@ -0,0 +1,45 @@
+package bytecode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/silbinarywolf/compiler-fel/token"
+)
+
+func TestDisassemble(t *testing.T) {
+	callee := NewBlock("Link", BlockHTMLComponentDefinition)
+	callee.Opcodes = []Code{
+		{Kind: Return},
+	}
+	block := NewBlock("Header", BlockHTMLComponentDefinition)
+	block.Token = token.Token{Filepath: "Header.fel", Line: 3}
+	block.StackSize = 1
+	block.Positions = []Position{
+		{Offset: 2, Token: token.Token{Filepath: "Header.fel", Line: 5}},
+	}
+	block.Opcodes = []Code{
+		{Kind: Push, Value: true},
+		{Kind: JumpIfFalse, Value: 3},
+		{Kind: CallHTML, Value: callee},
+		{Kind: Return},
+	}
+
+	var output bytes.Buffer
+	if err := Disassemble(&output, block, callee); err != nil {
+		t.Fatal(err)
+	}
+	expected := `component "Header" (Header.fel:3, stack size: 1)
+0000  Push                               true                                    ; line 3
+0001  JumpIfFalse                        L0
+0002  CallHTML                           "Link"                                  ; line 5
+L0:
+0003  Return
+
+component "Link" (stack size: 0)
+0000  Return
+`
+	if output.String() != expected {
+		t.Errorf("Expected:\n%s\nInstead got:\n%s", expected, output.String())
+	}
+}
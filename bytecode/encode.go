@@ -0,0 +1,776 @@
+package bytecode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/silbinarywolf/compiler-fel/data"
+	"github.com/silbinarywolf/compiler-fel/token"
+	"github.com/silbinarywolf/compiler-fel/types"
+)
+
+// EncodingVersion is bumped whenever the layout written by Encode changes.
+const EncodingVersion = 4
+
+var encodingMagic = []byte("FELB")
+
+// Tags for values in the constant pool
+const (
+	valueInt byte = 1 + iota
+	valueInt64
+	valueFloat64
+	valueBool
+	valueString
+	valueRawHTML
+	valueCSSLength
+	valueCSSColor
+	valueCSSCalc
+	valueCSSDefinition
+	valueCSSRule
+	valueStruct
+	valueBlock
+	valueStringMap
+)
+
+// Encode writes the blocks, and every block they call, in a versioned
+// binary format that can be read back with Decode.
+//
+// The file is laid out as:
+//   - header: "FELB" and EncodingVersion
+//   - string table: every name, string value and filepath used
+//   - opcode table: the names of the opcodes used, so renumbering opcodes
+//     doesn't silently change the meaning of old files
+//   - symbol table: the name and kind of each block, so calls can refer
+//     to blocks by index
+//   - constant pool: the values of opcodes, shared pointers such as
+//     *types.Struct are written once
+//   - block bodies, then the indexes of the blocks passed in
+func Encode(w io.Writer, blocks ...*Block) error {
+	enc := newEncoder()
+	for _, block := range blocks {
+		enc.addBlock(block)
+	}
+	// Callees are appended to enc.blocks as they're found
+	var constantIndexes [][]int
+	for i := 0; i < len(enc.blocks); i++ {
+		block := enc.blocks[i]
+		indexes := make([]int, len(block.Opcodes))
+		for offset, code := range block.Opcodes {
+			index, err := enc.addValue(code.Value)
+			if err != nil {
+				return fmt.Errorf("Cannot encode %s in \"%s\": %v", code.Kind.String(), block.Name(), err)
+			}
+			indexes[offset] = index
+		}
+		constantIndexes = append(constantIndexes, indexes)
+	}
+
+	var body bytes.Buffer
+	enc.writeUint(&body, uint64(len(enc.blocks)))
+	for _, block := range enc.blocks {
+		enc.writeString(&body, block.name)
+		enc.writeUint(&body, uint64(block.kind))
+		enc.writeBool(&body, block.isUnresolved)
+	}
+	enc.writeUint(&body, uint64(len(enc.constants)))
+	for _, value := range enc.constants {
+		enc.writeValue(&body, value)
+	}
+	for i, block := range enc.blocks {
+		enc.writeUint(&body, uint64(block.StackSize))
+		enc.writeBool(&body, block.HasReturnValue)
+		enc.writeToken(&body, block.Token)
+		enc.writeUint(&body, uint64(len(block.Positions)))
+		for _, position := range block.Positions {
+			enc.writeUint(&body, uint64(position.Offset))
+			enc.writeToken(&body, position.Token)
+		}
+		enc.writeUint(&body, uint64(len(block.Opcodes)))
+		for offset, code := range block.Opcodes {
+			enc.writeUint(&body, uint64(enc.kind(code.Kind)))
+			enc.writeUint(&body, uint64(constantIndexes[i][offset]))
+		}
+	}
+	enc.writeUint(&body, uint64(len(blocks)))
+	for _, block := range blocks {
+		enc.writeUint(&body, uint64(enc.blockIndex[block]))
+	}
+
+	// The string and opcode tables are built while writing the body,
+	// so they're written out last but placed first.
+	var header bytes.Buffer
+	header.Write(encodingMagic)
+	enc.writeUint(&header, EncodingVersion)
+	enc.writeUint(&header, uint64(len(enc.strings)))
+	for _, value := range enc.strings {
+		enc.writeUint(&header, uint64(len(value)))
+		header.WriteString(value)
+	}
+	enc.writeUint(&header, uint64(len(enc.kinds)))
+	for _, kind := range enc.kinds {
+		value := kind.String()
+		enc.writeUint(&header, uint64(len(value)))
+		header.WriteString(value)
+	}
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+type encoder struct {
+	strings     []string
+	stringIndex map[string]int
+
+	kinds     []Kind
+	kindIndex map[Kind]int
+
+	blocks     []*Block
+	blockIndex map[*Block]int
+
+	// constants are indexed from 1, 0 is nil
+	constants     []interface{}
+	constantIndex map[interface{}]int
+	scratch       [binary.MaxVarintLen64]byte
+}
+
+func newEncoder() *encoder {
+	enc := new(encoder)
+	enc.stringIndex = make(map[string]int)
+	enc.kindIndex = make(map[Kind]int)
+	enc.blockIndex = make(map[*Block]int)
+	enc.constantIndex = make(map[interface{}]int)
+	return enc
+}
+
+func (enc *encoder) addBlock(block *Block) {
+	if _, ok := enc.blockIndex[block]; ok {
+		return
+	}
+	enc.blockIndex[block] = len(enc.blocks)
+	enc.blocks = append(enc.blocks, block)
+}
+
+// addValue adds a value to the constant pool and returns its index
+func (enc *encoder) addValue(value interface{}) (int, error) {
+	switch value := value.(type) {
+	case nil:
+		return 0, nil
+	case map[string]string:
+		// Maps can't be used as keys, so they're never shared
+		enc.constants = append(enc.constants, value)
+		return len(enc.constants), nil
+	case int, int64, float64, bool, string, data.RawHTML, data.CSSLength, data.CSSColor, data.CSSCalc,
+		*data.CSSDefinition, *data.CSSRule, *types.Struct:
+		// ok
+	case *Block:
+		enc.addBlock(value)
+	default:
+		return 0, fmt.Errorf("unsupported value type %T", value)
+	}
+	if index, ok := enc.constantIndex[value]; ok {
+		return index, nil
+	}
+	enc.constants = append(enc.constants, value)
+	enc.constantIndex[value] = len(enc.constants)
+	return len(enc.constants), nil
+}
+
+func (enc *encoder) kind(kind Kind) int {
+	if index, ok := enc.kindIndex[kind]; ok {
+		return index
+	}
+	enc.kindIndex[kind] = len(enc.kinds)
+	enc.kinds = append(enc.kinds, kind)
+	return len(enc.kinds) - 1
+}
+
+func (enc *encoder) writeUint(buffer *bytes.Buffer, value uint64) {
+	n := binary.PutUvarint(enc.scratch[:], value)
+	buffer.Write(enc.scratch[:n])
+}
+
+func (enc *encoder) writeInt(buffer *bytes.Buffer, value int64) {
+	n := binary.PutVarint(enc.scratch[:], value)
+	buffer.Write(enc.scratch[:n])
+}
+
+func (enc *encoder) writeBool(buffer *bytes.Buffer, value bool) {
+	if value {
+		buffer.WriteByte(1)
+		return
+	}
+	buffer.WriteByte(0)
+}
+
+func (enc *encoder) writeFloat(buffer *bytes.Buffer, value float64) {
+	enc.writeUint(buffer, math.Float64bits(value))
+}
+
+func (enc *encoder) writeString(buffer *bytes.Buffer, value string) {
+	index, ok := enc.stringIndex[value]
+	if !ok {
+		index = len(enc.strings)
+		enc.stringIndex[value] = index
+		enc.strings = append(enc.strings, value)
+	}
+	enc.writeUint(buffer, uint64(index))
+}
+
+func (enc *encoder) writeToken(buffer *bytes.Buffer, t token.Token) {
+	enc.writeUint(buffer, uint64(t.Kind))
+	enc.writeString(buffer, t.Data)
+	enc.writeString(buffer, t.Filepath)
+	enc.writeInt(buffer, int64(t.Line))
+	enc.writeInt(buffer, int64(t.Column))
+	enc.writeInt(buffer, int64(t.Start))
+	enc.writeInt(buffer, int64(t.End))
+}
+
+func (enc *encoder) writeValue(buffer *bytes.Buffer, value interface{}) {
+	switch value := value.(type) {
+	case int:
+		buffer.WriteByte(valueInt)
+		enc.writeInt(buffer, int64(value))
+	case int64:
+		buffer.WriteByte(valueInt64)
+		enc.writeInt(buffer, value)
+	case float64:
+		buffer.WriteByte(valueFloat64)
+		enc.writeFloat(buffer, value)
+	case bool:
+		buffer.WriteByte(valueBool)
+		enc.writeBool(buffer, value)
+	case string:
+		buffer.WriteByte(valueString)
+		enc.writeString(buffer, value)
+	case data.RawHTML:
+		buffer.WriteByte(valueRawHTML)
+		enc.writeString(buffer, string(value))
+	case data.CSSLength:
+		buffer.WriteByte(valueCSSLength)
+		enc.writeFloat(buffer, value.Value)
+		enc.writeString(buffer, value.Unit)
+	case data.CSSColor:
+		buffer.WriteByte(valueCSSColor)
+		buffer.Write([]byte{value.R, value.G, value.B})
+		enc.writeFloat(buffer, value.A)
+	case data.CSSCalc:
+		buffer.WriteByte(valueCSSCalc)
+		enc.writeString(buffer, string(value))
+	case *data.CSSDefinition:
+		buffer.WriteByte(valueCSSDefinition)
+		enc.writeString(buffer, value.Name())
+		enc.writeCSSRules(buffer, value.Rules())
+	case *data.CSSRule:
+		buffer.WriteByte(valueCSSRule)
+		enc.writeCSSRule(buffer, value)
+	case *types.Struct:
+		buffer.WriteByte(valueStruct)
+		enc.writeString(buffer, value.Name())
+		fields := value.Fields()
+		enc.writeUint(buffer, uint64(len(fields)))
+		for _, field := range fields {
+			enc.writeString(buffer, field.Name)
+			enc.writeString(buffer, field.TypeIdentifier.Name)
+			enc.writeUint(buffer, uint64(field.TypeIdentifier.ArrayDepth))
+		}
+	case *Block:
+		buffer.WriteByte(valueBlock)
+		enc.writeUint(buffer, uint64(enc.blockIndex[value]))
+	case map[string]string:
+		buffer.WriteByte(valueStringMap)
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		enc.writeUint(buffer, uint64(len(keys)))
+		for _, key := range keys {
+			enc.writeString(buffer, key)
+			enc.writeString(buffer, value[key])
+		}
+	default:
+		panic(fmt.Sprintf("writeValue: Unhandled type %T. This should be caught in addValue.", value))
+	}
+}
+
+func (enc *encoder) writeCSSRules(buffer *bytes.Buffer, rules []*data.CSSRule) {
+	enc.writeUint(buffer, uint64(len(rules)))
+	for _, rule := range rules {
+		enc.writeCSSRule(buffer, rule)
+	}
+}
+
+func (enc *encoder) writeCSSRule(buffer *bytes.Buffer, rule *data.CSSRule) {
+	selectors := rule.Selectors()
+	enc.writeUint(buffer, uint64(len(selectors)))
+	for _, selector := range selectors {
+		enc.writeUint(buffer, uint64(len(selector)))
+		for _, part := range selector {
+			enc.writeUint(buffer, uint64(part.Kind()))
+			enc.writeString(buffer, part.Name())
+			enc.writeString(buffer, part.Operator())
+			enc.writeString(buffer, part.Value())
+		}
+	}
+	properties := rule.Properties()
+	enc.writeUint(buffer, uint64(len(properties)))
+	for _, property := range properties {
+		enc.writeString(buffer, property.Name())
+		enc.writeString(buffer, property.Value())
+	}
+	enc.writeCSSRules(buffer, rule.Rules())
+}
+
+// Decode reads blocks written by Encode, it returns the blocks in the
+// same order they were passed to Encode.
+func Decode(r io.Reader) ([]*Block, error) {
+	dec := &decoder{r: bufio.NewReader(r)}
+	result, err := dec.decode()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decode bytecode: %v", err)
+	}
+	return result, nil
+}
+
+type decoder struct {
+	r         *bufio.Reader
+	strings   []string
+	kinds     []Kind
+	blocks    []*Block
+	constants []interface{}
+}
+
+// errInvalid is returned when the data is truncated or an index is out of range
+var errInvalid = fmt.Errorf("invalid or corrupted data")
+
+func (dec *decoder) decode() ([]*Block, error) {
+	magic := make([]byte, len(encodingMagic))
+	if _, err := io.ReadFull(dec.r, magic); err != nil || !bytes.Equal(magic, encodingMagic) {
+		return nil, fmt.Errorf("not a bytecode file")
+	}
+	version, err := dec.readUint()
+	if err != nil {
+		return nil, err
+	}
+	if version != EncodingVersion {
+		return nil, fmt.Errorf("expected version %d, instead got %d", EncodingVersion, version)
+	}
+
+	// String table
+	count, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	dec.strings = make([]string, count)
+	for i := range dec.strings {
+		if dec.strings[i], err = dec.readRawString(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Opcode table
+	count, err = dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	kindsByName := make(map[string]Kind, len(kindToString))
+	for kind, name := range kindToString {
+		kindsByName[name] = Kind(kind)
+	}
+	dec.kinds = make([]Kind, count)
+	for i := range dec.kinds {
+		name, err := dec.readRawString()
+		if err != nil {
+			return nil, err
+		}
+		kind, ok := kindsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown opcode \"%s\"", name)
+		}
+		dec.kinds[i] = kind
+	}
+
+	// Symbol table
+	count, err = dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	dec.blocks = make([]*Block, count)
+	for i := range dec.blocks {
+		name, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		kind, err := dec.readUint()
+		if err != nil {
+			return nil, err
+		}
+		isUnresolved, err := dec.readBool()
+		if err != nil {
+			return nil, err
+		}
+		block := NewBlock(name, BlockKind(kind))
+		block.isUnresolved = isUnresolved
+		dec.blocks[i] = block
+	}
+
+	// Constant pool
+	count, err = dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	dec.constants = make([]interface{}, count)
+	for i := range dec.constants {
+		if dec.constants[i], err = dec.readValue(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Block bodies
+	for _, block := range dec.blocks {
+		stackSize, err := dec.readUint()
+		if err != nil {
+			return nil, err
+		}
+		block.StackSize = int(stackSize)
+		if block.HasReturnValue, err = dec.readBool(); err != nil {
+			return nil, err
+		}
+		if block.Token, err = dec.readToken(); err != nil {
+			return nil, err
+		}
+		count, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			block.Positions = make([]Position, count)
+		}
+		for i := range block.Positions {
+			offset, err := dec.readUint()
+			if err != nil {
+				return nil, err
+			}
+			block.Positions[i].Offset = int(offset)
+			if block.Positions[i].Token, err = dec.readToken(); err != nil {
+				return nil, err
+			}
+		}
+		if count, err = dec.readLength(); err != nil {
+			return nil, err
+		}
+		block.Opcodes = make([]Code, count)
+		for i := range block.Opcodes {
+			kindIndex, err := dec.readUint()
+			if err != nil {
+				return nil, err
+			}
+			if kindIndex >= uint64(len(dec.kinds)) {
+				return nil, errInvalid
+			}
+			constantIndex, err := dec.readUint()
+			if err != nil {
+				return nil, err
+			}
+			if constantIndex > uint64(len(dec.constants)) {
+				return nil, errInvalid
+			}
+			block.Opcodes[i].Kind = dec.kinds[kindIndex]
+			if constantIndex > 0 {
+				block.Opcodes[i].Value = dec.constants[constantIndex-1]
+			}
+		}
+	}
+
+	// Roots
+	if count, err = dec.readLength(); err != nil {
+		return nil, err
+	}
+	result := make([]*Block, count)
+	for i := range result {
+		if result[i], err = dec.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (dec *decoder) readUint() (uint64, error) {
+	value, err := binary.ReadUvarint(dec.r)
+	if err != nil {
+		return 0, errInvalid
+	}
+	return value, nil
+}
+
+func (dec *decoder) readInt() (int64, error) {
+	value, err := binary.ReadVarint(dec.r)
+	if err != nil {
+		return 0, errInvalid
+	}
+	return value, nil
+}
+
+// readLength reads a count, guarding against huge allocations from corrupted data
+func (dec *decoder) readLength() (int, error) {
+	value, err := dec.readUint()
+	if err != nil {
+		return 0, err
+	}
+	if value > math.MaxInt32 {
+		return 0, errInvalid
+	}
+	return int(value), nil
+}
+
+func (dec *decoder) readBool() (bool, error) {
+	value, err := dec.r.ReadByte()
+	if err != nil {
+		return false, errInvalid
+	}
+	return value != 0, nil
+}
+
+func (dec *decoder) readFloat() (float64, error) {
+	value, err := dec.readUint()
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(value), nil
+}
+
+func (dec *decoder) readRawString() (string, error) {
+	length, err := dec.readLength()
+	if err != nil {
+		return "", err
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(dec.r, value); err != nil {
+		return "", errInvalid
+	}
+	return string(value), nil
+}
+
+func (dec *decoder) readString() (string, error) {
+	index, err := dec.readUint()
+	if err != nil {
+		return "", err
+	}
+	if index >= uint64(len(dec.strings)) {
+		return "", errInvalid
+	}
+	return dec.strings[index], nil
+}
+
+func (dec *decoder) readBlock() (*Block, error) {
+	index, err := dec.readUint()
+	if err != nil {
+		return nil, err
+	}
+	if index >= uint64(len(dec.blocks)) {
+		return nil, errInvalid
+	}
+	return dec.blocks[index], nil
+}
+
+func (dec *decoder) readToken() (token.Token, error) {
+	var t token.Token
+	kind, err := dec.readUint()
+	if err != nil {
+		return t, err
+	}
+	t.Kind = token.Kind(kind)
+	if t.Data, err = dec.readString(); err != nil {
+		return t, err
+	}
+	if t.Filepath, err = dec.readString(); err != nil {
+		return t, err
+	}
+	var values [4]int64
+	for i := range values {
+		if values[i], err = dec.readInt(); err != nil {
+			return t, err
+		}
+	}
+	t.Line, t.Column, t.Start, t.End = int(values[0]), int(values[1]), int(values[2]), int(values[3])
+	return t, nil
+}
+
+func (dec *decoder) readValue() (interface{}, error) {
+	tag, err := dec.r.ReadByte()
+	if err != nil {
+		return nil, errInvalid
+	}
+	switch tag {
+	case valueInt:
+		value, err := dec.readInt()
+		return int(value), err
+	case valueInt64:
+		return dec.readInt()
+	case valueFloat64:
+		return dec.readFloat()
+	case valueBool:
+		return dec.readBool()
+	case valueString:
+		return dec.readString()
+	case valueRawHTML:
+		value, err := dec.readString()
+		return data.RawHTML(value), err
+	case valueCSSLength:
+		var value data.CSSLength
+		if value.Value, err = dec.readFloat(); err != nil {
+			return nil, err
+		}
+		value.Unit, err = dec.readString()
+		return value, err
+	case valueCSSColor:
+		var rgb [3]byte
+		if _, err := io.ReadFull(dec.r, rgb[:]); err != nil {
+			return nil, errInvalid
+		}
+		value := data.CSSColor{R: rgb[0], G: rgb[1], B: rgb[2]}
+		value.A, err = dec.readFloat()
+		return value, err
+	case valueCSSCalc:
+		value, err := dec.readString()
+		return data.CSSCalc(value), err
+	case valueCSSDefinition:
+		name, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		value := data.NewCSSDefinition(name)
+		rules, err := dec.readCSSRules()
+		if err != nil {
+			return nil, err
+		}
+		value.SetRules(rules)
+		return value, nil
+	case valueCSSRule:
+		return dec.readCSSRule()
+	case valueStruct:
+		name, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		count, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		fields := make([]types.StructField, count)
+		for i := range fields {
+			field := &fields[i]
+			if field.Name, err = dec.readString(); err != nil {
+				return nil, err
+			}
+			if field.TypeIdentifier.Name, err = dec.readString(); err != nil {
+				return nil, err
+			}
+			arrayDepth, err := dec.readUint()
+			if err != nil {
+				return nil, err
+			}
+			field.TypeIdentifier.ArrayDepth = int(arrayDepth)
+		}
+		// The VM only needs the field names and count, type info
+		// and default values are only used by the emitter.
+		return types.NewInternalStruct(name, fields), nil
+	case valueBlock:
+		return dec.readBlock()
+	case valueStringMap:
+		count, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		value := make(map[string]string, count)
+		for i := 0; i < count; i++ {
+			key, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			if value[key], err = dec.readString(); err != nil {
+				return nil, err
+			}
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("unknown value tag %d", tag)
+}
+
+func (dec *decoder) readCSSRules() ([]*data.CSSRule, error) {
+	count, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	var rules []*data.CSSRule
+	for i := 0; i < count; i++ {
+		rule, err := dec.readCSSRule()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (dec *decoder) readCSSRule() (*data.CSSRule, error) {
+	count, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	selectors := make([]data.CSSSelector, 0, count)
+	for i := 0; i < count; i++ {
+		partCount, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		selector := data.NewCSSSelector(partCount)
+		for j := 0; j < partCount; j++ {
+			kind, err := dec.readUint()
+			if err != nil {
+				return nil, err
+			}
+			var values [3]string
+			for k := range values {
+				if values[k], err = dec.readString(); err != nil {
+					return nil, err
+				}
+			}
+			if kind := data.CSSSelectorPartKind(kind); kind == data.SelectorPartKindAttribute {
+				selector.AddPart(data.NewCSSSelectorAttributePart(values[0], values[1], values[2]))
+			} else {
+				selector.AddPart(data.NewCSSSelectorPart(kind, values[0]))
+			}
+		}
+		selectors = append(selectors, selector)
+	}
+	rule := data.NewCSSRule(selectors)
+	if count, err = dec.readLength(); err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		name, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		rule.SetProperty(name, value)
+	}
+	rules, err := dec.readCSSRules()
+	if err != nil {
+		return nil, err
+	}
+	rule.SetRules(rules)
+	return rule, nil
+}
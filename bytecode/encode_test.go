@@ -0,0 +1,102 @@
+package bytecode
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/silbinarywolf/compiler-fel/data"
+	"github.com/silbinarywolf/compiler-fel/token"
+	"github.com/silbinarywolf/compiler-fel/types"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	structInfo := types.NewInternalStruct("Link", []types.StructField{
+		{Name: "href", TypeIdentifier: types.Identifier{Name: "string"}},
+	})
+	selector := data.NewCSSSelector(3)
+	selector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindClass, ".link"))
+	selector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindAncestor, " "))
+	selector.AddPart(data.NewCSSSelectorAttributePart("type", "=", "text"))
+	rule := data.NewCSSRule([]data.CSSSelector{selector})
+	rule.SetProperty("color", "red")
+
+	callee := NewBlock("Link", BlockHTMLComponentDefinition)
+	callee.Token = token.Token{Kind: token.Identifier, Data: "Link", Filepath: "Link.fel", Line: 1, Column: 1, End: 4}
+	callee.Opcodes = []Code{
+		{Kind: PushAllocStruct, Value: structInfo},
+		{Kind: PushAllocStruct, Value: structInfo},
+		{Kind: PushAllocCSSRule, Value: rule},
+		{Kind: Return},
+	}
+	block := NewBlock("index.fel", BlockTemplate)
+	block.StackSize = 2
+	block.HasReturnValue = true
+	block.Positions = []Position{
+		{Offset: 1, Token: token.Token{Filepath: "index.fel", Line: 2, Start: 10, End: 12}},
+	}
+	block.Opcodes = []Code{
+		{Kind: Push, Value: "hello"},
+		{Kind: Push, Value: int64(-3)},
+		{Kind: Push, Value: 3.5},
+		{Kind: Push, Value: data.NewCSSLength("10px")},
+		{Kind: Push, Value: data.NewCSSColor("#3366ff")},
+		{Kind: Push, Value: data.RawHTML("<br>")},
+		{Kind: JumpIfFalse, Value: 8},
+		{Kind: CallHTML, Value: callee},
+		{Kind: ReplaceCSSClassNames, Value: map[string]string{"a": "b"}},
+		{Kind: Return},
+	}
+
+	var buffer bytes.Buffer
+	if err := Encode(&buffer, block); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 {
+		t.Fatalf("Expected 1 block, instead got %d", len(decoded))
+	}
+
+	// Compare everything but the pointers
+	var expected, actual bytes.Buffer
+	Disassemble(&expected, block)
+	Disassemble(&actual, decoded[0])
+	if expected.String() != actual.String() {
+		t.Errorf("Expected:\n%s\nInstead got:\n%s", expected.String(), actual.String())
+	}
+	for i, code := range block.Opcodes {
+		switch value := code.Value.(type) {
+		case *Block:
+			if decoded[0].Opcodes[i].Value.(*Block).Token != value.Token {
+				t.Errorf("Expected callee token %v, instead got %v", value.Token, decoded[0].Opcodes[i].Value.(*Block).Token)
+			}
+		default:
+			if !reflect.DeepEqual(decoded[0].Opcodes[i].Value, value) {
+				t.Errorf("Opcode %d: Expected %#v, instead got %#v", i, value, decoded[0].Opcodes[i].Value)
+			}
+		}
+	}
+	if !reflect.DeepEqual(decoded[0].Positions, block.Positions) {
+		t.Errorf("Expected positions %v, instead got %v", block.Positions, decoded[0].Positions)
+	}
+
+	decodedCallee := decoded[0].Opcodes[7].Value.(*Block)
+	if decodedCallee.Opcodes[0].Value != decodedCallee.Opcodes[1].Value {
+		t.Errorf("Expected struct type info to be shared between opcodes.")
+	}
+	decodedRule := decodedCallee.Opcodes[2].Value.(*data.CSSRule)
+	if selector := decodedRule.Selectors()[0].String(); selector != `.link [type="text"]` {
+		t.Errorf("Expected selector \".link [type=\\\"text\\\"]\", instead got \"%s\"", selector)
+	}
+	if properties := decodedRule.Properties(); len(properties) != 1 || properties[0].Value() != "red" {
+		t.Errorf("Expected \"color: red\", instead got %v", properties)
+	}
+
+	// Truncated data should error rather than panic
+	if _, err := Decode(bytes.NewReader(buffer.Bytes()[:buffer.Len()/2])); err == nil {
+		t.Errorf("Expected an error decoding truncated data.")
+	}
+}
@@ -0,0 +1,252 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CSSLength is a number with a unit, ie. 10px, 50%, 0.5s
+type CSSLength struct {
+	Value float64
+	Unit  string
+}
+
+func NewCSSLength(value string) CSSLength {
+	i := 0
+	for i < len(value) && (value[i] == '-' || value[i] == '.' || (value[i] >= '0' && value[i] <= '9')) {
+		i++
+	}
+	number, err := strconv.ParseFloat(value[:i], 64)
+	if err != nil {
+		panic(fmt.Sprintf("NewCSSLength: Invalid number \"%s\", error: %s", value, err))
+	}
+	return CSSLength{
+		Value: number,
+		Unit:  value[i:],
+	}
+}
+
+func (value CSSLength) String() string {
+	return formatCSSNumber(value.Value) + value.Unit
+}
+
+// CSSCalc is the result of arithmetic on lengths with different
+// units, ie. "100% - 20px" becomes "calc(100% - 20px)"
+type CSSCalc string
+
+func (value CSSCalc) String() string {
+	return "calc(" + string(value) + ")"
+}
+
+// CSSColor is an RGBA color, ie. #3366ff
+type CSSColor struct {
+	R, G, B uint8
+	A       float64
+}
+
+// NewCSSColor parses a hex color, ie. "#fff", "#3366ff", "#3366ff80"
+func NewCSSColor(value string) CSSColor {
+	hex := strings.TrimPrefix(value, "#")
+	switch len(hex) {
+	case 3, 4:
+		expanded := make([]byte, 0, len(hex)*2)
+		for i := 0; i < len(hex); i++ {
+			expanded = append(expanded, hex[i], hex[i])
+		}
+		hex = string(expanded)
+	case 6, 8:
+		// no-op
+	default:
+		panic(fmt.Sprintf("NewCSSColor: Invalid color \"%s\". This should be caught in the typechecker.", value))
+	}
+	rgba, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		panic(fmt.Sprintf("NewCSSColor: Invalid color \"%s\", error: %s", value, err))
+	}
+	color := CSSColor{A: 1}
+	if len(hex) == 8 {
+		color.A = float64(rgba&0xff) / 255
+		rgba >>= 8
+	}
+	color.R = uint8(rgba >> 16)
+	color.G = uint8(rgba >> 8)
+	color.B = uint8(rgba)
+	return color
+}
+
+func (color CSSColor) String() string {
+	if color.A < 1 {
+		return fmt.Sprintf("rgba(%d, %d, %d, %s)", color.R, color.G, color.B, formatCSSNumber(color.A))
+	}
+	return fmt.Sprintf("#%02x%02x%02x", color.R, color.G, color.B)
+}
+
+// Lighten increases the lightness of the color, where amount is
+// between 0 and 1. Use a negative amount to darken.
+func (color CSSColor) Lighten(amount float64) CSSColor {
+	h, s, l := rgbToHSL(color.R, color.G, color.B)
+	l = math.Max(0, math.Min(1, l+amount))
+	result := CSSColor{A: color.A}
+	result.R, result.G, result.B = hslToRGB(h, s, l)
+	return result
+}
+
+func rgbToHSL(r, g, b uint8) (float64, float64, float64) {
+	rf, gf, bf := float64(r)/255, float64(g)/255, float64(b)/255
+	max := math.Max(rf, math.Max(gf, bf))
+	min := math.Min(rf, math.Min(gf, bf))
+	l := (max + min) / 2
+	if max == min {
+		return 0, 0, l
+	}
+	d := max - min
+	s := d / (max + min)
+	if l > 0.5 {
+		s = d / (2 - max - min)
+	}
+	var h float64
+	switch max {
+	case rf:
+		h = (gf - bf) / d
+		if gf < bf {
+			h += 6
+		}
+	case gf:
+		h = (bf-rf)/d + 2
+	default:
+		h = (rf-gf)/d + 4
+	}
+	return h / 6, s, l
+}
+
+func hslToRGB(h, s, l float64) (uint8, uint8, uint8) {
+	if s == 0 {
+		v := uint8(math.Round(l * 255))
+		return v, v, v
+	}
+	q := l * (1 + s)
+	if l >= 0.5 {
+		q = l + s - l*s
+	}
+	p := 2*l - q
+	r := hueToRGB(p, q, h+1.0/3)
+	g := hueToRGB(p, q, h)
+	b := hueToRGB(p, q, h-1.0/3)
+	return uint8(math.Round(r * 255)), uint8(math.Round(g * 255)), uint8(math.Round(b * 255))
+}
+
+func hueToRGB(p, q, t float64) float64 {
+	if t < 0 {
+		t += 1
+	}
+	if t > 1 {
+		t -= 1
+	}
+	switch {
+	case t < 1.0/6:
+		return p + (q-p)*6*t
+	case t < 1.0/2:
+		return q
+	case t < 2.0/3:
+		return p + (q-p)*(2.0/3-t)*6
+	}
+	return p
+}
+
+func formatCSSNumber(value float64) string {
+	// Round to avoid output like "33.333333333333336px"
+	value = math.Round(value*10000) / 10000
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// CSSValueString converts a value to how it's written in CSS.
+func CSSValueString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return formatCSSNumber(value)
+	case bool:
+		return strconv.FormatBool(value)
+	case fmt.Stringer:
+		return value.String()
+	}
+	panic(fmt.Sprintf("CSSValueString: Unhandled type %T. This should be caught in the typechecker.", value))
+}
+
+// CSSArithmetic applies the operator ('+', '-', '*', '/') to lengths and numbers.
+// Lengths with different units are combined with calc().
+func CSSArithmetic(operator byte, left interface{}, right interface{}) interface{} {
+	leftNumber, leftIsNumber := cssNumber(left)
+	rightNumber, rightIsNumber := cssNumber(right)
+	if leftIsNumber && rightIsNumber {
+		return applyOperator(operator, leftNumber, rightNumber)
+	}
+	leftLength, leftIsLength := left.(CSSLength)
+	rightLength, rightIsLength := right.(CSSLength)
+	switch operator {
+	case '+', '-':
+		if leftIsLength && rightIsLength && leftLength.Unit == rightLength.Unit {
+			return CSSLength{
+				Value: applyOperator(operator, leftLength.Value, rightLength.Value),
+				Unit:  leftLength.Unit,
+			}
+		}
+	case '*':
+		if leftIsLength && rightIsNumber {
+			return CSSLength{
+				Value: leftLength.Value * rightNumber,
+				Unit:  leftLength.Unit,
+			}
+		}
+		if leftIsNumber && rightIsLength {
+			return CSSLength{
+				Value: leftNumber * rightLength.Value,
+				Unit:  rightLength.Unit,
+			}
+		}
+	case '/':
+		if leftIsLength && rightIsNumber {
+			return CSSLength{
+				Value: leftLength.Value / rightNumber,
+				Unit:  leftLength.Unit,
+			}
+		}
+	}
+	return CSSCalc(calcOperand(left) + " " + string(operator) + " " + calcOperand(right))
+}
+
+func calcOperand(value interface{}) string {
+	if value, ok := value.(CSSCalc); ok {
+		return "(" + string(value) + ")"
+	}
+	return CSSValueString(value)
+}
+
+func cssNumber(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+	return 0, false
+}
+
+func applyOperator(operator byte, left float64, right float64) float64 {
+	switch operator {
+	case '+':
+		return left + right
+	case '-':
+		return left - right
+	case '*':
+		return left * right
+	case '/':
+		return left / right
+	}
+	panic(fmt.Sprintf("applyOperator: Unhandled operator '%c'", operator))
+}
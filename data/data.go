@@ -39,6 +39,41 @@ func (structData *Struct) FieldCount() int {
 	return len(structData.fields)
 }
 
+// Map is a map[string]T. Keys are kept in the order they're added so
+// iterating over it outputs the same HTML between builds.
+type Map struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func NewMap(capacity int) *Map {
+	result := new(Map)
+	result.keys = make([]string, 0, capacity)
+	result.values = make(map[string]interface{}, capacity)
+	return result
+}
+
+func (m *Map) Get(key string) (interface{}, bool) {
+	value, ok := m.values[key]
+	return value, ok
+}
+
+func (m *Map) Set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Key gets the key at the index, in the order they were added
+func (m *Map) Key(index int) string {
+	return m.keys[index]
+}
+
+func (m *Map) Len() int {
+	return len(m.keys)
+}
+
 type StructInterface interface {
 	GetField(index int) interface{}
 	SetField(index int, value interface{})
@@ -51,8 +86,13 @@ const (
 	HTMLKindElement
 	HTMLKindText
 	HTMLKindFragment
+	HTMLKindRaw
 )
 
+// RawHTML is a string that is written to the HTML output
+// without being escaped.
+type RawHTML string
+
 type HTMLElement struct {
 	// NOTE(Jake): Currently "Name" also stores HTMLText data.
 	kind       HTMLKind
@@ -71,6 +111,8 @@ func (node *HTMLElement) ImplementsHTMLInterface() {}
 type HTMLAttribute struct {
 	Name  string
 	Value string
+	// Boolean attributes are output without a value, ie. "disabled"
+	Boolean bool
 }
 
 func NewHTMLElement(tagName string) *HTMLElement {
@@ -87,6 +129,13 @@ func NewHTMLText(text string) *HTMLElement {
 	return node
 }
 
+func NewHTMLRaw(html string) *HTMLElement {
+	node := new(HTMLElement)
+	node.kind = HTMLKindRaw
+	node.nameOrText = html
+	return node
+}
+
 func NewHTMLFragment() *HTMLElement {
 	node := new(HTMLElement)
 	node.kind = HTMLKindFragment
@@ -105,6 +154,10 @@ func (node *HTMLElement) Kind() HTMLKind {
 	return node.kind
 }
 
+func (node *HTMLElement) ChildNodes() []*HTMLElement {
+	return node.childNodes
+}
+
 func (node *HTMLElement) SetParent(parent *HTMLElement) {
 	if node.parentNode == parent {
 		return
@@ -131,17 +184,38 @@ func (node *HTMLElement) GetAttributes() []HTMLAttribute {
 }
 
 func (node *HTMLElement) SetAttribute(name string, value string) {
+	node.setAttribute(HTMLAttribute{
+		Name:  name,
+		Value: value,
+	})
+}
+
+// SetBooleanAttribute sets an attribute without a value, ie. "disabled"
+func (node *HTMLElement) SetBooleanAttribute(name string) {
+	node.setAttribute(HTMLAttribute{
+		Name:    name,
+		Boolean: true,
+	})
+}
+
+func (node *HTMLElement) setAttribute(attribute HTMLAttribute) {
 	for i := 0; i < len(node.attributes); i++ {
 		attr := &node.attributes[i]
-		if attr.Name == name {
-			attr.Value = value
+		if attr.Name == attribute.Name {
+			*attr = attribute
+			return
+		}
+	}
+	node.attributes = append(node.attributes, attribute)
+}
+
+func (node *HTMLElement) RemoveAttribute(name string) {
+	for i := 0; i < len(node.attributes); i++ {
+		if node.attributes[i].Name == name {
+			node.attributes = append(node.attributes[:i], node.attributes[i+1:]...)
 			return
 		}
 	}
-	node.attributes = append(node.attributes, HTMLAttribute{
-		Name:  name,
-		Value: value,
-	})
 }
 
 func (node *HTMLElement) GetAttribute(name string) (string, bool) {
@@ -175,7 +249,7 @@ func (node *HTMLElement) debugIndent(indent int) string {
 				for _, node := range node.childNodes {
 					buffer.WriteString(node.debugIndent(indent))
 				}
-			case HTMLKindText:
+			case HTMLKindText, HTMLKindRaw:
 				for i := 0; i < indent; i++ {
 					buffer.WriteByte('\t')
 				}
@@ -215,6 +289,9 @@ func (node *HTMLElement) String() string {
 	for _, attribute := range node.GetAttributes() {
 		buffer.WriteByte(' ')
 		buffer.WriteString(attribute.Name)
+		if attribute.Boolean {
+			continue
+		}
 		buffer.WriteString("=\"")
 		buffer.WriteString(attribute.Value)
 		buffer.WriteString("\"")
@@ -229,8 +306,9 @@ func (node *HTMLElement) String() string {
 type CSSSelectorPartKind int
 
 const (
-	SelectorPartKindUnknown   CSSSelectorPartKind = 0 + iota
-	SelectorPartKindAttribute                     // [type="text"]
+	SelectorPartKindUnknown     CSSSelectorPartKind = 0 + iota
+	SelectorPartKindAttribute                       // [type="text"]
+	SelectorPartKindParenthesis                     // (max-width: 600px)
 
 	css_selector_identifier_begin
 	SelectorPartKindClass     // .a-class
@@ -252,8 +330,9 @@ const (
 )
 
 var selectorKindToString = []string{
-	SelectorPartKindUnknown:   "unknown part",
-	SelectorPartKindAttribute: "attribute",
+	SelectorPartKindUnknown:     "unknown part",
+	SelectorPartKindAttribute:   "attribute",
+	SelectorPartKindParenthesis: "parenthesis",
 
 	//SelectorKindIdentifier: "identifier",
 	SelectorPartKindClass:     "class",
@@ -288,9 +367,10 @@ type CSSDefinition struct {
 	rules []*CSSRule
 }
 
-func (def *CSSDefinition) Name() string          { return def.name }
-func (def *CSSDefinition) Rules() []*CSSRule     { return def.rules }
-func (def *CSSDefinition) AddRule(node *CSSRule) { def.rules = append(def.rules, node) }
+func (def *CSSDefinition) Name() string              { return def.name }
+func (def *CSSDefinition) Rules() []*CSSRule         { return def.rules }
+func (def *CSSDefinition) AddRule(node *CSSRule)     { def.rules = append(def.rules, node) }
+func (def *CSSDefinition) SetRules(rules []*CSSRule) { def.rules = rules }
 
 func NewCSSDefinition(name string) *CSSDefinition {
 	def := new(CSSDefinition)
@@ -307,13 +387,17 @@ type CSSRule struct {
 func (rule *CSSRule) Selectors() []CSSSelector  { return rule.selectors }
 func (rule *CSSRule) Properties() []CSSProperty { return rule.properties }
 func (rule *CSSRule) Rules() []*CSSRule         { return rule.rules }
-func (rule *CSSRule) AddRule(node *CSSRule)     { rule.rules = append(rule.rules, rule) }
+func (rule *CSSRule) AddRule(node *CSSRule)     { rule.rules = append(rule.rules, node) }
+func (rule *CSSRule) SetRules(rules []*CSSRule) { rule.rules = rules }
+func (rule *CSSRule) SetSelectors(selectors []CSSSelector) {
+	rule.selectors = selectors
+}
 func (rule *CSSRule) SetProperty(name string, value string) {
 	for i, _ := range rule.properties {
 		property := &rule.properties[i]
 		if property.Name() == name {
 			property.SetValue(value)
-			break
+			return
 		}
 	}
 	rule.properties = append(rule.properties, CSSProperty{
@@ -322,6 +406,19 @@ func (rule *CSSRule) SetProperty(name string, value string) {
 	})
 }
 
+// AtKeyword returns the keyword of an at-rule, ie. "@media", or
+// an empty string if it's a regular rule.
+func (rule *CSSRule) AtKeyword() string {
+	if len(rule.selectors) == 0 || len(rule.selectors[0]) == 0 {
+		return ""
+	}
+	part := rule.selectors[0][0]
+	if part.Kind() != SelectorPartKindAtKeyword {
+		return ""
+	}
+	return part.Name()
+}
+
 func NewCSSRule(selectors []CSSSelector) *CSSRule {
 	rule := new(CSSRule)
 	rule.selectors = selectors
@@ -349,6 +446,8 @@ func (node *CSSSelectorPart) String() string {
 			return fmt.Sprintf("[%s%s\"%s\"]", node.Name(), operator, node.Value())
 		}
 		return fmt.Sprintf("[%s]", node.Name())
+	case SelectorPartKindParenthesis:
+		return "(" + node.Name() + ")"
 	}
 	if kind.IsIdentifier() {
 		return node.Name()
@@ -384,14 +483,9 @@ func NewCSSSelector(size int) CSSSelector {
 
 func (nodes CSSSelector) String() string {
 	result := ""
-	for i, node := range nodes {
-		if i != 0 &&
-			node.Kind() == SelectorPartKindAncestor {
-			result += " "
-		}
+	for _, node := range nodes {
 		result += node.String()
 	}
-	//result = result[:len(result)-1]
 	return result
 }
 
@@ -417,39 +511,49 @@ func (property *CSSProperty) String() string {
 
 func (node *CSSDefinition) debugIndent(indent int) string {
 	var buffer bytes.Buffer
+	debugCSSRules(&buffer, node.Rules(), indent)
+	return buffer.String()
+}
 
-	if childNodes := node.Rules(); len(childNodes) > 0 {
-		for _, rule := range childNodes {
-			// Do selectors, ie. ".myClass {"
-			if selectors := rule.Selectors(); len(selectors) > 0 {
-				for i, selector := range selectors {
-					if i != 0 {
-						buffer.WriteString(",")
-					}
-					buffer.WriteString(selector.String())
-				}
-			}
-			buffer.WriteString("{")
-			indent += 1
-			for _, property := range rule.Properties() {
-				buffer.WriteByte('\n')
-				for i := 0; i < indent; i++ {
-					buffer.WriteByte('\t')
+func debugCSSRules(buffer *bytes.Buffer, rules []*CSSRule, indent int) {
+	for _, rule := range rules {
+		for i := 0; i < indent; i++ {
+			buffer.WriteByte('\t')
+		}
+		// Do selectors, ie. ".myClass {"
+		if selectors := rule.Selectors(); len(selectors) > 0 {
+			for i, selector := range selectors {
+				if i != 0 {
+					buffer.WriteString(",")
 				}
-				buffer.WriteString(property.Name())
-				buffer.WriteString(":")
-				buffer.WriteString(property.Value())
-				buffer.WriteString(";")
+				buffer.WriteString(selector.String())
 			}
-			indent -= 1
+		}
+		buffer.WriteString("{")
+		indent += 1
+		for _, property := range rule.Properties() {
 			buffer.WriteByte('\n')
 			for i := 0; i < indent; i++ {
 				buffer.WriteByte('\t')
 			}
-			buffer.WriteString("}\n")
+			buffer.WriteString(property.Name())
+			buffer.WriteString(":")
+			buffer.WriteString(property.Value())
+			buffer.WriteString(";")
 		}
+		// Do nested rules, ie. "@media print { .myClass {} }"
+		if childRules := rule.Rules(); len(childRules) > 0 {
+			buffer.WriteByte('\n')
+			debugCSSRules(buffer, childRules, indent)
+		} else {
+			buffer.WriteByte('\n')
+		}
+		indent -= 1
+		for i := 0; i < indent; i++ {
+			buffer.WriteByte('\t')
+		}
+		buffer.WriteString("}\n")
 	}
-	return buffer.String()
 }
 
 func (node *CSSDefinition) Debug() string {
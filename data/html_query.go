@@ -1,202 +1,232 @@
 package data
 
-/*import (
+import (
 	"fmt"
 	"strings"
 )
 
-func (node *HTMLNode) HasSelectorPartMatch(selectorPart *CSSSelectorPart) bool {
+// Fragments (component output, "children") don't exist in the final
+// HTML output, so when walking parents and siblings we treat a
+// fragments child nodes as if they belong to the fragments parent.
+
+func (node *HTMLElement) HasSelectorPartMatch(selectorPart *CSSSelectorPart) bool {
+	if node.Kind() != HTMLKindElement {
+		return false
+	}
 	selectorString := selectorPart.String()
-	switch selectorPart.Kind {
-	case SelectorKindClass:
+	switch selectorPart.Kind() {
+	case SelectorPartKindClass:
 		selectorString = selectorString[1:]
-		for _, attribute := range node.Attributes {
-			if attribute.Name != "class" {
-				continue
-			}
-			classList := strings.Fields(attribute.Value)
-			hasClass := false
-			for _, className := range classList {
-				hasClass = hasClass || strings.Contains(className, selectorString)
+		value, ok := node.GetAttribute("class")
+		if !ok {
+			return false
+		}
+		for _, className := range strings.Fields(value) {
+			if className == selectorString {
+				return true
 			}
-			return hasClass
 		}
 		return false
-	case SelectorKindID:
+	case SelectorPartKindID:
 		selectorString = selectorString[1:]
-		for _, attribute := range node.Attributes {
-			if attribute.Name != "id" {
-				continue
-			}
-			ID := attribute.Value
-			return ID == selectorString
+		value, ok := node.GetAttribute("id")
+		if !ok {
+			return false
 		}
-		return false
-	case SelectorKindAttribute:
-		selectorValue := selectorPart.Value
-		for _, attribute := range node.Attributes {
-			if attribute.Name != selectorPart.Name {
-				continue
+		return value == selectorString
+	case SelectorPartKindAttribute:
+		value, ok := node.GetAttribute(selectorPart.Name())
+		if !ok {
+			return false
+		}
+		selectorValue := selectorPart.Value()
+		switch selectorPart.Operator() {
+		case "":
+			return true
+		case "=":
+			return value == selectorValue
+		case "~=":
+			for _, word := range strings.Fields(value) {
+				if word == selectorValue {
+					return true
+				}
 			}
-			switch selectorPart.Operator {
-			case "=":
-				return attribute.Value == selectorValue
-			case "^=":
-				return strings.HasPrefix(attribute.Value, selectorValue)
+			return false
+		case "|=":
+			return value == selectorValue || strings.HasPrefix(value, selectorValue+"-")
+		case "^=":
+			return strings.HasPrefix(value, selectorValue)
+		case "$=":
+			return strings.HasSuffix(value, selectorValue)
+		case "*=":
+			return strings.Contains(value, selectorValue)
+		}
+		panic(fmt.Errorf("HasSelectorPartMatch: Unhandled attribute operator: %s", selectorPart.Operator()))
+	case SelectorPartKindTag:
+		return selectorString == "*" || node.Name() == selectorString
+	}
+	panic(fmt.Errorf("HasSelectorPartMatch: Unhandled selector part kind: %s", selectorPart.Kind().String()))
+}
+
+// hasCompoundSelectorMatch checks if each part of a compound selector
+// matches, ie. "div.is-active[type]". Pseudo-classes and pseudo-elements
+// depend on runtime state, so they're assumed to match.
+func (node *HTMLElement) hasCompoundSelectorMatch(compound CSSSelector) bool {
+	for i := 0; i < len(compound); i++ {
+		part := compound[i]
+		switch part.Kind() {
+		case SelectorPartKindColon, SelectorPartKindDoubleColon:
+			// Skip ":hover" / "::before" / ":not(.a)"
+			i++
+			if i+1 < len(compound) && compound[i+1].Kind() == SelectorPartKindParenthesis {
+				i++
 			}
-			panic(fmt.Errorf("HasSelectorPartMatch: Unhandled attribute operator: %s", selectorPart.Operator))
+			continue
+		}
+		if !node.HasSelectorPartMatch(part) {
+			return false
 		}
-		return false
-	case SelectorKindTag:
-		return node.Name == selectorString
 	}
-	panic(fmt.Errorf("HasSelectorPartMatch: Unhandled selector part kind: %s", selectorPart.Kind.String()))
+	return node.Kind() == HTMLKindElement
 }
 
-func (rootNode *HTMLNode) QuerySelectorAll(selectorParts CSSSelector) []*HTMLNode {
-	return rootNode.QuerySelectorAllWithOptions(selectorParts, false)
+// parentElement returns the closest ancestor that is an element,
+// skipping over fragments.
+func (node *HTMLElement) parentElement() *HTMLElement {
+	parent := node.parentNode
+	for parent != nil && parent.Kind() == HTMLKindFragment {
+		parent = parent.parentNode
+	}
+	return parent
 }
 
-func (rootNode *HTMLNode) QuerySelectorAllWithOptions(selectorParts CSSSelector, onlyScanCurrentHTMLComponentScope bool) []*HTMLNode {
-	nodeResultStack := make([]*HTMLNode, 0, 5)
-	nodeIterationStack := make([]*HTMLNode, 0, 50)
+// elementSiblings returns the elements that share the same parent
+// element (or root) as this node, including the node itself.
+func (node *HTMLElement) elementSiblings() []*HTMLElement {
+	root := node.parentNode
+	if root == nil {
+		return []*HTMLElement{node}
+	}
+	for root.Kind() == HTMLKindFragment && root.parentNode != nil {
+		root = root.parentNode
+	}
+	return appendChildElements(nil, root)
+}
 
-	//
-	{
-		childNodes := rootNode.Nodes()
-		for i := len(childNodes) - 1; i >= 0; i-- {
-			switch node := childNodes[i].(type) {
-			case *HTMLNode:
-				nodeIterationStack = append(nodeIterationStack, node)
-			case *HTMLComponentNode:
-				if onlyScanCurrentHTMLComponentScope {
-					continue
+func appendChildElements(nodes []*HTMLElement, parent *HTMLElement) []*HTMLElement {
+	for _, childNode := range parent.childNodes {
+		switch childNode.Kind() {
+		case HTMLKindElement:
+			nodes = append(nodes, childNode)
+		case HTMLKindFragment:
+			nodes = appendChildElements(nodes, childNode)
+		}
+	}
+	return nodes
+}
+
+// splitSelector splits a selector into compound selectors and the
+// combinators between them. ie. "div > .a" becomes ["div", ".a"] and [">"]
+func splitSelector(selector CSSSelector) ([]CSSSelector, []CSSSelectorPartKind) {
+	compounds := make([]CSSSelector, 0, 4)
+	combinators := make([]CSSSelectorPartKind, 0, 4)
+	compound := NewCSSSelector(len(selector))
+	for _, part := range selector {
+		switch kind := part.Kind(); kind {
+		case SelectorPartKindAncestor,
+			SelectorPartKindChild,
+			SelectorPartKindSibling,
+			SelectorPartKindAdjacent:
+			if len(compound) == 0 {
+				// Whitespace next to another combinator, ie. "a > b"
+				if len(combinators) > 0 && kind != SelectorPartKindAncestor {
+					combinators[len(combinators)-1] = kind
 				}
-				nodeIterationStack = append(nodeIterationStack, &node.HTMLNode)
-				//panic("todo(Jake): Add HTMLNode items from HTMLComponentNode")
-			case *HTMLText:
-				// skip
-			default:
-				panic(fmt.Sprintf("QuerySelectorAll: Unhandled type: %T", node))
+				continue
 			}
+			compounds = append(compounds, compound)
+			combinators = append(combinators, kind)
+			compound = NewCSSSelector(len(selector))
+		default:
+			compound.AddPart(part)
 		}
-		nodeIterationStack = append(nodeIterationStack, rootNode)
 	}
+	if len(compound) > 0 {
+		compounds = append(compounds, compound)
+	} else if len(combinators) > 0 {
+		combinators = combinators[:len(combinators)-1]
+	}
+	return compounds, combinators
+}
 
-	lastSelectorPart := &selectorParts[len(selectorParts)-1]
-	if lastSelectorPart.Kind != SelectorKindAttribute &&
-		!lastSelectorPart.Kind.IsIdentifier() {
-		panic(fmt.Sprintf("HTMLNode::HasMatchRecursive(): Unhandled type \"%s\" in selector \"%s\"", lastSelectorPart.Kind.String(), selectorParts.String()))
+func (node *HTMLElement) hasSelectorMatch(compounds []CSSSelector, combinators []CSSSelectorPartKind) bool {
+	last := len(compounds) - 1
+	if !node.hasCompoundSelectorMatch(compounds[last]) {
+		return false
+	}
+	if last == 0 {
+		return true
+	}
+	compounds = compounds[:last]
+	combinator := combinators[last-1]
+	combinators = combinators[:last-1]
+	switch combinator {
+	case SelectorPartKindAncestor:
+		for parent := node.parentElement(); parent != nil; parent = parent.parentElement() {
+			if parent.hasSelectorMatch(compounds, combinators) {
+				return true
+			}
+		}
+		return false
+	case SelectorPartKindChild:
+		parent := node.parentElement()
+		return parent != nil && parent.hasSelectorMatch(compounds, combinators)
+	case SelectorPartKindAdjacent, SelectorPartKindSibling:
+		siblings := node.elementSiblings()
+		index := -1
+		for i, sibling := range siblings {
+			if sibling == node {
+				index = i
+				break
+			}
+		}
+		for i := index - 1; i >= 0; i-- {
+			if siblings[i].hasSelectorMatch(compounds, combinators) {
+				return true
+			}
+			if combinator == SelectorPartKindAdjacent {
+				break
+			}
+		}
+		return false
+	}
+	panic(fmt.Sprintf("hasSelectorMatch: Unhandled combinator \"%s\"", combinator.String()))
+}
+
+// QuerySelectorAll returns all elements within the node (including itself)
+// that match the selector.
+func (rootNode *HTMLElement) QuerySelectorAll(selector CSSSelector) []*HTMLElement {
+	compounds, combinators := splitSelector(selector)
+	if len(compounds) == 0 {
+		return nil
 	}
 
-NodeRecursionLoop:
+	var result []*HTMLElement
+	nodeIterationStack := make([]*HTMLElement, 0, 50)
+	nodeIterationStack = append(nodeIterationStack, rootNode)
 	for len(nodeIterationStack) > 0 {
 		node := nodeIterationStack[len(nodeIterationStack)-1]
 		nodeIterationStack = nodeIterationStack[:len(nodeIterationStack)-1]
 
-		//
-		if node.HasSelectorPartMatch(lastSelectorPart) {
-			currentNode := node
-
-		SelectorPartMatchingLoop:
-			for p := len(selectorParts) - 1; p >= 0; p-- {
-				//
-				if selectorPart := &selectorParts[p]; selectorPart.Kind == SelectorKindAttribute ||
-					selectorPart.Kind.IsIdentifier() {
-					if !currentNode.HasSelectorPartMatch(selectorPart) {
-						continue NodeRecursionLoop
-					}
-					continue SelectorPartMatchingLoop
-				}
-
-				// If wasn't identifier, handle operator
-				selectorPartOperator := &selectorParts[p]
-				p--
-				if p < 0 {
-					panic(fmt.Errorf("Missing identifier before %s.", selectorPartOperator.Kind.String()))
-				}
-				selectorPart := &selectorParts[p]
-				if !selectorPart.Kind.IsIdentifier() {
-					panic(fmt.Sprintf("Expected selector identifier, not \"%s\"", selectorPartOperator.Kind))
-				}
-
-				switch selectorPartOperator.Kind {
-				case SelectorKindAncestor:
-					for {
-						currentNode = currentNode.Parent()
-						if currentNode == nil {
-							continue NodeRecursionLoop
-						}
-						if !currentNode.HasSelectorPartMatch(selectorPart) {
-							continue
-						}
-						break
-					}
-					// Has matched!
-					continue SelectorPartMatchingLoop
-				case SelectorKindChild:
-					currentNode = currentNode.Parent()
-					if currentNode == nil {
-						continue NodeRecursionLoop
-					}
-					if !currentNode.HasSelectorPartMatch(selectorPart) {
-						continue NodeRecursionLoop
-					}
-					// Has matched!
-					continue SelectorPartMatchingLoop
-				case SelectorKindAdjacent:
-					currentNode = currentNode.Previous()
-					if currentNode == nil {
-						continue NodeRecursionLoop
-					}
-					if !currentNode.HasSelectorPartMatch(selectorPart) {
-						continue NodeRecursionLoop
-					}
-					// Has matched!
-					continue SelectorPartMatchingLoop
-				case SelectorKindSibling:
-					for {
-						currentNode = currentNode.Previous()
-						if currentNode == nil {
-							continue NodeRecursionLoop
-						}
-						if !currentNode.HasSelectorPartMatch(selectorPart) {
-							continue
-						}
-						break
-					}
-					// Has matched!
-					continue SelectorPartMatchingLoop
-				}
-				panic(fmt.Sprintf("HTMLNode::HasMatchRecursive():inner: Unhandled type \"%s\"", selectorPart.Kind.String()))
-			}
-
-			// If got to end of loop, then it matched!
-			nodeResultStack = append(nodeResultStack, node)
+		if node.hasSelectorMatch(compounds, combinators) {
+			result = append(result, node)
 		}
 
 		// Add children
-		childNodes := node.Nodes()
+		childNodes := node.childNodes
 		for i := len(childNodes) - 1; i >= 0; i-- {
-			switch node := childNodes[i].(type) {
-			case *HTMLNode:
-				nodeIterationStack = append(nodeIterationStack, node)
-			case *HTMLComponentNode:
-				if onlyScanCurrentHTMLComponentScope {
-					continue
-				}
-				nodeIterationStack = append(nodeIterationStack, &node.HTMLNode)
-				//panic("todo(Jake): Add HTMLNode items from HTMLComponentNode")
-			case *HTMLText:
-				// skip
-			default:
-				panic(fmt.Sprintf("HasMatchRecursive()::loop: Unhandled type: %T", node))
-			}
+			nodeIterationStack = append(nodeIterationStack, childNodes[i])
 		}
 	}
-
-	if len(nodeResultStack) == 0 {
-		return nil
-	}
-	return nodeResultStack
-}*/
+	return result
+}
@@ -2,13 +2,16 @@ package emitter
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/silbinarywolf/compiler-fel/ast"
+	"github.com/silbinarywolf/compiler-fel/builtins"
 	"github.com/silbinarywolf/compiler-fel/bytecode"
 	"github.com/silbinarywolf/compiler-fel/data"
 	//"github.com/silbinarywolf/compiler-fel/data"
+	"github.com/silbinarywolf/compiler-fel/errors"
 	"github.com/silbinarywolf/compiler-fel/token"
 	"github.com/silbinarywolf/compiler-fel/types"
 )
@@ -36,8 +39,10 @@ type Scope struct {
 }
 
 type Emitter struct {
+	errors.ErrorHandler
 	symbols           map[string]*bytecode.Block
 	unresolvedSymbols map[string]*bytecode.Block
+	unresolvedTokens  map[string]token.Token // where an unresolved symbol was first used
 	workspaces        []*bytecode.Block
 	fileOptions       FileOptions
 	htmlElementStack  []string // mostly for debug purposes, can possibly be removed
@@ -46,6 +51,27 @@ type Emitter struct {
 
 type EmitterScope struct {
 	scope *Scope
+
+	// Class names to rename in the current ":: html" component,
+	// ie. "header" => "Header__header"
+	cssClassNames map[string]string
+
+	// Selectors of the CSS rule being emitted, used to flatten
+	// nested rules.
+	cssParentSelectors []data.CSSSelector
+
+	// Variables declared in nested scopes (ie. for-loops) release their
+	// stack position when the scope is popped, so we track the highest
+	// position used to determine the stack size of the block.
+	maxStackPos int
+
+	// Whether statements output HTML, ie. in a ":: html" component or
+	// template file rather than a procedure.
+	htmlContext bool
+
+	// Line table for the block being emitted
+	positions       []bytecode.Position
+	statementTokens []token.Token // statements being emitted, innermost last
 }
 
 type FileOptions struct {
@@ -74,9 +100,19 @@ func (emit *Emitter) PopScope() {
 	if parentScope == nil {
 		panic("Cannot pop last scope item.")
 	}
+	if stackPos := emit.scope.stackPos; stackPos > emit.maxStackPos {
+		emit.maxStackPos = stackPos
+	}
 	emit.scope = parentScope
 }
 
+func (emit *Emitter) StackSize() int {
+	if stackPos := emit.scope.stackPos; stackPos > emit.maxStackPos {
+		return stackPos
+	}
+	return emit.maxStackPos
+}
+
 func (scope *Scope) DeclareSet(name string, varInfo VariableInfo) {
 	_, ok := scope.mapToInfo[name]
 	if ok {
@@ -103,8 +139,11 @@ func (scope *Scope) Get(name string) (VariableInfo, bool) {
 
 func New() *Emitter {
 	emit := new(Emitter)
+	emit.ErrorHandler.Init()
+	emit.ErrorHandler.SetDeveloperMode(true)
 	emit.symbols = make(map[string]*bytecode.Block)
 	emit.unresolvedSymbols = make(map[string]*bytecode.Block)
+	emit.unresolvedTokens = make(map[string]token.Token)
 	emit.workspaces = make([]*bytecode.Block, 0, 3)
 	emit.PushScope()
 	return emit
@@ -114,22 +153,137 @@ func (emit *Emitter) Workspaces() []*bytecode.Block {
 	return emit.workspaces
 }
 
+// Symbol gets the block of an emitted component or procedure
+func (emit *Emitter) Symbol(name string) (*bytecode.Block, bool) {
+	block, ok := emit.symbols[name]
+	return block, ok
+}
+
 func (emit *Emitter) EmitGlobalScope(nodes []*ast.File) {
 	for _, astFile := range nodes {
 		for _, node := range astFile.Nodes() {
 			emit.emitGlobalScope(node)
 		}
 	}
-	if len(emit.unresolvedSymbols) > 0 {
-		panic("todo(Jake): Handle unresolved symbols.")
+	names := make([]string, 0, len(emit.unresolvedSymbols))
+	for name := range emit.unresolvedSymbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		emit.internalError(emit.unresolvedTokens[name], "Unresolved symbol \"%s\", this should be caught in the typechecker.", name)
+	}
+}
+
+// internalError reports something that should have been caught in
+// the typechecker.
+func (emit *Emitter) internalError(t token.Token, format string, args ...interface{}) {
+	emit.AddError(t, errors.CodeInternal, fmt.Errorf(format, args...))
+}
+
+// recoverInternalError turns a panic while emitting into an error on the
+// statement or definition being emitted, so a bug in the emitter doesn't
+// crash the build.
+func (emit *Emitter) recoverInternalError(t token.Token) {
+	if r := recover(); r != nil {
+		if len(emit.statementTokens) > 0 {
+			t = emit.statementToken()
+			emit.statementTokens = emit.statementTokens[:0]
+		}
+		emit.internalError(t, "Internal compiler error: %v", r)
+	}
+}
+
+// statementToken gets the token of the innermost statement being emitted,
+// used to report errors on nodes that don't have a token of their own.
+func (emit *Emitter) statementToken() token.Token {
+	if len(emit.statementTokens) == 0 {
+		return token.Token{}
+	}
+	return emit.statementTokens[len(emit.statementTokens)-1]
+}
+
+// nodeToken gets the first token of a node, used to report errors and
+// positions for nodes that don't have a token of their own.
+func nodeToken(node ast.Node) (token.Token, bool) {
+	switch node := node.(type) {
+	case *ast.Token:
+		return node.Token, true
+	case *ast.TokenList:
+		return node.Tokens()[0], true
+	case *ast.Call:
+		return node.Name, true
+	case *ast.StructLiteral:
+		return node.Name, true
+	case *ast.DeclareStatement:
+		return node.Name, true
+	case *ast.OpStatement:
+		return node.LeftHandSide[0], true
+	case *ast.ArrayAppendStatement:
+		return node.LeftHandSide[0], true
+	case *ast.CSSProperty:
+		return node.Name, true
+	case *ast.If:
+		return nodeToken(&node.Condition)
+	case *ast.For:
+		return nodeToken(&node.Array)
+	case *ast.MapLiteral:
+		return node.TypeIdentifier.Name, true
+	case *ast.Index:
+		return node.Bracket, true
+	case *ast.Slice:
+		return node.Bracket, true
+	case *ast.Selector:
+		return nodeToken(&node.Value)
+	case *ast.IndexAssignStatement:
+		return node.Index.Bracket, true
+	case *ast.CSSRule:
+		selectors := node.Selectors()
+		for i := range selectors {
+			if t, ok := nodeToken(&selectors[i]); ok {
+				return t, true
+			}
+		}
+	}
+	for _, child := range node.Nodes() {
+		if t, ok := nodeToken(child); ok {
+			return t, true
+		}
+	}
+	return token.Token{}, false
+}
+
+// markPosition records that the opcodes emitted from here on came
+// from t, so the VM can report where a runtime error occurred.
+func (emit *Emitter) markPosition(opcodes []bytecode.Code, t token.Token) {
+	offset := len(opcodes)
+
+	// Opcodes are sometimes removed after being emitted (ie. to replace
+	// the last opcode) and nested nodes start at the same offset as their
+	// parent, so drop any positions that would be overlapped.
+	positions := emit.positions
+	for len(positions) > 0 && positions[len(positions)-1].Offset >= offset {
+		positions = positions[:len(positions)-1]
 	}
+	emit.positions = append(positions, bytecode.Position{
+		Offset: offset,
+		Token:  t,
+	})
 }
 
 func (emit *Emitter) EmitBytecode(node *ast.File, fileOptions FileOptions) *bytecode.Block {
+	fileToken := token.Token{Filepath: node.Filepath, Line: 1}
+	defer emit.recoverInternalError(fileToken)
 	oldOptions := emit.fileOptions
+	oldPositions := emit.positions
+	oldHTMLContext := emit.htmlContext
 	emit.fileOptions = fileOptions
+	emit.positions = nil
+	emit.htmlContext = fileOptions.IsTemplateFile
 	defer func() {
 		emit.fileOptions = oldOptions
+		emit.positions = oldPositions
+		emit.htmlContext = oldHTMLContext
 	}()
 	isTemplateFile := emit.IsTemplateFile()
 
@@ -158,16 +312,29 @@ func (emit *Emitter) EmitBytecode(node *ast.File, fileOptions FileOptions) *byte
 	}
 
 	codeBlock := bytecode.NewBlock(node.Filepath, codeBlockType)
+	codeBlock.Token = fileToken
+	codeBlock.Positions = emit.positions
 	codeBlock.Opcodes = opcodes
-	codeBlock.StackSize = emit.scope.stackPos
+	codeBlock.StackSize = emit.StackSize()
 	codeBlock.HasReturnValue = codeBlockType == bytecode.BlockTemplate
-	//debugOpcodes(opcodes)
-	//fmt.Printf("Final bytecode output above\nStack Size: %d\n", codeBlock.StackSize)
+	//bytecode.Disassemble(os.Stdout, codeBlock)
 	return codeBlock
 }
 
-func (emit *Emitter) EmitCSSDefinition(def *ast.CSSDefinition) *bytecode.Block {
+func (emit *Emitter) EmitCSSDefinition(def *ast.CSSDefinition, cssConfig *ast.CSSConfigDefinition) *bytecode.Block {
+	defer emit.recoverInternalError(def.Name)
 	name := def.Name.String()
+	oldCSSClassNames := emit.cssClassNames
+	oldPositions := emit.positions
+	oldHTMLContext := emit.htmlContext
+	emit.cssClassNames = newCSSClassNameMap(def.Name, def, cssConfig)
+	emit.positions = nil
+	emit.htmlContext = false
+	defer func() {
+		emit.cssClassNames = oldCSSClassNames
+		emit.positions = oldPositions
+		emit.htmlContext = oldHTMLContext
+	}()
 
 	// Emit bytecode
 	opcodes := make([]bytecode.Code, 0, 50)
@@ -184,16 +351,15 @@ func (emit *Emitter) EmitCSSDefinition(def *ast.CSSDefinition) *bytecode.Block {
 		Kind: bytecode.Return,
 	})
 
-	debugOpcodes(opcodes)
-
 	// Create code block
 	codeBlock := bytecode.NewBlock(name, bytecode.BlockCSSDefinition)
+	codeBlock.Token = def.Name
+	codeBlock.Positions = emit.positions
 	codeBlock.Opcodes = opcodes
-	codeBlock.StackSize = emit.scope.stackPos
+	codeBlock.StackSize = emit.StackSize()
 	codeBlock.HasReturnValue = true
 
-	//debugOpcodes(codeBlock.Opcodes)
-	//fmt.Printf("Final bytecode output above\nStack Size: %d\n", codeBlock.StackSize)
+	//bytecode.Disassemble(os.Stdout, codeBlock)
 	//panic("todo(Jake): EmitCSSDefinition")
 	return codeBlock
 }
@@ -214,14 +380,6 @@ func addDebugString(opcodes []bytecode.Code, text string) []bytecode.Code {
 	return opcodes
 }
 
-func debugOpcodes(opcodes []bytecode.Code) {
-	fmt.Printf("Opcode Debug:\n-----------\n")
-	for i, code := range opcodes {
-		fmt.Printf("%d - %s\n", i, code.String())
-	}
-	fmt.Printf("-----------\n")
-}
-
 func (emit *Emitter) registerSymbol(name string, block *bytecode.Block) bool {
 	_, ok := emit.symbols[name]
 	if ok {
@@ -242,6 +400,21 @@ func (emit *Emitter) registerSymbol(name string, block *bytecode.Block) bool {
 	return true
 }
 
+// symbolOrPlaceholder gets the block of a component or procedure, if it
+// hasn't been emitted yet, ie. it's defined in a later file or calls itself,
+// a placeholder is returned that is filled in by registerSymbol.
+func (emit *Emitter) symbolOrPlaceholder(nameToken token.Token, kind bytecode.BlockKind) *bytecode.Block {
+	name := nameToken.String()
+	block, ok := emit.symbols[name]
+	if !ok {
+		block = bytecode.NewUnresolvedBlock(name, kind)
+		emit.symbols[name] = block
+		emit.unresolvedSymbols[name] = block
+		emit.unresolvedTokens[name] = nameToken
+	}
+	return block
+}
+
 func (emit *Emitter) registerWorkspace(block *bytecode.Block) {
 	emit.workspaces = append(emit.workspaces, block)
 }
@@ -252,13 +425,13 @@ func (emit *Emitter) registerWorkspace(block *bytecode.Block) {
 //
 // ie. "test: string"
 //
-func (emit *Emitter) emitNewFromType(opcodes []bytecode.Code, typeInfo types.TypeInfo) []bytecode.Code {
+func (emit *Emitter) emitNewFromType(opcodes []bytecode.Code, t token.Token, typeInfo types.TypeInfo) []bytecode.Code {
 	//opcodes = addDebugString(opcodes, "emitNewFromType")
 	switch typeInfo := typeInfo.(type) {
 	case *types.Int:
 		opcodes = append(opcodes, bytecode.Code{
 			Kind:  bytecode.Push,
-			Value: int(0),
+			Value: int64(0),
 		})
 	case *types.Float:
 		opcodes = append(opcodes, bytecode.Code{
@@ -270,6 +443,11 @@ func (emit *Emitter) emitNewFromType(opcodes []bytecode.Code, typeInfo types.Typ
 			Kind:  bytecode.Push,
 			Value: "",
 		})
+	case *types.RawHTML:
+		opcodes = append(opcodes, bytecode.Code{
+			Kind:  bytecode.Push,
+			Value: data.RawHTML(""),
+		})
 	case *types.Bool:
 		opcodes = append(opcodes, bytecode.Code{
 			Kind:  bytecode.Push,
@@ -283,14 +461,30 @@ func (emit *Emitter) emitNewFromType(opcodes []bytecode.Code, typeInfo types.Typ
 				Kind:  bytecode.PushAllocArrayString,
 				Value: 0,
 			})
+		case *types.Int:
+			opcodes = append(opcodes, bytecode.Code{
+				Kind:  bytecode.PushAllocArrayInt,
+				Value: 0,
+			})
+		case *types.Float:
+			opcodes = append(opcodes, bytecode.Code{
+				Kind:  bytecode.PushAllocArrayFloat,
+				Value: 0,
+			})
+		case *types.Struct:
+			opcodes = append(opcodes, bytecode.Code{
+				Kind:  bytecode.PushAllocArrayStruct,
+				Value: 0,
+			})
 		default:
-			panic(fmt.Sprintf("emitNewFromType:Array: Unhandled type %T", underlyingType))
+			emit.AddError(t, errors.CodeUnsupported, fmt.Errorf("Arrays of %s are not supported.", underlyingType))
 		}
 	case *types.Struct:
 		name := typeInfo.Name()
 		fields := typeInfo.Fields()
-		if name == "" || len(fields) == 0 {
-			panic("emitExpression: TypeInfo_Struct: Missing field data, this should be handled in the type checker.")
+		if name == "" {
+			emit.internalError(t, "Missing struct name for %s, this should be caught in the type checker.", typeInfo)
+			break
 		}
 		opcodes = append(opcodes, bytecode.Code{
 			Kind:  bytecode.PushAllocStruct,
@@ -301,10 +495,11 @@ func (emit *Emitter) emitNewFromType(opcodes []bytecode.Code, typeInfo types.Typ
 			exprNode := &structField.DefaultValue
 			fieldTypeInfo := exprNode.TypeInfo
 			if fieldTypeInfo == nil {
-				panic(fmt.Sprintf("emitExpression: Missing type info on property for \"%s :: struct { %s }\"", name, structField.Name))
+				emit.internalError(t, "Missing type info on property for \"%s :: struct { %s }\"", name, structField.Name)
+				continue
 			}
 			if len(exprNode.Nodes()) == 0 {
-				opcodes = emit.emitNewFromType(opcodes, fieldTypeInfo)
+				opcodes = emit.emitNewFromType(opcodes, t, fieldTypeInfo)
 			} else {
 				opcodes = emit.emitExpression(opcodes, exprNode)
 			}
@@ -314,8 +509,18 @@ func (emit *Emitter) emitNewFromType(opcodes []bytecode.Code, typeInfo types.Typ
 				Value: offset,
 			})
 		}
+	case *types.Map:
+		opcodes = append(opcodes, bytecode.Code{
+			Kind:  bytecode.PushAllocMap,
+			Value: 0,
+		})
+	case *types.Optional:
+		opcodes = append(opcodes, bytecode.Code{
+			Kind:  bytecode.Push,
+			Value: nil,
+		})
 	default:
-		panic(fmt.Sprintf("emitNewFromType: Unhandled type %T", typeInfo))
+		emit.AddError(t, errors.CodeUnsupported, fmt.Errorf("Cannot use %s without a value.", typeInfo))
 	}
 	return opcodes
 }
@@ -324,7 +529,8 @@ func (emit *Emitter) emitVariableIdent(opcodes []bytecode.Code, ident token.Toke
 	name := ident.String()
 	varInfo, ok := emit.scope.Get(name)
 	if !ok {
-		panic(fmt.Sprintf("Missing declaration for \"%s\", this should be caught in the type checker.", name))
+		emit.internalError(ident, "Missing declaration for \"%s\", this should be caught in the type checker.", name)
+		return opcodes
 	}
 	opcodes = append(opcodes, bytecode.Code{
 		Kind:  bytecode.PushStackVar,
@@ -344,7 +550,8 @@ func (emit *Emitter) emitVariableIdentWithProperty(
 	name := leftHandSide[0].String()
 	varInfo, ok := emit.scope.Get(name)
 	if !ok {
-		panic(fmt.Sprintf("Missing declaration for %s, this should be caught in the type checker.", name))
+		emit.internalError(leftHandSide[0], "Missing declaration for %s, this should be caught in the type checker.", name)
+		return opcodes, 0
 	}
 	opcodes = append(opcodes, bytecode.Code{
 		Kind:  bytecode.PushStackVar,
@@ -356,20 +563,19 @@ func (emit *Emitter) emitVariableIdentWithProperty(
 	}
 	structTypeInfo := varInfo.structTypeInfo
 	if structTypeInfo == nil {
-		panic(fmt.Sprintf("emitStatement: Expected parameter %s to be a struct, this should be set when declaring a new variable (if applicable)", name))
+		emit.internalError(leftHandSide[0], "Expected %s to be a struct, this should be set when declaring a new variable (if applicable).", name)
+		return opcodes, 0
 	}
 	for i := 1; i < len(leftHandSide)-1; i++ {
-		if structTypeInfo == nil {
-			panic("emitStatement: Non-struct cannot have properties. This should be caught in the typechecker.")
-		}
 		fieldName := leftHandSide[i].String()
 		field := structTypeInfo.GetFieldByName(fieldName)
 		if field == nil {
-			panic(fmt.Sprintf("emitStatement: \"%s :: struct\" does not have property \"%s\". This should be caught in the typechecker.", structTypeInfo.Name(), fieldName))
+			emit.internalError(leftHandSide[i], "\"%s :: struct\" does not have property \"%s\". This should be caught in the typechecker.", structTypeInfo.Name(), fieldName)
+			return opcodes, 0
 		}
 		opcodes = append(opcodes, bytecode.Code{
 			Kind:  bytecode.ReplaceStructFieldVar,
-			Value: field.Index,
+			Value: field.Index(),
 		})
 		if typeInfo, ok := field.TypeInfo.(*types.Struct); ok {
 			structTypeInfo = typeInfo
@@ -378,26 +584,30 @@ func (emit *Emitter) emitVariableIdentWithProperty(
 	fieldName := leftHandSide[len(leftHandSide)-1].String()
 	lastPropertyField = structTypeInfo.GetFieldByName(fieldName)
 	if lastPropertyField == nil {
-		panic(fmt.Sprintf("emitStatement: \"%s :: struct\" does not have property \"%s\". This should be caught in the typechecker.", structTypeInfo.Name(), fieldName))
+		emit.internalError(leftHandSide[len(leftHandSide)-1], "\"%s :: struct\" does not have property \"%s\". This should be caught in the typechecker.", structTypeInfo.Name(), fieldName)
+		return opcodes, 0
 	}
 	opcodes = append(opcodes, bytecode.Code{
 		Kind:  bytecode.ReplaceStructFieldVar,
-		Value: lastPropertyField.Index,
+		Value: lastPropertyField.Index(),
 	})
 	return opcodes, lastPropertyField.Index()
 }
 
 func (emit *Emitter) emitProcedureCall(opcodes []bytecode.Code, node *ast.Call) []bytecode.Code {
-	name := node.Name.String()
-	block, ok := emit.symbols[name]
-	if !ok {
-		panic(fmt.Sprintf("Missing procedure %s, this should be caught in the typechecker", name))
+	if node.TypeConversion != nil {
+		return emit.emitTypeConversion(opcodes, node)
+	}
+	if node.Builtin != nil {
+		return emit.emitBuiltinCall(opcodes, node)
 	}
+	block := emit.symbolOrPlaceholder(node.Name, bytecode.BlockProcedure)
 	for i := 0; i < len(node.Parameters); i++ {
 		expr := node.Parameters[i]
 		opcodes = emit.emitExpression(opcodes, &expr.Expression)
 		//opcodes = emit.emitNewFromType(opcodes, parameter.TypeInfo)
 	}
+	emit.markPosition(opcodes, node.Name)
 	opcodes = append(opcodes, bytecode.Code{
 		Kind:  bytecode.Call,
 		Value: block,
@@ -405,21 +615,62 @@ func (emit *Emitter) emitProcedureCall(opcodes []bytecode.Code, node *ast.Call)
 	return opcodes
 }
 
+// emitBuiltinCall emits a call to a built-in procedure, ie. darken(bg_color, 10%)
+// or upper(title)
+func (emit *Emitter) emitBuiltinCall(opcodes []bytecode.Code, node *ast.Call) []bytecode.Code {
+	for i := 0; i < len(node.Parameters); i++ {
+		opcodes = emit.emitExpression(opcodes, &node.Parameters[i].Expression)
+	}
+	kind := bytecode.CallCSSBuiltin
+	if _, ok := builtins.Lookup(node.Name.String()); ok {
+		kind = bytecode.CallNative
+	}
+	emit.markPosition(opcodes, node.Name)
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  kind,
+		Value: node.Name.String(),
+	})
+	return opcodes
+}
+
+// callResultType gets the type a procedure call returns, or nil if it
+// doesn't return anything.
+func callResultType(node *ast.Call) types.TypeInfo {
+	switch {
+	case node.TypeConversion != nil:
+		return node.TypeConversion
+	case node.Builtin != nil:
+		return node.Builtin
+	case node.Definition != nil:
+		return node.Definition.TypeInfo
+	}
+	return nil
+}
+
+func (emit *Emitter) emitTypeConversion(opcodes []bytecode.Code, node *ast.Call) []bytecode.Code {
+	if len(node.Parameters) != 1 {
+		emit.internalError(node.Name, "Expected 1 parameter for %s(), not %d. This should be caught in the typechecker.", node.Name.String(), len(node.Parameters))
+		return opcodes
+	}
+	opcodes = emit.emitExpression(opcodes, &node.Parameters[0].Expression)
+	switch typeInfo := node.TypeConversion.(type) {
+	case *types.RawHTML:
+		opcodes = append(opcodes, bytecode.Code{
+			Kind: bytecode.CastToRawHTML,
+		})
+	default:
+		emit.AddError(node.Name, errors.CodeUnsupported, fmt.Errorf("Cannot convert to %s.", typeInfo.String()))
+	}
+	return opcodes
+}
+
 func (emit *Emitter) emitHTMLNode(opcodes []bytecode.Code, node *ast.Call) []bytecode.Code {
 	emit.PushScope()
 	defer emit.PopScope()
 
 	definition := node.HTMLDefinition
 	if definition != nil {
-		name := node.Name.String()
-		block, ok := emit.symbols[name]
-		if !ok {
-			block = bytecode.NewUnresolvedBlock(name, bytecode.BlockHTMLComponentDefinition)
-			emit.symbols[name] = block
-			emit.unresolvedSymbols[name] = block
-			//fmt.Printf("Unresovled Symbol added: %s", name)
-			//panic(fmt.Sprintf("Missing HTML component \"%s\" symbol. Either this is:\n- Uncaught in the typechecker.\nor\n- A component that hasnt been emitted.", name))
-		}
+		block := emit.symbolOrPlaceholder(node.Name, bytecode.BlockHTMLComponentDefinition)
 
 		// If definition has used the "children" keyword
 		//
@@ -435,6 +686,11 @@ func (emit *Emitter) emitHTMLNode(opcodes []bytecode.Code, node *ast.Call) []byt
 			})
 			for _, node := range node.Nodes() {
 				opcodes = emit.emitStatement(opcodes, node)
+				if _, ok := node.(*ast.Call); !ok {
+					// Statements such as "for" and variable declarations
+					// append to the fragment themselves (if at all).
+					continue
+				}
 				// NOTE(Jake): 2018-02-08
 				//
 				// We remove the final bytecode here so that we can push the resulting
@@ -449,7 +705,7 @@ func (emit *Emitter) emitHTMLNode(opcodes []bytecode.Code, node *ast.Call) []byt
 						Kind: bytecode.AppendPopHTMLElementToHTMLElement,
 					})
 				default:
-					panic(fmt.Sprintf("emitHTMLNode:Component: Unhandled kind: %v in ast.Call: %s", kind, name))
+					emit.internalError(node.(*ast.Call).Name, "Unhandled opcode %v for child of component \"%s\".", kind, block.Name())
 				}
 			}
 		}
@@ -466,13 +722,14 @@ func (emit *Emitter) emitHTMLNode(opcodes []bytecode.Code, node *ast.Call) []byt
 					}
 				}
 				if len(exprNode.Nodes()) == 0 {
-					opcodes = emit.emitNewFromType(opcodes, exprNode.TypeInfo)
+					opcodes = emit.emitNewFromType(opcodes, structField.Name, exprNode.TypeInfo)
 				} else {
 					opcodes = emit.emitExpression(opcodes, exprNode)
 				}
 			}
 		}
 
+		emit.markPosition(opcodes, node.Name)
 		opcodes = append(opcodes, bytecode.Code{
 			Kind:  bytecode.CallHTML,
 			Value: block,
@@ -504,10 +761,17 @@ func (emit *Emitter) emitHTMLNode(opcodes []bytecode.Code, node *ast.Call) []byt
 		parameter := node.Parameters[i]
 		exprNode := &parameter.Expression
 		if len(exprNode.Nodes()) == 0 {
-			opcodes = emit.emitNewFromType(opcodes, exprNode.TypeInfo)
+			opcodes = emit.emitNewFromType(opcodes, parameter.Name, exprNode.TypeInfo)
 		} else {
 			opcodes = emit.emitExpression(opcodes, exprNode)
 		}
+		if parameter.Name.String() == "class" && len(emit.cssClassNames) > 0 {
+			opcodes = append(opcodes, bytecode.Code{
+				Kind:  bytecode.ReplaceCSSClassNames,
+				Value: emit.cssClassNames,
+			})
+		}
+		emit.markPosition(opcodes, parameter.Name)
 		opcodes = append(opcodes, bytecode.Code{
 			Kind:  bytecode.StorePopHTMLAttribute,
 			Value: parameter.Name.String(),
@@ -537,11 +801,49 @@ func (emit *Emitter) emitHTMLNode(opcodes []bytecode.Code, node *ast.Call) []byt
 func (emit *Emitter) emitExpression(opcodes []bytecode.Code, topNode *ast.Expression) []bytecode.Code {
 	nodes := topNode.Nodes()
 	if len(nodes) == 0 {
-		panic("emitExpression: Cannot provide an empty expression to emitExpression.")
+		emit.internalError(emit.statementToken(), "Cannot emit an empty expression, this should be caught in the type checker.")
+		return opcodes
 	}
 	typeInfo := topNode.TypeInfo
 
-	for _, node := range nodes {
+	// For short-circuit evaluation of "&&" and "||", find where the
+	// right-hand side of each starts so we can jump over it.
+	var shortCircuitOperators map[int]int // right-hand side index -> operator index
+	var shortCircuitJumps map[int]int     // operator index -> jump opcode index
+	{
+		operandStarts := make([]int, 0, len(nodes))
+		for i, node := range nodes {
+			t, ok := node.(*ast.Token)
+			if !ok || !t.IsOperator() {
+				operandStarts = append(operandStarts, i)
+				continue
+			}
+			if t.Kind == token.Not || t.Kind == token.Negate {
+				continue
+			}
+			rightStart := operandStarts[len(operandStarts)-1]
+			operandStarts = operandStarts[:len(operandStarts)-1]
+			if t.Kind == token.ConditionalAnd || t.Kind == token.ConditionalOr {
+				if shortCircuitOperators == nil {
+					shortCircuitOperators = make(map[int]int)
+					shortCircuitJumps = make(map[int]int)
+				}
+				shortCircuitOperators[rightStart] = i
+			}
+		}
+	}
+
+	for i, node := range nodes {
+		if operatorIndex, ok := shortCircuitOperators[i]; ok {
+			kind := bytecode.JumpIfFalseOrPop
+			if nodes[operatorIndex].(*ast.Token).Kind == token.ConditionalOr {
+				kind = bytecode.JumpIfTrueOrPop
+			}
+			shortCircuitJumps[operatorIndex] = len(opcodes)
+			opcodes = append(opcodes, bytecode.Code{
+				Kind: kind,
+			})
+		}
 		switch node := node.(type) {
 		case *ast.TokenList:
 			opcodes, _ = emit.emitVariableIdentWithProperty(opcodes, node.Tokens())
@@ -550,61 +852,128 @@ func (emit *Emitter) emitExpression(opcodes []bytecode.Code, topNode *ast.Expres
 			case ast.CallProcedure:
 				opcodes = emit.emitProcedureCall(opcodes, node)
 			case ast.CallHTMLNode:
-				if len(node.Nodes()) > 0 {
-					panic("emitExpression: Cannot have sub-elements for HTMLNode in an expression.")
-				}
-				panic("emitExpression: Cannot use HTMLNode in expression currently.")
+				emit.AddError(node.Name, errors.CodeUnsupported, fmt.Errorf("Cannot use HTML node \"%s\" in an expression.", node.Name.String()))
 				//opcodes = emit.emitHTMLNode(opcodes, node)
 			default:
-				panic(fmt.Errorf("emitExpression: Unhandled *ast.Call kind: %s", node.Name))
+				emit.internalError(node.Name, "Unhandled call kind for \"%s\".", node.Name.String())
 			}
 		case *ast.Token:
 			switch t := node.Token; t.Kind {
 			case token.Identifier:
+				if _, ok := emit.scope.Get(t.String()); !ok && types.IsCSSValue(typeInfo) {
+					// ie. "auto" in "margin: auto"
+					opcodes = append(opcodes, bytecode.Code{
+						Kind:  bytecode.Push,
+						Value: t.String(),
+					})
+					break
+				}
 				opcodes = emit.emitVariableIdent(opcodes, t)
-			case token.ConditionalEqual:
+			case token.NumberWithUnit:
+				opcodes = append(opcodes, bytecode.Code{
+					Kind:  bytecode.Push,
+					Value: data.NewCSSLength(t.String()),
+				})
+			case token.Color:
+				opcodes = append(opcodes, bytecode.Code{
+					Kind:  bytecode.Push,
+					Value: data.NewCSSColor(t.String()),
+				})
+			case token.Add,
+				token.Subtract,
+				token.Multiply,
+				token.Divide,
+				token.Modulo:
+				operandTypeInfo := node.TypeInfo
+				if operandTypeInfo == nil {
+					// ie. CSS values, "base * 2"
+					operandTypeInfo = typeInfo
+				}
+				kind, err := arithmeticKind(t.Kind, operandTypeInfo)
+				if err != nil {
+					emit.AddError(t, errors.CodeTypeMismatch, err)
+					break
+				}
+				emit.markPosition(opcodes, t)
+				opcodes = append(opcodes, bytecode.Code{
+					Kind: kind,
+				})
+			case token.Negate:
+				kind := bytecode.Negate
+				if _, ok := node.TypeInfo.(*types.Float); ok {
+					kind = bytecode.NegateFloat
+				}
+				opcodes = append(opcodes, bytecode.Code{
+					Kind: kind,
+				})
+			case token.ConditionalEqual,
+				token.ConditionalNotEqual,
+				token.LessThan,
+				token.GreaterThan,
+				token.LessThanOrEqual,
+				token.GreaterThanOrEqual,
+				token.Not:
+				kind, err := comparisonKind(t.Kind)
+				if err != nil {
+					emit.internalError(t, "%s", err)
+					break
+				}
 				opcodes = append(opcodes, bytecode.Code{
-					Kind: bytecode.ConditionalEqual,
+					Kind: kind,
 				})
+			case token.ConditionalAnd,
+				token.ConditionalOr:
+				// The result of the right-hand side is the result, so just
+				// set where to jump to if short-circuiting.
+				opcodes[shortCircuitJumps[i]].Value = len(opcodes)
 			case token.Number:
-				switch typeInfo.(type) {
+				numberTypeInfo := node.TypeInfo
+				if numberTypeInfo == nil {
+					numberTypeInfo = typeInfo
+				}
+				if types.IsCSSValue(numberTypeInfo) {
+					// ie. "2" in "base * 2"
+					tokenFloat, err := strconv.ParseFloat(t.String(), 64)
+					if err != nil {
+						emit.internalError(t, "Cannot convert \"%s\" to a number, error: %s", t.String(), err)
+						break
+					}
+					opcodes = append(opcodes, bytecode.Code{
+						Kind:  bytecode.Push,
+						Value: tokenFloat,
+					})
+					break
+				}
+				switch numberTypeInfo.(type) {
 				case *types.Int:
 					tokenString := t.String()
 					if strings.Contains(tokenString, ".") {
-						panic("Cannot add float to int, this should be caught in the type checker.")
+						emit.internalError(t, "Cannot use float \"%s\" as int, this should be caught in the type checker.", tokenString)
+						break
 					}
 					tokenInt, err := strconv.ParseInt(tokenString, 10, 0)
 					if err != nil {
-						panic(fmt.Sprintf("emitExpression:Int:Token: Cannot convert token string to int, error: %s", err))
+						emit.AddError(t, errors.CodeTypeMismatch, fmt.Errorf("Cannot convert \"%s\" to an int, error: %s", tokenString, err))
+						break
 					}
 					opcodes = append(opcodes, bytecode.Code{
 						Kind:  bytecode.Push,
 						Value: tokenInt,
 					})
 				case *types.Float:
-					panic("todo(Jake): Add support for floating point numbers")
-				default:
-					panic(fmt.Sprintf("emitExpression: Type %T cannot push number (\"%s\"), this should be caught by typechecker.", typeInfo, t.String()))
-				}
-			case token.Add:
-				switch typeInfo := topNode.TypeInfo.(type) {
-				case *types.Int:
-					//*types.Float:
-					opcodes = append(opcodes, bytecode.Code{
-						Kind: bytecode.Add,
-					})
-				case *types.String:
+					tokenFloat, err := strconv.ParseFloat(t.String(), 64)
+					if err != nil {
+						emit.AddError(t, errors.CodeTypeMismatch, fmt.Errorf("Cannot convert \"%s\" to a float, error: %s", t.String(), err))
+						break
+					}
 					opcodes = append(opcodes, bytecode.Code{
-						Kind: bytecode.AddString,
+						Kind:  bytecode.Push,
+						Value: tokenFloat,
 					})
 				default:
-					panic(fmt.Sprintf("emitExpression: Type %T does not support \"%s\", this should be caught by typechecker.", typeInfo, t.Kind.String()))
+					emit.internalError(t, "Type %s cannot be the number \"%s\", this should be caught by typechecker.", numberTypeInfo, t.String())
 				}
 			case token.String:
-				_, ok := topNode.TypeInfo.(*types.String)
-				if !ok {
-					panic(fmt.Sprintf("emitExpression: Type %T cannot push string (\"%s\"), this should be caught by typechecker.", typeInfo, t.String()))
-				}
 				opcodes = append(opcodes, bytecode.Code{
 					Kind:  bytecode.Push,
 					Value: t.String(),
@@ -619,16 +988,18 @@ func (emit *Emitter) emitExpression(opcodes []bytecode.Code, topNode *ast.Expres
 					Kind:  bytecode.Push,
 					Value: true,
 				})
+			case token.KeywordNil:
+				opcodes = append(opcodes, bytecode.Code{
+					Kind:  bytecode.Push,
+					Value: nil,
+				})
 			default:
-				panic(fmt.Sprintf("emitExpression:Token: Unhandled token kind: \"%s\", this should be caught by typechecker.", t.Kind.String()))
+				emit.internalError(t, "Unhandled token kind \"%s\" in expression, this should be caught by typechecker.", t.Kind.String())
 			}
 		case *ast.ArrayLiteral:
 			typeInfo := node.TypeInfo.(*types.Array)
 			underlyingTypeInfo := typeInfo.Underlying()
 			nodes := node.Nodes()
-			if len(nodes) == 0 {
-				panic(fmt.Sprintf("emitExpression:ArrayLiteral: Must have at least one item / node. This should be caught by typechecker"))
-			}
 
 			// Get bytecode to append per item in array literal
 			var appendPopArray bytecode.Code
@@ -641,29 +1012,109 @@ func (emit *Emitter) emitExpression(opcodes []bytecode.Code, topNode *ast.Expres
 				appendPopArray = bytecode.Code{
 					Kind: bytecode.AppendPopArrayString,
 				}
+			case *types.Int:
+				opcodes = append(opcodes, bytecode.Code{
+					Kind:  bytecode.PushAllocArrayInt,
+					Value: len(nodes),
+				})
+				appendPopArray = bytecode.Code{
+					Kind: bytecode.AppendPopArrayInt,
+				}
+			case *types.Float:
+				opcodes = append(opcodes, bytecode.Code{
+					Kind:  bytecode.PushAllocArrayFloat,
+					Value: len(nodes),
+				})
+				appendPopArray = bytecode.Code{
+					Kind: bytecode.AppendPopArrayFloat,
+				}
+			case *types.Struct:
+				opcodes = append(opcodes, bytecode.Code{
+					Kind:  bytecode.PushAllocArrayStruct,
+					Value: len(nodes),
+				})
+				appendPopArray = bytecode.Code{
+					Kind: bytecode.AppendPopArrayStruct,
+				}
 			default:
-				panic(fmt.Sprintf("emitExpression:ArrayLiteral: Unhandled type %T", underlyingTypeInfo))
+				t, _ := nodeToken(topNode)
+				emit.AddError(t, errors.CodeUnsupported, fmt.Errorf("Arrays of %s are not supported.", underlyingTypeInfo))
+				continue
 			}
 
 			for _, node := range nodes {
 				node := node.(*ast.Expression)
-				if len(node.Nodes()) == 0 {
-					panic("emitExpression:ArrayLiteral: No nodes in expression, this should be caught by typechecker")
-				}
 				opcodes = emit.emitExpression(opcodes, node)
 				opcodes = append(opcodes, appendPopArray)
 			}
+		case *ast.MapLiteral:
+			opcodes = append(opcodes, bytecode.Code{
+				Kind:  bytecode.PushAllocMap,
+				Value: len(node.Entries),
+			})
+			for i := range node.Entries {
+				entry := &node.Entries[i]
+				opcodes = emit.emitExpression(opcodes, &entry.Key)
+				opcodes = emit.emitExpression(opcodes, &entry.Value)
+				opcodes = append(opcodes, bytecode.Code{
+					Kind: bytecode.StorePopMapElement,
+				})
+			}
+		case *ast.Index:
+			opcodes = emit.emitIndex(opcodes, node)
+		case *ast.Slice:
+			opcodes = emit.emitExpression(opcodes, &node.Value)
+			if len(node.Low.Nodes()) == 0 {
+				opcodes = append(opcodes, bytecode.Code{
+					Kind:  bytecode.Push,
+					Value: int64(0),
+				})
+			} else {
+				opcodes = emit.emitExpression(opcodes, &node.Low)
+			}
+			hasHigh := len(node.High.Nodes()) > 0
+			if hasHigh {
+				opcodes = emit.emitExpression(opcodes, &node.High)
+			}
+			emit.markPosition(opcodes, node.Bracket)
+			opcodes = append(opcodes, bytecode.Code{
+				Kind:  bytecode.PushArraySlice,
+				Value: hasHigh,
+			})
+		case *ast.Selector:
+			opcodes = emit.emitExpression(opcodes, &node.Value)
+			typeInfo := node.Value.TypeInfo
+			for _, name := range node.Fields {
+				structTypeInfo, ok := typeInfo.(*types.Struct)
+				if !ok {
+					emit.internalError(name, "Cannot get property \"%s\" of %s. This should be caught in the typechecker.", name.String(), typeInfo)
+					break
+				}
+				field := structTypeInfo.GetFieldByName(name.String())
+				if field == nil {
+					emit.internalError(name, "\"%s :: struct\" does not have property \"%s\". This should be caught in the typechecker.", structTypeInfo.Name(), name.String())
+					break
+				}
+				opcodes = append(opcodes, bytecode.Code{
+					Kind:  bytecode.ReplaceStructFieldVar,
+					Value: field.Index(),
+				})
+				typeInfo = field.TypeInfo
+			}
 		case *ast.StructLiteral:
 			structLiteral := node
-			structTypeInfo, ok := topNode.TypeInfo.(*types.Struct)
+			// Use the literals type rather than the expressions as
+			// it can be optional, ie. "post : ?Post = Post{}"
+			structTypeInfo, ok := structLiteral.TypeInfo.(*types.Struct)
 			if !ok {
-				panic(fmt.Sprintf("emitExpression: Type %T cannot push struct literal (\"%s\"), this should be caught by typechecker.", typeInfo, structLiteral.Name))
+				emit.internalError(structLiteral.Name, "Type %s cannot be struct literal \"%s\", this should be caught by typechecker.", typeInfo, structLiteral.Name.String())
+				break
 			}
 			if len(structLiteral.Fields) == 0 {
 				// NOTE(Jake) 2017-12-28
 				// If using struct literal syntax "MyStruct{}" without fields, assume all fields
 				// use default values.
-				opcodes = emit.emitNewFromType(opcodes, typeInfo)
+				opcodes = emit.emitNewFromType(opcodes, structLiteral.Name, structTypeInfo)
 			} else {
 				structTypeInfoFields := structTypeInfo.Fields()
 				opcodes = append(opcodes, bytecode.Code{
@@ -682,10 +1133,11 @@ func (emit *Emitter) emitExpression(opcodes []bytecode.Code, topNode *ast.Expres
 						}
 					}
 					if fieldTypeInfo := exprNode.TypeInfo; fieldTypeInfo == nil {
-						panic(fmt.Sprintf("emitExpression: Missing type info on property for \"%s :: struct { %s }\"", structTypeInfo.Name(), structField.Name))
+						emit.internalError(structLiteral.Name, "Missing type info on property for \"%s :: struct { %s }\"", structTypeInfo.Name(), structField.Name)
+						continue
 					}
 					if len(exprNode.Nodes()) == 0 {
-						opcodes = emit.emitNewFromType(opcodes, exprNode.TypeInfo)
+						opcodes = emit.emitNewFromType(opcodes, structLiteral.Name, exprNode.TypeInfo)
 						//panic(fmt.Sprintf("emitExpression:TypeInfo_Struct: Missing value for field \"%s\" on \"%s :: struct\", type checker should enforce that you need all fields.", structField.Name, structDef.Name))
 					} else {
 						opcodes = emit.emitExpression(opcodes, exprNode)
@@ -697,12 +1149,113 @@ func (emit *Emitter) emitExpression(opcodes []bytecode.Code, topNode *ast.Expres
 				}
 			}
 		default:
-			panic(fmt.Sprintf("emitExpression:%T: Unhandled type %T", typeInfo, node))
+			t, ok := nodeToken(node)
+			if !ok {
+				t = emit.statementToken()
+			}
+			emit.internalError(t, "Unhandled %T in expression of type %s.", node, typeInfo)
 		}
 	}
 	return opcodes
 }
 
+// emitIndex emits an index expression, ie. icons["home"]
+func (emit *Emitter) emitIndex(opcodes []bytecode.Code, node *ast.Index) []bytecode.Code {
+	switch typeInfo := node.Value.TypeInfo.(type) {
+	case *types.Map:
+		// Keys that aren't set give the default value, ie. "" for strings
+		opcodes = emit.emitNewFromType(opcodes, node.Bracket, typeInfo.Underlying())
+		opcodes = emit.emitExpression(opcodes, &node.Value)
+		opcodes = emit.emitExpression(opcodes, &node.Index)
+		opcodes = append(opcodes, bytecode.Code{
+			Kind: bytecode.PushMapElement,
+		})
+	case *types.Array:
+		opcodes = emit.emitExpression(opcodes, &node.Value)
+		opcodes = emit.emitExpression(opcodes, &node.Index)
+		emit.markPosition(opcodes, node.Bracket)
+		opcodes = append(opcodes, bytecode.Code{
+			Kind: bytecode.PushArrayElement,
+		})
+	default:
+		emit.internalError(node.Bracket, "Cannot index %s, this should be caught in the typechecker.", typeInfo)
+	}
+	return opcodes
+}
+
+func arithmeticKind(kind token.Kind, typeInfo types.TypeInfo) (bytecode.Kind, error) {
+	if types.IsCSSValue(typeInfo) {
+		return cssArithmeticKind(kind, typeInfo)
+	}
+	switch typeInfo.(type) {
+	case *types.Int:
+		switch kind {
+		case token.Add:
+			return bytecode.Add, nil
+		case token.Subtract:
+			return bytecode.Subtract, nil
+		case token.Multiply:
+			return bytecode.Multiply, nil
+		case token.Divide:
+			return bytecode.Divide, nil
+		case token.Modulo:
+			return bytecode.Modulo, nil
+		}
+	case *types.Float:
+		switch kind {
+		case token.Add:
+			return bytecode.AddFloat, nil
+		case token.Subtract:
+			return bytecode.SubtractFloat, nil
+		case token.Multiply:
+			return bytecode.MultiplyFloat, nil
+		case token.Divide:
+			return bytecode.DivideFloat, nil
+		case token.Modulo:
+			return bytecode.ModuloFloat, nil
+		}
+	case *types.String:
+		if kind == token.Add {
+			return bytecode.AddString, nil
+		}
+	}
+	return bytecode.Unknown, fmt.Errorf("Operator \"%s\" is not supported for type %s.", kind.String(), typeInfo)
+}
+
+func comparisonKind(kind token.Kind) (bytecode.Kind, error) {
+	switch kind {
+	case token.ConditionalEqual:
+		return bytecode.ConditionalEqual, nil
+	case token.ConditionalNotEqual:
+		return bytecode.ConditionalNotEqual, nil
+	case token.LessThan:
+		return bytecode.LessThan, nil
+	case token.GreaterThan:
+		return bytecode.GreaterThan, nil
+	case token.LessThanOrEqual:
+		return bytecode.LessThanOrEqual, nil
+	case token.GreaterThanOrEqual:
+		return bytecode.GreaterThanOrEqual, nil
+	case token.Not:
+		return bytecode.Not, nil
+	}
+	return bytecode.Unknown, fmt.Errorf("Unhandled comparison operator \"%s\".", kind.String())
+}
+
+func cssArithmeticKind(kind token.Kind, typeInfo types.TypeInfo) (bytecode.Kind, error) {
+	switch kind {
+	case token.Add:
+		return bytecode.CSSAdd, nil
+	case token.Subtract:
+		return bytecode.CSSSubtract, nil
+	case token.Multiply:
+		return bytecode.CSSMultiply, nil
+	case token.Divide:
+		return bytecode.CSSDivide, nil
+	}
+	return bytecode.Unknown, fmt.Errorf("Operator \"%s\" is not supported for type %s.", kind.String(), typeInfo)
+}
+
 func (emit *Emitter) emitLeftHandSide(opcodes []bytecode.Code, leftHandSide []ast.Token) []bytecode.Code {
 	name := leftHandSide[0].String()
 	varInfo, ok := emit.scope.Get(name)
@@ -726,6 +1279,8 @@ func (emit *Emitter) emitHTMLComponentDefinition(node *ast.HTMLComponentDefiniti
 	defer func() {
 		emit.EmitterScope = oldEmitterScope
 	}()
+	emit.cssClassNames = newCSSClassNameMap(node.Name, node.CSSDefinition, node.CSSConfigDefinition)
+	emit.htmlContext = true
 
 	opcodes := make([]bytecode.Code, 0, 15)
 	opcodes = append(opcodes, bytecode.Code{
@@ -776,12 +1331,25 @@ func (emit *Emitter) emitHTMLComponentDefinition(node *ast.HTMLComponentDefiniti
 	})
 
 	block := bytecode.NewBlock(node.Name.String(), bytecode.BlockHTMLComponentDefinition)
+	block.Token = node.Name
+	block.Positions = emit.positions
 	block.Opcodes = opcodes
-	block.StackSize = emit.scope.stackPos
+	block.StackSize = emit.StackSize()
 	block.HasReturnValue = true
 	return block
 }
 
+// variableStructTypeInfo gets the struct type of a variable so its fields can
+// be accessed, this includes optional structs as they can be checked against
+// nil, ie. "if post != nil { post.title }"
+func variableStructTypeInfo(typeInfo types.TypeInfo) *types.Struct {
+	if optional, ok := typeInfo.(*types.Optional); ok {
+		typeInfo = optional.Underlying()
+	}
+	structTypeInfo, _ := typeInfo.(*types.Struct)
+	return structTypeInfo
+}
+
 func (emit *Emitter) emitParameter(opcodes []bytecode.Code, name string, typeInfo types.TypeInfo, stackPos int) []bytecode.Code {
 	opcodes = append(opcodes, bytecode.Code{
 		Kind:  bytecode.Store,
@@ -790,8 +1358,8 @@ func (emit *Emitter) emitParameter(opcodes []bytecode.Code, name string, typeInf
 	opcodes = append(opcodes, bytecode.Code{
 		Kind: bytecode.Pop,
 	})
-	structTypeInfo, ok := typeInfo.(*types.Struct)
-	if !ok {
+	structTypeInfo := variableStructTypeInfo(typeInfo)
+	if structTypeInfo == nil {
 		emit.scope.DeclareSet(name, VariableInfo{
 			stackPos: stackPos,
 		})
@@ -805,8 +1373,14 @@ func (emit *Emitter) emitParameter(opcodes []bytecode.Code, name string, typeInf
 	return opcodes
 }
 
-func emitProcedureDefinition(node *ast.ProcedureDefinition) *bytecode.Block {
-	emit := New()
+func (emit *Emitter) emitProcedureDefinition(node *ast.ProcedureDefinition) *bytecode.Block {
+	// Reset scope / html nest variables
+	oldEmitterScope := emit.EmitterScope
+	emit.EmitterScope = EmitterScope{}
+	emit.PushScope()
+	defer func() {
+		emit.EmitterScope = oldEmitterScope
+	}()
 
 	opcodes := make([]bytecode.Code, 0, 35)
 	opcodes = append(opcodes, bytecode.Code{
@@ -825,53 +1399,125 @@ func emitProcedureDefinition(node *ast.ProcedureDefinition) *bytecode.Block {
 		opcodes = emit.emitStatement(opcodes, node)
 	}
 
-	// todo(Jake): If last opcode is not a return statement, add a return statement.
-	lastOpcode := &opcodes[len(opcodes)-1]
-	if lastOpcode.Kind != bytecode.Return {
-		panic("emitProcedureDefinition: Automatically add return")
+	// NOTE: The typechecker ensures every path returns a value if the
+	// procedure has a return type, so this is only reached by void procedures.
+	if lastOpcode := &opcodes[len(opcodes)-1]; lastOpcode.Kind != bytecode.Return {
+		opcodes = append(opcodes, bytecode.Code{
+			Kind: bytecode.Return,
+		})
 	}
 
 	block := bytecode.NewBlock(node.Name.String(), bytecode.BlockProcedure)
+	block.Token = node.Name
+	block.Positions = emit.positions
 	block.Opcodes = opcodes
-	block.StackSize = emit.scope.stackPos
+	block.StackSize = emit.StackSize()
 	block.HasReturnValue = node.TypeInfo != nil
 	return block
 }
 
-func (emit *Emitter) emitCSSSelectors(selectors []ast.CSSSelector) []data.CSSSelector {
-	// NOTE(Jake): 2018-04-19
-	//
-	// Selector data is all built during this emitter step
-	// as I'm fairly certain I will not want interpolation
-	// in CSS classes or any other fancy features.
-	//
-	// The reasoning is that it'd make static analysis of
-	// used CSS rules harder.
-	//
-	// So we'll build the data structures here and pass them off
-	// to some bytecode.
-	//
+// splitCSSIdentifier splits up compound selectors as they're scanned
+// as one identifier. ie. "div.header.is-active" => "div", ".header", ".is-active"
+func splitCSSIdentifier(value string) []string {
+	var result []string
+	for len(value) > 0 {
+		end := strings.IndexAny(value[1:], ".#") + 1
+		if end == 0 {
+			end = len(value)
+		}
+		result = append(result, value[:end])
+		value = value[end:]
+	}
+	return result
+}
+
+// newCSSClassNameMap gets the classes used in a named ":: css" block
+// and maps them to a name unique to the component, ie. "header" => "Header__header".
+// Classes matching a "modify: false" rule in the ":: css_config" are left as-is.
+func newCSSClassNameMap(nameToken token.Token, def *ast.CSSDefinition, cssConfig *ast.CSSConfigDefinition) map[string]string {
+	if def == nil || nameToken.Kind == token.Unknown {
+		return nil
+	}
+	name := nameToken.String()
+	result := make(map[string]string)
+	nodeStack := make([]ast.Node, 0, 10)
+	nodeStack = append(nodeStack, def.Nodes()...)
+	for len(nodeStack) > 0 {
+		node := nodeStack[len(nodeStack)-1]
+		nodeStack = nodeStack[:len(nodeStack)-1]
+		rule, ok := node.(*ast.CSSRule)
+		if !ok {
+			continue
+		}
+		for _, selector := range rule.Selectors() {
+			for _, selectorPartNode := range selector.Nodes() {
+				selectorPartNode, ok := selectorPartNode.(*ast.Token)
+				if !ok || selectorPartNode.Kind != token.Identifier {
+					continue
+				}
+				for _, part := range splitCSSIdentifier(selectorPartNode.String()) {
+					if part[0] != '.' {
+						continue
+					}
+					if config := cssConfig.GetSettings(part); !config.Modify {
+						continue
+					}
+					className := part[1:]
+					result[className] = name + "__" + className
+				}
+			}
+		}
+		nodeStack = append(nodeStack, rule.Nodes()...)
+	}
+	return result
+}
+
+func (emit *Emitter) emitCSSSelectors(selectors []ast.CSSSelector, parentSelectors []data.CSSSelector) []data.CSSSelector {
+	// NOTE(Jake): 2018-04-19
+	//
+	// Selector data is all built during this emitter step
+	// as I'm fairly certain I will not want interpolation
+	// in CSS classes or any other fancy features.
+	//
+	// The reasoning is that it'd make static analysis of
+	// used CSS rules harder.
+	//
+	// So we'll build the data structures here and pass them off
+	// to some bytecode.
+	//
 	resultSelectors := make([]data.CSSSelector, 0, 10)
 	for _, selector := range selectors {
 		selectorPartNodes := selector.Nodes()
 		selector := data.NewCSSSelector(len(selectorPartNodes))
+		var parentReference *ast.Token
 		for _, selectorPartNode := range selectorPartNodes {
 			switch selectorPartNode := selectorPartNode.(type) {
 			case *ast.Token:
 				value := selectorPartNode.String()
 				switch selectorPartNode.Kind {
 				case token.Identifier:
-					switch value[0] {
-					case '.':
-						selector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindClass, value))
-					case '#':
-						selector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindID, value))
-					default:
-						selector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindTag, value))
+					// Compound selectors are scanned as one identifier,
+					// so split them up. ie. "div.header.is-active"
+					for _, part := range splitCSSIdentifier(value) {
+						switch part[0] {
+						case '.':
+							if scopedClassName, ok := emit.cssClassNames[part[1:]]; ok {
+								part = "." + scopedClassName
+							}
+							selector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindClass, part))
+						case '#':
+							selector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindID, part))
+						default:
+							selector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindTag, part))
+						}
 					}
+				case token.And: // &
+					// Placeholder for the parent selector, replaced in appendNestedCSSSelectors()
+					selector.AddPart(nil)
+					parentReference = selectorPartNode
 				case token.AtKeyword:
 					selector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindAtKeyword, value))
-				case token.Number:
+				case token.Number, token.NumberWithUnit:
 					selector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindNumber, value))
 				case token.Colon: // :
 					selector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindColon, value))
@@ -886,15 +1532,7 @@ func (emit *Emitter) emitCSSSelectors(selectors []ast.CSSSelector) []data.CSSSel
 				case token.Tilde: // ~
 					selector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindSibling, value))
 				default:
-					if selectorPartNode.IsOperator() {
-						panic("todo(Jake): Fixme (or add support for operator in above `switch`)")
-						// 	selectorPartString := selectorPartNode.String()
-						// 	selectorList = append(selectorList, data.CSSSelectorOperator{
-						// 		Operator: selectorPartString,
-						// 	})
-						// 	continue
-					}
-					panic(fmt.Sprintf("emitCSSRule(): Unhandled selector part kind: %s", selectorPartNode.Kind.String()))
+					emit.AddError(selectorPartNode.Token, errors.CodeUnsupported, fmt.Errorf("Cannot use %s in a CSS selector.", selectorPartNode.Kind.String()))
 				}
 			case *ast.CSSAttributeSelector:
 				if hasValueSet := selectorPartNode.Operator.Kind != 0; hasValueSet {
@@ -913,95 +1551,181 @@ func (emit *Emitter) emitCSSSelectors(selectors []ast.CSSSelector) []data.CSSSel
 					"",
 				))
 			case *ast.CSSSelector:
-				// todo(Jake)
-				panic(fmt.Sprintf("todo(Jake): Fix this, %v", selectorPartNode.Nodes()))
-			//subSelectorList := program.evaluateSelector(selectorPartNode.Nodes())
-			//selectorList = append(selectorList, subSelectorList)
-
-			//for _, token := range selectorPartNode.ChildNodes {
-			//	value += token.String() + " "
-			//}
-			//value = value[:len(value)-1]
+				// Handle "(max-width: 600px)" in at-rules and ":not(.a)"
+				selector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindParenthesis, emit.cssParenthesisString(selectorPartNode)))
 			default:
-				panic(fmt.Sprintf("emitCSSRule(): Unhandled selector type: %T", selectorPartNode))
+				emit.internalError(emit.statementToken(), "Unhandled %T in CSS selector.", selectorPartNode)
 			}
 		}
-		resultSelectors = append(resultSelectors, selector)
+		if parentReference != nil && len(parentSelectors) == 0 {
+			emit.internalError(parentReference.Token, "Cannot use & outside of a nested CSS rule. This should be caught in the typechecker.")
+			continue
+		}
+		resultSelectors = appendNestedCSSSelectors(resultSelectors, parentSelectors, selector)
 	}
 	return resultSelectors
 }
 
+// appendNestedCSSSelectors combines the selector with its parent rules selectors,
+// ie. ".header { &.is-active {} .title {} }" becomes ".header.is-active" and ".header .title"
+func appendNestedCSSSelectors(resultSelectors []data.CSSSelector, parentSelectors []data.CSSSelector, selector data.CSSSelector) []data.CSSSelector {
+	hasParentReference := false
+	for _, part := range selector {
+		if part == nil {
+			hasParentReference = true
+			break
+		}
+	}
+	if len(parentSelectors) == 0 {
+		// Selectors using & without a parent are caught in emitCSSSelectors()
+		return append(resultSelectors, selector)
+	}
+	if !hasParentReference {
+		for _, parentSelector := range parentSelectors {
+			newSelector := data.NewCSSSelector(len(parentSelector) + 1 + len(selector))
+			newSelector = append(newSelector, parentSelector...)
+			newSelector.AddPart(data.NewCSSSelectorPart(data.SelectorPartKindAncestor, " "))
+			newSelector = append(newSelector, selector...)
+			resultSelectors = append(resultSelectors, newSelector)
+		}
+		return resultSelectors
+	}
+
+	// Each & is replaced with every parent selector, so
+	// ".a, .b { & + & {} }" creates 4 selectors.
+	newSelectors := []data.CSSSelector{data.NewCSSSelector(len(selector))}
+	for _, part := range selector {
+		if part != nil {
+			for i := range newSelectors {
+				newSelectors[i].AddPart(part)
+			}
+			continue
+		}
+		expandedSelectors := make([]data.CSSSelector, 0, len(newSelectors)*len(parentSelectors))
+		for _, newSelector := range newSelectors {
+			for _, parentSelector := range parentSelectors {
+				expandedSelector := data.NewCSSSelector(len(newSelector) + len(parentSelector) + len(selector))
+				expandedSelector = append(expandedSelector, newSelector...)
+				expandedSelector = append(expandedSelector, parentSelector...)
+				expandedSelectors = append(expandedSelectors, expandedSelector)
+			}
+		}
+		newSelectors = expandedSelectors
+	}
+	return append(resultSelectors, newSelectors...)
+}
+
 func (emit *Emitter) emitCSSRule(opcodes []bytecode.Code, node *ast.CSSRule) []bytecode.Code {
 	emit.PushScope()
 	defer emit.PopScope()
 
-	// todo(Jake): Track cssRule depth in emitter and handle nested cases below
-	/*switch node.Kind() {
+	// Nested rules are flattened, so they're emitted after the
+	// parent rule has been popped and get added to the CSS definition
+	// (or wrapping @media rule) rather than the parent rule.
+	parentSelectors := emit.cssParentSelectors
+	nestedRules := make([]*ast.CSSRule, 0, 5)
+
+	switch node.Kind() {
 	case ast.CSSKindRule:
-		// Nested selectors
-		panic("Nested selectors are not allowed. This should be caught by the typechecker.")
+		selectors := emit.emitCSSSelectors(node.Selectors(), parentSelectors)
+		opcodes = append(opcodes, bytecode.Code{
+			Kind:  bytecode.PushAllocCSSRule,
+			Value: data.NewCSSRule(selectors),
+		})
+		opcodes, nestedRules = emit.emitCSSRuleStatements(opcodes, node, nestedRules)
+		opcodes = append(opcodes, bytecode.Code{
+			Kind: bytecode.Pop,
+		})
+
+		emit.cssParentSelectors = selectors
+		for _, node := range nestedRules {
+			opcodes = emit.emitCSSRule(opcodes, node)
+		}
+		emit.cssParentSelectors = parentSelectors
 	case ast.CSSKindAtKeyword:
-		panic("todo(Jake): Handle @media nested selector")
-		// Setup rule node
-		selectors := emit.emitCSSSelectors(node.Selectors())
-		mediaRuleNode := data.NewCSSRule(selectors)
-		mediaRuleNode.AddRule(resultRule)
-
-		// Get parent selector
-		//for _, parentSelectorListNode := range parentCSSRule.Selectors {
-		//	selectorList := make(data.CSSSelector, 0, len(parentSelectorListNode))
-		//	selectorList = append(selectorList, parentSelectorListNode...)
-		//	ruleNode.Selectors = append(ruleNode.Selectors, selectorList)
-		//}
-		//mediaRuleNode.Rules = append(mediaRuleNode.Rules, ruleNode)
-
-		// Become the wrapping @media query
-		resultRule = mediaRuleNode
+		opcodes = append(opcodes, bytecode.Code{
+			Kind:  bytecode.PushAllocCSSRule,
+			Value: data.NewCSSRule(emit.emitCSSSelectors(node.Selectors(), nil)),
+		})
+		hasProperties := false
+		for _, node := range node.Nodes() {
+			if _, ok := node.(*ast.CSSRule); !ok {
+				hasProperties = true
+				break
+			}
+		}
+		if len(parentSelectors) == 0 || !hasProperties {
+			// ie. "@font-face { font-family: ... }"
+			opcodes, nestedRules = emit.emitCSSRuleStatements(opcodes, node, nestedRules)
+		} else {
+			// Bubble @media up and around the parent rule, ie.
+			// ".header { @media (x) { color: red; } }" becomes
+			// "@media (x) { .header { color: red; } }"
+			opcodes = append(opcodes, bytecode.Code{
+				Kind:  bytecode.PushAllocCSSRule,
+				Value: data.NewCSSRule(parentSelectors),
+			})
+			opcodes, nestedRules = emit.emitCSSRuleStatements(opcodes, node, nestedRules)
+			opcodes = append(opcodes, bytecode.Code{
+				Kind: bytecode.Pop,
+			})
+		}
+		for _, node := range nestedRules {
+			opcodes = emit.emitCSSRule(opcodes, node)
+		}
+		opcodes = append(opcodes, bytecode.Code{
+			Kind: bytecode.Pop,
+		})
 	default:
-		panic("emitCSSRule(): Unhandled CSSType.")
-	}*/
-
-	resultRule := data.NewCSSRule(emit.emitCSSSelectors(node.Selectors()))
-	opcodes = append(opcodes, bytecode.Code{
-		Kind:  bytecode.PushAllocCSSRule,
-		Value: resultRule,
-	})
+		emit.internalError(emit.statementToken(), "Unhandled CSS rule kind: %v", node.Kind())
+	}
+	return opcodes
+}
 
-	// Attach CSS properties
+// emitCSSRuleStatements emits the properties and declarations of a rule
+// and returns nested rules to be emitted after it.
+func (emit *Emitter) emitCSSRuleStatements(opcodes []bytecode.Code, node *ast.CSSRule, nestedRules []*ast.CSSRule) ([]bytecode.Code, []*ast.CSSRule) {
 	for _, node := range node.Nodes() {
+		if node, ok := node.(*ast.CSSRule); ok {
+			nestedRules = append(nestedRules, node)
+			continue
+		}
 		opcodes = emit.emitStatement(opcodes, node)
 	}
-
-	opcodes = append(opcodes, bytecode.Code{
-		Kind: bytecode.Pop,
-	})
-
-	//panic("todo(Jake): handle css rule emit")
-	return opcodes
+	return opcodes, nestedRules
 }
 
 func (emit *Emitter) emitGlobalScope(node ast.Node) {
 	switch node := node.(type) {
 	case *ast.WorkspaceDefinition:
-		block := emitWorkspaceDefinition(node)
+		defer emit.recoverInternalError(node.Name)
+		block := emit.emitWorkspaceDefinition(node)
 		emit.registerWorkspace(block)
 	case *ast.ProcedureDefinition:
-		block := emitProcedureDefinition(node)
+		defer emit.recoverInternalError(node.Name)
+		block := emit.emitProcedureDefinition(node)
 		ok := emit.registerSymbol(node.Name.String(), block)
 		if !ok {
-			panic(fmt.Sprintf("Procedure name %s is used already. This should be caught in the typechecker.", node.Name.String()))
+			emit.internalError(node.Name, "Procedure name %s is used already. This should be caught in the typechecker.", node.Name.String())
 		}
 	case *ast.HTMLComponentDefinition:
+		defer emit.recoverInternalError(node.Name)
 		block := emit.emitHTMLComponentDefinition(node)
 		ok := emit.registerSymbol(node.Name.String(), block)
 		if !ok {
-			panic(fmt.Sprintf("HTML Component name %s is used already. This should be caught in the typechecker.", node.Name.String()))
+			emit.internalError(node.Name, "HTML Component name %s is used already. This should be caught in the typechecker.", node.Name.String())
 		}
 	}
 }
 
-func emitWorkspaceDefinition(node *ast.WorkspaceDefinition) *bytecode.Block {
-	emit := New()
+func (emit *Emitter) emitWorkspaceDefinition(node *ast.WorkspaceDefinition) *bytecode.Block {
+	// Reset scope / html nest variables
+	oldEmitterScope := emit.EmitterScope
+	emit.EmitterScope = EmitterScope{}
+	emit.PushScope()
+	defer func() {
+		emit.EmitterScope = oldEmitterScope
+	}()
 	structTypeInfo := node.WorkspaceTypeInfo.(*types.Struct)
 	//emit.PushScope()
 	//defer emit.PopScope()
@@ -1016,7 +1740,7 @@ func emitWorkspaceDefinition(node *ast.WorkspaceDefinition) *bytecode.Block {
 			structTypeInfo: structTypeInfo,
 			stackPos:       workspaceStackPos,
 		})
-		opcodes = emit.emitNewFromType(opcodes, structTypeInfo)
+		opcodes = emit.emitNewFromType(opcodes, node.Name, structTypeInfo)
 		opcodes = append(opcodes, bytecode.Code{
 			Kind:  bytecode.Store,
 			Value: workspaceStackPos,
@@ -1057,58 +1781,94 @@ func emitWorkspaceDefinition(node *ast.WorkspaceDefinition) *bytecode.Block {
 	}
 
 	block := bytecode.NewBlock(node.Name.String(), bytecode.BlockWorkspaceDefinition)
+	block.Token = node.Name
+	block.Positions = emit.positions
 	block.Opcodes = opcodes
-	block.StackSize = emit.scope.stackPos
+	block.StackSize = emit.StackSize()
 	block.HasReturnValue = true
 	return block
 }
 
-func (emit *Emitter) emitCSSDefinition(opcodes []bytecode.Code, definition *ast.CSSDefinition) []bytecode.Code {
-	for _, node := range definition.Nodes() {
-		switch node := node.(type) {
-		case *ast.CSSRule:
-			/*var emptyTypeData *data.CSSDefinition
-			internalType := reflect.TypeOf(emptyTypeData)
-			code := bytecode.Init(bytecode.PushAllocInternalStruct)
-			code.Value = internalType
-			opcodes = append(opcodes, code)
-			{
-				fieldMeta, ok := internalType.FieldByName("Name")
-				if !ok {
-					panic("Cannot find \"Name\".")
-				}
-				code := bytecode.Init(bytecode.StoreInternalStructField)
-				code.Value = fieldMeta
-				opcodes = append(opcodes, code)
-			}*/
-			// no-op
-			//debugOpcodes(opcodes)
-			panic("todo(Jake): *ast.CSSRule")
-		case *ast.CSSProperty:
-			panic("todo(Jake): *ast.CSSProperty" + node.Name.String())
-		}
-	}
-	return opcodes
-}
-
 func (emit *Emitter) emitCSSProperty(opcodes []bytecode.Code, property *ast.CSSProperty) []bytecode.Code {
-	for _, node := range property.Nodes() {
-		switch node := node.(type) {
+	// Values with multiple parts (ie. "0 auto", "url("font.woff")") are
+	// concatenated into a single string. Constant parts are joined
+	// here rather than at runtime.
+	parts := emit.appendCSSValueParts(nil, property.Nodes())
+	if len(parts) == 0 {
+		opcodes = append(opcodes, bytecode.Code{
+			Kind:  bytecode.Push,
+			Value: "",
+		})
+	}
+	for i, part := range parts {
+		switch part := part.(type) {
+		case string:
+			opcodes = append(opcodes, bytecode.Code{
+				Kind:  bytecode.Push,
+				Value: part,
+			})
+		case *ast.Expression:
+			// ie. "padding: base * 2"
+			opcodes = emit.emitExpression(opcodes, part)
 		case *ast.TokenList:
-			opcodes, _ = emit.emitVariableIdentWithProperty(opcodes, node.Tokens())
+			opcodes, _ = emit.emitVariableIdentWithProperty(opcodes, part.Tokens())
+		case *ast.Token:
+			opcodes = emit.emitVariableIdent(opcodes, part.Token)
 		case *ast.Call:
-			switch node.Kind() {
+			switch part.Kind() {
 			case ast.CallProcedure:
-				opcodes = emit.emitProcedureCall(opcodes, node)
+				opcodes = emit.emitProcedureCall(opcodes, part)
 			case ast.CallHTMLNode:
-				if len(node.Nodes()) > 0 {
-					panic("emitCSSProperty: Cannot have sub-elements for HTMLNode in an expression.")
-				}
-				panic("emitCSSProperty: Cannot use HTMLNode in expression currently.")
-				//opcodes = emit.emitHTMLNode(opcodes, node)
+				emit.AddError(part.Name, errors.CodeUnsupported, fmt.Errorf("Cannot use HTML node \"%s\" in a CSS property.", part.Name.String()))
 			default:
-				panic(fmt.Errorf("emitCSSProperty: Unhandled *ast.Call kind: %s", node.Name))
+				emit.internalError(part.Name, "Unhandled call kind for \"%s\".", part.Name.String())
+			}
+		default:
+			emit.internalError(property.Name, "Unhandled %T in CSS property.", part)
+		}
+		if _, ok := part.(string); !ok {
+			opcodes = append(opcodes, bytecode.Code{
+				Kind: bytecode.CastToCSSString,
+			})
+		}
+		if i > 0 {
+			opcodes = append(opcodes, bytecode.Code{
+				Kind: bytecode.AddString,
+			})
+		}
+	}
+
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.AppendCSSPropertyToCSSRule,
+		Value: property.Name.String(),
+	})
+
+	return opcodes
+}
+
+// appendCSSValueParts flattens CSS value nodes into constant strings
+// and nodes that need to be evaluated, joining adjacent strings.
+func (emit *Emitter) appendCSSValueParts(parts []interface{}, nodes []ast.Node) []interface{} {
+	appendString := func(value string) {
+		if len(parts) > 0 {
+			if lastValue, ok := parts[len(parts)-1].(string); ok {
+				parts[len(parts)-1] = lastValue + value
+				return
 			}
+		}
+		parts = append(parts, value)
+	}
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *ast.TokenList,
+			*ast.Call,
+			*ast.Expression:
+			parts = append(parts, node)
+		case *ast.CSSSelector:
+			// ie. "url("font.woff")", "rotate(45deg)"
+			appendString("(")
+			parts = emit.appendCSSValueParts(parts, node.Nodes())
+			appendString(")")
 		case *ast.Token:
 			t := node.Token
 			switch t.Kind {
@@ -1118,39 +1878,89 @@ func (emit *Emitter) emitCSSProperty(opcodes []bytecode.Code, property *ast.CSSP
 				// Use a variable if it's defined, otherwise print
 				// out the raw identifier.
 				//
-				name := t.String()
-				_, ok := emit.scope.Get(name)
-				if ok {
-					opcodes = emit.emitVariableIdent(opcodes, t)
+				if _, ok := emit.scope.Get(t.String()); ok {
+					parts = append(parts, node)
 					break
 				}
-				opcodes = append(opcodes, bytecode.Code{
-					Kind:  bytecode.Push,
-					Value: t.String(),
-				})
-			case token.Number,
-				token.String:
-				opcodes = append(opcodes, bytecode.Code{
-					Kind:  bytecode.Push,
-					Value: t.String(),
-				})
-			default: // ie. number, string
-				panic(fmt.Sprintf("emitCSSProperty: Unhandled token kind: %s", node.Kind.String()))
+				appendString(t.String())
+			case token.String:
+				appendString("\"" + t.String() + "\"")
+			case token.Colon:
+				appendString(": ")
+			default:
+				appendString(emit.cssTokenString(t))
 			}
 		default:
-			panic(fmt.Sprintf("emitCSSProperty: Unhandled type: %T", node))
+			emit.internalError(emit.statementToken(), "Unhandled %T in CSS value.", node)
 		}
 	}
+	return parts
+}
 
-	opcodes = append(opcodes, bytecode.Code{
-		Kind:  bytecode.AppendCSSPropertyToCSSRule,
-		Value: property.Name.String(),
-	})
+// cssParenthesisString returns the contents of a parenthesized
+// at-rule condition, ie. "max-width: 600px"
+func (emit *Emitter) cssParenthesisString(node *ast.CSSSelector) string {
+	result := ""
+	for _, node := range node.Nodes() {
+		switch node := node.(type) {
+		case *ast.CSSSelector:
+			result += "(" + emit.cssParenthesisString(node) + ")"
+		case *ast.Token:
+			switch node.Kind {
+			case token.String:
+				result += "\"" + node.String() + "\""
+			case token.Colon:
+				result += ": "
+			default:
+				result += emit.cssTokenString(node.Token)
+			}
+		default:
+			emit.internalError(emit.statementToken(), "Unhandled %T in CSS parenthesis.", node)
+		}
+	}
+	return result
+}
 
-	return opcodes
+func (emit *Emitter) cssTokenString(t token.Token) string {
+	switch t.Kind {
+	case token.Identifier,
+		token.Number,
+		token.NumberWithUnit,
+		token.AtKeyword,
+		token.KeywordTrue,
+		token.KeywordFalse,
+		token.KeywordNil:
+		return t.String()
+	case token.Whitespace:
+		return " "
+	case token.Comma:
+		return ", "
+	case token.Multiply,
+		token.Add,
+		token.Subtract,
+		token.Divide,
+		token.Tilde,
+		token.GreaterThan,
+		token.DoubleColon,
+		token.And:
+		return t.Kind.String()
+	}
+	emit.AddError(t, errors.CodeUnsupported, fmt.Errorf("Cannot use %s in CSS.", t.Kind.String()))
+	return ""
 }
 
 func (emit *Emitter) emitStatement(opcodes []bytecode.Code, node ast.Node) []bytecode.Code {
+	if t, ok := nodeToken(node); ok {
+		emit.markPosition(opcodes, t)
+		emit.statementTokens = append(emit.statementTokens, t)
+		defer func() {
+			emit.statementTokens = emit.statementTokens[:len(emit.statementTokens)-1]
+			if len(emit.statementTokens) > 0 {
+				// Opcodes after a nested statement belong to the parent again
+				emit.markPosition(opcodes, emit.statementTokens[len(emit.statementTokens)-1])
+			}
+		}()
+	}
 	switch node := node.(type) {
 	case *ast.Block:
 		emit.PushScope()
@@ -1171,13 +1981,19 @@ func (emit *Emitter) emitStatement(opcodes []bytecode.Code, node ast.Node) []byt
 			Kind:  bytecode.Label,
 			Value: "DeclareStatement",
 		})
-		opcodes = emit.emitExpression(opcodes, &node.Expression)
 		typeInfo := node.Expression.TypeInfo
+		if len(node.Expression.Nodes()) == 0 {
+			// ie. "items : []string"
+			opcodes = emit.emitNewFromType(opcodes, node.Name, typeInfo)
+		} else {
+			opcodes = emit.emitExpression(opcodes, &node.Expression)
+		}
 
 		nameString := node.Name.String()
 		_, ok := emit.scope.GetThisScope(nameString)
 		if ok {
-			panic(fmt.Sprintf("Redeclared \"%s\" in same scope, this should be caught in the type checker.", nameString))
+			emit.AddError(node.Name, errors.CodeRedeclared, fmt.Errorf("Redeclared \"%s\" in same scope.", nameString))
+			break
 		}
 
 		opcodes = append(opcodes, bytecode.Code{
@@ -1189,14 +2005,10 @@ func (emit *Emitter) emitStatement(opcodes []bytecode.Code, node ast.Node) []byt
 		})
 
 		{
-			var varStructTypeInfo *types.Struct = nil
-			if typeInfo, ok := typeInfo.(*types.Struct); ok {
-				varStructTypeInfo = typeInfo
-			}
 			emit.scope.DeclareSet(nameString, VariableInfo{
 				kind:           VariableStruct,
 				stackPos:       emit.scope.stackPos,
-				structTypeInfo: varStructTypeInfo,
+				structTypeInfo: variableStructTypeInfo(typeInfo),
 			})
 			emit.scope.stackPos++
 		}
@@ -1207,7 +2019,7 @@ func (emit *Emitter) emitStatement(opcodes []bytecode.Code, node ast.Node) []byt
 		// is not a ":: html" definition.
 		//
 		switch typeInfo := node.TypeInfo.(type) {
-		case *types.String:
+		case *types.String, *types.RawHTML:
 			opcodes = emit.emitExpression(opcodes, node)
 			opcodes = append(opcodes, bytecode.Code{
 				Kind: bytecode.CastToHTMLText,
@@ -1220,28 +2032,65 @@ func (emit *Emitter) emitStatement(opcodes []bytecode.Code, node ast.Node) []byt
 			opcodes = append(opcodes, bytecode.Code{
 				Kind: bytecode.AppendPopHTMLElementToHTMLElement,
 			})
+		case *types.Optional:
+			t, _ := nodeToken(node)
+			emit.internalError(t, "Cannot output %s as HTML as it can be nil. This should be caught in the typechecker.", typeInfo)
+		case nil:
+			// ie. a call to a procedure without a return type
+			t, _ := nodeToken(node)
+			emit.AddError(t, errors.CodeUnsupported, fmt.Errorf("Cannot output an expression without a value as HTML."))
 		default:
-			panic(fmt.Sprintf("emitStatement:Expression: Unhandled type %T", typeInfo))
+			t, _ := nodeToken(node)
+			emit.AddError(t, errors.CodeUnsupported, fmt.Errorf("Cannot output %s as HTML.", typeInfo))
 		}
 	case *ast.Call:
 		switch node.Kind() {
 		case ast.CallProcedure:
+			if _, ok := node.TypeConversion.(*types.RawHTML); ok {
+				// rawhtml("<b>Bold</b>")
+				opcodes = emit.emitTypeConversion(opcodes, node)
+				opcodes = append(opcodes, bytecode.Code{
+					Kind: bytecode.CastToHTMLText,
+				})
+				opcodes = append(opcodes, bytecode.Code{
+					Kind: bytecode.AppendPopHTMLElementToHTMLElement,
+				})
+				break
+			}
+			resultTypeInfo := callResultType(node)
+			if emit.htmlContext && resultTypeInfo != nil {
+				// ie. "span { formatPrice(5) }" outputs the result like
+				// any other expression.
+				expression := &ast.Expression{
+					TypeInfo: resultTypeInfo,
+				}
+				expression.ChildNodes = []ast.Node{node}
+				opcodes = emit.emitStatement(opcodes, expression)
+				break
+			}
 			opcodes = emit.emitProcedureCall(opcodes, node)
 			// NOTE(Jake): 2018-02-17
 			//
 			// Since the context of this is simply a statement
 			// we just pop the return value. (if there is one)
 			//
-			resultTypeInfo := node.Definition.TypeInfo
 			if resultTypeInfo != nil {
 				opcodes = append(opcodes, bytecode.Code{
 					Kind: bytecode.Pop,
 				})
 			}
 		case ast.CallHTMLNode:
+			if len(node.IfExpression.Nodes()) > 0 {
+				// ie. "div if isBlue { } else { }"
+				htmlNode := *node
+				htmlNode.IfExpression = ast.Expression{}
+				htmlNode.ElseNodes = nil
+				opcodes = emit.emitIf(opcodes, &node.IfExpression, []ast.Node{&htmlNode}, node.ElseNodes)
+				break
+			}
 			opcodes = emit.emitHTMLNode(opcodes, node)
 		default:
-			panic(fmt.Errorf("emitExpression: Unhandled *ast.Call kind: %s", node.Name))
+			emit.internalError(node.Name, "Unhandled call kind for \"%s\".", node.Name.String())
 		}
 	case *ast.ArrayAppendStatement:
 		leftHandSide := node.LeftHandSide
@@ -1264,8 +2113,21 @@ func (emit *Emitter) emitStatement(opcodes []bytecode.Code, node ast.Node) []byt
 			opcodes = append(opcodes, bytecode.Code{
 				Kind: bytecode.AppendPopArrayString,
 			})
+		case *types.Int:
+			opcodes = append(opcodes, bytecode.Code{
+				Kind: bytecode.AppendPopArrayInt,
+			})
+		case *types.Float:
+			opcodes = append(opcodes, bytecode.Code{
+				Kind: bytecode.AppendPopArrayFloat,
+			})
+		case *types.Struct:
+			opcodes = append(opcodes, bytecode.Code{
+				Kind: bytecode.AppendPopArrayStruct,
+			})
 		default:
-			panic(fmt.Errorf("emitExpression:ArrayAppend: Unhandled kind: %s", typeInfo))
+			emit.AddError(leftHandSide[0], errors.CodeUnsupported, fmt.Errorf("Cannot append %s to an array.", typeInfo))
+			return opcodes
 		}
 		if len(leftHandSide) > 1 {
 			opcodes = append(opcodes, bytecode.Code{
@@ -1284,6 +2146,29 @@ func (emit *Emitter) emitStatement(opcodes []bytecode.Code, node ast.Node) []byt
 		opcodes = append(opcodes, bytecode.Code{
 			Kind: bytecode.Pop,
 		})
+	case *ast.IndexAssignStatement:
+		index := node.Index
+		opcodes = emit.emitExpression(opcodes, &index.Value)
+		opcodes = emit.emitExpression(opcodes, &index.Index)
+		opcodes = emit.emitExpression(opcodes, &node.Expression)
+		emit.markPosition(opcodes, index.Bracket)
+		switch typeInfo := index.Value.TypeInfo.(type) {
+		case *types.Array:
+			opcodes = append(opcodes, bytecode.Code{
+				Kind: bytecode.StorePopArrayElement,
+			})
+		case *types.Map:
+			opcodes = append(opcodes, bytecode.Code{
+				Kind: bytecode.StorePopMapElement,
+			})
+		default:
+			emit.internalError(index.Bracket, "Cannot assign to an element of %s, this should be caught in the typechecker.", typeInfo)
+			return opcodes
+		}
+		// Pop the array or map
+		opcodes = append(opcodes, bytecode.Code{
+			Kind: bytecode.Pop,
+		})
 	case *ast.OpStatement:
 		opcodes = append(opcodes, bytecode.Code{
 			Kind:  bytecode.Label,
@@ -1317,22 +2202,17 @@ func (emit *Emitter) emitStatement(opcodes []bytecode.Code, node ast.Node) []byt
 		}
 
 		opcodes = emit.emitExpression(opcodes, &node.Expression)
-		switch node.Operator.Kind {
-		case token.Equal:
-			// no-op
-		case token.AddEqual:
-			switch node.TypeInfo.(type) {
-			case *types.String:
-				opcodes = append(opcodes, bytecode.Code{
-					Kind: bytecode.AddString,
-				})
-			default:
-				opcodes = append(opcodes, bytecode.Code{
-					Kind: bytecode.Add,
-				})
+		if operatorKind := node.Operator.Kind.AssignOperator(); operatorKind != token.Unknown {
+			// ie. "+=" uses the same opcode as "+"
+			kind, err := arithmeticKind(operatorKind, node.TypeInfo)
+			if err != nil {
+				emit.AddError(node.Operator, errors.CodeUnsupported, err)
+				return opcodes
 			}
-		default:
-			panic(fmt.Sprintf("emitStatement: Unhandled operator kind: %s", node.Operator.Kind.String()))
+			emit.markPosition(opcodes, node.Operator)
+			opcodes = append(opcodes, bytecode.Code{
+				Kind: kind,
+			})
 		}
 
 		if len(leftHandSide) > 1 {
@@ -1360,38 +2240,235 @@ func (emit *Emitter) emitStatement(opcodes []bytecode.Code, node ast.Node) []byt
 			Kind: bytecode.Return,
 		})
 	case *ast.If:
-		originalOpcodesLength := len(opcodes)
+		opcodes = emit.emitIf(opcodes, &node.Condition, node.Nodes(), node.ElseNodes)
+	case *ast.For:
+		opcodes = emit.emitFor(opcodes, node)
+	case *ast.HTMLComponentDefinition:
+		//panic(fmt.Sprintf("emitStatement: Todo HTMLComponentDef"))
+	case *ast.StructDefinition,
+		*ast.CSSConfigDefinition:
+		break
+	default:
+		emit.internalError(emit.statementToken(), "Unhandled statement %T.", node)
+	}
+	return opcodes
+}
 
-		opcodes = emit.emitExpression(opcodes, &node.Condition)
+// emitIf emits an if-statement, else-if chains are an *ast.If as the only else node.
+func (emit *Emitter) emitIf(opcodes []bytecode.Code, condition *ast.Expression, nodes []ast.Node, elseNodes []ast.Node) []bytecode.Code {
+	originalOpcodesLength := len(opcodes)
 
-		var jumpCodeOffset int
-		{
-			jumpCodeOffset = len(opcodes)
-			opcodes = append(opcodes, bytecode.Code{
-				Kind: bytecode.JumpIfFalse,
-			})
-		}
+	opcodes = emit.emitExpression(opcodes, condition)
 
-		// Generate bytecode
-		beforeIfStatementCount := len(opcodes)
-		nodes := node.Nodes()
-		for _, node := range nodes {
-			opcodes = emit.emitStatement(opcodes, node)
-		}
+	var jumpCodeOffset int
+	{
+		jumpCodeOffset = len(opcodes)
+		opcodes = append(opcodes, bytecode.Code{
+			Kind: bytecode.JumpIfFalse,
+		})
+	}
 
+	// Generate bytecode
+	beforeIfStatementCount := len(opcodes)
+	emit.PushScope()
+	for _, node := range nodes {
+		opcodes = emit.emitStatement(opcodes, node)
+	}
+	emit.PopScope()
+
+	if len(elseNodes) == 0 {
 		if beforeIfStatementCount == len(opcodes) {
 			// Dont output any bytecode for an empty `if`
 			opcodes = opcodes[:originalOpcodesLength] // Remove if statement
-			break
+			return opcodes
 		}
 		opcodes[jumpCodeOffset].Value = len(opcodes)
-	case *ast.HTMLComponentDefinition:
-		//panic(fmt.Sprintf("emitStatement: Todo HTMLComponentDef"))
-	case *ast.StructDefinition,
-		*ast.CSSConfigDefinition:
-		break
-	default:
-		panic(fmt.Sprintf("emitStatement: Unhandled type %T", node))
+		return opcodes
+	}
+
+	// Skip over else nodes if condition was true
+	var jumpToEndCodeOffset int
+	{
+		jumpToEndCodeOffset = len(opcodes)
+		opcodes = append(opcodes, bytecode.Code{
+			Kind: bytecode.Jump,
+		})
+	}
+	opcodes[jumpCodeOffset].Value = len(opcodes)
+
+	emit.PushScope()
+	for _, node := range elseNodes {
+		opcodes = emit.emitStatement(opcodes, node)
+	}
+	emit.PopScope()
+	opcodes[jumpToEndCodeOffset].Value = len(opcodes)
+	return opcodes
+}
+
+func (emit *Emitter) emitFor(opcodes []bytecode.Code, node *ast.For) []bytecode.Code {
+	emit.PushScope()
+	defer emit.PopScope()
+
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.Label,
+		Value: "For",
+	})
+
+	// Store array in hidden stack variable so it's only evaluated once
+	arrayStackPos := emit.scope.stackPos
+	emit.scope.stackPos++
+	opcodes = emit.emitExpression(opcodes, &node.Array)
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.Store,
+		Value: arrayStackPos,
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind: bytecode.Pop,
+	})
+
+	// Initialize index, ie. "i := 0"
+	indexStackPos := emit.scope.stackPos
+	emit.scope.stackPos++
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.Push,
+		Value: int64(0),
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.Store,
+		Value: indexStackPos,
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind: bytecode.Pop,
+	})
+	_, isMap := node.Array.TypeInfo.(*types.Map)
+	keyStackPos := -1
+	if node.IndexName.Kind != token.Unknown {
+		// ie. "i" in "for i, record := array" or "key" in "for key, value := map".
+		// This is a copy so changing it in the loop doesn't change the iteration.
+		keyStackPos = emit.scope.stackPos
+		emit.scope.stackPos++
+		emit.scope.DeclareSet(node.IndexName.String(), VariableInfo{
+			stackPos: keyStackPos,
+		})
+	}
+
+	// Declare record, ie. "for record := array"
+	recordStackPos := emit.scope.stackPos
+	emit.scope.stackPos++
+	{
+		var recordStructTypeInfo *types.Struct
+		switch typeInfo := node.Array.TypeInfo.(type) {
+		case *types.Array:
+			recordStructTypeInfo, _ = typeInfo.Underlying().(*types.Struct)
+		case *types.Map:
+			recordStructTypeInfo, _ = typeInfo.Underlying().(*types.Struct)
+		}
+		emit.scope.DeclareSet(node.RecordName.String(), VariableInfo{
+			kind:           VariableStruct,
+			stackPos:       recordStackPos,
+			structTypeInfo: recordStructTypeInfo,
+		})
+	}
+
+	// Check "i < len(array)"
+	loopBeginOffset := len(opcodes)
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.PushStackVar,
+		Value: indexStackPos,
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.PushStackVar,
+		Value: arrayStackPos,
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind: bytecode.PushArrayLength,
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind: bytecode.LessThan,
+	})
+	jumpCodeOffset := len(opcodes)
+	opcodes = append(opcodes, bytecode.Code{
+		Kind: bytecode.JumpIfFalse,
+	})
+
+	// Set record to "array[i]"
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.PushStackVar,
+		Value: arrayStackPos,
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.PushStackVar,
+		Value: indexStackPos,
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind: bytecode.PushArrayElement,
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.Store,
+		Value: recordStackPos,
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind: bytecode.Pop,
+	})
+	if keyStackPos != -1 {
+		if isMap {
+			// Set key to the "i"th key of the map
+			opcodes = append(opcodes, bytecode.Code{
+				Kind:  bytecode.PushStackVar,
+				Value: arrayStackPos,
+			})
+			opcodes = append(opcodes, bytecode.Code{
+				Kind:  bytecode.PushStackVar,
+				Value: indexStackPos,
+			})
+			opcodes = append(opcodes, bytecode.Code{
+				Kind: bytecode.PushMapKey,
+			})
+		} else {
+			opcodes = append(opcodes, bytecode.Code{
+				Kind:  bytecode.PushStackVar,
+				Value: indexStackPos,
+			})
+		}
+		opcodes = append(opcodes, bytecode.Code{
+			Kind:  bytecode.Store,
+			Value: keyStackPos,
+		})
+		opcodes = append(opcodes, bytecode.Code{
+			Kind: bytecode.Pop,
+		})
+	}
+
+	// Generate bytecode
+	emit.PushScope()
+	for _, node := range node.Nodes() {
+		opcodes = emit.emitStatement(opcodes, node)
 	}
+	emit.PopScope()
+
+	// Increment "i++"
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.PushStackVar,
+		Value: indexStackPos,
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.Push,
+		Value: int64(1),
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind: bytecode.Add,
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.Store,
+		Value: indexStackPos,
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind: bytecode.Pop,
+	})
+	opcodes = append(opcodes, bytecode.Code{
+		Kind:  bytecode.Jump,
+		Value: loopBeginOffset,
+	})
+	opcodes[jumpCodeOffset].Value = len(opcodes)
 	return opcodes
 }
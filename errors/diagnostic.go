@@ -0,0 +1,373 @@
+package errors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/silbinarywolf/compiler-fel/token"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	}
+	return "unknown"
+}
+
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Code identifies the kind of a diagnostic, ie. "FEL0012"
+//
+// These are used by CI annotations and editors, so the values
+// must never change. Add new codes at the end of their range.
+type Code int
+
+const (
+	// Parser
+	CodeSyntax            Code = 1
+	CodeEmptyFile         Code = 2
+	CodeInvalidCharacter  Code = 3
+	CodeInvalidParameter  Code = 4
+	CodeInvalidDefinition Code = 5
+	CodeInvalidCSSConfig  Code = 6
+	CodeEmptyArrayLiteral Code = 7
+
+	// Typer
+	CodeTypeMismatch         Code = 8
+	CodeUndeclaredType       Code = 9
+	CodeWrongSymbolKind      Code = 10
+	CodeUnknownField         Code = 11
+	CodeUndeclaredIdentifier Code = 12
+	CodeArgumentCount        Code = 13
+	CodeRedeclared           Code = 14
+	CodeUnknownElement       Code = 15
+	CodeInvalidCSS           Code = 16
+	CodeCyclicReference      Code = 17
+	CodeReturnMismatch       Code = 18
+	CodeReservedName         Code = 19
+
+	// Emitter / VM
+	CodeUnsupported Code = 20
+	CodeRuntime     Code = 21
+
+	CodeInternal Code = 999
+)
+
+var codeTitles = map[Code]string{
+	CodeSyntax:               "syntax error",
+	CodeEmptyFile:            "empty source file",
+	CodeInvalidCharacter:     "invalid character",
+	CodeInvalidParameter:     "invalid parameter",
+	CodeInvalidDefinition:    "invalid definition",
+	CodeInvalidCSSConfig:     "invalid css_config",
+	CodeEmptyArrayLiteral:    "empty array literal",
+	CodeTypeMismatch:         "mismatched types",
+	CodeUndeclaredType:       "undeclared type",
+	CodeWrongSymbolKind:      "wrong kind of symbol",
+	CodeUnknownField:         "unknown field",
+	CodeUndeclaredIdentifier: "undeclared identifier",
+	CodeArgumentCount:        "wrong number of arguments",
+	CodeRedeclared:           "redeclared",
+	CodeUnknownElement:       "unknown element or component",
+	CodeInvalidCSS:           "invalid css",
+	CodeCyclicReference:      "cyclic reference",
+	CodeReturnMismatch:       "mismatched return type",
+	CodeReservedName:         "reserved name",
+	CodeUnsupported:          "unsupported feature",
+	CodeRuntime:              "runtime error",
+	CodeInternal:             "internal compiler error",
+}
+
+func (c Code) String() string {
+	return fmt.Sprintf("FEL%04d", int(c))
+}
+
+// Title is a short description of the code, ie. "undeclared identifier"
+func (c Code) Title() string {
+	return codeTitles[c]
+}
+
+func (c Code) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// Location is a span of source code. Line and Column are 1-based,
+// Start and End are byte offsets.
+type Location struct {
+	Filepath  string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column,omitempty"`
+	EndLine   int    `json:"endLine,omitempty"`
+	EndColumn int    `json:"endColumn,omitempty"`
+	Start     int    `json:"start"`
+	End       int    `json:"end"`
+}
+
+func LocationFromToken(t token.Token) Location {
+	return Location{
+		Filepath: t.Filepath,
+		Line:     t.Line,
+		Start:    t.Start,
+		End:      t.End,
+	}
+}
+
+// isWholeLine is true for locations without an offset, ie. scanner errors
+func (l *Location) isWholeLine() bool {
+	return l.Start == 0 && l.End == 0
+}
+
+// resolve sets the columns from the byte offsets
+func (l *Location) resolve(source []byte) {
+	if source == nil {
+		return
+	}
+	if l.isWholeLine() {
+		start := lineStart(source, l.Line)
+		end := start + lineLength(source[start:])
+		l.Start, l.End = start, end
+		if l.Line < 1 {
+			l.Line = 1
+		}
+		l.Column, l.EndLine, l.EndColumn = 1, l.Line, end-start+1
+		return
+	}
+	if l.Start > len(source) {
+		l.Start = len(source)
+	}
+	if l.End < l.Start {
+		l.End = l.Start
+	}
+	if l.End > len(source) {
+		l.End = len(source)
+	}
+	l.Line, l.Column = lineAndColumn(source, l.Start)
+	l.EndLine, l.EndColumn = lineAndColumn(source, l.End)
+}
+
+func (l Location) String() string {
+	if l.Column == 0 {
+		return fmt.Sprintf("%s:%d", l.Filepath, l.Line)
+	}
+	return fmt.Sprintf("%s:%d:%d", l.Filepath, l.Line, l.Column)
+}
+
+// Related is another location that helps explain a diagnostic,
+// ie. where something was first declared.
+type Related struct {
+	Location
+	Message string `json:"message"`
+}
+
+type Diagnostic struct {
+	Code        Code      `json:"code"`
+	Severity    Severity  `json:"severity"`
+	Message     string    `json:"message"`
+	Location    Location  `json:"location"`
+	Related     []Related `json:"related,omitempty"`
+	Suggestions []string  `json:"suggestions,omitempty"`
+
+	// Trace is where the compiler raised the error, only set in developer mode
+	Trace []string `json:"-"`
+}
+
+func NewDiagnostic(t token.Token, code Code, message error) *Diagnostic {
+	return &Diagnostic{
+		Code:     code,
+		Severity: SeverityError,
+		Message:  message.Error(),
+		Location: LocationFromToken(t),
+	}
+}
+
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s[%s]: %s", d.Location, d.Severity, d.Code, d.Message)
+}
+
+func (d *Diagnostic) AddRelated(t token.Token, message string) *Diagnostic {
+	d.Related = append(d.Related, Related{
+		Location: LocationFromToken(t),
+		Message:  message,
+	})
+	return d
+}
+
+func (d *Diagnostic) AddSuggestion(suggestion string) *Diagnostic {
+	d.Suggestions = append(d.Suggestions, suggestion)
+	return d
+}
+
+// Sources are the file contents by filepath, used to render source snippets
+type Sources map[string][]byte
+
+// resolveDiagnostics copies the diagnostics with their columns set and sorts
+// them by file and position, so output is the same between runs.
+func resolveDiagnostics(diagnostics []*Diagnostic, sources Sources) []Diagnostic {
+	result := make([]Diagnostic, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		resolved := *d
+		resolved.Location.resolve(sources[resolved.Location.Filepath])
+		resolved.Related = make([]Related, len(d.Related))
+		copy(resolved.Related, d.Related)
+		for i := range resolved.Related {
+			related := &resolved.Related[i]
+			related.Location.resolve(sources[related.Filepath])
+		}
+		result = append(result, resolved)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i].Location, result[j].Location
+		if a.Filepath != b.Filepath {
+			return a.Filepath < b.Filepath
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		return result[i].Code < result[j].Code
+	})
+	return result
+}
+
+// WriteText writes the diagnostics for a terminal, with a source snippet
+// for each diagnostic if the file is in sources.
+func WriteText(w io.Writer, diagnostics []*Diagnostic, sources Sources) error {
+	if len(diagnostics) == 0 {
+		return nil
+	}
+	var buffer bytes.Buffer
+	errorOrErrors := "errors"
+	if len(diagnostics) == 1 {
+		errorOrErrors = "error"
+	}
+	fmt.Fprintf(&buffer, "Found %d %s...\n", len(diagnostics), errorOrErrors)
+	for _, d := range resolveDiagnostics(diagnostics, sources) {
+		buffer.WriteString("\n")
+		writeDiagnosticText(&buffer, &d, sources[d.Location.Filepath])
+	}
+	buffer.WriteString("\n")
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+func writeDiagnosticText(buffer *bytes.Buffer, d *Diagnostic, source []byte) {
+	indentString := "\n  "
+	fmt.Fprintf(buffer, "%s[%s]: %s\n", d.Severity, d.Code, strings.Replace(d.Message, "\n", indentString, -1))
+	gutter := strconv.Itoa(d.Location.Line)
+	padding := strings.Repeat(" ", len(gutter))
+	fmt.Fprintf(buffer, "%s--> %s\n", padding, d.Location)
+	if source != nil {
+		start := lineStart(source, d.Location.Line)
+		line := source[start : start+lineLength(source[start:])]
+		underlineStart := d.Location.Start - start
+		underlineEnd := d.Location.End - start
+		if underlineEnd > len(line) {
+			underlineEnd = len(line)
+		}
+		if underlineStart < 0 || underlineStart > len(line) {
+			underlineStart = 0
+		}
+		prefix := utf8.RuneCountInString(expandTabs(line[:underlineStart]))
+		underline := utf8.RuneCountInString(expandTabs(line[underlineStart:underlineEnd]))
+		if underline == 0 {
+			underline = 1
+		}
+		fmt.Fprintf(buffer, "%s |\n", padding)
+		fmt.Fprintf(buffer, "%s | %s\n", gutter, expandTabs(line))
+		fmt.Fprintf(buffer, "%s | %s%s\n", padding, strings.Repeat(" ", prefix), strings.Repeat("^", underline))
+	}
+	for _, related := range d.Related {
+		fmt.Fprintf(buffer, "%s = note: %s: %s\n", padding, related.Location, related.Message)
+	}
+	for _, suggestion := range d.Suggestions {
+		fmt.Fprintf(buffer, "%s = help: %s\n", padding, suggestion)
+	}
+	for _, trace := range d.Trace {
+		fmt.Fprintf(buffer, "%s -- %s\n", padding, trace)
+	}
+}
+
+// WriteJSON writes the diagnostics as a JSON object, ie. for CI annotations
+//
+// {"diagnostics": [{"code": "FEL0012", "severity": "error", "message": "...", "location": {...}}]}
+func WriteJSON(w io.Writer, diagnostics []*Diagnostic, sources Sources) error {
+	type jsonDiagnostic struct {
+		Diagnostic
+		Title string `json:"title"`
+	}
+	resolved := resolveDiagnostics(diagnostics, sources)
+	result := struct {
+		Diagnostics []jsonDiagnostic `json:"diagnostics"`
+	}{
+		Diagnostics: make([]jsonDiagnostic, 0, len(resolved)),
+	}
+	for _, d := range resolved {
+		result.Diagnostics = append(result.Diagnostics, jsonDiagnostic{
+			Diagnostic: d,
+			Title:      d.Code.Title(),
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(result)
+}
+
+// lineStart gets the byte offset of a 1-based line
+func lineStart(source []byte, line int) int {
+	offset := 0
+	for ; line > 1; line-- {
+		index := bytes.IndexByte(source[offset:], '\n')
+		if index == -1 {
+			return offset
+		}
+		offset += index + 1
+	}
+	return offset
+}
+
+// lineLength gets the length of the first line, without the line ending
+func lineLength(source []byte) int {
+	length := bytes.IndexByte(source, '\n')
+	if length == -1 {
+		length = len(source)
+	}
+	if length > 0 && source[length-1] == '\r' {
+		length--
+	}
+	return length
+}
+
+// lineAndColumn gets the 1-based line and column of a byte offset,
+// columns are counted in characters.
+func lineAndColumn(source []byte, offset int) (int, int) {
+	line := 1 + bytes.Count(source[:offset], []byte{'\n'})
+	start := bytes.LastIndexByte(source[:offset], '\n') + 1
+	return line, len(bytes.Runes(source[start:offset])) + 1
+}
+
+func expandTabs(line []byte) string {
+	return strings.Replace(string(line), "\t", "    ", -1)
+}
@@ -0,0 +1,76 @@
+package errors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/silbinarywolf/compiler-fel/token"
+)
+
+func TestDiagnostics(t *testing.T) {
+	sources := Sources{
+		"a.fel": []byte("x := 1\n\tdiv(class=missing)\n"),
+		"b.fel": []byte("y := 2\n"),
+	}
+	var e ErrorHandler
+	e.Init()
+	e.AddError(token.Token{Filepath: "b.fel", Line: 1, Start: 0, End: 1}, CodeRedeclared, fmt.Errorf("Cannot redeclare \"y\".")).
+		AddRelated(token.Token{Filepath: "a.fel", Line: 1, Start: 0, End: 1}, "previously declared here")
+	e.AddError(token.Token{Filepath: "a.fel", Line: 2, Start: 18, End: 25}, CodeUndeclaredIdentifier, fmt.Errorf("Undeclared identifier \"missing\".")).
+		AddSuggestion("Did you mean \"x\"?")
+
+	// Errors are sorted by file, not the order they were added
+	var text bytes.Buffer
+	WriteText(&text, e.Diagnostics(), sources)
+	expected := `Found 2 errors...
+
+error[FEL0012]: Undeclared identifier "missing".
+ --> a.fel:2:12
+  |
+2 |     div(class=missing)
+  |               ^^^^^^^
+  = help: Did you mean "x"?
+
+error[FEL0014]: Cannot redeclare "y".
+ --> b.fel:1:1
+  |
+1 | y := 2
+  | ^
+  = note: a.fel:1:1: previously declared here
+
+`
+	if text.String() != expected {
+		t.Errorf("Expected:\n%s\nInstead got:\n%s", expected, text.String())
+	}
+
+	var jsonOutput bytes.Buffer
+	WriteJSON(&jsonOutput, e.Diagnostics(), sources)
+	var result struct {
+		Diagnostics []struct {
+			Code     string
+			Title    string
+			Location Location
+			Related  []Related
+		}
+	}
+	if err := json.Unmarshal(jsonOutput.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Diagnostics) != 2 {
+		t.Fatalf("Expected 2 diagnostics, instead got: %s", jsonOutput.String())
+	}
+	first := result.Diagnostics[0]
+	if first.Code != "FEL0012" || first.Title != "undeclared identifier" ||
+		first.Location.Line != 2 || first.Location.Column != 12 || first.Location.EndColumn != 19 {
+		t.Errorf("Unexpected first diagnostic: %s", jsonOutput.String())
+	}
+	if related := result.Diagnostics[1].Related; len(related) != 1 || related[0].Filepath != "a.fel" || related[0].Column != 1 {
+		t.Errorf("Expected related location in a.fel, instead got: %v", related)
+	}
+	if strings.Contains(jsonOutput.String(), "Line:") {
+		t.Errorf("Developer mode trace should not be in JSON output: %s", jsonOutput.String())
+	}
+}
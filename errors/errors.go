@@ -1,6 +1,7 @@
 package errors
 
 import (
+	"bytes"
 	"fmt"
 	"runtime"
 	"strings"
@@ -11,15 +12,15 @@ import (
 const fatalErrorMessage = "Fatal parsing error occurred. Please notify the developer(s)."
 
 type ErrorHandler struct {
-	errors  map[string][]error
-	devMode bool
+	diagnostics []*Diagnostic
+	devMode     bool
 }
 
 func (e *ErrorHandler) Init() {
-	if e.errors != nil {
+	if e.diagnostics != nil {
 		panic("Cannot initialize error handler more than once.")
 	}
-	e.errors = make(map[string][]error)
+	e.diagnostics = make([]*Diagnostic, 0, 10)
 	e.devMode = false
 }
 
@@ -95,29 +96,28 @@ func expect(thisToken token.Token, expectedList []interface{}) *ParserError {
 }
 
 func (e *ErrorHandler) HasErrors() bool {
-	return len(e.errors) > 0
+	return len(e.diagnostics) > 0
+}
+
+// Diagnostics returns the errors in the order they were added
+func (e *ErrorHandler) Diagnostics() []*Diagnostic {
+	return e.diagnostics
 }
 
 func (e *ErrorHandler) AddUnexpectedErrorWithContext(t token.Token, context string) {
-	e.AddError(t, unexpected(t, context))
+	e.AddError(t, CodeSyntax, unexpected(t, context))
 }
 
 func (e *ErrorHandler) AddExpectError(t token.Token, expectedList ...interface{}) {
-	e.AddError(t, expect(t, expectedList))
+	e.AddError(t, CodeSyntax, expect(t, expectedList))
 }
 
-func (e *ErrorHandler) AddError(token token.Token, message error) {
-	filepath := token.Filepath
-	_, ok := e.errors[filepath]
-	if !ok {
-		e.errors[filepath] = make([]error, 0, 10)
-	}
-	lineMessage := fmt.Sprintf("Line %d | ", token.Line)
-	indentString := "\n  "
-	for i := 0; i < len(lineMessage); i++ {
-		indentString += " "
-	}
-	message = fmt.Errorf(lineMessage + strings.Replace(message.Error(), "\n", indentString, -1))
+// AddError adds an error and returns it so related locations and
+// suggestions can be added, ie.
+//
+// p.AddError(t, errors.CodeRedeclared, err).AddRelated(previous, "previously declared here")
+func (e *ErrorHandler) AddError(token token.Token, code Code, message error) *Diagnostic {
+	diagnostic := NewDiagnostic(token, code, message)
 
 	// Get where the error message was added from to help
 	// track where error messages are raised.
@@ -144,12 +144,13 @@ func (e *ErrorHandler) AddError(token token.Token, message error) {
 				if len(fileParts) >= 3 {
 					file = fileParts[len(fileParts)-3] + "/" + fileParts[len(fileParts)-2] + "/" + fileParts[len(fileParts)-1]
 				}
-				message = fmt.Errorf("%s\n-- Line: %d | %s", message, line, file)
+				diagnostic.Trace = append(diagnostic.Trace, fmt.Sprintf("Line: %d | %s", line, file))
 			}
 			callIndex++
 		}
 	}
-	e.errors[filepath] = append(e.errors[filepath], message)
+	e.diagnostics = append(e.diagnostics, diagnostic)
+	return diagnostic
 }
 
 func (e *ErrorHandler) PanicMessage(message error) {
@@ -159,42 +160,18 @@ func (e *ErrorHandler) PanicMessage(message error) {
 }
 
 func (e *ErrorHandler) PanicError(t token.Token, message error) {
-	e.AddError(t, fmt.Errorf("%s %s", "**FATAL ERROR**", message))
+	e.AddError(t, CodeInternal, fmt.Errorf("%s %s", "**FATAL ERROR**", message))
 	e.PrintErrors()
 	panic(fatalErrorMessage)
 }
 
 func (e *ErrorHandler) PrintErrors() {
-	errors := e.errors
-	errorCount := 0
-	for _, errorList := range errors {
-		errorCount += len(errorList)
-	}
-	//if p.Scanner.Error != nil {
-	//	errorCount += 1
-	//}
-	if errorCount > 0 {
-		errorOrErrors := "errors"
-		if errorCount == 1 {
-			errorOrErrors = "error"
-		}
-		fmt.Printf("Found %d %s...\n", errorCount, errorOrErrors)
-		//if p.Scanner.Error != nil {
-		//	fmt.Printf("File: %s\n", p.Scanner.Filepath)
-		//	fmt.Printf("- %s \n", p.Scanner.Error)
-		//}
-
-		isFirst := true
-		for filepath, errorList := range errors {
-			fmt.Printf("File: %s\n", filepath)
-			for _, err := range errorList {
-				fmt.Printf("- %v \n", err)
-			}
-			if !isFirst {
-				fmt.Printf("\n")
-			}
-			isFirst = false
-		}
-		fmt.Printf("\n")
-	}
+	fmt.Print(e.String())
+}
+
+// String returns the errors in the same format as PrintErrors, without source snippets
+func (e *ErrorHandler) String() string {
+	var buffer bytes.Buffer
+	WriteText(&buffer, e.diagnostics, nil)
+	return buffer.String()
 }
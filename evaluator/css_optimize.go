@@ -1,127 +1,74 @@
 package evaluator
 
-/*import (
+import (
 	"github.com/silbinarywolf/compiler-fel/ast"
 	"github.com/silbinarywolf/compiler-fel/data"
 )
 
-func optimizeRules(definition *data.CSSDefinition, htmlNodeInfo *HTMLComponentNodeInfo, cssConfigDefinition *ast.CSSConfigDefinition, onlyScanCurrentHTMLComponentScope bool) {
-	for ruleIndex := 0; ruleIndex < len(definition.ChildNodes); ruleIndex++ {
-		cssRule := definition.ChildNodes[ruleIndex]
+// OptimizeCSSDefinition removes rules and selectors from the definition that
+// don't match any of the given HTML nodes.
+//
+// Selectors containing parts flagged with "modify: false" in the
+// css_config are always kept. ie. Keeping "js-my-hook", "is-active", "active"
+func OptimizeCSSDefinition(definition *data.CSSDefinition, htmlNodes []*data.HTMLElement, cssConfigDefinition *ast.CSSConfigDefinition) {
+	definition.SetRules(optimizeRules(definition.Rules(), htmlNodes, cssConfigDefinition))
+}
 
+func optimizeRules(rules []*data.CSSRule, htmlNodes []*data.HTMLElement, cssConfigDefinition *ast.CSSConfigDefinition) []*data.CSSRule {
+	resultRules := make([]*data.CSSRule, 0, len(rules))
+RuleLoop:
+	for _, cssRule := range rules {
 		// If no properties on rule, remove it completely.
-		if len(cssRule.Properties) == 0 {
-			definition.ChildNodes = append(definition.ChildNodes[:ruleIndex], definition.ChildNodes[ruleIndex+1:]...)
-			ruleIndex--
+		if len(cssRule.Properties()) == 0 && len(cssRule.Rules()) == 0 {
 			continue
 		}
 
+		selectors := cssRule.Selectors()
+		resultSelectors := make([]data.CSSSelector, 0, len(selectors))
 	SelectorLoop:
-		for selectorIndex := 0; selectorIndex < len(cssRule.Selectors); selectorIndex++ {
-			selector := cssRule.Selectors[selectorIndex]
-
-			// If part of a selector has "modify: false" rule, do not optimize
-			// this selector away.
-			// ie. Keeping "js-my-hook", "is-active", "active"
+		for _, selector := range selectors {
 			for _, part := range selector {
-				partString := part.String()
-				config := cssConfigDefinition.GetSettings(partString)
-				// Don't optimize away if specifically flagged to not modify.
+				switch part.Kind() {
+				case data.SelectorPartKindAtKeyword:
+					// Leave at-rules (ie. @media, @font-face) as-is, but
+					// optimize the rules nested inside them.
+					//
+					// Keyframe selectors (ie. "from", "50%") aren't elements
+					// so @keyframes is always kept.
+					if len(cssRule.Rules()) > 0 && cssRule.AtKeyword() != "@keyframes" {
+						cssRule.SetRules(optimizeRules(cssRule.Rules(), htmlNodes, cssConfigDefinition))
+						if len(cssRule.Rules()) == 0 && len(cssRule.Properties()) == 0 {
+							continue RuleLoop
+						}
+					}
+					resultRules = append(resultRules, cssRule)
+					continue RuleLoop
+				}
+
+				// If part of a selector has "modify: false" rule, do not optimize
+				// this selector away.
+				config := cssConfigDefinition.GetSettings(part.String())
 				if !config.Modify {
+					resultSelectors = append(resultSelectors, selector)
 					continue SelectorLoop
 				}
 			}
 
-			//{
-			//	res := ""
-			//	for _, htmlNode := range htmlNodeInfo.Nodes {
-			//		res += generate.PrettyHTML([]data.Type{htmlNode}) + "\n"
-			//	}
-			//	panic(fmt.Sprintf("%s\nDone %d nodes for \"%s\"", res, len(htmlNodeInfo.Nodes), htmlNodeInfo.HTMLDefinition.Name))
-			//}
-
 			// Check for matches
-			nodesMatchedCount := 0
-			for _, htmlNode := range htmlNodeInfo.Nodes {
-				nodesMatched := htmlNode.HTMLNode.QuerySelectorAllWithOptions(selector, onlyScanCurrentHTMLComponentScope)
-				nodesMatchedCount += len(nodesMatched)
-			}
-			if nodesMatchedCount == 0 {
-				// Remove if no match
-				cssRule.Selectors = append(cssRule.Selectors[:selectorIndex], cssRule.Selectors[selectorIndex+1:]...)
-				selectorIndex--
-				continue SelectorLoop
+			for _, htmlNode := range htmlNodes {
+				if nodesMatched := htmlNode.QuerySelectorAll(selector); len(nodesMatched) > 0 {
+					resultSelectors = append(resultSelectors, selector)
+					continue SelectorLoop
+				}
 			}
-			// If found a match, stop looking for matches with this
-			// selector
-			continue SelectorLoop
 		}
 
 		// If no selectors (ie. removed all the ones that didnt match, remove this rule)
-		if len(cssRule.Selectors) == 0 {
-			definition.ChildNodes = append(definition.ChildNodes[:ruleIndex], definition.ChildNodes[ruleIndex+1:]...)
-			ruleIndex--
+		if len(resultSelectors) == 0 {
 			continue
 		}
+		cssRule.SetSelectors(resultSelectors)
+		resultRules = append(resultRules, cssRule)
 	}
+	return resultRules
 }
-
-func (program *Program) evaluateOptimizeAndReturnUsedCSS() []*data.CSSDefinition {
-	outputCSSDefinitionSet := make([]*data.CSSDefinition, 0, 3)
-
-	// Output named "MyComponent :: css" blocks
-	for _, htmlNodeInfo := range program.htmlDefinitionUsed {
-		htmlDefinition := htmlNodeInfo.HTMLDefinition
-		if htmlDefinition == nil {
-			panic("Unexpected error. HTMLNodeSet should always have a HTMLDefinition.")
-		}
-
-		cssDefinition := htmlDefinition.CSSDefinition
-		if cssDefinition == nil {
-			continue
-		}
-
-		// Process CSSDefinition
-		program.currentComponentScope = append(program.currentComponentScope, htmlNodeInfo.HTMLDefinition)
-		dataCSSDefinition := program.evaluateCSSDefinition(cssDefinition, program.globalScope)
-		program.currentComponentScope = program.currentComponentScope[:len(program.currentComponentScope)-1]
-
-		// Debug
-		//{
-		//	json, _ := json.MarshalIndent(htmlNodeInfo, "", "   ")
-		//	fmt.Printf("%s", string(json))
-		//	panic("ROY")
-		//}
-
-		// Optimize
-		optimizeRules(dataCSSDefinition, htmlNodeInfo, htmlDefinition.CSSConfigDefinition, true)
-
-		// Add output
-		if len(dataCSSDefinition.ChildNodes) > 0 {
-			outputCSSDefinitionSet = append(outputCSSDefinitionSet, dataCSSDefinition)
-		}
-	}
-
-	// Output anonymous ":: css" blocks
-	htmlNodeInfo := new(HTMLComponentNodeInfo)
-	for _, itHtmlNodeInfo := range program.htmlTemplatesUsed {
-		// NOTE: Packing each seperate template into this so `optimizeRules`
-		//		 code can be reused easily.
-		htmlNodeInfo.Nodes = append(htmlNodeInfo.Nodes, itHtmlNodeInfo.Nodes...)
-	}
-	for _, cssDefinition := range program.anonymousCSSDefinitionsUsed {
-		dataCSSDefinition := program.evaluateCSSDefinition(cssDefinition, program.globalScope)
-
-		optimizeRules(dataCSSDefinition, htmlNodeInfo, nil, false)
-
-		// Add output
-		if len(dataCSSDefinition.ChildNodes) > 0 {
-			outputCSSDefinitionSet = append(outputCSSDefinitionSet, dataCSSDefinition)
-		}
-
-		// todo(Jake): Get all templates, iterate over each
-		//			   and optimize away anonymous CSS
-	}
-
-	return outputCSSDefinitionSet
-}*/
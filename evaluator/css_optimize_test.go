@@ -1,13 +1,18 @@
 package evaluator
 
-/*import (
+import (
 	"strings"
 	"testing"
 
 	"github.com/silbinarywolf/compiler-fel/ast"
+	"github.com/silbinarywolf/compiler-fel/bytecode"
 	"github.com/silbinarywolf/compiler-fel/data"
-	"github.com/silbinarywolf/compiler-fel/generate"
+	"github.com/silbinarywolf/compiler-fel/emitter"
 	"github.com/silbinarywolf/compiler-fel/parser"
+	"github.com/silbinarywolf/compiler-fel/printer"
+	"github.com/silbinarywolf/compiler-fel/token"
+	"github.com/silbinarywolf/compiler-fel/typer"
+	"github.com/silbinarywolf/compiler-fel/vm"
 )
 
 func TestOptimizeCSSClass(t *testing.T) {
@@ -194,35 +199,277 @@ func TestOptimizeCSSAdjacent(t *testing.T) {
 	`, []string{
 		".MyComponent__p+.MyComponent__p",
 	}, []string{
-		".MyComponent__div+MyComponent__div",
+		".MyComponent__div+.MyComponent__div",
 	})
 }
 
+func TestOptimizeCSSConfigModify(t *testing.T) {
+	CSSOptimizeRuleCheck(t, `
+		MyComponent :: css_config {
+			.is-* {
+				modify: false
+			}
+		}
+
+		MyComponent :: css {
+			.header.is-active {
+				color: green;
+			}
+			.header.not-active {
+				color: red;
+			}
+		}
+
+		MyComponent :: html {
+			div(class="header") {}
+		}
+
+		MyComponent() {}
+	`, []string{
+		".MyComponent__header.is-active",
+	}, []string{
+		".MyComponent__header.MyComponent__not-active",
+	})
+}
+
+func TestOptimizeCSSNested(t *testing.T) {
+	CSSOptimizeRuleCheck(t, `
+		MyComponent :: css {
+			.header {
+				color: green;
+
+				&.is-active {
+					color: blue;
+				}
+				&.not-active {
+					color: red;
+				}
+				.title, span {
+					color: green;
+				}
+				.no-exists {
+					color: red;
+				}
+				@media print {
+					color: black;
+				}
+			}
+		}
+
+		MyComponent :: html {
+			div(class="header is-active") {
+				div(class="title") {
+				}
+			}
+		}
+
+		MyComponent() {}
+	`, []string{
+		".MyComponent__header.MyComponent__is-active",
+		".MyComponent__header .MyComponent__title",
+		"@media print",
+	}, []string{
+		".MyComponent__header.MyComponent__not-active",
+		".MyComponent__header span",
+		".MyComponent__no-exists",
+	})
+}
+
+func TestOptimizeCSSAtRules(t *testing.T) {
+	CSSOptimizeRuleCheck(t, `
+		:: css {
+			@font-face {
+				font-family: "Foo"
+				src: url("foo.woff") format("woff")
+			}
+			@keyframes fade-in {
+				from { opacity: 0 }
+				50% { opacity: 0.5 }
+				to { opacity: 1 }
+			}
+			@media screen and (max-width: 600px) {
+				.exists {
+					color: green;
+				}
+				.no-exists {
+					color: red;
+				}
+			}
+			@supports (display: grid) {
+				.no-exists {
+					display: grid;
+				}
+			}
+		}
+		div(class="exists") {
+		}
+	`, []string{
+		"@font-face",
+		"src: url(\"foo.woff\") format(\"woff\");",
+		"@keyframes fade-in",
+		"50% {",
+		"@media screen and (max-width: 600px) {",
+		".exists",
+	}, []string{
+		".no-exists",
+		"@supports",
+	})
+}
+
+func TestOptimizeCSSValues(t *testing.T) {
+	CSSOptimizeRuleCheck(t, `
+		:: css {
+			base := 10px
+			bg_color := #3366ff
+
+			.exists {
+				padding: base * 2
+				margin: 0 auto
+				width: 100% - 20px
+				height: base / 4 + 1px
+				background-color: darken(bg_color, 10%)
+				border-color: lighten(#000, 50%)
+				border-bottom: 1px solid darken(bg_color, 10%)
+				transition: opacity 0.5s
+			}
+		}
+		div(class="exists") {
+		}
+	`, []string{
+		"padding: 20px;",
+		"margin: 0 auto;",
+		"width: calc(100% - 20px);",
+		"height: 3.5px;",
+		"background-color: #0040ff;",
+		"border-color: #808080;",
+		"border-bottom: 1px solid #0040ff;",
+		"transition: opacity 0.5s;",
+	}, nil)
+}
+
+func TestOptimizeCSSExecuteTwice(t *testing.T) {
+	p := parser.New()
+	astFile := p.Parse([]byte(`
+		MyComponent :: css {
+			.exists {
+				color: green;
+			}
+			.no-exists {
+				color: red;
+			}
+		}
+
+		MyComponent :: html {
+			div(class="exists") {}
+		}
+
+		MyComponent() {}
+	`), "Layout.fel")
+	if p.HasErrors() {
+		p.PrintErrors()
+		t.Fatalf("Stopping due to scanning/parsing errors.")
+	}
+	astFiles := []*ast.File{astFile}
+	typer := typer.New()
+	typer.ApplyTypeInfoAndTypecheck(astFiles)
+	if typer.HasErrors() {
+		typer.PrintErrors()
+		t.Fatalf("Stopping due to type errors.")
+	}
+	emit := emitter.New()
+	emit.EmitGlobalScope(astFiles)
+	result, err := vm.ExecuteNewProgram(emit.EmitBytecode(astFile, emitter.FileOptions{
+		IsTemplateFile: true,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	htmlNodes := []*data.HTMLElement{result.(*data.HTMLElement)}
+
+	// Optimizing the output of one execution should not affect the next
+	htmlDefinition := typer.HTMLComponentsUsed()[0]
+	codeBlock := emit.EmitCSSDefinition(htmlDefinition.CSSDefinition, htmlDefinition.CSSConfigDefinition)
+	for i := 0; i < 2; i++ {
+		result, err := vm.ExecuteNewProgram(codeBlock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cssDefinition := result.(*data.CSSDefinition)
+		if len(cssDefinition.Rules()) != 2 {
+			t.Fatalf("Execution %d: expected 2 rules before optimizing, instead got %d", i+1, len(cssDefinition.Rules()))
+		}
+		OptimizeCSSDefinition(cssDefinition, htmlNodes, htmlDefinition.CSSConfigDefinition)
+		if len(cssDefinition.Rules()) != 1 {
+			t.Fatalf("Execution %d: expected 1 rule after optimizing, instead got %d", i+1, len(cssDefinition.Rules()))
+		}
+	}
+}
+
 func CSSOptimizeRuleCheck(t *testing.T, template string, successContainsList []string, failContainsList []string) {
 	p := parser.New()
 	astFile := p.Parse([]byte(template), "Layout.fel")
 	if astFile == nil {
 		t.Fatalf("p.Parse should not return nil.")
 	}
-	p.TypecheckAndFinalize([]*ast.File{astFile})
 	if p.HasErrors() {
 		p.PrintErrors()
 		t.Fatalf("Stopping due to scanning/parsing errors.")
 	}
-	program := New()
-	node, err := program.evaluateTemplate(astFile)
+	astFiles := []*ast.File{astFile}
+	typer := typer.New()
+	typer.ApplyTypeInfoAndTypecheck(astFiles)
+	if typer.HasErrors() {
+		typer.PrintErrors()
+		t.Fatalf("Stopping due to type errors.")
+	}
+
+	// Emit and execute template
+	emit := emitter.New()
+	emit.EmitGlobalScope(astFiles)
+	result, err := vm.ExecuteNewProgram(emit.EmitBytecode(astFile, emitter.FileOptions{
+		IsTemplateFile: true,
+	}))
 	if err != nil {
-		t.Fatalf("%v", err)
+		t.Fatal(err)
+	}
+	node, ok := result.(*data.HTMLElement)
+	if !ok {
+		t.Fatalf("Expected template to return *data.HTMLElement.")
+	}
+	htmlNodes := []*data.HTMLElement{node}
+
+	// Emit, execute and optimize CSS
+	executeCSSDefinition := func(codeBlock *bytecode.Block) *data.CSSDefinition {
+		result, err := vm.ExecuteNewProgram(codeBlock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.(*data.CSSDefinition)
+	}
+	cssDefinitionSet := make([]*data.CSSDefinition, 0, 3)
+	for _, htmlDefinition := range typer.HTMLComponentsUsed() {
+		if htmlDefinition.CSSDefinition == nil {
+			continue
+		}
+		cssDefinition := executeCSSDefinition(emit.EmitCSSDefinition(htmlDefinition.CSSDefinition, htmlDefinition.CSSConfigDefinition))
+		OptimizeCSSDefinition(cssDefinition, htmlNodes, htmlDefinition.CSSConfigDefinition)
+		cssDefinitionSet = append(cssDefinitionSet, cssDefinition)
+	}
+	for _, itNode := range astFile.Nodes() {
+		if cssDef, ok := itNode.(*ast.CSSDefinition); ok && cssDef.Name.Kind == token.Unknown {
+			cssDefinition := executeCSSDefinition(emit.EmitCSSDefinition(cssDef, nil))
+			OptimizeCSSDefinition(cssDefinition, htmlNodes, nil)
+			cssDefinitionSet = append(cssDefinitionSet, cssDefinition)
+		}
 	}
-	cssDefinitionSet := program.evaluateOptimizeAndReturnUsedCSS()
 	if len(cssDefinitionSet) == 0 {
-		htmlOutput := generate.PrettyHTMLComponentNode(node)
+		htmlOutput := printer.PrettyHTML(node)
 		t.Fatalf("Expected at least 1 CSS definition to be returned. Not %d.\n\nOutput HTML:\n%s", len(cssDefinitionSet), htmlOutput)
 	}
 
 	cssOutput := ""
 	for _, cssDefinition := range cssDefinitionSet {
-		cssOutput += generate.PrettyCSS(cssDefinition) + "\n"
+		cssOutput += printer.PrettyCSS(cssDefinition) + "\n"
 	}
 
 	// If test failed, print out errors and output so the issue can be diagnosed
@@ -240,8 +487,7 @@ func CSSOptimizeRuleCheck(t *testing.T, template string, successContainsList []s
 		}
 	}
 	if outputCSSWithFatal {
-		htmlOutput := generate.PrettyHTML([]data.Type{node})
+		htmlOutput := printer.PrettyHTML(node)
 		t.Fatalf("\nCSS:\n%s\n\nHTML:\n%s", cssOutput, htmlOutput)
 	}
 }
-*/
@@ -86,11 +86,18 @@ func GetWorkspacesFromConfig(configFilepath string) ([]Workspace, error) {
 	_ = emit.EmitBytecode(astFile, emitter.FileOptions{
 		IsTemplateFile: true,
 	})
+	if emit.HasErrors() {
+		emit.PrintErrors()
+		return nil, fmt.Errorf("Emitter errors in config.fel in root of project directory")
+	}
 
 	workspaceCodeBlocks := emit.Workspaces()
 	workspaces := make([]Workspace, 0, len(workspaceCodeBlocks))
 	for _, workspaceCode := range workspaceCodeBlocks {
-		result := vm.ExecuteNewProgram(workspaceCode)
+		result, err := vm.ExecuteNewProgram(workspaceCode)
+		if err != nil {
+			return nil, err
+		}
 		structData := result.(*data.Struct)
 		workspace := Workspace{}
 		workspace.name = structData.GetFieldByName(" name").(string)
@@ -0,0 +1,852 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/silbinarywolf/compiler-fel/ast"
+	"github.com/silbinarywolf/compiler-fel/bytecode"
+	"github.com/silbinarywolf/compiler-fel/data"
+	"github.com/silbinarywolf/compiler-fel/emitter"
+	"github.com/silbinarywolf/compiler-fel/errors"
+	"github.com/silbinarywolf/compiler-fel/evaluator"
+	"github.com/silbinarywolf/compiler-fel/parser"
+	"github.com/silbinarywolf/compiler-fel/printer"
+	"github.com/silbinarywolf/compiler-fel/token"
+	"github.com/silbinarywolf/compiler-fel/typer"
+	"github.com/silbinarywolf/compiler-fel/vm"
+)
+
+type TemplateFile struct {
+	filepath string
+	code     *bytecode.Block
+	output   *data.HTMLElement
+}
+
+type CSSDefinition struct {
+	name      string
+	ast       *ast.CSSDefinition
+	cssConfig *ast.CSSConfigDefinition
+	code      *bytecode.Block
+}
+
+type Verbosity int
+
+const (
+	VerbosityQuiet Verbosity = iota
+	VerbosityNormal
+	VerbosityVerbose
+)
+
+type BuildOptions struct {
+	Workspace string // optional, only build the workspace with this name
+	CheckOnly bool   // parse and typecheck only, don't emit or write files
+	NoCache   bool   // don't read or write compiled bytecode in .felcache
+	Jobs      int    // how many files or templates to process at once, 0 uses every CPU
+	Verbosity Verbosity
+}
+
+func (options BuildOptions) jobs() int {
+	if options.Jobs <= 0 {
+		return runtime.NumCPU()
+	}
+	return options.Jobs
+}
+
+type SourceFile struct {
+	modTime  time.Time
+	size     int64
+	contents []byte
+}
+
+type BuildTimings struct {
+	diskIO    time.Duration
+	parsing   time.Duration
+	typer     time.Duration
+	emit      time.Duration
+	execution time.Duration
+}
+
+// BuildResult describes what was written to disk by a build.
+type BuildResult struct {
+	Templates  []string // template files that were rebuilt
+	HTMLFiles  []string // html files that were written
+	CSSChanged bool
+	FromCache  bool // parsing, typechecking and emitting was skipped
+	Timings    BuildTimings
+}
+
+// BuildError is returned when a workspace has parse or type errors
+type BuildError struct {
+	Message     string
+	Diagnostics []*errors.Diagnostic
+	Sources     errors.Sources
+}
+
+func (e *BuildError) Error() string { return e.Message }
+
+// Details gets the errors found, as printed to the terminal
+func (e *BuildError) Details() string {
+	var buffer bytes.Buffer
+	errors.WriteText(&buffer, e.Diagnostics, e.Sources)
+	return buffer.String()
+}
+
+// WorkspaceBuild holds the state of a workspace between builds so that
+// "fel watch" can rebuild only the templates affected by a change.
+type WorkspaceBuild struct {
+	workspace               evaluator.Workspace
+	options                 BuildOptions
+	projectDirpath          string
+	templateInputDirectory  string
+	templateOutputDirectory string
+	cssOutputDirectory      string
+
+	files         map[string]*SourceFile
+	htmlOutputs   map[string]*data.HTMLElement
+	htmlContents  map[string]string
+	cssOutput     string
+	hasWrittenCSS bool
+
+	cache  *BuildCache // nil if disabled
+	stdout io.Writer   // where verbose output is printed
+
+	// needsFullBuild is set when the last build failed, as the outputs
+	// of templates that weren't rebuilt may be stale.
+	needsFullBuild bool
+}
+
+func (b *WorkspaceBuild) newBuildError(message string, diagnostics []*errors.Diagnostic) *BuildError {
+	sources := make(errors.Sources, len(b.files))
+	for filepath, file := range b.files {
+		sources[filepath] = file.contents
+	}
+	return &BuildError{
+		Message:     message,
+		Diagnostics: diagnostics,
+		Sources:     sources,
+	}
+}
+
+// runtimeDiagnostic converts an error from executing bytecode into a diagnostic
+func runtimeDiagnostic(err error) *errors.Diagnostic {
+	if err, ok := err.(*vm.RuntimeError); ok {
+		return err.Diagnostic()
+	}
+	return errors.NewDiagnostic(token.Token{}, errors.CodeRuntime, err)
+}
+
+func unexpectedResultDiagnostic(block *bytecode.Block, result interface{}) *errors.Diagnostic {
+	return errors.NewDiagnostic(block.Token, errors.CodeInternal, fmt.Errorf("Unexpected result type %T from \"%s\".", result, block.Name()))
+}
+
+// filterWorkspaces returns the workspace with the given name or all workspaces if name is empty.
+func filterWorkspaces(workspaces []evaluator.Workspace, name string) ([]evaluator.Workspace, error) {
+	if name == "" {
+		return workspaces, nil
+	}
+	names := make([]string, 0, len(workspaces))
+	for _, workspace := range workspaces {
+		if workspace.Name() == name {
+			return []evaluator.Workspace{workspace}, nil
+		}
+		names = append(names, "\""+workspace.Name()+"\"")
+	}
+	return nil, fmt.Errorf("Cannot find workspace \"%s\" in config.fel, available workspaces: %s", name, strings.Join(names, ", "))
+}
+
+// newWorkspaceBuilds reads the workspaces from config.fel
+func newWorkspaceBuilds(projectDirpath string, options BuildOptions) ([]*WorkspaceBuild, error) {
+	configFilepath := projectDirpath + "config.fel"
+	workspaces, err := evaluator.GetWorkspacesFromConfig(configFilepath)
+	if err != nil {
+		return nil, err
+	}
+	if len(workspaces) == 0 {
+		return nil, fmt.Errorf("No workspaces found in config.fel file.")
+	}
+	workspaces, err = filterWorkspaces(workspaces, options.Workspace)
+	if err != nil {
+		return nil, err
+	}
+	builds := make([]*WorkspaceBuild, 0, len(workspaces))
+	for _, workspace := range workspaces {
+		b, err := newWorkspaceBuild(projectDirpath, workspace, options)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, b)
+	}
+	return builds, nil
+}
+
+func newWorkspaceBuild(projectDirpath string, workspace evaluator.Workspace, options BuildOptions) (*WorkspaceBuild, error) {
+	templateInputDirectory := workspace.TemplateInputDirectory()
+	if templateInputDirectory == "" {
+		return nil, fmt.Errorf("template_input_directory has not been configured.")
+	}
+	templateOutputDirectory := workspace.TemplateOutputDirectory()
+	if templateOutputDirectory == "" {
+		return nil, fmt.Errorf("template_output_directory has not been configured.")
+	}
+	cssOutputDirectory := workspace.CSSOutputDirectory()
+	if cssOutputDirectory == "" {
+		return nil, fmt.Errorf("css_output_directory has not been configured.")
+	}
+
+	b := &WorkspaceBuild{
+		workspace:               workspace,
+		options:                 options,
+		projectDirpath:          projectDirpath,
+		templateInputDirectory:  path.Clean(fmt.Sprintf("%s/%s", projectDirpath, templateInputDirectory)),
+		templateOutputDirectory: path.Clean(fmt.Sprintf("%s/%s", projectDirpath, templateOutputDirectory)),
+		cssOutputDirectory:      path.Clean(fmt.Sprintf("%s/%s", projectDirpath, cssOutputDirectory)),
+		files:                   make(map[string]*SourceFile),
+		htmlOutputs:             make(map[string]*data.HTMLElement),
+		htmlContents:            make(map[string]string),
+		needsFullBuild:          true,
+		stdout:                  os.Stdout,
+	}
+	if !options.CheckOnly && !options.NoCache {
+		b.cache = NewBuildCache(projectDirpath)
+	}
+
+	// Check if configured folders exist, output folders are created
+	// when building so it's reported with the rest of the build's output.
+	err := b.folderExistsMaybeCreate(b.templateInputDirectory, "template_input_directory", false)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *WorkspaceBuild) Name() string { return b.workspace.Name() }
+
+// ScanFiles finds all *.fel files in the project and reads the ones that were added or modified
+// since the last scan. It returns the filepaths of added, modified and removed files.
+func (b *WorkspaceBuild) ScanFiles(timings *BuildTimings) ([]string, error) {
+	diskIOTimeSpentTimer := time.Now()
+	defer func() {
+		timings.diskIO += time.Since(diskIOTimeSpentTimer)
+	}()
+
+	var changed []string
+	found := make(map[string]bool, len(b.files))
+	err := filepath.Walk(b.projectDirpath, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() || filepath.Ext(f.Name()) != ".fel" {
+			return nil
+		}
+		// Replace Windows-slash with forward slash.
+		// NOTE: This ensures consistent comparison of filepath strings and fixes a bug.
+		path = strings.Replace(path, "\\", "/", -1)
+		found[path] = true
+		if file, ok := b.files[path]; ok &&
+			file.modTime.Equal(f.ModTime()) &&
+			file.size == f.Size() {
+			return nil
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("An error occurred reading file: %v, Error message: %v", path, err)
+		}
+		b.files[path] = &SourceFile{
+			modTime:  f.ModTime(),
+			size:     f.Size(),
+			contents: contents,
+		}
+		changed = append(changed, path)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("An error occurred reading: %v, Error Message: %v", b.projectDirpath, err)
+	}
+	for path := range b.files {
+		if !found[path] {
+			delete(b.files, path)
+			changed = append(changed, path)
+		}
+	}
+	if len(b.files) == 0 {
+		return nil, fmt.Errorf("No *.fel files found in your project's \"templates\" directory: %v", b.templateInputDirectory)
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// filepaths gets the scanned files in sorted order
+func (b *WorkspaceBuild) filepaths() []string {
+	filepathSet := make([]string, 0, len(b.files))
+	for filepath := range b.files {
+		filepathSet = append(filepathSet, filepath)
+	}
+	sort.Strings(filepathSet)
+	return filepathSet
+}
+
+func (b *WorkspaceBuild) isTemplateFile(filepath string) bool {
+	return strings.HasPrefix(filepath, b.templateInputDirectory+"/")
+}
+
+// affectedTemplates determines which templates need to be rebuilt after the
+// given files changed, or returns nil if everything should be rebuilt.
+func (b *WorkspaceBuild) affectedTemplates(astFiles []*ast.File, changed []string) map[string]bool {
+	changedFiles := make(map[string]bool, len(changed))
+	for _, filepath := range changed {
+		changedFiles[filepath] = true
+		if _, ok := b.files[filepath]; !ok {
+			// Removed files may have defined anything
+			return nil
+		}
+	}
+
+	// Get components defined in the changed files
+	changedComponents := make(map[string]bool)
+	for _, astFile := range astFiles {
+		if !changedFiles[astFile.Filepath] {
+			continue
+		}
+		for _, node := range astFile.Nodes() {
+			switch node := node.(type) {
+			case *ast.HTMLComponentDefinition:
+				changedComponents[node.Name.String()] = true
+			case *ast.CSSDefinition, *ast.CSSConfigDefinition:
+				// CSS output is always regenerated
+			default:
+				// Structs and procedures aren't tracked in
+				// ast.File.Dependencies, so we can't know who
+				// uses them.
+				if !b.isTemplateFile(astFile.Filepath) {
+					return nil
+				}
+			}
+		}
+	}
+
+	// Get components that use a changed component
+	for _, astFile := range astFiles {
+		for _, node := range astFile.Nodes() {
+			htmlDefinition, ok := node.(*ast.HTMLComponentDefinition)
+			if !ok {
+				continue
+			}
+			for name := range htmlDefinition.Dependencies {
+				if changedComponents[name] {
+					changedComponents[htmlDefinition.Name.String()] = true
+					break
+				}
+			}
+		}
+	}
+
+	result := make(map[string]bool)
+	for _, astFile := range astFiles {
+		if !b.isTemplateFile(astFile.Filepath) {
+			continue
+		}
+		if changedFiles[astFile.Filepath] {
+			result[astFile.Filepath] = true
+			continue
+		}
+		for name := range astFile.Dependencies {
+			if changedComponents[name] {
+				result[astFile.Filepath] = true
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Build compiles the workspace. If changed is nil, all templates are rebuilt, otherwise
+// only templates affected by the changed files are rebuilt.
+//
+// Only emitting, executing and writing templates is incremental. Every file
+// in the workspace is still parsed and typechecked, as the typer stores what
+// it resolves (ie. types of structs declared in other files) on the AST, so
+// an unchanged file's AST can be stale after another file changes.
+func (b *WorkspaceBuild) Build(changed []string, timings *BuildTimings) (BuildResult, error) {
+	isVerbose := b.options.Verbosity == VerbosityVerbose
+	result := BuildResult{}
+	if b.needsFullBuild {
+		changed = nil
+	}
+
+	// Failed builds need to rebuild everything next time
+	b.needsFullBuild = true
+
+	// Unchanged files are re-parsed from memory rather than
+	// reusing their AST, see above.
+	filepathSet := b.filepaths()
+
+	var codeRecords []TemplateFile
+	var cssDefinitionBlocks []CSSDefinition
+	var affected map[string]bool
+	if changed == nil && b.cache != nil {
+		diskIOTimeSpentTimer := time.Now()
+		codeRecords, cssDefinitionBlocks, result.FromCache = b.loadWorkspace()
+		timings.diskIO += time.Since(diskIOTimeSpentTimer)
+	}
+	if !result.FromCache {
+		var err error
+		codeRecords, cssDefinitionBlocks, affected, err = b.compile(filepathSet, changed, timings)
+		if err != nil {
+			return result, err
+		}
+	}
+	if b.options.CheckOnly {
+		b.needsFullBuild = false
+		return result, nil
+	}
+	err := b.folderExistsMaybeCreate(b.templateOutputDirectory, "template_output_directory", true)
+	if err != nil {
+		return result, err
+	}
+	err = b.folderExistsMaybeCreate(b.cssOutputDirectory, "css_output_directory", true)
+	if err != nil {
+		return result, err
+	}
+
+	// Execute template code
+	{
+		executionSpentTimer := time.Now()
+		results := make([]interface{}, len(codeRecords))
+		errs := make([]error, len(codeRecords))
+		forEachParallel(b.options.jobs(), len(codeRecords), func(i int) {
+			results[i], errs[i] = vm.ExecuteNewProgram(codeRecords[i].code)
+		})
+
+		// Check results in order so errors are reported the same
+		// regardless of how many jobs are used.
+		var diagnostics []*errors.Diagnostic
+		for i, _ := range codeRecords {
+			codeRecord := &codeRecords[i]
+			if err := errs[i]; err != nil {
+				diagnostics = append(diagnostics, runtimeDiagnostic(err))
+				continue
+			}
+			switch result := results[i].(type) {
+			case *data.HTMLElement:
+				codeRecord.output = result
+				if isVerbose {
+					fmt.Fprintf(b.stdout, "Filename: %s\n%s\n", codeRecord.filepath, result.Debug())
+				}
+			default:
+				diagnostics = append(diagnostics, unexpectedResultDiagnostic(codeRecord.code, result))
+			}
+		}
+		timings.execution += time.Since(executionSpentTimer)
+		if len(diagnostics) > 0 {
+			return result, b.newBuildError("Stopping due to runtime errors.", diagnostics)
+		}
+
+		//
+		diskIOTimeSpentTimer := time.Now()
+		if affected == nil {
+			b.htmlOutputs = make(map[string]*data.HTMLElement, len(codeRecords))
+		}
+		for _, codeRecord := range codeRecords {
+			filename := codeRecord.filepath
+			htmlElement := codeRecord.output
+			result.Templates = append(result.Templates, filename)
+			if htmlElement == nil {
+				continue
+			}
+			b.htmlOutputs[filename] = htmlElement
+
+			baseFilename := filename[len(b.templateInputDirectory) : len(filename)-4]
+			outputFilepath := filepath.Clean(fmt.Sprintf("%s%s.html", b.templateOutputDirectory, baseFilename))
+
+			content := printer.PrettyHTML(htmlElement)
+			if previousContent, ok := b.htmlContents[outputFilepath]; ok && previousContent == content {
+				continue
+			}
+			err := ioutil.WriteFile(
+				outputFilepath,
+				[]byte(content),
+				0644,
+			)
+			if err != nil {
+				return result, fmt.Errorf("An error occurred writing file: %v, Error message: %v", outputFilepath, err)
+			}
+			b.htmlContents[outputFilepath] = content
+			result.HTMLFiles = append(result.HTMLFiles, outputFilepath)
+		}
+		timings.diskIO += time.Since(diskIOTimeSpentTimer)
+	}
+
+	// Execute CSS code
+	{
+		// Unused CSS rules are removed based on every template's
+		// output, so we keep outputs of templates that weren't rebuilt.
+		htmlOutputs := make([]*data.HTMLElement, 0, len(b.htmlOutputs))
+		for _, filepath := range filepathSet {
+			if output, ok := b.htmlOutputs[filepath]; ok {
+				htmlOutputs = append(htmlOutputs, output)
+			}
+		}
+
+		var buffer bytes.Buffer
+		var diagnostics []*errors.Diagnostic
+		executionSpentTimer := time.Now()
+		for _, codeRecord := range cssDefinitionBlocks {
+			result, err := vm.ExecuteNewProgram(codeRecord.code)
+			if err != nil {
+				diagnostics = append(diagnostics, runtimeDiagnostic(err))
+				continue
+			}
+			switch result := result.(type) {
+			case *data.CSSDefinition:
+				// Remove unused CSS rules
+				evaluator.OptimizeCSSDefinition(result, htmlOutputs, codeRecord.cssConfig)
+				if len(result.Rules()) == 0 {
+					continue
+				}
+
+				//fmt.Printf("Filename: %s\n%v\n\n", codeRecord.ast.Name.String(), result.Debug())
+				buffer.WriteString("/* Filename: ")
+				buffer.WriteString(codeRecord.name)
+				buffer.WriteString("*/ \n")
+				buffer.WriteString(result.Debug())
+				buffer.WriteString("\n")
+			default:
+				diagnostics = append(diagnostics, unexpectedResultDiagnostic(codeRecord.code, result))
+			}
+		}
+		cssOutput := buffer.String()
+		timings.execution += time.Since(executionSpentTimer)
+		if len(diagnostics) > 0 {
+			return result, b.newBuildError("Stopping due to runtime errors.", diagnostics)
+		}
+
+		// Generate files
+		if !b.hasWrittenCSS || cssOutput != b.cssOutput {
+			result.CSSChanged = true
+			b.cssOutput = cssOutput
+			b.hasWrittenCSS = true
+			cssDirpath := b.projectDirpath + b.workspace.CSSOutputDirectory() + "/"
+			diskIOTimeSpentTimer := time.Now()
+			for _, cssFilename := range b.workspace.CSSFiles() {
+				// todo(Jake): 2018-04-23
+				//
+				// Fix permissions on this file write to a better default
+				//
+				err := ioutil.WriteFile(cssDirpath+cssFilename, []byte(cssOutput), 0744)
+				if err != nil {
+					return result, fmt.Errorf("An error occurred writing file: %v, Error message: %v", cssDirpath+cssFilename, err)
+				}
+			}
+			timings.diskIO += time.Since(diskIOTimeSpentTimer)
+		}
+		if isVerbose {
+			fmt.Fprintf(b.stdout, "CSS Output:\n%s\n", cssOutput)
+		}
+	}
+	b.needsFullBuild = false
+	return result, nil
+}
+
+// compile parses, typechecks and emits bytecode for the templates affected by
+// the changed files, or all templates if changed is nil. It returns which
+// templates were affected, or nil if all of them were.
+func (b *WorkspaceBuild) compile(filepathSet []string, changed []string, timings *BuildTimings) ([]TemplateFile, []CSSDefinition, map[string]bool, error) {
+	astFiles, htmlComponentsUsed, err := b.parseAndTypecheck(filepathSet, timings)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if b.options.CheckOnly {
+		return nil, nil, nil, nil
+	}
+
+	var affected map[string]bool
+	if changed != nil {
+		affected = b.affectedTemplates(astFiles, changed)
+	}
+
+	emitSpentTimer := time.Now()
+	emit := emitter.New()
+	emit.EmitGlobalScope(astFiles)
+
+	// Emit CSS
+	cssDefinitionBlocks := make([]CSSDefinition, 0, 100)
+	for _, htmlDefinition := range htmlComponentsUsed {
+		cssDef := htmlDefinition.CSSDefinition
+		if cssDef == nil {
+			continue
+		}
+		codeBlock := emit.EmitCSSDefinition(cssDef, htmlDefinition.CSSConfigDefinition)
+		cssDefinitionBlocks = append(cssDefinitionBlocks, CSSDefinition{
+			name:      cssDef.Name.String(),
+			ast:       cssDef,
+			cssConfig: htmlDefinition.CSSConfigDefinition,
+			code:      codeBlock,
+		})
+	}
+
+	// Emit anonymous ":: css" blocks in templates
+	for _, astFile := range astFiles {
+		if !b.isTemplateFile(astFile.Filepath) {
+			continue
+		}
+		for _, node := range astFile.Nodes() {
+			cssDef, ok := node.(*ast.CSSDefinition)
+			if !ok || cssDef.Name.Kind != token.Unknown {
+				continue
+			}
+			codeBlock := emit.EmitCSSDefinition(cssDef, nil)
+			cssDefinitionBlocks = append(cssDefinitionBlocks, CSSDefinition{
+				name: astFile.Filepath[len(b.templateInputDirectory)+1:],
+				ast:  cssDef,
+				code: codeBlock,
+			})
+		}
+	}
+
+	// Emit template directories
+	var sharedHash string
+	if b.cache != nil {
+		sharedHash = b.sharedHash()
+	}
+	codeRecords := make([]TemplateFile, 0, len(astFiles))
+	var emitted []TemplateFile
+	var templates []string
+	for _, astFile := range astFiles {
+		if !b.isTemplateFile(astFile.Filepath) ||
+			len(astFile.Nodes()) == 0 {
+			continue
+		}
+		templates = append(templates, astFile.Filepath)
+		if affected != nil && !affected[astFile.Filepath] {
+			continue
+		}
+		if b.cache != nil {
+			if codeBlock, ok := b.loadTemplate(astFile.Filepath, sharedHash); ok {
+				codeRecords = append(codeRecords, TemplateFile{
+					filepath: astFile.Filepath,
+					code:     codeBlock,
+				})
+				continue
+			}
+		}
+		codeBlock := emit.EmitBytecode(astFile, emitter.FileOptions{
+			IsTemplateFile: true,
+		})
+		codeRecord := TemplateFile{
+			filepath: astFile.Filepath,
+			code:     codeBlock,
+		}
+		codeRecords = append(codeRecords, codeRecord)
+		emitted = append(emitted, codeRecord)
+	}
+	timings.emit += time.Since(emitSpentTimer)
+	if emit.HasErrors() {
+		return nil, nil, nil, b.newBuildError("Stopping due to emitter errors.", emit.Diagnostics())
+	}
+
+	if b.cache != nil {
+		diskIOTimeSpentTimer := time.Now()
+		err := b.saveTemplates(astFiles, emitted)
+		if err == nil {
+			err = b.saveWorkspace(templates, cssDefinitionBlocks)
+		}
+		if err != nil && b.options.Verbosity != VerbosityQuiet {
+			// The cache is only an optimization, so carry on without it
+			fmt.Fprintf(b.stdout, "Warning: Unable to write to %s: %v\n", cacheDirectoryName, err)
+		}
+		timings.diskIO += time.Since(diskIOTimeSpentTimer)
+	}
+	return codeRecords, cssDefinitionBlocks, affected, nil
+}
+
+// parseAndTypecheck parses the files from memory and typechecks them,
+// it returns the components that need their CSS output.
+func (b *WorkspaceBuild) parseAndTypecheck(filepathSet []string, timings *BuildTimings) ([]*ast.File, []*ast.HTMLComponentDefinition, error) {
+	// Parse files
+	astFiles := make([]*ast.File, 0, len(filepathSet))
+	{
+		parseSpentTimer := time.Now()
+		parsers := make([]*parser.Parser, len(filepathSet))
+		parsedFiles := make([]*ast.File, len(filepathSet))
+		forEachParallel(b.options.jobs(), len(filepathSet), func(i int) {
+			// A parser can only be used by one goroutine
+			p := parser.New()
+			parsedFiles[i] = p.Parse(b.files[filepathSet[i]].contents, filepathSet[i])
+			parsers[i] = p
+		})
+		timings.parsing += time.Since(parseSpentTimer)
+
+		// Errors are collected in file order so they're the same
+		// regardless of how many jobs are used.
+		var diagnostics []*errors.Diagnostic
+		hasErrors := false
+		for i, filepath := range filepathSet {
+			p := parsers[i]
+			diagnostics = append(diagnostics, p.Diagnostics()...)
+			hasErrors = hasErrors || p.HasErrors()
+			astFile := parsedFiles[i]
+			if astFile == nil {
+				return nil, nil, b.newBuildError(fmt.Sprintf("Empty source file: %s.", filepath), diagnostics)
+			}
+			if p.Scanner.HasErrors() {
+				return nil, nil, b.newBuildError("Stopping due to scanning errors.", diagnostics)
+			}
+			astFiles = append(astFiles, astFile)
+		}
+		if hasErrors {
+			return nil, nil, b.newBuildError("Stopping due to parsing errors.", diagnostics)
+		}
+	}
+
+	// Apply type information and typecheck when we've parsed all files
+	typerSpentTimer := time.Now()
+	p := typer.New()
+	p.ApplyTypeInfoAndTypecheck(astFiles)
+	timings.typer += time.Since(typerSpentTimer)
+	if p.HasErrors() {
+		return nil, nil, b.newBuildError("Stopping due to type errors.", p.Diagnostics())
+	}
+	return astFiles, p.HTMLComponentsUsed(), nil
+}
+
+func printTimings(w io.Writer, timings BuildTimings, totalTimeSpent time.Duration) {
+	fmt.Fprintf(w, "Disk IO time: %s\n", timings.diskIO)
+	fmt.Fprintf(w, "Parsing time: %s (Typer: %s)\n", timings.parsing+timings.typer, timings.typer)
+	fmt.Fprintf(w, "Emitter time: %s\n", timings.emit)
+	fmt.Fprintf(w, "Execution time: %s\n", timings.execution)
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	fmt.Fprintf(w, "Total time: %s (Memory used: %fmb)\n", totalTimeSpent, float32(m.TotalAlloc/1024)/100)
+	//fmt.Printf("\nAlloc = %v\nTotalAlloc = %v\nSys = %v\nNumGC = %v\n\n", m.Alloc/1024, (m.TotalAlloc/1024)/100, m.Sys/1024, m.NumGC)
+}
+
+func compileProject(projectDirpath string, options BuildOptions) error {
+	builds, err := newWorkspaceBuilds(projectDirpath, options)
+	if err != nil {
+		return err
+	}
+	isQuiet := options.Verbosity == VerbosityQuiet
+	isVerbose := options.Verbosity == VerbosityVerbose
+
+	// Workspaces are built in parallel, so each one prints to a
+	// buffer that is written out in order once they've all finished.
+	outputs := make([]bytes.Buffer, len(builds))
+	errs := make([]error, len(builds))
+	forEachParallel(options.jobs(), len(builds), func(i int) {
+		b := builds[i]
+		w := &outputs[i]
+		b.stdout = w
+		totalTimeSpentTimer := time.Now()
+		var timings BuildTimings
+		if _, err := b.ScanFiles(&timings); err != nil {
+			errs[i] = err
+			return
+		}
+		result, err := b.Build(nil, &timings)
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		if isQuiet {
+			return
+		}
+		if options.CheckOnly {
+			fmt.Fprintf(w, "Checked workspace #%d \"%s\", found no errors in %d files.\n", i, b.Name(), len(b.files))
+			return
+		}
+		fromCache := ""
+		if result.FromCache {
+			fromCache = ", from " + cacheDirectoryName
+		}
+		if !isVerbose {
+			fmt.Fprintf(w, "Built workspace #%d \"%s\" (%d templates%s) in %s\n", i, b.Name(), len(result.Templates), fromCache, time.Since(totalTimeSpentTimer))
+			return
+		}
+		fmt.Fprintf(w, "Built workspace #%d \"%s\"%s\n", i, b.Name(), strings.TrimPrefix(fromCache, ","))
+		printTimings(w, timings, time.Since(totalTimeSpentTimer))
+	})
+	for i := range builds {
+		os.Stdout.Write(outputs[i].Bytes())
+		if errs[i] != nil {
+			return errs[i]
+		}
+	}
+	return nil
+}
+
+// forEachParallel calls fn for 0 to n-1, running at most jobs calls at once.
+// It returns when every call has finished. If a call panics, the panic is
+// raised again on the calling goroutine so it can still be recovered.
+func forEachParallel(jobs int, n int, fn func(i int)) {
+	if jobs > n {
+		jobs = n
+	}
+	if jobs <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var panicOnce sync.Once
+	var panicValue interface{}
+	wg.Add(jobs)
+	for j := 0; j < jobs; j++ {
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					panicOnce.Do(func() { panicValue = r })
+					// Keep draining so the sender doesn't block
+					for range indexes {
+					}
+				}
+			}()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	if panicValue != nil {
+		panic(panicValue)
+	}
+}
+
+func (b *WorkspaceBuild) folderExistsMaybeCreate(directory string, configName string, createIfDoesntExist bool) error {
+	_, err := os.Stat(directory)
+	if os.IsNotExist(err) {
+		if !createIfDoesntExist {
+			return fmt.Errorf("%s: does not exist: %s", configName, directory)
+		}
+		if b.options.Verbosity != VerbosityQuiet {
+			fmt.Fprintf(b.stdout, "%s: Creating missing folder \"%s\"\n", configName, directory)
+		}
+		err = os.MkdirAll(directory, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("%s: error: %v", configName, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: OS error: %v", configName, err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildMultipleWorkspaces(t *testing.T) {
+	projectDirpath := writeTestProject(t, map[string]string{
+		"config.fel": `
+			first :: workspace {
+				w := workspace
+				w.template_input_directory = "first"
+				w.template_output_directory = "public/first"
+				w.css_output_directory = "public/first/css"
+			}
+
+			second :: workspace {
+				w := workspace
+				w.template_input_directory = "second"
+				w.template_output_directory = "public/second"
+				w.css_output_directory = "public/second/css"
+			}
+		`,
+		"first/index.fel": `
+			div {
+				"First page"
+			}
+		`,
+		"second/index.fel": `
+			div {
+				"Second page"
+			}
+		`,
+	})
+	defer os.RemoveAll(projectDirpath)
+
+	err := compileProject(projectDirpath, BuildOptions{
+		NoCache:   true,
+		Verbosity: VerbosityQuiet,
+	})
+	if err != nil {
+		if err, ok := err.(*BuildError); ok {
+			t.Fatal(err.Details())
+		}
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"first":  "First page",
+		"second": "Second page",
+	} {
+		contents, err := ioutil.ReadFile(filepath.Join(projectDirpath, "public", name, "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Expected %s/index.html to contain \"%s\", instead got:\n%s", name, expected, contents)
+		}
+	}
+}
+
+func TestAffectedTemplates(t *testing.T) {
+	projectDirpath := writeTestProject(t, map[string]string{
+		"config.fel": `
+			default :: workspace {
+				w := workspace
+				w.template_input_directory = "templates"
+				w.template_output_directory = "public"
+				w.css_output_directory = "public/css"
+			}
+		`,
+		"components/Button.fel": `
+			Button :: html {
+				button {
+					"Click"
+				}
+			}
+		`,
+		"components/Card.fel": `
+			Card :: html {
+				div {
+					Button {
+					}
+				}
+			}
+		`,
+		"templates/button.fel": `
+			div {
+				Button {
+				}
+			}
+		`,
+		"templates/card.fel": `
+			div {
+				Card {
+				}
+			}
+		`,
+		"templates/plain.fel": `
+			div {
+				"No components"
+			}
+		`,
+	})
+	defer os.RemoveAll(projectDirpath)
+
+	builds, err := newWorkspaceBuilds(projectDirpath, BuildOptions{
+		CheckOnly: true,
+		Verbosity: VerbosityQuiet,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := builds[0]
+	var timings BuildTimings
+	if _, err := b.ScanFiles(&timings); err != nil {
+		t.Fatal(err)
+	}
+	astFiles, _, err := b.parseAndTypecheck(b.filepaths(), &timings)
+	if err != nil {
+		if err, ok := err.(*BuildError); ok {
+			t.Fatal(err.Details())
+		}
+		t.Fatal(err)
+	}
+
+	affected := b.affectedTemplates(astFiles, []string{projectDirpath + "components/Button.fel"})
+	expected := map[string]bool{
+		b.templateInputDirectory + "/button.fel": true,
+		b.templateInputDirectory + "/card.fel":   true,
+	}
+	if len(affected) != len(expected) {
+		t.Errorf("Expected %d affected templates, instead got %v", len(expected), affected)
+	}
+	for filepath := range expected {
+		if !affected[filepath] {
+			t.Errorf("Expected %s to be affected, instead got %v", filepath, affected)
+		}
+	}
+}
+
+func TestBuildCache(t *testing.T) {
+	projectDirpath := writeTestProject(t, map[string]string{
+		"config.fel": `
+			default :: workspace {
+				w := workspace
+				w.template_input_directory = "templates"
+				w.template_output_directory = "public"
+				w.css_output_directory = "public/css"
+			}
+		`,
+		"templates/index.fel": `
+			div {
+				"Cached page"
+			}
+		`,
+	})
+	defer os.RemoveAll(projectDirpath)
+
+	for i, expectFromCache := range []bool{false, true} {
+		if err := os.RemoveAll(filepath.Join(projectDirpath, "public")); err != nil {
+			t.Fatal(err)
+		}
+		builds, err := newWorkspaceBuilds(projectDirpath, BuildOptions{
+			Verbosity: VerbosityQuiet,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := builds[0]
+		var timings BuildTimings
+		if _, err := b.ScanFiles(&timings); err != nil {
+			t.Fatal(err)
+		}
+		result, err := b.Build(nil, &timings)
+		if err != nil {
+			if err, ok := err.(*BuildError); ok {
+				t.Fatal(err.Details())
+			}
+			t.Fatal(err)
+		}
+		if result.FromCache != expectFromCache {
+			t.Fatalf("Build #%d: expected FromCache to be %v", i+1, expectFromCache)
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(projectDirpath, "public", "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(contents), "Cached page") {
+			t.Errorf("Build #%d: expected index.html to contain \"Cached page\", instead got:\n%s", i+1, contents)
+		}
+	}
+}
+
+// writeTestProject creates a project in a temporary directory and
+// returns its path, with a trailing slash like the "fel" command uses.
+func writeTestProject(t *testing.T, files map[string]string) string {
+	projectDirpath, err := ioutil.TempDir("", "fel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		path := filepath.Join(projectDirpath, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return projectDirpath + "/"
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/silbinarywolf/compiler-fel/ast"
+	"github.com/silbinarywolf/compiler-fel/bytecode"
+	"github.com/silbinarywolf/compiler-fel/token"
+)
+
+// cacheDirectoryName is created in the project directory
+const cacheDirectoryName = ".felcache"
+
+// BuildCache stores compiled bytecode on disk so that a build where no
+// files have changed can skip parsing, typechecking and emitting.
+//
+// Entries are named by a hash of the source file (or workspace) they're
+// for, followed by a hash of everything that affects their output. They're
+// never updated in-place, instead writing an entry removes the ones it
+// supersedes.
+type BuildCache struct {
+	dirpath string
+}
+
+// cachedTemplate is the bytecode for a single template file
+type cachedTemplate struct {
+	Filepath string
+	// Dependencies are other template files that declared components,
+	// structs or procedures when this was compiled, with their hash.
+	Dependencies map[string]string
+	Bytecode     []byte
+}
+
+// cachedWorkspace is the CSS bytecode for a workspace, and the template
+// files that make up its output.
+type cachedWorkspace struct {
+	Templates      []string
+	CSSDefinitions []cachedCSSDefinition
+	Bytecode       []byte
+}
+
+type cachedCSSDefinition struct {
+	Name      string
+	CSSConfig *ast.CSSConfigDefinition
+}
+
+func NewBuildCache(projectDirpath string) *BuildCache {
+	return &BuildCache{
+		dirpath: filepath.Join(projectDirpath, cacheDirectoryName),
+	}
+}
+
+// hashFile gets the hash used to detect changes to a file
+func hashFile(contents []byte) string {
+	hash := sha256.Sum256(contents)
+	return hex.EncodeToString(hash[:])
+}
+
+var (
+	compilerHashOnce  sync.Once
+	compilerHashValue string
+)
+
+// compilerHash identifies the build of the running compiler. The version
+// isn't bumped for every change to the emitter, so a build from a clean
+// checkout uses its VCS revision, otherwise the size and modification time
+// of the executable are used. This avoids reading the executable itself on
+// every build.
+func compilerHash() string {
+	compilerHashOnce.Do(func() {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			revision, modified := "", false
+			for _, setting := range info.Settings {
+				switch setting.Key {
+				case "vcs.revision":
+					revision = setting.Value
+				case "vcs.modified":
+					modified = setting.Value == "true"
+				}
+			}
+			if revision != "" && !modified {
+				compilerHashValue = revision
+				return
+			}
+		}
+		if executable, err := os.Executable(); err == nil {
+			if info, err := os.Stat(executable); err == nil {
+				compilerHashValue = fmt.Sprintf("%s\x00%d\x00%d", executable, info.Size(), info.ModTime().UnixNano())
+			}
+		}
+	})
+	return compilerHashValue
+}
+
+// cacheKey hashes the parts into a filename-safe key
+func cacheKey(parts ...string) string {
+	hash := sha256.New()
+	// Cache entries are only valid for the compiler that wrote
+	// them, as the emitted bytecode changes between builds.
+	fmt.Fprintf(hash, "%s\x00%d\x00%s\x00", version, bytecode.EncodingVersion, compilerHash())
+	for _, part := range parts {
+		hash.Write([]byte(strconv.Itoa(len(part))))
+		hash.Write([]byte{0})
+		hash.Write([]byte(part))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// cacheEntryName combines the key of what an entry is for (ie. a template
+// file) with the key of its contents.
+func cacheEntryName(sourceKey string, contentKey string) string {
+	return sourceKey[:16] + "-" + contentKey
+}
+
+func (cache *BuildCache) read(key string, value interface{}) bool {
+	contents, err := ioutil.ReadFile(filepath.Join(cache.dirpath, key))
+	if err != nil {
+		return false
+	}
+	return gob.NewDecoder(bytes.NewReader(contents)).Decode(value) == nil
+}
+
+func (cache *BuildCache) write(key string, value interface{}) error {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(value); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cache.dirpath, os.ModePerm); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a build that's interrupted
+	// doesn't leave a partial entry behind.
+	file, err := ioutil.TempFile(cache.dirpath, key+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(buffer.Bytes())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(cache.dirpath, key))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	cache.prune(key)
+	return nil
+}
+
+// prune removes entries for the same source as key, so the cache doesn't
+// grow every time a file is edited.
+func (cache *BuildCache) prune(key string) {
+	index := strings.IndexByte(key, '-')
+	if index == -1 {
+		return
+	}
+	prefix := key[:index+1]
+	files, err := ioutil.ReadDir(cache.dirpath)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		name := file.Name()
+		if name == key ||
+			!strings.HasPrefix(name, prefix) ||
+			strings.Contains(name, ".tmp") {
+			continue
+		}
+		os.Remove(filepath.Join(cache.dirpath, name))
+	}
+}
+
+// sharedHash hashes every file that isn't a template, as templates
+// can use anything declared in them.
+func (b *WorkspaceBuild) sharedHash() string {
+	var parts []string
+	for _, filepath := range b.filepaths() {
+		if b.isTemplateFile(filepath) {
+			continue
+		}
+		parts = append(parts, filepath, hashFile(b.files[filepath].contents))
+	}
+	return cacheKey(parts...)
+}
+
+func (b *WorkspaceBuild) templateCacheKey(filepath string, sharedHash string) string {
+	return cacheEntryName(
+		cacheKey("template", b.Name(), filepath),
+		cacheKey("template", filepath, hashFile(b.files[filepath].contents), sharedHash),
+	)
+}
+
+func (b *WorkspaceBuild) workspaceCacheKey() string {
+	parts := []string{"workspace", b.Name(), b.templateInputDirectory}
+	for _, filepath := range b.filepaths() {
+		parts = append(parts, filepath, hashFile(b.files[filepath].contents))
+	}
+	return cacheEntryName(cacheKey("workspace", b.Name()), cacheKey(parts...))
+}
+
+// loadTemplate gets the cached bytecode for a template file
+func (b *WorkspaceBuild) loadTemplate(filepath string, sharedHash string) (*bytecode.Block, bool) {
+	var entry cachedTemplate
+	if !b.cache.read(b.templateCacheKey(filepath, sharedHash), &entry) ||
+		entry.Filepath != filepath {
+		return nil, false
+	}
+	for dependency, hash := range entry.Dependencies {
+		file, ok := b.files[dependency]
+		if !ok || hashFile(file.contents) != hash {
+			return nil, false
+		}
+	}
+	blocks, err := bytecode.Decode(bytes.NewReader(entry.Bytecode))
+	if err != nil || len(blocks) != 1 {
+		return nil, false
+	}
+	return blocks[0], true
+}
+
+// loadWorkspace gets the bytecode for every template and CSS definition if
+// none of the files have changed since it was cached.
+func (b *WorkspaceBuild) loadWorkspace() ([]TemplateFile, []CSSDefinition, bool) {
+	var entry cachedWorkspace
+	if !b.cache.read(b.workspaceCacheKey(), &entry) {
+		return nil, nil, false
+	}
+	sharedHash := b.sharedHash()
+	codeRecords := make([]TemplateFile, 0, len(entry.Templates))
+	for _, filepath := range entry.Templates {
+		block, ok := b.loadTemplate(filepath, sharedHash)
+		if !ok {
+			return nil, nil, false
+		}
+		codeRecords = append(codeRecords, TemplateFile{
+			filepath: filepath,
+			code:     block,
+		})
+	}
+	blocks, err := bytecode.Decode(bytes.NewReader(entry.Bytecode))
+	if err != nil || len(blocks) != len(entry.CSSDefinitions) {
+		return nil, nil, false
+	}
+	cssDefinitionBlocks := make([]CSSDefinition, 0, len(blocks))
+	for i, block := range blocks {
+		cssDefinitionBlocks = append(cssDefinitionBlocks, CSSDefinition{
+			name:      entry.CSSDefinitions[i].Name,
+			cssConfig: entry.CSSDefinitions[i].CSSConfig,
+			code:      block,
+		})
+	}
+	return codeRecords, cssDefinitionBlocks, true
+}
+
+// declaresSymbols is true if a template file declares anything other
+// templates could use.
+func declaresSymbols(astFile *ast.File) bool {
+	for _, node := range astFile.Nodes() {
+		switch node := node.(type) {
+		case *ast.HTMLComponentDefinition, *ast.StructDefinition, *ast.ProcedureDefinition:
+			return true
+		case *ast.CSSDefinition:
+			if node.Name.Kind != token.Unknown {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// saveTemplates caches the bytecode of templates that were emitted
+func (b *WorkspaceBuild) saveTemplates(astFiles []*ast.File, codeRecords []TemplateFile) error {
+	dependencies := make(map[string]string)
+	for _, astFile := range astFiles {
+		if b.isTemplateFile(astFile.Filepath) && declaresSymbols(astFile) {
+			dependencies[astFile.Filepath] = hashFile(b.files[astFile.Filepath].contents)
+		}
+	}
+	sharedHash := b.sharedHash()
+	for _, codeRecord := range codeRecords {
+		var buffer bytes.Buffer
+		if err := bytecode.Encode(&buffer, codeRecord.code); err != nil {
+			return err
+		}
+		entry := cachedTemplate{
+			Filepath:     codeRecord.filepath,
+			Dependencies: dependencies,
+			Bytecode:     buffer.Bytes(),
+		}
+		if err := b.cache.write(b.templateCacheKey(codeRecord.filepath, sharedHash), &entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveWorkspace caches the CSS bytecode and the list of templates
+func (b *WorkspaceBuild) saveWorkspace(templates []string, cssDefinitionBlocks []CSSDefinition) error {
+	entry := cachedWorkspace{
+		Templates: templates,
+	}
+	blocks := make([]*bytecode.Block, 0, len(cssDefinitionBlocks))
+	for _, codeRecord := range cssDefinitionBlocks {
+		entry.CSSDefinitions = append(entry.CSSDefinitions, cachedCSSDefinition{
+			Name:      codeRecord.name,
+			CSSConfig: codeRecord.cssConfig,
+		})
+		blocks = append(blocks, codeRecord.code)
+	}
+	var buffer bytes.Buffer
+	if err := bytecode.Encode(&buffer, blocks...); err != nil {
+		return err
+	}
+	entry.Bytecode = buffer.Bytes()
+	return b.cache.write(b.workspaceCacheKey(), &entry)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/silbinarywolf/compiler-fel/ast"
+	"github.com/silbinarywolf/compiler-fel/bytecode"
+	"github.com/silbinarywolf/compiler-fel/emitter"
+)
+
+// findProjectDirectory walks up from dir until it finds a config.fel
+func findProjectDirectory(dir string) (string, error) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "config.fel")); err == nil {
+			return filepath.ToSlash(dir) + "/", nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("Cannot find config.fel in any parent directory.")
+		}
+		dir = parent
+	}
+}
+
+// disassembleFile writes the bytecode emitted for a file, or for one component
+// if componentName is set, along with every block it calls.
+func disassembleFile(w io.Writer, filename string, componentName string, options BuildOptions) error {
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filename); err != nil {
+		return fmt.Errorf("Cannot find file: %s", filename)
+	}
+	projectDirpath, err := findProjectDirectory(filepath.Dir(filename))
+	if err != nil {
+		return err
+	}
+	filename = filepath.ToSlash(filename)
+
+	// Components can use anything in the project, so the whole
+	// project is parsed and typechecked.
+	options.CheckOnly = true
+	builds, err := newWorkspaceBuilds(projectDirpath, options)
+	if err != nil {
+		return err
+	}
+	b := builds[0]
+	var timings BuildTimings
+	if _, err := b.ScanFiles(&timings); err != nil {
+		return err
+	}
+	astFiles, _, err := b.parseAndTypecheck(b.filepaths(), &timings)
+	if err != nil {
+		return err
+	}
+	var astFile *ast.File
+	for _, file := range astFiles {
+		if file.Filepath == filename {
+			astFile = file
+			break
+		}
+	}
+	if astFile == nil {
+		return fmt.Errorf("File is not in the project: %s", filename)
+	}
+
+	emit := emitter.New()
+	emit.EmitGlobalScope(astFiles)
+	var blocks []*bytecode.Block
+	switch {
+	case componentName != "":
+		block, ok := emit.Symbol(componentName)
+		if !ok {
+			return fmt.Errorf("Cannot find component or procedure \"%s\".", componentName)
+		}
+		blocks = append(blocks, block)
+	case b.isTemplateFile(filename):
+		blocks = append(blocks, emit.EmitBytecode(astFile, emitter.FileOptions{
+			IsTemplateFile: true,
+		}))
+	default:
+		for _, node := range astFile.Nodes() {
+			var name string
+			switch node := node.(type) {
+			case *ast.HTMLComponentDefinition:
+				name = node.Name.String()
+			case *ast.ProcedureDefinition:
+				name = node.Name.String()
+			default:
+				continue
+			}
+			if block, ok := emit.Symbol(name); ok {
+				blocks = append(blocks, block)
+			}
+		}
+		if len(blocks) == 0 {
+			return fmt.Errorf("No components or procedures found in: %s", filename)
+		}
+	}
+	if emit.HasErrors() {
+		return b.newBuildError("Stopping due to emitter errors.", emit.Diagnostics())
+	}
+	return bytecode.Disassemble(w, blocks...)
+}
+
+func runDisasm(args []string) int {
+	flagSet := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	flagSet.SetOutput(os.Stderr)
+	workspace := flagSet.String("workspace", "", "use the workspace with this name")
+	component := flagSet.String("component", "", "only disassemble the component or procedure with this name")
+	positional, err := parseFlags(flagSet, args)
+	if err != nil {
+		return exitUsage
+	}
+	switch len(positional) {
+	case 0:
+		fmt.Fprintf(os.Stderr, "fel disasm: Expected a file to disassemble.\n")
+		return exitUsage
+	case 1:
+	default:
+		fmt.Fprintf(os.Stderr, "fel disasm: Expected one file, instead got %d arguments: %s\n", len(positional), strings.Join(positional, " "))
+		return exitUsage
+	}
+	options := BuildOptions{
+		Workspace: *workspace,
+		Verbosity: VerbosityQuiet,
+	}
+	if err := disassembleFile(os.Stdout, positional[0], *component, options); err != nil {
+		if buildErr, ok := err.(*BuildError); ok {
+			fmt.Print(buildErr.Details())
+		}
+		fmt.Fprintf(os.Stderr, "fel disasm: %v\n", err)
+		return exitFailure
+	}
+	return exitSuccess
+}
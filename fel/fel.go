@@ -1,339 +1,345 @@
 package main
 
 import (
-	"bytes"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"time"
 
-	"github.com/silbinarywolf/compiler-fel/ast"
-	"github.com/silbinarywolf/compiler-fel/bytecode"
-	"github.com/silbinarywolf/compiler-fel/data"
-	"github.com/silbinarywolf/compiler-fel/emitter"
-	"github.com/silbinarywolf/compiler-fel/evaluator"
-	"github.com/silbinarywolf/compiler-fel/parser"
-	"github.com/silbinarywolf/compiler-fel/typer"
-	"github.com/silbinarywolf/compiler-fel/vm"
+	"github.com/silbinarywolf/compiler-fel/errors"
+	"github.com/silbinarywolf/compiler-fel/lsp"
 )
 
-type TemplateFile struct {
-	ast    *ast.File
-	code   *bytecode.Block
-	output *data.HTMLElement
-}
+const version = "0.1.0-dev"
 
-type CSSDefinition struct {
-	ast  *ast.CSSDefinition
-	code *bytecode.Block
-}
+const (
+	exitSuccess = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
 
-func compileProject(projectDirpath string) error {
-	configFilepath := projectDirpath + "config.fel"
-	workspaces, err := evaluator.GetWorkspacesFromConfig(configFilepath)
-	if err != nil {
-		return err
-	}
-	if len(workspaces) == 0 {
-		return fmt.Errorf("No workspaces found in config.fel file.")
-	}
+const usage = `fel is a tool for compiling Front-End Language projects.
 
-	var diskIOTimeSpent time.Duration
-	var parsingTimeSpent time.Duration
-	var typerTimeSpent time.Duration
-	var emitTimeSpent time.Duration
-	var executionTimeSpent time.Duration
-	totalTimeSpentTimer := time.Now()
+Usage:
 
-	for i, workspace := range workspaces {
-		templateInputDirectory := workspace.TemplateInputDirectory()
-		if templateInputDirectory == "" {
-			return fmt.Errorf("template_input_directory has not been configured.")
-		}
-		templateOutputDirectory := workspace.TemplateOutputDirectory()
-		if templateOutputDirectory == "" {
-			return fmt.Errorf("template_output_directory has not been configured.")
-		}
-		cssOutputDirectory := workspace.CSSOutputDirectory()
-		if cssOutputDirectory == "" {
-			return fmt.Errorf("css_output_directory has not been configured.")
-		}
+	fel <command> [arguments]
 
-		templateInputDirectory = path.Clean(fmt.Sprintf("%s/%s", projectDirpath, templateInputDirectory))
-		templateOutputDirectory = path.Clean(fmt.Sprintf("%s/%s", projectDirpath, templateOutputDirectory))
-		cssOutputDirectory = path.Clean(fmt.Sprintf("%s/%s", projectDirpath, cssOutputDirectory))
+The commands are:
 
-		// Check if configured folders exist, create output folders automatically if it doesn't.
-		//fmt.Printf("Creating output folders defined from \"config.fel\"...\n")
-		//fmt.Printf("------------------------------------------\n")
-		err = folderExistsMaybeCreate(templateInputDirectory, "template_input_directory", false)
-		if err != nil {
-			return err
-		}
-		err = folderExistsMaybeCreate(templateOutputDirectory, "template_output_directory", true)
-		if err != nil {
-			return err
-		}
-		err = folderExistsMaybeCreate(cssOutputDirectory, "css_output_directory", true)
-		if err != nil {
-			return err
-		}
+	build [project-dir]   compile templates and CSS into the configured output directories
+	check [project-dir]   parse and typecheck only, nothing is written to disk
+	watch [project-dir]   rebuild affected templates whenever a *.fel file changes
+	serve [project-dir]   watch and serve the output over HTTP with live reload
+	lsp                   run a Language Server Protocol server over stdio
+	disasm <file>         print the bytecode emitted for a file, for debugging the compiler
+	init [project-dir]    create a config.fel and templates folder for a new project
+	version               print the fel version
 
-		// Get list of all files in folder recursively with *.fel
-		filepathSet := make([]string, 0, 50)
-		{
-			diskIOTimeSpentTimer := time.Now()
-			err := filepath.Walk(projectDirpath, func(path string, f os.FileInfo, _ error) error {
-				if !f.IsDir() && filepath.Ext(f.Name()) == ".fel" {
-					// Replace Windows-slash with forward slash.
-					// NOTE: This ensures consistent comparison of filepath strings and fixes a bug.
-					path = strings.Replace(path, "\\", "/", -1)
-					filepathSet = append(filepathSet, path)
-				}
-				return nil
-			})
-			diskIOTimeSpent += time.Since(diskIOTimeSpentTimer)
-			if err != nil {
-				return fmt.Errorf("An error occurred reading: %v, Error Message: %v", templateInputDirectory, err)
-			}
-			if len(filepathSet) == 0 {
-				return fmt.Errorf("No *.fel files found in your project's \"templates\" directory: %v", templateInputDirectory)
-			}
-		}
+If project-dir is omitted, the current directory is used.
 
-		// Parse files
-		astFiles := make([]*ast.File, 0, 50)
-		{
-			p := parser.New()
-			for _, filepath := range filepathSet {
-				diskIOTimeSpentTimer := time.Now()
-				filecontentsAsBytes, err := ioutil.ReadFile(filepath)
-				diskIOTimeSpent += time.Since(diskIOTimeSpentTimer)
-
-				if err != nil {
-					return fmt.Errorf("An error occurred reading file: %v, Error message: %v", filepath, err)
-				}
-
-				parseSpentTimer := time.Now()
-				astFile := p.Parse(filecontentsAsBytes, filepath)
-				parsingTimeSpent += time.Since(parseSpentTimer)
-
-				if astFile == nil {
-					if p.HasErrors() {
-						p.PrintErrors()
-					}
-					return fmt.Errorf("Empty source file: %s.", filepath)
-				}
-				if p.Scanner.HasErrors() {
-					p.PrintErrors()
-					return fmt.Errorf("Stopping due to scanning errors.")
-				}
-				astFiles = append(astFiles, astFile)
-			}
-			if p.HasErrors() {
-				p.PrintErrors()
-				return fmt.Errorf("Stopping due to parsing errors.")
-			}
-		}
+Flags for build, check, watch and serve:
 
-		// Apply type information and typecheck when we've parsed all files
-		var htmlComponentsUsed []*ast.HTMLComponentDefinition
-		{
-			typerSpentTimer := time.Now()
-			p := typer.New()
-			p.ApplyTypeInfoAndTypecheck(astFiles)
-			typerTimeSpent += time.Since(typerSpentTimer)
-			if p.HasErrors() {
-				p.PrintErrors()
-				return fmt.Errorf("Stopping due to parsing errors.")
-			}
-			htmlComponentsUsed = p.HTMLComponentsUsed()
-		}
+	--workspace <name>    only build the workspace with this name
+	--quiet               only print errors
+	--verbose             print timings and the generated HTML and CSS
+	--no-cache            don't read or write compiled bytecode in .felcache
+	-j <n>                how many files, templates or workspaces to process at once (default is the number of CPUs)
 
-		// Emit bytecode
-		codeRecords := make([]TemplateFile, 0, len(astFiles))
-		cssDefinitionBlocks := make([]CSSDefinition, 0, 100)
-		{
-			emitSpentTimer := time.Now()
-			emit := emitter.New()
-			emit.EmitGlobalScope(astFiles)
-
-			// Emit CSS
-			for _, htmlDefinition := range htmlComponentsUsed {
-				cssDef := htmlDefinition.CSSDefinition
-				if cssDef == nil {
-					continue
-				}
-				codeBlock := emit.EmitCSSDefinition(cssDef)
-				cssDefinitionBlocks = append(cssDefinitionBlocks, CSSDefinition{
-					ast:  htmlDefinition.CSSDefinition,
-					code: codeBlock,
-				})
-			}
-
-			// Emit template directories
-			fmt.Printf("\n")
-			for _, astFile := range astFiles {
-				if !strings.HasPrefix(astFile.Filepath, templateInputDirectory) ||
-					len(astFile.Nodes()) == 0 {
-					continue
-				}
-				codeBlock := emit.EmitBytecode(astFile, emitter.FileOptions{
-					IsTemplateFile: true,
-				})
-
-				codeRecords = append(codeRecords, TemplateFile{
-					ast:  astFile,
-					code: codeBlock,
-				})
-			}
-			emitTimeSpent += time.Since(emitSpentTimer)
-		}
+Flags for build and check:
+
+	--format <format>     print errors as "text" or "json" (default text)
+
+Flags for watch and serve:
+
+	--interval <duration> how often to check for changes (default 250ms)
+
+Flags for serve:
 
-		// Execute CSS code
-		{
-			var buffer bytes.Buffer
-			executionSpentTimer := time.Now()
-			for _, codeRecord := range cssDefinitionBlocks {
-				result := vm.ExecuteNewProgram(codeRecord.code)
-				switch result := result.(type) {
-				case *data.CSSDefinition:
-					//htmlElements = append(htmlElements, result)
-					//fmt.Printf("Filename: %s\n%v\n\n", codeRecord.ast.Name.String(), result.Debug())
-					buffer.WriteString("/* Filename: ")
-					buffer.WriteString(codeRecord.ast.Name.String())
-					buffer.WriteString("*/ \n")
-					buffer.WriteString(result.Debug())
-					buffer.WriteString("\n")
-				case nil:
-					panic(fmt.Sprintf("Unexpected type: nil"))
-				default:
-					panic(fmt.Sprintf("Unknown type: %T", result))
-				}
-			}
-			cssOutput := buffer.String()
-			executionTimeSpent += time.Since(executionSpentTimer)
-
-			// Generate files
-			cssDirpath := projectDirpath + workspace.CSSOutputDirectory() + "/"
-			diskIOTimeSpentTimer := time.Now()
-			for _, cssFilename := range workspace.CSSFiles() {
-				// todo(Jake): 2018-04-23
-				//
-				// Fix permissions on this file write to a better default
-				//
-				ioutil.WriteFile(cssDirpath+cssFilename, []byte(cssOutput), 0744)
-			}
-			diskIOTimeSpent += time.Since(diskIOTimeSpentTimer)
-			fmt.Printf("CSS Output:\n%s", cssOutput)
+	--addr <host:port>    address to serve on (default localhost:8080)
+
+Flags for disasm:
+
+	--component <name>    only print the component or procedure with this name
+	--workspace <name>    use the workspace with this name
+`
+
+const initConfigFile = `default :: workspace {
+	w := workspace
+	w.template_input_directory = "templates"
+	w.template_output_directory = "public"
+	w.css_output_directory = "public/css"
+	w.css_files = []string{
+		"main.css",
+	}
+}
+`
+
+const initTemplateFile = `:: css {
+	h1 {
+		color: #333
+	}
+}
+
+html {
+	head {
+		title {
+			"Hello world"
+		}
+	}
+	body {
+		h1 {
+			"Hello world"
 		}
+	}
+}
+`
+
+// projectDirectory gets the project directory from the command arguments,
+// the compiler expects a trailing slash.
+func projectDirectory(args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		return "./", nil
+	case 1:
+		dir := filepath.ToSlash(args[0])
+		if !strings.HasSuffix(dir, "/") {
+			dir += "/"
+		}
+		return dir, nil
+	}
+	return "", fmt.Errorf("Expected one project directory, instead got %d arguments: %s", len(args), strings.Join(args, " "))
+}
 
-		// Execute template code
-		{
-			executionSpentTimer := time.Now()
-			for i, _ := range codeRecords {
-				codeRecord := &codeRecords[i]
-				result := vm.ExecuteNewProgram(codeRecord.code)
-				switch result := result.(type) {
-				case *data.HTMLElement:
-					codeRecord.output = result
-					fmt.Printf("Filename: %s\n%s\n", codeRecord.ast.Filepath, result.Debug())
-				case nil:
-					panic(fmt.Sprintf("Unexpected type: nil"))
-				default:
-					panic(fmt.Sprintf("Unknown type: %T", result))
-				}
-			}
-			executionTimeSpent += time.Since(executionSpentTimer)
-
-			//
-			diskIOTimeSpentTimer := time.Now()
-			for _, codeRecord := range codeRecords {
-				filename := codeRecord.ast.Filepath
-				htmlElement := codeRecord.output
-				if htmlElement == nil {
-					continue
-				}
-
-				baseFilename := filename[len(templateInputDirectory) : len(filename)-4]
-				outputFilepath := filepath.Clean(fmt.Sprintf("%s%s.html", templateOutputDirectory, baseFilename))
-
-				err := ioutil.WriteFile(
-					outputFilepath,
-					[]byte(htmlElement.Debug()),
-					0644,
-				)
-				if err != nil {
-					panic(err)
-				}
-			}
-			diskIOTimeSpent += time.Since(diskIOTimeSpentTimer)
-			/*result := TemplateFile{
-				Filepath: outputFilepath,
-				Content:  generate.PrettyHTML(nodes),
-			}
-			outputTemplateFileSet = append(outputTemplateFileSet, result)*/
+// parseFlags parses flags that come before or after the project directory,
+// ie. "fel build --quiet dir" and "fel build dir --quiet"
+func parseFlags(flagSet *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flagSet.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flagSet.Args()
+		if len(args) == 0 {
+			return positional, nil
 		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
 
-		fmt.Printf("\n")
-		fmt.Printf("Building workspace #%d \"%s\"...\n", i, workspace.Name())
-		fmt.Printf("Disk IO time: %s\n", diskIOTimeSpent)
-		fmt.Printf("Parsing time: %s (Typer: %s)\n", parsingTimeSpent+typerTimeSpent, typerTimeSpent)
-		fmt.Printf("Emitter time: %s\n", emitTimeSpent)
-		fmt.Printf("Execution time: %s\n", executionTimeSpent)
+// buildFlags are the flags shared by build, check, watch and serve
+type buildFlags struct {
+	workspace *string
+	quiet     *bool
+	verbose   *bool
+	noCache   *bool
+	jobs      *int
+}
+
+func addBuildFlags(flagSet *flag.FlagSet) buildFlags {
+	return buildFlags{
+		workspace: flagSet.String("workspace", "", "only build the workspace with this name"),
+		quiet:     flagSet.Bool("quiet", false, "only print errors"),
+		verbose:   flagSet.Bool("verbose", false, "print timings and the generated HTML and CSS"),
+		noCache:   flagSet.Bool("no-cache", false, "don't read or write compiled bytecode in "+cacheDirectoryName),
+		jobs:      flagSet.Int("j", 0, "how many files, templates or workspaces to process at once (default is the number of CPUs)"),
+	}
+}
 
-		var m runtime.MemStats
-		runtime.ReadMemStats(&m)
-		fmt.Printf("Total time: %s (Memory used: %fmb)\n", time.Since(totalTimeSpentTimer), float32(m.TotalAlloc/1024)/100)
-		//fmt.Printf("\nAlloc = %v\nTotalAlloc = %v\nSys = %v\nNumGC = %v\n\n", m.Alloc/1024, (m.TotalAlloc/1024)/100, m.Sys/1024, m.NumGC)
+func (f buildFlags) options() (BuildOptions, error) {
+	if *f.quiet && *f.verbose {
+		return BuildOptions{}, fmt.Errorf("cannot use --quiet and --verbose together")
+	}
+	if *f.jobs < 0 {
+		return BuildOptions{}, fmt.Errorf("-j cannot be negative, instead got %d", *f.jobs)
+	}
+	options := BuildOptions{
+		Workspace: *f.workspace,
+		Verbosity: VerbosityNormal,
+		NoCache:   *f.noCache,
+		Jobs:      *f.jobs,
 	}
-	return nil
+	switch {
+	case *f.quiet:
+		options.Verbosity = VerbosityQuiet
+	case *f.verbose:
+		options.Verbosity = VerbosityVerbose
+	}
+	return options, nil
 }
 
-func folderExistsMaybeCreate(directory string, configName string, createIfDoesntExist bool) error {
-	_, err := os.Stat(directory)
-	if os.IsNotExist(err) {
-		if !createIfDoesntExist {
-			return fmt.Errorf("%s: does not exist: %s", configName, directory)
+func runBuild(name string, args []string, checkOnly bool) int {
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagSet.SetOutput(os.Stderr)
+	flags := addBuildFlags(flagSet)
+	format := flagSet.String("format", "text", "how to print errors, \"text\" or \"json\"")
+	positional, err := parseFlags(flagSet, args)
+	if err != nil {
+		return exitUsage
+	}
+	options, err := flags.options()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fel %s: %v\n", name, err)
+		return exitUsage
+	}
+	options.CheckOnly = checkOnly
+	switch *format {
+	case "text":
+	case "json":
+		if options.Verbosity == VerbosityVerbose {
+			fmt.Fprintf(os.Stderr, "fel %s: cannot use --verbose with --format=json\n", name)
+			return exitUsage
 		}
-		fmt.Printf("%s: Creating missing folder \"%s\"\n", configName, directory)
-		err = os.MkdirAll(directory, os.ModePerm)
-		if err != nil {
-			return fmt.Errorf("%s: error: %v", configName, err)
+		// Only the JSON goes to stdout, so it can be parsed
+		options.Verbosity = VerbosityQuiet
+	default:
+		fmt.Fprintf(os.Stderr, "fel %s: unknown format \"%s\", expected \"text\" or \"json\"\n", name, *format)
+		return exitUsage
+	}
+	projectDirpath, err := projectDirectory(positional)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fel %s: %v\n", name, err)
+		return exitUsage
+	}
+	err = compileProject(projectDirpath, options)
+	buildErr, _ := err.(*BuildError)
+	if *format == "json" {
+		var diagnostics []*errors.Diagnostic
+		var sources errors.Sources
+		if buildErr != nil {
+			diagnostics, sources = buildErr.Diagnostics, buildErr.Sources
 		}
-		return nil
+		errors.WriteJSON(os.Stdout, diagnostics, sources)
+	} else if buildErr != nil {
+		fmt.Print(buildErr.Details())
 	}
 	if err != nil {
-		return fmt.Errorf("%s: OS error: %v", configName, err)
+		fmt.Fprintf(os.Stderr, "fel %s: %v\n", name, err)
+		return exitFailure
 	}
-	return nil
+	return exitSuccess
 }
 
-func main() {
-	/*{
-		ctx := duktape.New()
-		ctx.PevalString(`let result = 2 + 3; return result;`)
-		result := ctx.GetNumber(-1)
-		ctx.Pop()
-		fmt.Println("result is:", result)
+func runWatch(name string, args []string) int {
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagSet.SetOutput(os.Stderr)
+	flags := addBuildFlags(flagSet)
+	interval := flagSet.Duration("interval", 250*time.Millisecond, "how often to check for changes")
+	var address *string
+	if name == "serve" {
+		address = flagSet.String("addr", "localhost:8080", "address to serve on")
+	}
+	positional, err := parseFlags(flagSet, args)
+	if err != nil {
+		return exitUsage
+	}
+	options, err := flags.options()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fel %s: %v\n", name, err)
+		return exitUsage
+	}
+	projectDirpath, err := projectDirectory(positional)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fel %s: %v\n", name, err)
+		return exitUsage
+	}
+	if *interval <= 0 {
+		fmt.Fprintf(os.Stderr, "fel %s: --interval must be greater than 0\n", name)
+		return exitUsage
+	}
+	if address == nil {
+		fmt.Printf("Watching \"%s\" for changes...\n", projectDirpath)
+		watchProject(projectDirpath, options, *interval)
+		return exitSuccess
+	}
+	if err := serveProject(projectDirpath, options, *address, *interval); err != nil {
+		fmt.Fprintf(os.Stderr, "fel %s: %v\n", name, err)
+		return exitFailure
+	}
+	return exitSuccess
+}
 
-		// To prevent memory leaks, don't forget to clean up after
-		// yourself when you're done using a context.
-		//ctx.DestroyHeap()
+func runLSP() int {
+	// Stdout is used for LSP messages, so anything else printed
+	// by the compiler is redirected to stderr.
+	server := lsp.NewServer(os.Stdin, os.Stdout)
+	os.Stdout = os.Stderr
+	if err := server.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "fel lsp: %v\n", err)
+		return exitFailure
+	}
+	return exitSuccess
+}
 
-		//panic("Done!")
-	}*/
+func runInit(args []string) int {
+	flagSet := flag.NewFlagSet("init", flag.ContinueOnError)
+	flagSet.SetOutput(os.Stderr)
+	positional, err := parseFlags(flagSet, args)
+	if err != nil {
+		return exitUsage
+	}
+	projectDirpath, err := projectDirectory(positional)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fel init: %v\n", err)
+		return exitUsage
+	}
+	if err := initProject(projectDirpath); err != nil {
+		fmt.Fprintf(os.Stderr, "fel init: %v\n", err)
+		return exitFailure
+	}
+	fmt.Printf("Created new project in \"%s\", run \"fel build %s\" to compile it.\n", projectDirpath, projectDirpath)
+	return exitSuccess
+}
+
+func initProject(projectDirpath string) error {
+	configFilepath := projectDirpath + "config.fel"
+	if _, err := os.Stat(configFilepath); err == nil {
+		return fmt.Errorf("A project already exists, found: %s", configFilepath)
+	}
+	templateDirpath := projectDirpath + "templates"
+	if err := os.MkdirAll(templateDirpath, os.ModePerm); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(configFilepath, []byte(initConfigFile), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(templateDirpath+"/HomePage.fel", []byte(initTemplateFile), 0644)
+}
 
-	compileProject("testdata/sampleproject/fel/")
+func run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	command := args[0]
+	args = args[1:]
+	switch command {
+	case "build":
+		return runBuild(command, args, false)
+	case "check":
+		return runBuild(command, args, true)
+	case "watch", "serve":
+		return runWatch(command, args)
+	case "lsp":
+		return runLSP()
+	case "disasm":
+		return runDisasm(args)
+	case "init":
+		return runInit(args)
+	case "version", "--version":
+		fmt.Printf("fel version %s\n", version)
+		return exitSuccess
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return exitSuccess
+	}
+	fmt.Fprintf(os.Stderr, "fel: unknown command \"%s\"\n\n%s", command, usage)
+	return exitUsage
+}
 
-	/*program := evaluator.New()
-	err := program.RunProject("testdata/sampleproject/fel")
-	if err != nil {
-		panic(fmt.Errorf("%v", err))
-	}*/
-	fmt.Printf("Done.")
+func main() {
+	os.Exit(run(os.Args[1:]))
 }
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const liveReloadPath = "/__fel/"
+
+const liveReloadScriptTag = `<script src="` + liveReloadPath + `livereload.js"></script>`
+
+// liveReloadScript listens for rebuilds from "fel serve".
+//
+// EventSource reconnects automatically if the server restarts,
+// so we reload the page when it reconnects to get the latest build.
+const liveReloadScript = `(function() {
+	var overlayId = "__fel-error-overlay";
+	var hasConnected = false;
+	function removeOverlay() {
+		var overlay = document.getElementById(overlayId);
+		if (overlay) {
+			overlay.parentNode.removeChild(overlay);
+		}
+	}
+	function showOverlay(data) {
+		removeOverlay();
+		var overlay = document.createElement("div");
+		overlay.id = overlayId;
+		overlay.style.cssText = "position:fixed;top:0;left:0;right:0;bottom:0;z-index:2147483647;overflow:auto;padding:24px;background:rgba(20,20,20,0.95);color:#ff6b6b;font:14px/1.5 monospace;";
+		var title = document.createElement("h2");
+		title.style.cssText = "margin:0 0 16px;color:#fff;font:bold 18px monospace;";
+		title.textContent = "fel: " + data.message;
+		var details = document.createElement("pre");
+		details.style.cssText = "margin:0;white-space:pre-wrap;color:#eee;";
+		details.textContent = data.details;
+		overlay.appendChild(title);
+		overlay.appendChild(details);
+		document.body.appendChild(overlay);
+	}
+	function reloadCSS() {
+		var links = document.querySelectorAll("link[rel=stylesheet]");
+		for (var i = 0; i < links.length; i++) {
+			var href = links[i].getAttribute("href").replace(/[?&]felreload=\d+/, "");
+			links[i].setAttribute("href", href + (href.indexOf("?") === -1 ? "?" : "&") + "felreload=" + Date.now());
+		}
+	}
+	var source = new EventSource("` + liveReloadPath + `events");
+	source.addEventListener("open", function() {
+		if (hasConnected) {
+			location.reload();
+		}
+		hasConnected = true;
+	});
+	source.addEventListener("reload", function() {
+		location.reload();
+	});
+	source.addEventListener("css", function() {
+		removeOverlay();
+		reloadCSS();
+	});
+	source.addEventListener("compile-error", function(e) {
+		showOverlay(JSON.parse(e.data));
+	});
+	source.addEventListener("ok", function() {
+		removeOverlay();
+	});
+})();
+`
+
+type liveReloadEvent struct {
+	name string
+	data string
+}
+
+// liveReloadBroker sends build events to all connected browsers
+type liveReloadBroker struct {
+	mutex     sync.Mutex
+	clients   map[chan liveReloadEvent]bool
+	lastError *liveReloadEvent
+}
+
+func newLiveReloadBroker() *liveReloadBroker {
+	return &liveReloadBroker{
+		clients: make(map[chan liveReloadEvent]bool),
+	}
+}
+
+func (broker *liveReloadBroker) publish(event liveReloadEvent) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	switch event.name {
+	case "compile-error":
+		broker.lastError = &event
+	default:
+		broker.lastError = nil
+	}
+	for client := range broker.clients {
+		select {
+		case client <- event:
+		default:
+			// Drop events for clients that aren't keeping up
+		}
+	}
+}
+
+func (broker *liveReloadBroker) publishError(err error) {
+	details := ""
+	if buildErr, ok := err.(*BuildError); ok {
+		details = buildErr.Details()
+	}
+	data, _ := json.Marshal(struct {
+		Message string `json:"message"`
+		Details string `json:"details"`
+	}{
+		Message: err.Error(),
+		Details: details,
+	})
+	broker.publish(liveReloadEvent{name: "compile-error", data: string(data)})
+}
+
+func (broker *liveReloadBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	client := make(chan liveReloadEvent, 8)
+	broker.mutex.Lock()
+	broker.clients[client] = true
+	if broker.lastError != nil {
+		client <- *broker.lastError
+	}
+	broker.mutex.Unlock()
+	defer func() {
+		broker.mutex.Lock()
+		delete(broker.clients, client)
+		broker.mutex.Unlock()
+	}()
+
+	// Send a comment so the browser fires the "open" event straight away
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event := <-client:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, event.data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// injectLiveReload adds the live reload script to the end of the body
+func injectLiveReload(html []byte) []byte {
+	index := bytes.LastIndex(bytes.ToLower(html), []byte("</body>"))
+	if index == -1 {
+		return append(html, []byte(liveReloadScriptTag)...)
+	}
+	result := make([]byte, 0, len(html)+len(liveReloadScriptTag))
+	result = append(result, html[:index]...)
+	result = append(result, liveReloadScriptTag...)
+	result = append(result, html[index:]...)
+	return result
+}
+
+// templateHandler serves files from the template output directory, injecting
+// the live reload script into HTML files.
+type templateHandler struct {
+	directory  string
+	fileServer http.Handler
+}
+
+func (h *templateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := path.Clean("/" + r.URL.Path)
+	filename := filepath.Join(h.directory, filepath.FromSlash(urlPath))
+	if info, err := os.Stat(filename); err == nil && info.IsDir() {
+		filename = filepath.Join(filename, "index.html")
+	}
+	if filepath.Ext(filename) != ".html" {
+		h.fileServer.ServeHTTP(w, r)
+		return
+	}
+	html, err := ioutil.ReadFile(filename)
+	if err != nil {
+		h.fileServer.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(injectLiveReload(html))
+}
+
+func noCache(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// newServeMux serves the template and CSS output directories of a workspace.
+func newServeMux(b *WorkspaceBuild, broker *liveReloadBroker) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle(liveReloadPath+"events", broker)
+	mux.HandleFunc(liveReloadPath+"livereload.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Header().Set("Cache-Control", "no-cache")
+		fmt.Fprint(w, liveReloadScript)
+	})
+	mux.Handle("/", &templateHandler{
+		directory:  b.templateOutputDirectory,
+		fileServer: noCache(http.FileServer(http.Dir(b.templateOutputDirectory))),
+	})
+
+	// If the CSS isn't output within the template directory, serve
+	// it by its folder name, ie. "../public/css" is served at "/css/"
+	if !strings.HasPrefix(b.cssOutputDirectory+"/", b.templateOutputDirectory+"/") {
+		cssURLPath := "/" + path.Base(b.cssOutputDirectory) + "/"
+		mux.Handle(cssURLPath, http.StripPrefix(cssURLPath, noCache(http.FileServer(http.Dir(b.cssOutputDirectory)))))
+	}
+	return mux
+}
+
+// serveProject builds the project, serves the output over HTTP and rebuilds whenever
+// a *.fel file changes.
+func serveProject(projectDirpath string, options BuildOptions, address string, interval time.Duration) error {
+	w := NewWatcher(projectDirpath, options)
+	broker := newLiveReloadBroker()
+	startTime := time.Now()
+	w.OnBuild = func(b *WorkspaceBuild, result BuildResult, err error) {
+		printWatchResult(b, result, err, time.Since(startTime), options)
+		switch {
+		case err != nil:
+			broker.publishError(err)
+		case len(result.HTMLFiles) > 0:
+			broker.publish(liveReloadEvent{name: "reload"})
+		case result.CSSChanged:
+			broker.publish(liveReloadEvent{name: "css"})
+		default:
+			broker.publish(liveReloadEvent{name: "ok"})
+		}
+	}
+	if _, err := w.Poll(); err != nil {
+		return err
+	}
+	if len(w.builds) > 1 {
+		return fmt.Errorf("Found %d workspaces in config.fel, use --workspace to choose which one to serve.", len(w.builds))
+	}
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- http.ListenAndServe(address, newServeMux(w.builds[0], broker))
+	}()
+	fmt.Printf("Serving workspace \"%s\" at http://%s/\n", w.builds[0].Name(), address)
+
+	var lastErr string
+	for {
+		select {
+		case err := <-listenErr:
+			return err
+		case <-time.After(interval):
+		}
+		startTime = time.Now()
+		_, err := w.Poll()
+		if err != nil && err.Error() != lastErr {
+			fmt.Fprintf(os.Stderr, "[%s] %v\n", time.Now().Format("15:04:05"), err)
+			broker.publishError(err)
+		}
+		lastErr = ""
+		if err != nil {
+			lastErr = err.Error()
+		}
+	}
+}
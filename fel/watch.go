@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Watcher polls a project's *.fel files and rebuilds templates affected by changes.
+//
+// Polling is used so that we don't need an OS-specific dependency
+// for file notifications.
+type Watcher struct {
+	projectDirpath string
+	options        BuildOptions
+	builds         []*WorkspaceBuild
+	configModTime  time.Time
+	configErr      error
+
+	// OnBuild is called after every build, err is nil if the build succeeded.
+	OnBuild func(b *WorkspaceBuild, result BuildResult, err error)
+}
+
+func NewWatcher(projectDirpath string, options BuildOptions) *Watcher {
+	return &Watcher{
+		projectDirpath: projectDirpath,
+		options:        options,
+	}
+}
+
+// loadConfig reads config.fel if it was modified
+func (w *Watcher) loadConfig() error {
+	info, err := os.Stat(w.projectDirpath + "config.fel")
+	if err != nil {
+		w.builds = nil
+		return fmt.Errorf("Cannot find config.fel in root of project directory: %v", w.projectDirpath)
+	}
+	if !w.configModTime.IsZero() && info.ModTime().Equal(w.configModTime) {
+		return w.configErr
+	}
+	w.configModTime = info.ModTime()
+	w.builds, w.configErr = newWorkspaceBuilds(w.projectDirpath, w.options)
+	return w.configErr
+}
+
+// Poll rebuilds any workspaces that have changed since the last call to Poll.
+// It returns true if anything was rebuilt.
+func (w *Watcher) Poll() (bool, error) {
+	if err := w.loadConfig(); err != nil {
+		return false, err
+	}
+	hasRebuilt := false
+	for _, b := range w.builds {
+		var timings BuildTimings
+		changed, err := b.ScanFiles(&timings)
+		if err != nil {
+			return hasRebuilt, err
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		hasRebuilt = true
+		result, err := w.build(b, changed, &timings)
+		if w.OnBuild != nil {
+			w.OnBuild(b, result, err)
+		}
+	}
+	return hasRebuilt, nil
+}
+
+// build rebuilds a workspace, recovering from panics in the VM so
+// the watcher keeps running.
+func (w *Watcher) build(b *WorkspaceBuild, changed []string, timings *BuildTimings) (result BuildResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.needsFullBuild = true
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	result, err = b.Build(changed, timings)
+	result.Timings = *timings
+	return result, err
+}
+
+func printWatchResult(b *WorkspaceBuild, result BuildResult, err error, duration time.Duration, options BuildOptions) {
+	timestamp := time.Now().Format("15:04:05")
+	if err != nil {
+		if buildErr, ok := err.(*BuildError); ok {
+			fmt.Fprint(os.Stderr, buildErr.Details())
+		}
+		fmt.Fprintf(os.Stderr, "[%s] workspace \"%s\": %v\n", timestamp, b.Name(), err)
+		return
+	}
+	if options.Verbosity == VerbosityQuiet {
+		return
+	}
+	templates := make([]string, 0, len(result.Templates))
+	for _, filepath := range result.Templates {
+		templates = append(templates, filepath[len(b.templateInputDirectory)+1:])
+	}
+	message := fmt.Sprintf("rebuilt %d templates", len(templates))
+	if len(templates) > 0 {
+		message += " (" + strings.Join(templates, ", ") + ")"
+	}
+	if result.CSSChanged {
+		message += " and CSS"
+	}
+	fmt.Printf("[%s] workspace \"%s\": %s in %s\n", timestamp, b.Name(), message, duration)
+	if options.Verbosity == VerbosityVerbose {
+		printTimings(os.Stdout, result.Timings, duration)
+	}
+}
+
+// watchProject rebuilds the project whenever a *.fel file changes, it never returns.
+func watchProject(projectDirpath string, options BuildOptions, interval time.Duration) {
+	w := NewWatcher(projectDirpath, options)
+	startTime := time.Now()
+	w.OnBuild = func(b *WorkspaceBuild, result BuildResult, err error) {
+		printWatchResult(b, result, err, time.Since(startTime), options)
+	}
+	var lastErr string
+	for {
+		startTime = time.Now()
+		_, err := w.Poll()
+		if err != nil && err.Error() != lastErr {
+			fmt.Fprintf(os.Stderr, "[%s] %v\n", time.Now().Format("15:04:05"), err)
+		}
+		lastErr = ""
+		if err != nil {
+			lastErr = err.Error()
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,524 @@
+package lsp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/silbinarywolf/compiler-fel/ast"
+	"github.com/silbinarywolf/compiler-fel/builtins"
+	"github.com/silbinarywolf/compiler-fel/errors"
+	"github.com/silbinarywolf/compiler-fel/parser"
+	"github.com/silbinarywolf/compiler-fel/token"
+	"github.com/silbinarywolf/compiler-fel/typer"
+	"github.com/silbinarywolf/compiler-fel/types"
+)
+
+// reference is a token that refers to a declaration, ie. "Header" in "Header(isBlue=true)"
+type reference struct {
+	token      token.Token
+	definition *token.Token // optional
+	hover      string
+}
+
+// declaration is a variable that can be used for completion after it's declared
+type declaration struct {
+	name     string
+	offset   int
+	typeInfo types.TypeInfo
+}
+
+type fileIndex struct {
+	references   []reference
+	declarations []declaration
+}
+
+// analysis is the result of parsing and type checking a project
+type analysis struct {
+	sources     map[string]string // filepath to contents
+	files       map[string]*fileIndex
+	diagnostics map[string][]*errors.Diagnostic
+	components  map[string]*ast.HTMLComponentDefinition
+	structs     map[string]*ast.StructDefinition
+	procedures  map[string]*ast.ProcedureDefinition
+}
+
+// findProjectRoot gets the closest parent directory with a config.fel file
+func findProjectRoot(filename string, workspaceRoot string) string {
+	dir := filepath.Dir(filename)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "config.fel")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir || (workspaceRoot != "" && !strings.HasPrefix(parent, workspaceRoot)) {
+			break
+		}
+		dir = parent
+	}
+	return filepath.Dir(filename)
+}
+
+// readProjectSources reads every *.fel file in the project, preferring the
+// contents of documents that are open in the editor.
+func readProjectSources(projectRoot string, openDocuments map[string]string) map[string]string {
+	sources := make(map[string]string)
+	filepath.Walk(projectRoot, func(path string, f os.FileInfo, err error) error {
+		if err != nil || f.IsDir() || filepath.Ext(path) != ".fel" {
+			return nil
+		}
+		if text, ok := openDocuments[path]; ok {
+			sources[path] = text
+			return nil
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		sources[path] = string(contents)
+		return nil
+	})
+	for path, text := range openDocuments {
+		if strings.HasPrefix(path, projectRoot+string(filepath.Separator)) {
+			sources[path] = text
+		}
+	}
+	return sources
+}
+
+func newAnalysis(sources map[string]string) *analysis {
+	return &analysis{
+		sources:     sources,
+		files:       make(map[string]*fileIndex),
+		diagnostics: make(map[string][]*errors.Diagnostic),
+		components:  make(map[string]*ast.HTMLComponentDefinition),
+		structs:     make(map[string]*ast.StructDefinition),
+		procedures:  make(map[string]*ast.ProcedureDefinition),
+	}
+}
+
+// analyze parses and type checks all the sources. The index is only built if there
+// are no parse errors, as the typer expects a valid AST.
+func analyze(sources map[string]string) (result *analysis) {
+	result = newAnalysis(sources)
+
+	filepaths := make([]string, 0, len(sources))
+	for filepath := range sources {
+		filepaths = append(filepaths, filepath)
+	}
+	sort.Strings(filepaths)
+
+	p := parser.New()
+	var t *typer.Typer
+	defer func() {
+		// The parser and typer panic on some invalid code, we don't want
+		// that to take down the language server.
+		r := recover()
+		if r == nil {
+			return
+		}
+		result.addErrors(p.ErrorHandler.Diagnostics())
+		if t != nil {
+			result.addErrors(t.Diagnostics())
+		}
+		if len(result.diagnostics) > 0 || len(filepaths) == 0 {
+			return
+		}
+		filepath := filepaths[0]
+		result.diagnostics[filepath] = append(result.diagnostics[filepath], errors.NewDiagnostic(
+			token.Token{Filepath: filepath},
+			errors.CodeInternal,
+			fmt.Errorf("Internal compiler error: %v", r),
+		))
+	}()
+
+	astFiles := make([]*ast.File, 0, len(filepaths))
+	for _, filepath := range filepaths {
+		astFile := p.Parse([]byte(sources[filepath]), filepath)
+		if p.Scanner.HasErrors() {
+			diagnostics := p.Diagnostics()
+			result.addErrors(diagnostics[len(diagnostics)-1:])
+		}
+		if astFile != nil {
+			astFiles = append(astFiles, astFile)
+		}
+	}
+	if p.HasErrors() {
+		result.addErrors(p.ErrorHandler.Diagnostics())
+		return result
+	}
+
+	t = typer.New()
+	t.ApplyTypeInfoAndTypecheck(astFiles)
+	result.addErrors(t.Diagnostics())
+	result.index(astFiles)
+	return result
+}
+
+func (a *analysis) addErrors(errorList []*errors.Diagnostic) {
+	for _, err := range errorList {
+		filepath := err.Location.Filepath
+		a.diagnostics[filepath] = append(a.diagnostics[filepath], err)
+	}
+}
+
+func (a *analysis) index(astFiles []*ast.File) {
+	for _, astFile := range astFiles {
+		for _, node := range astFile.Nodes() {
+			switch node := node.(type) {
+			case *ast.HTMLComponentDefinition:
+				a.components[node.Name.String()] = node
+			case *ast.StructDefinition:
+				a.structs[node.Name.String()] = node
+			case *ast.ProcedureDefinition:
+				a.procedures[node.Name.String()] = node
+			}
+		}
+	}
+	for _, astFile := range astFiles {
+		w := &indexWalker{
+			analysis: a,
+			file:     &fileIndex{},
+		}
+		w.pushScope()
+		w.walkNodes(astFile.Nodes())
+		a.files[astFile.Filepath] = w.file
+	}
+}
+
+// indexWalker walks a typechecked file to find references and declarations.
+type indexWalker struct {
+	analysis *analysis
+	file     *fileIndex
+	scopes   []map[string]declaredVariable
+}
+
+type declaredVariable struct {
+	name     token.Token
+	typeInfo types.TypeInfo
+}
+
+func (w *indexWalker) pushScope() {
+	w.scopes = append(w.scopes, make(map[string]declaredVariable))
+}
+
+func (w *indexWalker) popScope() {
+	w.scopes = w.scopes[:len(w.scopes)-1]
+}
+
+func (w *indexWalker) declare(name token.Token, typeInfo types.TypeInfo) {
+	if name.Kind == token.Unknown {
+		return
+	}
+	w.scopes[len(w.scopes)-1][name.String()] = declaredVariable{
+		name:     name,
+		typeInfo: typeInfo,
+	}
+	w.file.declarations = append(w.file.declarations, declaration{
+		name:     name.String(),
+		offset:   name.Start,
+		typeInfo: typeInfo,
+	})
+	definition := name
+	w.addReference(name, &definition, variableHover(name.String(), typeInfo))
+}
+
+func (w *indexWalker) lookup(name string) (declaredVariable, bool) {
+	for i := len(w.scopes) - 1; i >= 0; i-- {
+		if variable, ok := w.scopes[i][name]; ok {
+			return variable, true
+		}
+	}
+	return declaredVariable{}, false
+}
+
+func (w *indexWalker) addReference(t token.Token, definition *token.Token, hover string) {
+	if t.Kind == token.Unknown {
+		return
+	}
+	w.file.references = append(w.file.references, reference{
+		token:      t,
+		definition: definition,
+		hover:      hover,
+	})
+}
+
+func variableHover(name string, typeInfo types.TypeInfo) string {
+	typeName := "unknown"
+	if typeInfo != nil {
+		typeName = typeInfo.String()
+	}
+	return fmt.Sprintf("```fel\n%s: %s\n```", name, typeName)
+}
+
+func componentHover(node *ast.HTMLComponentDefinition) string {
+	result := fmt.Sprintf("```fel\n%s :: html\n```", node.Name.String())
+	if node.Struct == nil || len(node.Struct.Fields) == 0 {
+		return result
+	}
+	fields := make([]string, 0, len(node.Struct.Fields))
+	for _, field := range node.Struct.Fields {
+		typeName := "unknown"
+		if field.TypeInfo != nil {
+			typeName = field.TypeInfo.String()
+		}
+		fields = append(fields, fmt.Sprintf("- `%s: %s`", field.Name.String(), typeName))
+	}
+	return result + "\n\nProperties:\n" + strings.Join(fields, "\n")
+}
+
+func (w *indexWalker) walkNodes(nodes []ast.Node) {
+	for _, node := range nodes {
+		w.walk(node)
+	}
+}
+
+func (w *indexWalker) walkScoped(nodes []ast.Node) {
+	w.pushScope()
+	w.walkNodes(nodes)
+	w.popScope()
+}
+
+func (w *indexWalker) walk(node ast.Node) {
+	switch node := node.(type) {
+	case nil:
+		// no-op
+	case *ast.HTMLComponentDefinition:
+		definition := node.Name
+		w.addReference(node.Name, &definition, componentHover(node))
+		w.pushScope()
+		if node.Struct != nil {
+			for i := range node.Struct.Fields {
+				field := &node.Struct.Fields[i]
+				w.walkExpression(&field.Expression)
+				w.declare(field.Name, field.TypeInfo)
+			}
+		}
+		w.walkNodes(node.Nodes())
+		w.popScope()
+	case *ast.StructDefinition:
+		definition := node.Name
+		w.addReference(node.Name, &definition, fmt.Sprintf("```fel\n%s :: struct\n```", node.Name.String()))
+		for i := range node.Fields {
+			field := &node.Fields[i]
+			w.walkExpression(&field.Expression)
+			fieldDefinition := field.Name
+			w.addReference(field.Name, &fieldDefinition, variableHover(field.Name.String(), field.TypeInfo))
+		}
+	case *ast.ProcedureDefinition:
+		definition := node.Name
+		w.addReference(node.Name, &definition, fmt.Sprintf("```fel\n%s :: ()\n```", node.Name.String()))
+		w.pushScope()
+		for i := range node.Parameters {
+			parameter := &node.Parameters[i]
+			w.declare(parameter.Name, parameter.TypeInfo)
+		}
+		w.walkNodes(node.Nodes())
+		w.popScope()
+	case *ast.CSSDefinition:
+		w.walkScoped(node.Nodes())
+	case *ast.CSSProperty:
+		w.walkExpression(&node.Expression)
+	case *ast.DeclareStatement:
+		w.walkExpression(&node.Expression)
+		w.declare(node.Name, node.Expression.TypeInfo)
+	case *ast.OpStatement:
+		w.walkLeftHandSide(node.LeftHandSide)
+		w.walkExpression(&node.Expression)
+	case *ast.ArrayAppendStatement:
+		w.walkLeftHandSide(node.LeftHandSide)
+		w.walkExpression(&node.Expression)
+	case *ast.IndexAssignStatement:
+		w.walkExpression(&node.Index.Value)
+		w.walkExpression(&node.Index.Index)
+		w.walkExpression(&node.Expression)
+	case *ast.Return:
+		w.walkExpression(&node.Expression)
+	case *ast.If:
+		w.walkExpression(&node.Condition)
+		w.walkScoped(node.Nodes())
+		w.walkScoped(node.ElseNodes)
+	case *ast.For:
+		w.walkExpression(&node.Array)
+		w.pushScope()
+		if node.IndexName.Kind != token.Unknown {
+			w.declare(node.IndexName, types.TypeInfo(new(types.Int)))
+		}
+		var recordTypeInfo types.TypeInfo
+		if array, ok := node.Array.TypeInfo.(*types.Array); ok {
+			recordTypeInfo = array.Underlying()
+		}
+		w.declare(node.RecordName, recordTypeInfo)
+		w.walkNodes(node.Nodes())
+		w.popScope()
+	case *ast.Block:
+		w.walkScoped(node.Nodes())
+	case *ast.Call:
+		w.walkCall(node)
+	case *ast.Expression:
+		w.walkExpression(node)
+	default:
+		w.walkNodes(node.Nodes())
+	}
+}
+
+func (w *indexWalker) walkCall(node *ast.Call) {
+	var fieldsStruct *ast.StructDefinition
+	switch {
+	case node.HTMLDefinition != nil:
+		definition := node.HTMLDefinition.Name
+		w.addReference(node.Name, &definition, componentHover(node.HTMLDefinition))
+		fieldsStruct = node.HTMLDefinition.Struct
+	case node.Definition != nil:
+		definition := node.Definition.Name
+		w.addReference(node.Name, &definition, fmt.Sprintf("```fel\n%s :: ()\n```", node.Name.String()))
+	case node.Builtin != nil:
+		signature := fmt.Sprintf("%s(): %s", node.Name.String(), node.Builtin.String())
+		if procedure, ok := builtins.Lookup(node.Name.String()); ok {
+			signature = procedure.String()
+		}
+		w.addReference(node.Name, nil, fmt.Sprintf("```fel\n%s\n```", signature))
+	case node.TypeConversion != nil:
+		w.addReference(node.Name, nil, fmt.Sprintf("```fel\n%s()\n```\nType conversion", node.Name.String()))
+	}
+	for _, parameter := range node.Parameters {
+		w.walkExpression(&parameter.Expression)
+		if fieldsStruct == nil {
+			continue
+		}
+		if field := fieldsStruct.GetFieldByName(parameter.Name.String()); field != nil {
+			definition := field.Name
+			w.addReference(parameter.Name, &definition, variableHover(field.Name.String(), field.TypeInfo))
+		}
+	}
+	if len(node.IfExpression.Nodes()) > 0 {
+		w.walkExpression(&node.IfExpression)
+	}
+	w.walkScoped(node.Nodes())
+	w.walkScoped(node.ElseNodes)
+}
+
+func (w *indexWalker) walkExpression(expression *ast.Expression) {
+	for _, node := range expression.Nodes() {
+		switch node := node.(type) {
+		case *ast.Token:
+			if node.Kind != token.Identifier {
+				continue
+			}
+			if variable, ok := w.lookup(node.String()); ok {
+				definition := variable.name
+				w.addReference(node.Token, &definition, variableHover(node.String(), variable.typeInfo))
+			}
+		case *ast.TokenList:
+			w.walkLeftHandSide(node.Tokens())
+		case *ast.StructLiteral:
+			w.walkStructLiteral(node)
+		case *ast.MapLiteral:
+			for i := range node.Entries {
+				w.walkExpression(&node.Entries[i].Key)
+				w.walkExpression(&node.Entries[i].Value)
+			}
+		case *ast.Index:
+			w.walkExpression(&node.Value)
+			w.walkExpression(&node.Index)
+		case *ast.Slice:
+			w.walkExpression(&node.Value)
+			w.walkExpression(&node.Low)
+			w.walkExpression(&node.High)
+		case *ast.Selector:
+			w.walkExpression(&node.Value)
+		case *ast.Call:
+			w.walkCall(node)
+		default:
+			w.walk(node)
+		}
+	}
+}
+
+func (w *indexWalker) walkStructLiteral(node *ast.StructLiteral) {
+	def := w.analysis.structs[node.Name.String()]
+	if def == nil {
+		return
+	}
+	definition := def.Name
+	w.addReference(node.Name, &definition, fmt.Sprintf("```fel\n%s :: struct\n```", def.Name.String()))
+	for i := range node.Fields {
+		property := &node.Fields[i]
+		w.walkExpression(&property.Expression)
+		if field := def.GetFieldByName(property.Name.String()); field != nil {
+			fieldDefinition := field.Name
+			w.addReference(property.Name, &fieldDefinition, variableHover(field.Name.String(), field.TypeInfo))
+		}
+	}
+}
+
+// walkLeftHandSide adds references for "a.b.c", resolving the type of each field.
+func (w *indexWalker) walkLeftHandSide(tokens []token.Token) {
+	if len(tokens) == 0 {
+		return
+	}
+	variable, ok := w.lookup(tokens[0].String())
+	if !ok {
+		return
+	}
+	definition := variable.name
+	w.addReference(tokens[0], &definition, variableHover(tokens[0].String(), variable.typeInfo))
+	typeInfo := variable.typeInfo
+	for _, t := range tokens[1:] {
+		structInfo, ok := typeInfo.(*types.Struct)
+		if !ok {
+			return
+		}
+		field := structInfo.GetFieldByName(t.String())
+		if field == nil {
+			return
+		}
+		typeInfo = field.TypeInfo
+		var fieldDefinition *token.Token
+		if def := w.analysis.structs[structInfo.Name()]; def != nil {
+			if defField := def.GetFieldByName(t.String()); defField != nil {
+				name := defField.Name
+				fieldDefinition = &name
+			}
+		}
+		w.addReference(t, fieldDefinition, variableHover(t.String(), typeInfo))
+	}
+}
+
+// referenceAt gets the reference at the byte offset in a file
+func (a *analysis) referenceAt(filepath string, offset int) *reference {
+	file := a.files[filepath]
+	if file == nil {
+		return nil
+	}
+	for i := range file.references {
+		ref := &file.references[i]
+		if offset >= ref.token.Start && offset <= ref.token.End {
+			return ref
+		}
+	}
+	return nil
+}
+
+// typeOfVariable gets the type of the closest variable declared before the offset
+func (a *analysis) typeOfVariable(filepath string, offset int, name string) types.TypeInfo {
+	file := a.files[filepath]
+	if file == nil {
+		return nil
+	}
+	var result types.TypeInfo
+	closestOffset := -1
+	for _, declaration := range file.declarations {
+		if declaration.name != name ||
+			declaration.offset > offset ||
+			declaration.offset < closestOffset {
+			continue
+		}
+		result = declaration.typeInfo
+		closestOffset = declaration.offset
+	}
+	return result
+}
@@ -0,0 +1,271 @@
+package lsp
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/silbinarywolf/compiler-fel/builtins"
+	"github.com/silbinarywolf/compiler-fel/types"
+	"github.com/silbinarywolf/compiler-fel/util"
+)
+
+var keywords = []string{"if", "else", "for", "true", "false", "nil"}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '-' ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9')
+}
+
+// trimIdentifierSuffix removes the partially typed identifier before the cursor
+func trimIdentifierSuffix(text string) string {
+	i := len(text)
+	for i > 0 && isIdentifierByte(text[i-1]) {
+		i--
+	}
+	return text[:i]
+}
+
+// fieldChainBefore gets the variable and fields before a ".", ie. "blah.link." returns ["blah", "link"]
+func fieldChainBefore(line string) ([]string, bool) {
+	text := trimIdentifierSuffix(line)
+	if !strings.HasSuffix(text, ".") {
+		return nil, false
+	}
+	var chain []string
+	for strings.HasSuffix(text, ".") {
+		text = text[:len(text)-1]
+		rest := trimIdentifierSuffix(text)
+		name := text[len(rest):]
+		if name == "" {
+			return nil, false
+		}
+		chain = append([]string{name}, chain...)
+		text = rest
+	}
+	return chain, true
+}
+
+// openCallBefore gets the name of a call if the cursor is where a parameter
+// name goes, ie. "Header(is" returns "Header"
+func openCallBefore(text string) (string, bool) {
+	depth := 0
+	isValue := false
+	hasPreviousParameter := false
+	for i := len(text) - 1; i >= 0; i-- {
+		switch text[i] {
+		case ')':
+			depth++
+		case '(':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			if isValue {
+				return "", false
+			}
+			name := text[len(trimIdentifierSuffix(text[:i])):i]
+			return name, name != ""
+		case '=':
+			if depth == 0 && !hasPreviousParameter {
+				isValue = true
+			}
+		case ',':
+			if depth == 0 {
+				hasPreviousParameter = true
+			}
+		case '{', '}':
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// isCSSContext checks if the end of the text is inside a ":: css" block
+func isCSSContext(text string) bool {
+	stack := make([]bool, 0, 8)
+	headerStart := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"':
+			for i++; i < len(text) && text[i] != '"' && text[i] != '\n'; i++ {
+				if text[i] == '\\' {
+					i++
+				}
+			}
+		case c == '/' && i+1 < len(text) && text[i+1] == '/':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+			headerStart = i
+		case c == '/' && i+1 < len(text) && text[i+1] == '*':
+			end := strings.Index(text[i+2:], "*/")
+			if end == -1 {
+				return len(stack) > 0 && stack[len(stack)-1]
+			}
+			i += end + 3
+			headerStart = i + 1
+		case c == '{':
+			header := text[headerStart:i]
+			isCSS := len(stack) > 0 && stack[len(stack)-1]
+			if strings.Contains(header, ":: css") && !strings.Contains(header, "css_config") {
+				isCSS = true
+			}
+			stack = append(stack, isCSS)
+			headerStart = i + 1
+		case c == '}':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			headerStart = i + 1
+		case c == '\n' || c == ';':
+			headerStart = i + 1
+		}
+	}
+	return len(stack) > 0 && stack[len(stack)-1]
+}
+
+func (s *Server) completion(params textDocumentPositionParams) []CompletionItem {
+	a, filename, offset := s.analysisFor(params)
+	if offset < 0 {
+		return []CompletionItem{}
+	}
+	text := a.sources[filename]
+	before := text[:offset]
+	line := before[strings.LastIndexByte(before, '\n')+1:]
+
+	// ie. "blah.ti"
+	if chain, ok := fieldChainBefore(line); ok {
+		return a.fieldCompletions(filename, offset, chain)
+	}
+
+	// ie. "Header(is"
+	if name, ok := openCallBefore(trimIdentifierSuffix(before)); ok {
+		if component := a.components[name]; component != nil {
+			items := make([]CompletionItem, 0, 8)
+			if component.Struct != nil {
+				for _, field := range component.Struct.Fields {
+					items = append(items, fieldCompletionItem(field.Name.String(), field.TypeInfo))
+				}
+			}
+			return items
+		}
+	}
+
+	// ie. "back" in "Header :: css { .header { back"
+	if isCSSContext(before) && !strings.Contains(line, ":") {
+		items := make([]CompletionItem, 0, len(util.CSSPropertyNames()))
+		for _, name := range util.CSSPropertyNames() {
+			items = append(items, CompletionItem{
+				Label: name,
+				Kind:  completionKindProperty,
+			})
+		}
+		return items
+	}
+
+	items := make([]CompletionItem, 0, 64)
+	componentNames := make([]string, 0, len(a.components))
+	for name := range a.components {
+		componentNames = append(componentNames, name)
+	}
+	sort.Strings(componentNames)
+	for _, name := range componentNames {
+		items = append(items, CompletionItem{
+			Label:  name,
+			Kind:   completionKindClass,
+			Detail: name + " :: html",
+		})
+	}
+	procedureNames := make([]string, 0, len(a.procedures))
+	for name := range a.procedures {
+		procedureNames = append(procedureNames, name)
+	}
+	sort.Strings(procedureNames)
+	for _, name := range procedureNames {
+		items = append(items, CompletionItem{
+			Label:  name,
+			Kind:   completionKindFunction,
+			Detail: name + " :: ()",
+		})
+	}
+	for _, procedure := range builtins.All() {
+		if _, ok := a.procedures[procedure.Name]; ok {
+			continue
+		}
+		items = append(items, CompletionItem{
+			Label:  procedure.Name,
+			Kind:   completionKindFunction,
+			Detail: procedure.String(),
+		})
+	}
+	seen := make(map[string]bool)
+	if file := a.files[filename]; file != nil {
+		for _, declaration := range file.declarations {
+			if declaration.offset > offset || seen[declaration.name] {
+				continue
+			}
+			seen[declaration.name] = true
+			detail := ""
+			if declaration.typeInfo != nil {
+				detail = declaration.typeInfo.String()
+			}
+			items = append(items, CompletionItem{
+				Label:  declaration.name,
+				Kind:   completionKindVariable,
+				Detail: detail,
+			})
+		}
+	}
+	for _, name := range util.ValidHTML5TagNames() {
+		items = append(items, CompletionItem{
+			Label: name,
+			Kind:  completionKindProperty,
+		})
+	}
+	for _, keyword := range keywords {
+		items = append(items, CompletionItem{
+			Label: keyword,
+			Kind:  completionKindKeyword,
+		})
+	}
+	return items
+}
+
+func (a *analysis) fieldCompletions(filename string, offset int, chain []string) []CompletionItem {
+	typeInfo := a.typeOfVariable(filename, offset, chain[0])
+	for _, name := range chain[1:] {
+		structInfo, ok := typeInfo.(*types.Struct)
+		if !ok {
+			return []CompletionItem{}
+		}
+		field := structInfo.GetFieldByName(name)
+		if field == nil {
+			return []CompletionItem{}
+		}
+		typeInfo = field.TypeInfo
+	}
+	structInfo, ok := typeInfo.(*types.Struct)
+	if !ok {
+		return []CompletionItem{}
+	}
+	items := make([]CompletionItem, 0, len(structInfo.Fields()))
+	for _, field := range structInfo.Fields() {
+		items = append(items, fieldCompletionItem(field.Name, field.TypeInfo))
+	}
+	return items
+}
+
+func fieldCompletionItem(name string, typeInfo types.TypeInfo) CompletionItem {
+	detail := ""
+	if typeInfo != nil {
+		detail = typeInfo.String()
+	}
+	return CompletionItem{
+		Label:  name,
+		Kind:   completionKindField,
+		Detail: detail,
+	}
+}
@@ -0,0 +1,195 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testProjectFiles = map[string]string{
+	"config.fel": `default :: workspace {
+	w := workspace
+	w.template_input_directory = "templates"
+	w.template_output_directory = "public"
+	w.css_output_directory = "public/css"
+}
+`,
+	"includes/Header.fel": `Header :: struct {
+	isBlue: bool = true
+}
+
+Header :: css {
+	.header {
+		color: red
+	}
+}
+
+Header :: html {
+	header(class="header") {
+		children
+	}
+}
+`,
+	"templates/Home.fel": `Post :: struct {
+	title: string = ""
+}
+
+post := Post{title: "Hello"}
+Header(isBlue=false) {
+	div {
+		post.title
+	}
+}
+`,
+}
+
+type testClient struct {
+	input  bytes.Buffer
+	nextID int
+}
+
+func (c *testClient) send(method string, params interface{}, isRequest bool) int {
+	message := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	}
+	id := 0
+	if isRequest {
+		c.nextID++
+		id = c.nextID
+		message["id"] = id
+	}
+	content, _ := json.Marshal(message)
+	fmt.Fprintf(&c.input, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	return id
+}
+
+func positionParams(uri string, line int, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     Position{Line: line, Character: character},
+	}
+}
+
+func readMessages(t *testing.T, output []byte) []map[string]json.RawMessage {
+	c := newConn(bufio.NewReader(bytes.NewReader(output)), nil)
+	var messages []map[string]json.RawMessage
+	for {
+		content, err := c.read()
+		if err != nil {
+			return messages
+		}
+		var message map[string]json.RawMessage
+		if err := json.Unmarshal(content, &message); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, message)
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fel-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for filename, contents := range testProjectFiles {
+		filename = filepath.Join(dir, filepath.FromSlash(filename))
+		os.MkdirAll(filepath.Dir(filename), os.ModePerm)
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	homeURI := pathToURI(filepath.Join(dir, "templates", "Home.fel"))
+	headerURI := pathToURI(filepath.Join(dir, "includes", "Header.fel"))
+
+	client := &testClient{}
+	client.send("initialize", map[string]interface{}{"rootUri": pathToURI(dir)}, true)
+	client.send("initialized", map[string]interface{}{}, false)
+	brokenHome := strings.Replace(testProjectFiles["templates/Home.fel"], "isBlue=false", "isBlue=\"no\"", 1)
+	client.send("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": homeURI, "text": brokenHome},
+	}, false)
+	client.send("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": homeURI},
+		"contentChanges": []map[string]string{{"text": testProjectFiles["templates/Home.fel"]}},
+	}, false)
+	definitionID := client.send("textDocument/definition", positionParams(homeURI, 5, 2), true)
+	hoverID := client.send("textDocument/hover", positionParams(homeURI, 7, 3), true)
+	fieldsID := client.send("textDocument/completion", positionParams(homeURI, 7, 7), true)
+	componentsID := client.send("textDocument/completion", positionParams(homeURI, 6, 0), true)
+	cssID := client.send("textDocument/completion", positionParams(headerURI, 6, 2), true)
+	client.send("shutdown", nil, true)
+	client.send("exit", nil, false)
+
+	var output bytes.Buffer
+	if err := NewServer(&client.input, &output).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var diagnostics []publishDiagnosticsParams
+	results := make(map[int]json.RawMessage)
+	for _, message := range readMessages(t, output.Bytes()) {
+		if method, ok := message["method"]; ok {
+			if string(method) == `"textDocument/publishDiagnostics"` {
+				var params publishDiagnosticsParams
+				json.Unmarshal(message["params"], &params)
+				diagnostics = append(diagnostics, params)
+			}
+			continue
+		}
+		var id int
+		json.Unmarshal(message["id"], &id)
+		if errorMessage, ok := message["error"]; ok && string(errorMessage) != "null" {
+			t.Errorf("Request %d failed: %s", id, errorMessage)
+		}
+		results[id] = message["result"]
+	}
+
+	// Diagnostics are published for the type error, then cleared once fixed
+	if len(diagnostics) != 2 || diagnostics[0].URI != homeURI {
+		t.Fatalf("Expected 2 diagnostics notifications for %s, instead got: %v", homeURI, diagnostics)
+	}
+	if len(diagnostics[0].Diagnostics) == 0 || diagnostics[0].Diagnostics[0].Range.Start.Line != 5 {
+		t.Errorf("Expected error on line 5, instead got: %v", diagnostics[0].Diagnostics)
+	}
+	if len(diagnostics[0].Diagnostics) > 0 && diagnostics[0].Diagnostics[0].Code != "FEL0008" {
+		t.Errorf("Expected error code FEL0008, instead got: %v", diagnostics[0].Diagnostics[0].Code)
+	}
+	if len(diagnostics[1].Diagnostics) != 0 {
+		t.Errorf("Expected diagnostics to be cleared, instead got: %v", diagnostics[1].Diagnostics)
+	}
+
+	var locations []Location
+	json.Unmarshal(results[definitionID], &locations)
+	if len(locations) != 1 || locations[0].URI != headerURI || locations[0].Range.Start != (Position{Line: 10, Character: 0}) {
+		t.Errorf("Expected definition of Header in %s on line 10, instead got: %v", headerURI, locations)
+	}
+
+	var hover Hover
+	json.Unmarshal(results[hoverID], &hover)
+	if !strings.Contains(hover.Contents.Value, "post: Post") {
+		t.Errorf("Expected hover to contain \"post: Post\", instead got: %q", hover.Contents.Value)
+	}
+
+	expectCompletion := func(id int, label string) {
+		var items []CompletionItem
+		json.Unmarshal(results[id], &items)
+		for _, item := range items {
+			if item.Label == label {
+				return
+			}
+		}
+		t.Errorf("Expected completion request %d to contain \"%s\", instead got: %v", id, label, items)
+	}
+	expectCompletion(fieldsID, "title")
+	expectCompletion(componentsID, "Header")
+	expectCompletion(cssID, "background-color")
+}
@@ -0,0 +1,114 @@
+package lsp
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/silbinarywolf/compiler-fel/errors"
+	"github.com/silbinarywolf/compiler-fel/token"
+)
+
+// LSP positions are zero-based lines and UTF-16 code units,
+// but token.Token uses byte offsets.
+
+func offsetToPosition(text string, offset int) Position {
+	if offset > len(text) {
+		offset = len(text)
+	}
+	line := 0
+	lineStart := 0
+	for i := 0; i < offset; i++ {
+		if text[i] == '\n' {
+			line++
+			lineStart = i + 1
+		}
+	}
+	return Position{
+		Line:      line,
+		Character: utf16Length(text[lineStart:offset]),
+	}
+}
+
+func positionToOffset(text string, position Position) int {
+	offset := 0
+	for line := 0; line < position.Line; line++ {
+		index := strings.IndexByte(text[offset:], '\n')
+		if index == -1 {
+			return len(text)
+		}
+		offset += index + 1
+	}
+	character := 0
+	for offset < len(text) && text[offset] != '\n' && character < position.Character {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		character += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+func utf16Length(s string) int {
+	length := 0
+	for _, r := range s {
+		length += len(utf16.Encode([]rune{r}))
+	}
+	return length
+}
+
+// tokenRange gets the range of a token, tokens without an offset, ie. scanner errors,
+// cover the whole line.
+func tokenRange(text string, t token.Token) Range {
+	if t.Start == 0 && t.End == 0 {
+		line := t.Line - 1
+		if line < 0 {
+			line = 0
+		}
+		lineStart := positionToOffset(text, Position{Line: line})
+		lineEnd := strings.IndexByte(text[lineStart:], '\n')
+		if lineEnd == -1 {
+			lineEnd = len(text) - lineStart
+		}
+		return Range{
+			Start: offsetToPosition(text, lineStart),
+			End:   offsetToPosition(text, lineStart+lineEnd),
+		}
+	}
+	end := t.End
+	if end < t.Start {
+		end = t.Start
+	}
+	return Range{
+		Start: offsetToPosition(text, t.Start),
+		End:   offsetToPosition(text, end),
+	}
+}
+
+// locationRange gets the range of a diagnostic location
+func locationRange(text string, l errors.Location) Range {
+	return tokenRange(text, token.Token{Line: l.Line, Start: l.Start, End: l.End})
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	path := u.Path
+	// ie. "file:///C:/project" on Windows
+	if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.Clean(filepath.FromSlash(path))
+}
+
+func pathToURI(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u := url.URL{Scheme: "file", Path: path}
+	return u.String()
+}
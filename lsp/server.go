@@ -0,0 +1,293 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Server is a Language Server Protocol server for *.fel files
+type Server struct {
+	conn          *conn
+	workspaceRoot string
+	documents     map[string]string    // open documents by filepath
+	analyses      map[string]*analysis // last analysis by project root
+	diagnosed     map[string]bool      // files with published diagnostics
+	isShutdown    bool
+}
+
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		conn:      newConn(r, w),
+		documents: make(map[string]string),
+		analyses:  make(map[string]*analysis),
+		diagnosed: make(map[string]bool),
+	}
+}
+
+// Run handles requests until the client sends "exit" or the connection is closed.
+func (s *Server) Run() error {
+	for {
+		content, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			s.conn.write(response{
+				JSONRPC: "2.0",
+				Error:   &responseError{Code: errorParseError, Message: err.Error()},
+			})
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.isShutdown {
+				return fmt.Errorf("Received exit before shutdown.")
+			}
+			return nil
+		}
+		result, respErr := s.handle(&req)
+		if req.ID == nil {
+			// Notifications don't have a response
+			continue
+		}
+		if err := s.conn.write(response{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Result:  result,
+			Error:   respErr,
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(req *request) (result interface{}, respErr *responseError) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			respErr = &responseError{Code: errorInternalError, Message: fmt.Sprintf("%v", r)}
+		}
+	}()
+	invalidParams := func(err error) *responseError {
+		return &responseError{Code: errorInvalidParams, Message: err.Error()}
+	}
+
+	switch req.Method {
+	case "initialize":
+		var params initializeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		switch {
+		case params.RootURI != "":
+			s.workspaceRoot = uriToPath(params.RootURI)
+		case params.RootPath != "":
+			s.workspaceRoot = filepath.Clean(params.RootPath)
+		}
+		return initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:   textDocumentSyncFull,
+				DefinitionProvider: true,
+				HoverProvider:      true,
+				CompletionProvider: completionOptions{
+					TriggerCharacters: []string{".", "("},
+				},
+			},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.isShutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		filename := uriToPath(params.TextDocument.URI)
+		s.documents[filename] = params.TextDocument.Text
+		s.update(filename)
+		return nil, nil
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		filename := uriToPath(params.TextDocument.URI)
+		s.documents[filename] = params.ContentChanges[len(params.ContentChanges)-1].Text
+		s.update(filename)
+		return nil, nil
+	case "textDocument/didSave":
+		var params didCloseParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		s.update(uriToPath(params.TextDocument.URI))
+		return nil, nil
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		filename := uriToPath(params.TextDocument.URI)
+		delete(s.documents, filename)
+		s.update(filename)
+		return nil, nil
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.definition(params), nil
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.hover(params), nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.completion(params), nil
+	}
+	if req.ID == nil || strings.HasPrefix(req.Method, "$/") {
+		// Ignore unsupported notifications
+		return nil, nil
+	}
+	return nil, &responseError{Code: errorMethodNotFound, Message: fmt.Sprintf("Method not supported: %s", req.Method)}
+}
+
+func (s *Server) projectRoot(filename string) string {
+	return findProjectRoot(filename, s.workspaceRoot)
+}
+
+// update re-analyzes the project that the file belongs to and publishes diagnostics
+func (s *Server) update(filename string) {
+	projectRoot := s.projectRoot(filename)
+	sources := readProjectSources(projectRoot, s.documents)
+	result := analyze(sources)
+
+	// Keep the index from the last analysis that type checked, so
+	// go-to-definition and completion still work while typing.
+	if previous := s.analyses[projectRoot]; previous != nil && len(result.files) == 0 {
+		previous.sources = result.sources
+		previous.diagnostics = result.diagnostics
+		result = previous
+	}
+	s.analyses[projectRoot] = result
+	s.publishDiagnostics(result)
+}
+
+func (s *Server) publishDiagnostics(a *analysis) {
+	filenames := make([]string, 0, len(a.sources))
+	for filename := range a.sources {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		errorList := a.diagnostics[filename]
+		if len(errorList) == 0 && !s.diagnosed[filename] {
+			continue
+		}
+		text := a.sources[filename]
+		diagnostics := make([]Diagnostic, 0, len(errorList))
+		for _, err := range errorList {
+			diagnostic := Diagnostic{
+				Range:    locationRange(text, err.Location),
+				Severity: severityError,
+				Code:     err.Code.String(),
+				Source:   "fel",
+				Message:  err.Message,
+			}
+			for _, suggestion := range err.Suggestions {
+				diagnostic.Message += "\n" + suggestion
+			}
+			for _, related := range err.Related {
+				relatedText, ok := a.sources[related.Filepath]
+				if !ok {
+					continue
+				}
+				diagnostic.RelatedInformation = append(diagnostic.RelatedInformation, DiagnosticRelatedInformation{
+					Location: Location{
+						URI:   pathToURI(related.Filepath),
+						Range: locationRange(relatedText, related.Location),
+					},
+					Message: related.Message,
+				})
+			}
+			diagnostics = append(diagnostics, diagnostic)
+		}
+		s.diagnosed[filename] = len(diagnostics) > 0
+		s.conn.write(notification{
+			JSONRPC: "2.0",
+			Method:  "textDocument/publishDiagnostics",
+			Params: publishDiagnosticsParams{
+				URI:         pathToURI(filename),
+				Diagnostics: diagnostics,
+			},
+		})
+	}
+}
+
+// analysisFor gets the analysis and byte offset for a position in a document
+func (s *Server) analysisFor(params textDocumentPositionParams) (*analysis, string, int) {
+	filename := uriToPath(params.TextDocument.URI)
+	projectRoot := s.projectRoot(filename)
+	a := s.analyses[projectRoot]
+	if a == nil {
+		s.update(filename)
+		a = s.analyses[projectRoot]
+	}
+	text, ok := a.sources[filename]
+	if !ok {
+		return a, filename, -1
+	}
+	return a, filename, positionToOffset(text, params.Position)
+}
+
+func (s *Server) definition(params textDocumentPositionParams) []Location {
+	a, filename, offset := s.analysisFor(params)
+	ref := a.referenceAt(filename, offset)
+	if ref == nil || ref.definition == nil {
+		return []Location{}
+	}
+	definition := ref.definition
+	text, ok := a.sources[definition.Filepath]
+	if !ok {
+		return []Location{}
+	}
+	return []Location{
+		{
+			URI:   pathToURI(definition.Filepath),
+			Range: tokenRange(text, *definition),
+		},
+	}
+}
+
+func (s *Server) hover(params textDocumentPositionParams) *Hover {
+	a, filename, offset := s.analysisFor(params)
+	ref := a.referenceAt(filename, offset)
+	if ref == nil || ref.hover == "" {
+		return nil
+	}
+	textRange := tokenRange(a.sources[filename], ref.token)
+	return &Hover{
+		Contents: markupContent{
+			Kind:  "markdown",
+			Value: ref.hover,
+		},
+		Range: &textRange,
+	}
+}
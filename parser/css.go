@@ -4,8 +4,10 @@ import (
 	"fmt"
 
 	"github.com/silbinarywolf/compiler-fel/ast"
+	"github.com/silbinarywolf/compiler-fel/errors"
 	"github.com/silbinarywolf/compiler-fel/scanner"
 	"github.com/silbinarywolf/compiler-fel/token"
+	"github.com/silbinarywolf/compiler-fel/util"
 )
 
 func getNewTokenList() []ast.Node {
@@ -105,6 +107,122 @@ func (p *Parser) parseCSSProperty(tokenList []ast.Node) *ast.CSSProperty {
 	return cssPropertyNode
 }
 
+// parseCSSPropertyExpression parses property values that use arithmetic
+// as an expression, ie. "padding: base * 2", and values that call procedures,
+// ie. "border: 1px solid darken(bg, 10%)". Otherwise, it returns nil and
+// the value is kept as-is. (ie. "margin: 0 auto")
+func (p *Parser) parseCSSPropertyExpression(tokenList []ast.Node) *ast.CSSProperty {
+	if len(tokenList) != 1 {
+		return nil
+	}
+	name, ok := tokenList[0].(*ast.Token)
+	if !ok || name.Kind != token.Identifier {
+		return nil
+	}
+
+	// Look ahead to determine if we're a property or a selector
+	// (ie. "a:hover {") and if we're an expression.
+	isExpression := false
+	hasCall := false
+	state := p.ScannerState()
+	parenDepth := 0
+	lastKind := token.Unknown
+	lastIdentifierName := ""
+Loop:
+	for {
+		t := p.GetNextToken()
+		switch t.Kind {
+		case token.BraceOpen, token.EOF:
+			p.SetScannerState(state)
+			return nil
+		case token.Newline, token.Semicolon, token.BraceClose:
+			break Loop
+		case token.ParenOpen:
+			// ie. "darken(", but not "url("
+			if lastKind == token.Identifier && !util.IsCSSFunctionName(lastIdentifierName) {
+				hasCall = true
+			}
+			parenDepth++
+		case token.ParenClose:
+			parenDepth--
+		case token.Multiply, token.Add, token.Subtract:
+			if parenDepth == 0 {
+				isExpression = true
+			}
+		case token.Divide:
+			// Only treat as divide if surrounded by whitespace as "/"
+			// is used in shorthand properties, ie. "font: 12px/1.5"
+			if parenDepth == 0 && lastKind == token.Whitespace {
+				isExpression = true
+			}
+		case token.Identifier:
+			lastIdentifierName = t.String()
+		}
+		lastKind = t.Kind
+	}
+	p.SetScannerState(state)
+	if !isExpression && !hasCall {
+		return nil
+	}
+
+	node := new(ast.CSSProperty)
+	node.Name = name.Token
+	if !isExpression {
+		node.ChildNodes = p.parseCSSPropertyValueWithCalls()
+		return node
+	}
+	p.SetScanMode(scanner.ModeDefault)
+	expression := p.parseExpression(false)
+	p.SetScanMode(scanner.ModeCSS)
+	node.ChildNodes = []ast.Node{expression}
+	return node
+}
+
+// parseCSSPropertyValueWithCalls parses a value where each part is kept
+// as-is except for procedure calls, ie. "1px solid darken(bg, 10%)"
+func (p *Parser) parseCSSPropertyValueWithCalls() []ast.Node {
+	nodes := make([]ast.Node, 0, 10)
+	for {
+		t := p.PeekNextToken()
+		switch t.Kind {
+		case token.Newline, token.Semicolon, token.BraceClose:
+			return removeTrailingWhitespaceTokens(nodes)
+		case token.Whitespace:
+			p.GetNextToken()
+			if len(nodes) == 0 {
+				continue
+			}
+			nodes = append(nodes, &ast.Token{Token: t})
+		case token.ParenOpen:
+			p.GetNextToken()
+			if len(nodes) > 0 {
+				if name, ok := nodes[len(nodes)-1].(*ast.Token); ok &&
+					name.Kind == token.Identifier &&
+					!util.IsCSSFunctionName(name.String()) {
+					// ie. "darken("
+					p.SetScanMode(scanner.ModeDefault)
+					call := p.parseProcedureOrHTMLNode(name.Token, false)
+					p.SetScanMode(scanner.ModeCSS)
+					if call == nil {
+						return nil
+					}
+					nodes[len(nodes)-1] = call
+					continue
+				}
+			}
+			// ie. "url("font.woff")"
+			childNodes := p.parseCSSStatements()
+			if len(childNodes) != 1 {
+				p.PanicError(t, fmt.Errorf("Expected a single value inside ()"))
+			}
+			nodes = append(nodes, childNodes[0])
+		default:
+			p.GetNextToken()
+			nodes = append(nodes, &ast.Token{Token: t})
+		}
+	}
+}
+
 func (p *Parser) parseCSSStatements() []ast.Node {
 	resultNodes := make([]ast.Node, 0, 10)
 	tokenList := make([]ast.Node, 0, 30)
@@ -133,12 +251,23 @@ Loop:
 
 			// Clear
 			tokenList = getNewTokenList()
-		case token.AtKeyword, token.KeywordTrue, token.KeywordFalse, token.Identifier, token.Number, token.Multiply:
+		case token.AtKeyword, token.KeywordTrue, token.KeywordFalse, token.KeywordNil, token.Identifier, token.Number, token.NumberWithUnit, token.String, token.Multiply, token.Divide, token.And:
 			// NOTE: We do -NOT- want to eat whitespace surrounding `token.Identifier`
 			//       as that is used to detect / determine descendent selectors. (ie. ".top-class .descendent")
+			//
+			//       `token.And` is a parent selector reference. (ie. "&.is-active")
 			tokenList = append(tokenList, &ast.Token{Token: t})
 		case token.Add, token.Tilde, token.GreaterThan, token.Colon, token.DoubleColon:
 			tokenList = removeTrailingWhitespaceTokens(tokenList)
+			if t.Kind == token.Colon {
+				if node := p.parseCSSPropertyExpression(tokenList); node != nil {
+					resultNodes = append(resultNodes, node)
+
+					// Clear
+					tokenList = getNewTokenList()
+					continue Loop
+				}
+			}
 			tokenList = append(tokenList, &ast.Token{Token: t})
 			p.eatWhitespace()
 		case token.Semicolon, token.Newline:
@@ -172,7 +301,7 @@ Loop:
 			}
 		case token.BraceOpen:
 			if len(tokenList) == 0 {
-				p.AddError(t, fmt.Errorf("Unexpected {, expected identifiers preceding for CSS rule."))
+				p.AddError(t, errors.CodeSyntax, fmt.Errorf("Unexpected {, expected identifiers preceding for CSS rule."))
 				return nil
 			}
 
@@ -271,7 +400,14 @@ Loop:
 				panic(fmt.Sprintf("parseCSSStatements(): Too many nodes inside () on Line %d", t.Line))
 			}
 			tokenList = append(tokenList, nodes[0])
-		case token.BraceClose, token.ParenClose:
+		case token.BraceClose:
+			// Finish statement, ie. "from { opacity: 0 }"
+			if len(tokenList) > 0 {
+				resultNodes = append(resultNodes, p.parseCSSProperty(tokenList))
+				tokenList = getNewTokenList()
+			}
+			break Loop
+		case token.ParenClose:
 			// Finish statement
 			break Loop
 		case token.EOF:
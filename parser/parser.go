@@ -1,7 +1,8 @@
 package parser
 
 import (
-	"encoding/json"
+	"bytes"
+	//"encoding/json"
 	"fmt"
 	"io/ioutil"
 
@@ -32,6 +33,27 @@ func (p *Parser) HasErrors() bool {
 	return p.Scanner.HasErrors() || p.ErrorHandler.HasErrors()
 }
 
+// Diagnostics returns the parser errors and the scanner error, if any
+func (p *Parser) Diagnostics() []*errors.Diagnostic {
+	diagnostics := p.ErrorHandler.Diagnostics()
+	if p.Scanner.HasErrors() {
+		t := token.Token{Filepath: p.Scanner.Filepath, Line: p.Scanner.Line()}
+		diagnostics = append(diagnostics[:len(diagnostics):len(diagnostics)], errors.NewDiagnostic(t, errors.CodeInvalidCharacter, p.Scanner.Error))
+	}
+	return diagnostics
+}
+
+// String returns the errors in the same format as PrintErrors, without source snippets
+func (p *Parser) String() string {
+	var buffer bytes.Buffer
+	errors.WriteText(&buffer, p.Diagnostics(), nil)
+	return buffer.String()
+}
+
+func (p *Parser) PrintErrors() {
+	fmt.Print(p.String())
+}
+
 func (p *Parser) ParseFile(filepath string) (*ast.File, error) {
 	filecontentsAsBytes, err := ioutil.ReadFile(filepath)
 	if err != nil {
@@ -48,7 +70,7 @@ func (p *Parser) Parse(filecontentsAsBytes []byte, filepath string) *ast.File {
 	p.Scanner.Init(filecontentsAsBytes, filepath)
 	t := p.PeekNextToken()
 	if t.Kind == token.EOF {
-		p.AddError(t, fmt.Errorf("Empty source file: %s", filepath))
+		p.AddError(t, errors.CodeEmptyFile, fmt.Errorf("Empty source file: %s", filepath))
 		return nil
 	}
 
@@ -59,8 +81,8 @@ func (p *Parser) Parse(filecontentsAsBytes []byte, filepath string) *ast.File {
 	}
 	astFile.ChildNodes = p.parseStatements()
 	astFile.Dependencies = p.dependencies
-	json, _ := json.MarshalIndent(astFile.Dependencies, "", "   ")
-	fmt.Printf("%s\nJSON AST\n---------------\n", string(json))
+	//json, _ := json.MarshalIndent(astFile.Dependencies, "", "   ")
+	//fmt.Printf("%s\nJSON AST\n---------------\n", string(json))
 	p.dependencies = nil
 
 	return astFile
@@ -69,7 +91,7 @@ func (p *Parser) Parse(filecontentsAsBytes []byte, filepath string) *ast.File {
 func (p *Parser) validateHTMLNode(node *ast.Call) {
 	name := node.Name.String()
 	if len(node.ChildNodes) > 0 && util.IsSelfClosingTagName(name) {
-		p.AddError(node.Name, fmt.Errorf("%s is a self-closing tag and cannot have child elements.", name))
+		p.AddError(node.Name, errors.CodeInvalidDefinition, fmt.Errorf("%s is a self-closing tag and cannot have child elements.", name))
 	}
 	p.dependencies[name] = true
 	// todo(Jake): Extend this to allow user configured/whitelisted tag names
@@ -88,7 +110,7 @@ func (p *Parser) NewDeclareStatement(name token.Token, typeIdent ast.TypeIdent,
 
 	nameString := name.String()
 	if len(nameString) > 0 && nameString[len(nameString)-1] == '-' {
-		p.AddError(name, fmt.Errorf("Declaring variable name ending with - is illegal."))
+		p.AddError(name, errors.CodeSyntax, fmt.Errorf("Declaring variable name ending with - is illegal."))
 	}
 
 	return node
@@ -112,6 +134,24 @@ Loop:
 		case token.Identifier:
 			storeScannerState := p.ScannerState()
 			name := p.GetNextToken()
+			// return {expr}  -or-  return \n
+			//
+			// Checked first as the expression can start with any token,
+			// ie. "return []string{}"
+			if name.String() == "return" {
+				node := new(ast.Return)
+				node.TypeIdentifier.Name = name
+				// NOTE(Jake): 2017-12-30, Hack to store Line/Column/File data from token on ast.Return
+				node.TypeIdentifier.Name.Kind = token.Unknown
+				node.TypeIdentifier.Name.Data = ""
+				if t := p.PeekNextToken(); t.Kind != token.Newline &&
+					t.Kind != token.BraceClose &&
+					t.Kind != token.EOF {
+					node.Expression.ChildNodes = p.parseExpressionNodes(false)
+				}
+				resultNodes = append(resultNodes, node)
+				continue
+			}
 			switch t := p.GetNextToken(); t.Kind {
 			// myVar := {Expression} \n
 			//
@@ -134,10 +174,16 @@ Loop:
 				resultNodes = append(resultNodes, node)
 			// myVar []= "append array item"
 			case token.BracketOpen:
-				if t := p.GetNextToken(); t.Kind != token.BracketClose {
-					p.AddExpectError(t, token.BracketClose)
+				if p.PeekNextToken().Kind != token.BracketClose {
+					p.SetScannerState(storeScannerState)
+					node := p.parseIndexStatement()
+					if node == nil {
+						return nil
+					}
+					resultNodes = append(resultNodes, node)
 					continue
 				}
+				p.GetNextToken()
 				if t := p.GetNextToken(); t.Kind != token.Equal {
 					p.AddExpectError(t, token.Equal)
 					continue
@@ -170,11 +216,29 @@ Loop:
 				}
 
 				operatorToken := p.GetNextToken()
+				// myVar.Property \n
+				//
+				if operatorToken.Kind == token.Newline ||
+					operatorToken.Kind == token.BraceClose ||
+					operatorToken.Kind == token.EOF {
+					p.SetScannerState(storeScannerState)
+					node := p.parseExpression(false)
+					resultNodes = append(resultNodes, node)
+					continue
+				}
 				if operatorToken.Kind == token.BracketOpen {
-					if t := p.GetNextToken(); t.Kind != token.BracketClose {
-						p.AddExpectError(t, token.BracketClose)
+					// myVar.Property[i] = {Expression} -or- myVar.Property[i] \n
+					//
+					if p.PeekNextToken().Kind != token.BracketClose {
+						p.SetScannerState(storeScannerState)
+						node := p.parseIndexStatement()
+						if node == nil {
+							return nil
+						}
+						resultNodes = append(resultNodes, node)
 						continue
 					}
+					p.GetNextToken()
 					if t := p.GetNextToken(); t.Kind != token.Equal {
 						p.AddExpectError(t, token.Equal)
 						continue
@@ -187,13 +251,13 @@ Loop:
 					continue
 				}
 				if operatorToken.Kind == token.DeclareSet {
-					p.AddError(operatorToken, fmt.Errorf("Cannot use := on a property. (%s)", ast.LeftHandSide(leftHandSide)))
+					p.AddError(operatorToken, errors.CodeSyntax, fmt.Errorf("Cannot use := on a property. (%s)", ast.LeftHandSide(leftHandSide)))
 					continue
 				}
 
 				if operatorToken.Kind != token.Equal &&
-					operatorToken.Kind != token.AddEqual {
-					p.AddExpectError(operatorToken, operatorToken, token.Equal, token.AddEqual)
+					operatorToken.Kind.AssignOperator() == token.Unknown {
+					p.AddExpectError(operatorToken, token.Equal, token.AddEqual, token.SubtractEqual, token.MultiplyEqual, token.DivideEqual, token.ModuloEqual)
 					continue
 				}
 
@@ -204,7 +268,7 @@ Loop:
 				resultNodes = append(resultNodes, node)
 			// myVar = {Expression} \n
 			//
-			case token.Equal, token.AddEqual:
+			case token.Equal, token.AddEqual, token.SubtractEqual, token.MultiplyEqual, token.DivideEqual, token.ModuloEqual:
 				leftHandSide := make([]token.Token, 1)
 				leftHandSide[0] = name
 				node := new(ast.OpStatement)
@@ -222,18 +286,12 @@ Loop:
 					p.AddExpectError(t, token.BraceOpen)
 					return nil
 				}
-				// NOTE(Jake): 2018-01-14
-				//
-				// This code seems like a mistake, but perhaps its solving
-				// a quirk I've forgotten about. To be deleted if its not
-				// needed.
-				//
-				//if t := p.GetNextToken(); t.Kind == token.BraceOpen {
-				//	p.AddExpectError(t, token.BraceOpen)
-				//	return nil
-				//}
-				p.GetNextToken()
 				node.ChildNodes = p.parseStatements()
+				elseNodes, ok := p.parseElse()
+				if !ok {
+					return nil
+				}
+				node.ElseNodes = elseNodes
 				p.validateHTMLNode(node)
 				resultNodes = append(resultNodes, node)
 			// div {
@@ -247,7 +305,7 @@ Loop:
 			// div(class="hey")  -or-  div(class="hey") if expr {
 			//    ^						  ^
 			case token.ParenOpen:
-				node := p.parseProcedureOrHTMLNode(name)
+				node := p.parseProcedureOrHTMLNode(name, false)
 				if node == nil {
 					return nil
 				}
@@ -255,17 +313,6 @@ Loop:
 			// PrintThisVariable \n
 			// ^
 			case token.Newline:
-				if name.String() == "return" {
-					p.SetScannerState(storeScannerState)
-					node := new(ast.Return)
-					node.TypeIdentifier.Name = p.GetNextToken() // consume `return`
-					// NOTE(Jake): 2017-12-30, Hack to store Line/Column/File data from token on ast.Return
-					node.TypeIdentifier.Name.Kind = token.Unknown
-					node.TypeIdentifier.Name.Data = ""
-					//node.Expression.ChildNodes = nil
-					resultNodes = append(resultNodes, node)
-					continue
-				}
 				p.SetScannerState(storeScannerState)
 				node := p.parseExpression(false)
 				resultNodes = append(resultNodes, node)
@@ -284,20 +331,7 @@ Loop:
 					resultNodes = append(resultNodes, node)
 					continue
 				}
-				// return {expr}
-				if name.Kind == token.Identifier &&
-					name.String() == "return" {
-					p.SetScannerState(storeScannerState)
-					node := new(ast.Return)
-					node.TypeIdentifier.Name = p.GetNextToken() // consume `return`
-					// NOTE(Jake): 2017-12-30, Hack to store Line/Column/File data from token on ast.Return
-					node.TypeIdentifier.Name.Kind = token.Unknown
-					node.TypeIdentifier.Name.Data = ""
-					node.Expression.ChildNodes = p.parseExpressionNodes(false)
-					resultNodes = append(resultNodes, node)
-					continue
-				}
-				p.AddError(t, fmt.Errorf("Unexpected %s (%s) after identifier (%s).", t.Kind.String(), t.String(), name.String()))
+				p.AddError(t, errors.CodeSyntax, fmt.Errorf("Unexpected %s (%s) after identifier (%s).", t.Kind.String(), t.String(), name.String()))
 				return nil
 			}
 		// :: css {
@@ -326,46 +360,11 @@ Loop:
 			p.GetNextToken()
 		case token.KeywordIf:
 			p.GetNextToken()
-			// NOTE(Jake): Disable struct literal in if-statement as
-			//			   the parser needs to understand '{' is the
-			//			   start of the if-block when testing boolean exxpressions
-			//
-			//			   ie. "if myBool {" vs "if myStructLit{val:3} {"
-			//
-			exprNodes := p.parseExpressionNodes(true)
-			if t := p.GetNextToken(); t.Kind != token.BraceOpen {
-				p.AddExpectError(t, token.BraceOpen)
+			node := p.parseIf()
+			if node == nil {
 				return nil
 			}
-			node := new(ast.If)
-			node.Condition.ChildNodes = exprNodes
-			node.ChildNodes = p.parseStatements()
 			resultNodes = append(resultNodes, node)
-			p.eatNewlines()
-			// Eat newlines
-			/*for {
-				t := p.PeekNextToken()
-				if t.Kind == token.Newline {
-					p.GetNextToken()
-					continue
-				}
-				break
-			}*/
-			t = p.PeekNextToken()
-			if t.Kind == token.KeywordElse {
-				p.GetNextToken()
-				t := p.PeekNextToken()
-				switch t.Kind {
-				case token.KeywordIf:
-					// no-op
-				case token.BraceOpen:
-					p.GetNextToken()
-				default:
-					p.AddExpectError(t, token.BraceOpen, token.KeywordIf)
-					return nil
-				}
-				node.ElseNodes = p.parseStatements()
-			}
 		case token.KeywordFor:
 			p.GetNextToken()
 			varName := p.GetNextToken()
@@ -381,7 +380,7 @@ Loop:
 				node = new(ast.For)
 				node.IsDeclareSet = true
 				node.RecordName = varName
-				node.Array.ChildNodes = p.parseExpressionNodes(false)
+				node.Array.ChildNodes = p.parseExpressionNodes(true)
 			case token.Comma:
 				secondVarName := p.GetNextToken()
 				if secondVarName.Kind != token.Identifier {
@@ -397,7 +396,7 @@ Loop:
 				node.IsDeclareSet = true
 				node.IndexName = varName
 				node.RecordName = secondVarName
-				node.Array.ChildNodes = p.parseExpressionNodes(false)
+				node.Array.ChildNodes = p.parseExpressionNodes(true)
 			default:
 				p.AddExpectError(t, token.DeclareSet, token.Comma)
 				return nil
@@ -425,7 +424,7 @@ Loop:
 }
 
 func (p *Parser) isParseTypeAhead() bool {
-	if t := p.PeekNextToken(); t.Kind != token.BracketOpen && t.Kind != token.Identifier {
+	if t := p.PeekNextToken(); t.Kind != token.BracketOpen && t.Kind != token.Identifier && t.Kind != token.Ternary {
 		return false
 	}
 	return true
@@ -435,6 +434,11 @@ func (p *Parser) parseTypeIdent() ast.TypeIdent {
 	result := ast.TypeIdent{}
 
 	t := p.GetNextToken()
+	if t.Kind == token.Ternary {
+		// ie. ?string, ?[]string
+		result.Optional = true
+		t = p.GetNextToken()
+	}
 	if t.Kind == token.BracketOpen {
 		// Parse array / array-of-array / etc
 		// ie. []string, [][]string, [][][]string, etc
@@ -457,10 +461,213 @@ func (p *Parser) parseTypeIdent() ast.TypeIdent {
 		p.AddExpectError(t, "type identifier")
 		return ast.TypeIdent{}
 	}
+	if p.isMapType(t) {
+		mapTypeIdent := p.parseMapTypeIdent(t)
+		if mapTypeIdent.Name.Kind == token.Unknown {
+			return ast.TypeIdent{}
+		}
+		mapTypeIdent.ArrayDepth = result.ArrayDepth
+		mapTypeIdent.Optional = result.Optional
+		return mapTypeIdent
+	}
 	result.Name = t
 	return result
 }
 
+// isMapType is true if the identifier starts a map type, ie. "map[string]int"
+func (p *Parser) isMapType(name token.Token) bool {
+	// "map" isn't a keyword as it's also a HTML element, so it's only
+	// a map type if it's followed by "[".
+	return name.String() == "map" && p.PeekNextToken().Kind == token.BracketOpen
+}
+
+// parseMapTypeIdent parses the rest of a map type after "map", ie. "[string]int"
+func (p *Parser) parseMapTypeIdent(name token.Token) ast.TypeIdent {
+	p.GetNextToken() // [
+	keyType := p.GetNextToken()
+	if keyType.Kind != token.Identifier {
+		p.AddExpectError(keyType, "type identifier")
+		return ast.TypeIdent{}
+	}
+	if t := p.GetNextToken(); t.Kind != token.BracketClose {
+		p.AddExpectError(t, token.BracketClose)
+		return ast.TypeIdent{}
+	}
+	if !p.isParseTypeAhead() {
+		p.AddExpectError(p.GetNextToken(), "type identifier")
+		return ast.TypeIdent{}
+	}
+	valueTypeIdent := p.parseTypeIdent()
+	if valueTypeIdent.Name.Kind == token.Unknown {
+		return ast.TypeIdent{}
+	}
+	if keyType.String() != "string" {
+		// Checked after the value type so the rest of the line still parses
+		p.AddError(keyType, errors.CodeSyntax, fmt.Errorf("Map keys must be string, not %s.", keyType.String()))
+		return ast.TypeIdent{}
+	}
+	return ast.TypeIdent{
+		Name:     name,
+		MapValue: &valueTypeIdent,
+	}
+}
+
+// parseMapLiteral parses a map literal after the type, ie. `{"home": "icon-home"}`
+func (p *Parser) parseMapLiteral(typeIdent ast.TypeIdent) *ast.MapLiteral {
+	if t := p.GetNextToken(); t.Kind != token.BraceOpen {
+		p.AddExpectError(t, token.BraceOpen)
+		return nil
+	}
+	node := new(ast.MapLiteral)
+	node.TypeIdentifier = typeIdent
+	for i := 0; true; i++ {
+		p.eatNewlines()
+		if t := p.PeekNextToken(); t.Kind == token.BraceClose {
+			p.GetNextToken()
+			break
+		}
+		entry := ast.MapEntry{}
+		entry.Key.ChildNodes = p.parseExpressionNodes(false)
+		if entry.Key.ChildNodes == nil {
+			return nil
+		}
+		if t := p.GetNextToken(); t.Kind != token.Colon {
+			p.AddError(t, errors.CodeSyntax, fmt.Errorf("Expected : after key of map item #%d, not %s.", i, t.Kind.String()))
+			return nil
+		}
+		entry.Value.ChildNodes = p.parseExpressionNodes(false)
+		if entry.Value.ChildNodes == nil {
+			return nil
+		}
+		node.Entries = append(node.Entries, entry)
+		switch sep := p.GetNextToken(); sep.Kind {
+		case token.Comma:
+			continue
+		case token.BraceClose:
+		case token.EOF:
+			p.AddUnexpectedErrorWithContext(sep, "map literal")
+			return nil
+		default:
+			p.AddError(sep, errors.CodeSyntax, fmt.Errorf("Expected , or } after map item #%d, not %s.", i, sep.Kind.String()))
+			return nil
+		}
+		break
+	}
+	return node
+}
+
+// parseIndexStatement parses a statement that starts with an index,
+// ie. `items[i] = "x"` or `post.tags[0] = "x"`, or outputs it, ie. `icons["home"]`
+func (p *Parser) parseIndexStatement() ast.Node {
+	storeScannerState := p.ScannerState()
+	name := p.GetNextToken()
+	var value ast.Node = &ast.Token{Token: name}
+	if p.PeekNextToken().Kind == token.Dot {
+		// ie. post.meta["title"]
+		tokens := make([]token.Token, 0, 5)
+		tokens = append(tokens, name)
+		for p.PeekNextToken().Kind == token.Dot {
+			p.GetNextToken()
+			identToken := p.GetNextToken()
+			if identToken.Kind != token.Identifier {
+				p.AddExpectError(identToken, token.Identifier)
+				return nil
+			}
+			tokens = append(tokens, identToken)
+		}
+		value = ast.NewTokenList(tokens)
+	}
+	leftHandSide := p.parseIndexes(value)
+	if leftHandSide == nil {
+		return nil
+	}
+	if t := p.PeekNextToken(); t.Kind != token.Equal {
+		// Parse again as an expression, ie. `items[i] + "x"`
+		p.SetScannerState(storeScannerState)
+		return p.parseExpression(false)
+	}
+	operator := p.GetNextToken()
+	index, ok := leftHandSide.(*ast.Index)
+	if !ok {
+		p.AddError(operator, errors.CodeUnsupported, fmt.Errorf("Can only assign to an index, ie. items[i] = value."))
+		return nil
+	}
+	node := new(ast.IndexAssignStatement)
+	node.Index = index
+	node.Expression.ChildNodes = p.parseExpressionNodes(false)
+	if node.Expression.ChildNodes == nil {
+		return nil
+	}
+	if len(node.Expression.ChildNodes) == 0 {
+		p.AddExpectError(p.PeekNextToken(), "expression")
+		return nil
+	}
+	return node
+}
+
+// parseIndexes parses any indexes, slices or fields after a value,
+// ie. `icons["home"]`, `items[1:3]` or `items[i].title`
+func (p *Parser) parseIndexes(node ast.Node) ast.Node {
+	for {
+		switch p.PeekNextToken().Kind {
+		case token.BracketOpen:
+			bracket := p.GetNextToken()
+			var low []ast.Node
+			if p.PeekNextToken().Kind != token.Colon {
+				low = p.parseExpressionNodes(false)
+				if low == nil {
+					return nil
+				}
+				if len(low) == 0 {
+					p.AddExpectError(p.GetNextToken(), "expression")
+					return nil
+				}
+			}
+			if p.PeekNextToken().Kind == token.Colon {
+				// ie. items[1:3]
+				p.GetNextToken()
+				slice := new(ast.Slice)
+				slice.Bracket = bracket
+				slice.Value.ChildNodes = []ast.Node{node}
+				slice.Low.ChildNodes = low
+				if p.PeekNextToken().Kind != token.BracketClose {
+					slice.High.ChildNodes = p.parseExpressionNodes(false)
+					if slice.High.ChildNodes == nil {
+						return nil
+					}
+				}
+				node = slice
+			} else {
+				index := new(ast.Index)
+				index.Bracket = bracket
+				index.Value.ChildNodes = []ast.Node{node}
+				index.Index.ChildNodes = low
+				node = index
+			}
+			if t := p.GetNextToken(); t.Kind != token.BracketClose {
+				p.AddExpectError(t, token.BracketClose)
+				return nil
+			}
+		case token.Dot:
+			// ie. items[i].title
+			selector := new(ast.Selector)
+			selector.Value.ChildNodes = []ast.Node{node}
+			for p.PeekNextToken().Kind == token.Dot {
+				p.GetNextToken()
+				name := p.GetNextToken()
+				if name.Kind != token.Identifier {
+					p.AddExpectError(name, token.Identifier)
+					return nil
+				}
+				selector.Fields = append(selector.Fields, name)
+			}
+			node = selector
+		default:
+			return node
+		}
+	}
+}
+
 func (p *Parser) parseExpression(disableStructLiteral bool) *ast.Expression {
 	node := new(ast.Expression)
 	node.ChildNodes = p.parseExpressionNodes(disableStructLiteral)
@@ -484,9 +691,23 @@ Loop:
 		case token.Identifier:
 			name := p.GetNextToken()
 			if expectOperator {
-				p.AddError(name, fmt.Errorf("Expected operator instead got identifier (%s).", name.String()))
+				p.AddError(name, errors.CodeSyntax, fmt.Errorf("Expected operator instead got identifier (%s).", name.String()))
 				return nil
 			}
+			if p.isMapType(name) {
+				// ie. map[string]string{"home": "icon-home"}
+				typeIdent := p.parseMapTypeIdent(name)
+				if typeIdent.Name.Kind == token.Unknown {
+					return nil
+				}
+				node := p.parseMapLiteral(typeIdent)
+				if node == nil {
+					return nil
+				}
+				expectOperator = true
+				infixNodes = append(infixNodes, node)
+				continue Loop
+			}
 			switch t := p.PeekNextToken(); t.Kind {
 			case token.Dot:
 				p.GetNextToken()
@@ -507,18 +728,43 @@ Loop:
 					if t.IsOperator() ||
 						t.Kind == token.Comma ||
 						t.Kind == token.Newline ||
-						t.Kind == token.ParenClose {
+						t.Kind == token.ParenClose ||
+						t.Kind == token.BracketOpen ||
+						t.Kind == token.BracketClose ||
+						t.Kind == token.BraceOpen ||
+						t.Kind == token.BraceClose ||
+						t.Kind == token.Colon {
+						// "{" ends the expression in statements,
+						// ie. "for link := page.links {", "if page.active {"
 						break
 					}
 					p.AddExpectError(t, token.Operator, token.Newline, token.ParenClose)
 					return nil
 				}
+				node := p.parseIndexes(ast.NewTokenList(tokens))
+				if node == nil {
+					return nil
+				}
 				expectOperator = true
-				infixNodes = append(infixNodes, ast.NewTokenList(tokens))
+				infixNodes = append(infixNodes, node)
 				continue Loop
 			case token.ParenOpen:
 				p.GetNextToken()
-				node := p.parseProcedureOrHTMLNode(name)
+				call := p.parseProcedureOrHTMLNode(name, disableStructLiteral)
+				if call == nil {
+					return nil
+				}
+				// ie. split(text, ",")[0]
+				node := p.parseIndexes(call)
+				if node == nil {
+					return nil
+				}
+				expectOperator = true
+				infixNodes = append(infixNodes, node)
+				continue Loop
+			case token.BracketOpen:
+				// ie. icons["home"]
+				node := p.parseIndexes(&ast.Token{Token: name})
 				if node == nil {
 					return nil
 				}
@@ -555,18 +801,18 @@ Loop:
 					}
 					if propertyName.Kind != token.Identifier {
 						if i == 0 {
-							p.AddError(propertyName, fmt.Errorf("Expected identifier after %s{ not %s", name, propertyName.Kind.String()))
+							p.AddError(propertyName, errors.CodeSyntax, fmt.Errorf("Expected identifier after %s{ not %s", name, propertyName.Kind.String()))
 							return nil
 						}
-						p.AddError(propertyName, fmt.Errorf("Expected identifier after \"%s\" not %s", errorMsgLastToken, propertyName.Kind.String()))
+						p.AddError(propertyName, errors.CodeSyntax, fmt.Errorf("Expected identifier after \"%s\" not %s", errorMsgLastToken, propertyName.Kind.String()))
 						return nil
 					}
 					if t := p.GetNextToken(); t.Kind != token.Colon {
 						if i == 0 {
-							p.AddError(t, fmt.Errorf("Expected : after \"%s{%s\"", name.String(), propertyName.String()))
+							p.AddError(t, errors.CodeSyntax, fmt.Errorf("Expected : after \"%s{%s\"", name.String(), propertyName.String()))
 							return nil
 						}
-						p.AddError(t, fmt.Errorf("Expected : after property \"%s\"", propertyName.String()))
+						p.AddError(t, errors.CodeSyntax, fmt.Errorf("Expected : after property \"%s\"", propertyName.String()))
 						return nil
 					}
 					exprNodes := p.parseExpressionNodes(false)
@@ -597,7 +843,7 @@ Loop:
 					Fields: fields,
 				})
 				continue Loop
-			case token.Newline:
+			case token.Newline, token.Comma, token.BracketClose, token.Colon:
 				// no-op
 			case token.ParenClose:
 				// NOTE(Jake): 2018-04-23
@@ -611,15 +857,15 @@ Loop:
 				if t.IsOperator() {
 					break
 				}
-				p.AddError(t, fmt.Errorf("Expected operator, instead got %s.", t.String()))
+				p.AddError(t, errors.CodeSyntax, fmt.Errorf("Expected operator, instead got %s.", t.String()))
 				return nil
 			}
 			expectOperator = true
 			infixNodes = append(infixNodes, &ast.Token{Token: t})
-		case token.KeywordTrue, token.KeywordFalse:
+		case token.KeywordTrue, token.KeywordFalse, token.KeywordNil:
 			p.GetNextToken()
 			if expectOperator {
-				p.AddError(t, fmt.Errorf("Expected operator, instead got true/false keyword (\"%s\").", t.String()))
+				p.AddError(t, errors.CodeSyntax, fmt.Errorf("Expected operator, instead got keyword (\"%s\").", t.String()))
 				return nil
 			}
 			expectOperator = true
@@ -627,7 +873,7 @@ Loop:
 		case token.String:
 			p.GetNextToken()
 			if expectOperator {
-				p.AddError(t, fmt.Errorf("Expected operator, instead got string (\"%s\").", t.String()))
+				p.AddError(t, errors.CodeSyntax, fmt.Errorf("Expected operator, instead got string (\"%s\").", t.String()))
 				return nil
 			}
 			expectOperator = true
@@ -643,34 +889,45 @@ Loop:
 			//}
 			break Loop
 		case token.BraceOpen, token.BraceClose, token.Comma,
+			token.BracketClose, token.Colon,
 			token.EOF, token.Illegal:
 			// NOTE(Jake): We specifically don't call p.GetNextToken()
 			//			   so the calleee function can consume and use
 			//			   the token.
 			break Loop
-		case token.Number:
+		case token.Number, token.NumberWithUnit, token.Color:
 			p.GetNextToken()
 			if expectOperator {
-				p.AddError(t, fmt.Errorf("Expected operator, instead got number (\"%s\").", t.String()))
+				p.AddError(t, errors.CodeSyntax, fmt.Errorf("Expected operator, instead got number (\"%s\").", t.String()))
 				return nil
 			}
 			expectOperator = true
 			infixNodes = append(infixNodes, &ast.Token{Token: t})
 		case token.ParenOpen:
+			p.GetNextToken()
+			if expectOperator {
+				p.AddError(t, errors.CodeSyntax, fmt.Errorf("Expected operator, instead got (."))
+				return nil
+			}
 			parenOpenCount++
+			operatorNodes = append(operatorNodes, &ast.Token{Token: t})
 		case token.ParenClose:
 			// If hit end of parameter list
 			// ie. `div(prop=param1, prop2=param2)` or `functionCall(param1, param2)`
-			if parenCloseCount == 0 && parenOpenCount == 0 {
+			if parenCloseCount == parenOpenCount {
 				break Loop
 			}
-
+			p.GetNextToken()
 			parenCloseCount++
 
-			topOperatorNode := operatorNodes[len(operatorNodes)-1]
-			if topOperatorNode.Kind == token.ParenOpen {
-				infixNodes = append(infixNodes, topOperatorNode)
+			// Pop operators until we reach the matching (
+			for len(operatorNodes) > 0 {
+				topOperatorNode := operatorNodes[len(operatorNodes)-1]
 				operatorNodes = operatorNodes[:len(operatorNodes)-1]
+				if topOperatorNode.Kind == token.ParenOpen {
+					break
+				}
+				infixNodes = append(infixNodes, topOperatorNode)
 			}
 		// ie. :: css, :: html
 		case token.DoubleColon:
@@ -709,18 +966,22 @@ Loop:
 					}
 					continue
 				case token.BraceClose:
+					if i == 0 && len(expr.ChildNodes) == 0 {
+						// ie. []string{}
+						break ArrayLiteralLoop
+					}
 					childNodes = append(childNodes, expr)
 					break ArrayLiteralLoop
 				case token.EOF:
 					p.AddUnexpectedErrorWithContext(sep, "array literal")
 					return nil
 				}
-				p.AddError(sep, fmt.Errorf("Expected , or } after array item #%d, not %s.", i, sep.Kind.String()))
+				p.AddError(sep, errors.CodeSyntax, fmt.Errorf("Expected , or } after array item #%d, not %s.", i, sep.Kind.String()))
 				return nil
 			}
 
 			if len(childNodes) == 0 {
-				p.AddError(typeIdent.Name, fmt.Errorf("Cannot have array literal with zero elements."))
+				p.AddError(typeIdent.Name, errors.CodeEmptyArrayLiteral, fmt.Errorf("Cannot have array literal with zero elements."))
 			}
 
 			node := new(ast.ArrayLiteral)
@@ -732,7 +993,28 @@ Loop:
 		default:
 			if t.IsOperator() {
 				if !expectOperator {
-					p.AddError(t, fmt.Errorf("Expected identifiers or string, instead got operator \"%s\".", t.String()))
+					switch t.Kind {
+					case token.Not:
+						// Unary operator, ie. "!isBlue"
+						p.GetNextToken()
+						operatorNodes = append(operatorNodes, &ast.Token{Token: t})
+						continue
+					case token.Subtract:
+						// Negative number, ie. "-1"
+						p.GetNextToken()
+						if number := p.PeekNextToken(); number.Kind == token.Number {
+							p.GetNextToken()
+							number.Data = "-" + number.Data
+							expectOperator = true
+							infixNodes = append(infixNodes, &ast.Token{Token: number})
+							continue
+						}
+						// Unary operator, ie. "-width"
+						t.Kind = token.Negate
+						operatorNodes = append(operatorNodes, &ast.Token{Token: t})
+						continue
+					}
+					p.AddError(t, errors.CodeSyntax, fmt.Errorf("Expected identifiers or string, instead got operator \"%s\".", t.String()))
 					return nil
 				}
 				p.GetNextToken()
@@ -762,8 +1044,8 @@ Loop:
 	}
 
 	if parenOpenCount != parenCloseCount {
-		// todo(Jake): better error message
-		panic("Mismatching paren open and close count")
+		p.AddError(p.PeekNextToken(), errors.CodeSyntax, fmt.Errorf("Expected %d closing ) in expression.", parenOpenCount-parenCloseCount))
+		return nil
 	}
 
 	// DEBUG
@@ -774,7 +1056,10 @@ Loop:
 	return infixNodes
 }
 
-func (p *Parser) parseProcedureOrHTMLNode(name token.Token) *ast.Call {
+// parseProcedureOrHTMLNode parses the parameters after "name(" and the
+// optional block or if-statement after them. If disableBlock is set, a "{"
+// is left for the caller, ie. "if contains(title, "a") {"
+func (p *Parser) parseProcedureOrHTMLNode(name token.Token, disableBlock bool) *ast.Call {
 	hasDeterminedMode := false
 	isHTMLNode := false
 	parameters := make([]*ast.Parameter, 0, 10)
@@ -805,13 +1090,13 @@ func (p *Parser) parseProcedureOrHTMLNode(name token.Token) *ast.Call {
 			if name.Kind == token.Identifier &&
 				equalOp.Kind == token.Equal {
 				if hasDeterminedMode && !isHTMLNode {
-					p.AddError(name, fmt.Errorf("Cannot use named parameter after unnamed parameter, parameter #%d.", len(parameters)))
+					p.AddError(name, errors.CodeInvalidParameter, fmt.Errorf("Cannot use named parameter after unnamed parameter, parameter #%d.", len(parameters)))
 				}
 				isHTMLNode = true
 				hasDeterminedMode = true
 			} else {
 				if hasDeterminedMode && isHTMLNode {
-					p.AddError(name, fmt.Errorf("Cannot use unnamed parameter after named parameter, parameter #%d.", len(parameters)))
+					p.AddError(name, errors.CodeInvalidParameter, fmt.Errorf("Cannot use unnamed parameter after named parameter, parameter #%d.", len(parameters)))
 				}
 				hasDeterminedMode = true
 			}
@@ -823,7 +1108,7 @@ func (p *Parser) parseProcedureOrHTMLNode(name token.Token) *ast.Call {
 			p.eatNewlines()
 
 			if exprNodes == nil {
-				p.AddError(name, fmt.Errorf("Missing value for parameter #%d", len(parameters)))
+				p.AddError(name, errors.CodeInvalidParameter, fmt.Errorf("Missing value for parameter #%d", len(parameters)))
 				return nil
 			}
 			parameter := new(ast.Parameter)
@@ -849,7 +1134,7 @@ func (p *Parser) parseProcedureOrHTMLNode(name token.Token) *ast.Call {
 					p.GetNextToken()
 					break CallLoop
 				case token.Comma:
-					p.AddError(t, fmt.Errorf("Cannot have more than 1 trailing comma for procedure calls."))
+					p.AddError(t, errors.CodeInvalidParameter, fmt.Errorf("Cannot have more than 1 trailing comma for procedure calls."))
 					return nil
 				}
 			case token.ParenClose:
@@ -864,13 +1149,22 @@ func (p *Parser) parseProcedureOrHTMLNode(name token.Token) *ast.Call {
 
 	childStatements := make([]ast.Node, 0, 10)
 	ifExprNodes := make([]ast.Node, 0, 10)
+	var elseNodes []ast.Node
 
 	{
 		storeScannerState := p.ScannerState()
 		switch t := p.GetNextToken(); t.Kind {
-		case token.Newline:
-			// no-op
+		case token.Newline, token.Semicolon, token.Comma,
+			token.ParenClose, token.BraceClose, token.EOF,
+			token.BracketOpen, token.BracketClose, token.Colon:
+			// Leave the token for the caller so that calls within
+			// expressions end at the newline, ie. `a := rawhtml("b")`
+			p.SetScannerState(storeScannerState)
 		case token.BraceOpen:
+			if disableBlock {
+				p.SetScannerState(storeScannerState)
+				break
+			}
 			childStatements = p.parseStatements()
 			isHTMLNode = true
 		case token.KeywordIf:
@@ -880,6 +1174,11 @@ func (p *Parser) parseProcedureOrHTMLNode(name token.Token) *ast.Call {
 				return nil
 			}
 			childStatements = p.parseStatements()
+			var ok bool
+			elseNodes, ok = p.parseElse()
+			if !ok {
+				return nil
+			}
 			isHTMLNode = true
 		default:
 			if t.IsOperator() {
@@ -908,10 +1207,59 @@ func (p *Parser) parseProcedureOrHTMLNode(name token.Token) *ast.Call {
 	node.Parameters = parameters
 	node.ChildNodes = childStatements
 	node.IfExpression.ChildNodes = ifExprNodes
+	node.ElseNodes = elseNodes
 	p.validateHTMLNode(node)
 	return node
 }
 
+// parseIf parses an if-statement after the "if" keyword
+func (p *Parser) parseIf() *ast.If {
+	// NOTE(Jake): Disable struct literal in if-statement as
+	//			   the parser needs to understand '{' is the
+	//			   start of the if-block when testing boolean exxpressions
+	//
+	//			   ie. "if myBool {" vs "if myStructLit{val:3} {"
+	//
+	exprNodes := p.parseExpressionNodes(true)
+	if t := p.GetNextToken(); t.Kind != token.BraceOpen {
+		p.AddExpectError(t, token.BraceOpen)
+		return nil
+	}
+	node := new(ast.If)
+	node.Condition.ChildNodes = exprNodes
+	node.ChildNodes = p.parseStatements()
+	elseNodes, ok := p.parseElse()
+	if !ok {
+		return nil
+	}
+	node.ElseNodes = elseNodes
+	return node
+}
+
+// parseElse parses the optional "else {" or "else if expr {" after an if-block.
+func (p *Parser) parseElse() ([]ast.Node, bool) {
+	storeScannerState := p.ScannerState()
+	p.eatNewlines()
+	if t := p.PeekNextToken(); t.Kind != token.KeywordElse {
+		p.SetScannerState(storeScannerState)
+		return nil, true
+	}
+	p.GetNextToken()
+	switch t := p.GetNextToken(); t.Kind {
+	case token.KeywordIf:
+		node := p.parseIf()
+		if node == nil {
+			return nil, false
+		}
+		return []ast.Node{node}, true
+	case token.BraceOpen:
+		return p.parseStatements(), true
+	default:
+		p.AddExpectError(t, token.BraceOpen, token.KeywordIf)
+		return nil, false
+	}
+}
+
 func (p *Parser) parseProcedureDefinition(name token.Token) *ast.ProcedureDefinition {
 	var parameters []ast.Parameter
 	if hasNoParameters := p.PeekNextToken().Kind == token.ParenClose; hasNoParameters {
@@ -1023,7 +1371,7 @@ func (p *Parser) parseDefinition(name token.Token) ast.Node {
 					field.Expression = node.Expression
 					fields = append(fields, field)
 				default:
-					p.AddError(name, fmt.Errorf("Expected statement, instead got %T.", itNode))
+					p.AddError(name, errors.CodeSyntax, fmt.Errorf("Expected statement, instead got %T.", itNode))
 					return nil
 				}
 			}
@@ -1053,7 +1401,7 @@ func (p *Parser) parseDefinition(name token.Token) ast.Node {
 					nameString = name.String() + " "
 				}
 				if htmlNodeCount == 0 {
-					p.AddError(name, fmt.Errorf("\"%s:: html\" must contain one HTML node at the top-level.", nameString))
+					p.AddError(name, errors.CodeInvalidDefinition, fmt.Errorf("\"%s:: html\" must contain one HTML node at the top-level.", nameString))
 				}
 				// NOTE: No longer applicable.
 				//if htmlNodeCount > 1 {
@@ -1070,14 +1418,14 @@ func (p *Parser) parseDefinition(name token.Token) ast.Node {
 					switch node := itNode.(type) {
 					case *ast.StructDefinition:
 						if structDef != nil {
-							p.AddError(node.Name, fmt.Errorf("Cannot declare \":: struct\" twice in the same HTML component."))
-							p.AddError(structDef.Name, fmt.Errorf("Cannot declare \":: struct\" twice in the same HTML component."))
+							p.AddError(node.Name, errors.CodeInvalidDefinition, fmt.Errorf("Cannot declare \":: struct\" twice in the same HTML component.")).AddRelated(structDef.Name, "previously declared here")
+							p.AddError(structDef.Name, errors.CodeInvalidDefinition, fmt.Errorf("Cannot declare \":: struct\" twice in the same HTML component.")).AddRelated(node.Name, "redeclared here")
 							break RetrievePropertyDefinitionLoop
 						}
 						structDef = node
 					case *ast.CSSDefinition:
 						if cssDef != nil {
-							p.AddError(node.Name, fmt.Errorf("Cannot declare \":: css\" twice in the same HTML component."))
+							p.AddError(node.Name, errors.CodeInvalidDefinition, fmt.Errorf("Cannot declare \":: css\" twice in the same HTML component.")).AddRelated(cssDef.Name, "previously declared here")
 							break RetrievePropertyDefinitionLoop
 						}
 						cssDef = node
@@ -1101,7 +1449,7 @@ func (p *Parser) parseDefinition(name token.Token) ast.Node {
 			return node
 		}
 	}
-	p.AddError(keywordToken, fmt.Errorf("Unexpected keyword '%s' for definition (::) type. Expected 'css', 'html', 'struct', 'workspace' or () on Line %d", keyword, keywordToken.Line))
+	p.AddError(keywordToken, errors.CodeInvalidDefinition, fmt.Errorf("Unexpected keyword '%s' for definition (::) type. Expected 'css', 'html', 'struct', 'workspace' or () on Line %d", keyword, keywordToken.Line))
 	return nil
 }
 
@@ -1132,11 +1480,12 @@ func (p *Parser) parseCSSConfigRuleDefinition(name token.Token) *ast.CSSConfigDe
 						}
 						configRule.Modify = value
 					default:
-						p.AddError(node.Name, fmt.Errorf("Invalid config key \"%s\". Expected \"modify\".", name))
+						p.AddError(node.Name, errors.CodeInvalidCSSConfig, fmt.Errorf("Invalid config key \"%s\". Expected \"modify\".", name))
 						return nil
 					}
 				case *ast.DeclareStatement:
-					p.AddError(node.Name, fmt.Errorf("Cannot declare variables in a css_config block. Did you mean to use : instead of :="))
+					p.AddError(node.Name, errors.CodeInvalidCSSConfig, fmt.Errorf("Cannot declare variables in a css_config block.")).
+						AddSuggestion("Did you mean to use : instead of :=")
 					return nil
 				default:
 					panic(fmt.Sprintf("parseCSSConfigRuleDefinition:propertyLoop: Unknown type %T", node))
@@ -1155,13 +1504,13 @@ func (p *Parser) parseCSSConfigRuleDefinition(name token.Token) *ast.CSSConfigDe
 							case token.Multiply:
 								rulePartList = append(rulePartList, operator)
 							default:
-								p.AddError(selectorPartNode.Token, fmt.Errorf("Only supports * wildcard, not %s", operator))
+								p.AddError(selectorPartNode.Token, errors.CodeInvalidCSSConfig, fmt.Errorf("Only supports * wildcard, not %s", operator))
 								return nil
 							}
 							continue
 						}
 						if selectorPartNode.Kind != token.Identifier {
-							p.AddError(selectorPartNode.Token, fmt.Errorf("Expected identifier, instead got %s", selectorPartNode.Kind.String()))
+							p.AddError(selectorPartNode.Token, errors.CodeInvalidCSSConfig, fmt.Errorf("Expected identifier, instead got %s", selectorPartNode.Kind.String()))
 							return nil
 						}
 						name := selectorPartNode.String()
@@ -1184,7 +1533,7 @@ func (p *Parser) parseCSSConfigRuleDefinition(name token.Token) *ast.CSSConfigDe
 
 			cssConfigDefinition.Rules = append(cssConfigDefinition.Rules, configRule)
 		case *ast.DeclareStatement:
-			p.AddError(node.Name, fmt.Errorf("Cannot declare variables in a css_config block."))
+			p.AddError(node.Name, errors.CodeInvalidCSSConfig, fmt.Errorf("Cannot declare variables in a css_config block."))
 			return nil
 		default:
 			panic(fmt.Sprintf("parseCSSConfigRuleDefinition: Unknown type %T", node))
@@ -1201,7 +1550,7 @@ func (p *Parser) parseCSSConfigRuleDefinition(name token.Token) *ast.CSSConfigDe
 
 func (p *Parser) getBoolFromCSSConfigProperty(node *ast.CSSProperty) (bool, bool) {
 	if len(node.ChildNodes) == 0 && len(node.ChildNodes) > 1 {
-		p.AddError(node.Name, fmt.Errorf("Expected \"true\" or \"false\" after \"%s\".", node.Name.String()))
+		p.AddError(node.Name, errors.CodeInvalidCSSConfig, fmt.Errorf("Expected \"true\" or \"false\" after \"%s\".", node.Name.String()))
 		return false, false
 	}
 	itNode := node.ChildNodes[0]
@@ -1209,7 +1558,7 @@ func (p *Parser) getBoolFromCSSConfigProperty(node *ast.CSSProperty) (bool, bool
 	case *ast.Token:
 		t := node.Token
 		if t.Kind != token.KeywordTrue && t.Kind != token.KeywordFalse {
-			p.AddError(t, fmt.Errorf("Expected \"true\" or \"false\" after \"%s\".", t.String()))
+			p.AddError(t, errors.CodeInvalidCSSConfig, fmt.Errorf("Expected \"true\" or \"false\" after \"%s\".", t.String()))
 			return false, false
 		}
 		valueString := node.String()
@@ -1224,7 +1573,7 @@ func (p *Parser) getBoolFromCSSConfigProperty(node *ast.CSSProperty) (bool, bool
 			ok = true
 		}
 		if !ok {
-			p.AddError(t, fmt.Errorf("Expected \"true\" or \"false\" after \"%s\".", t.String()))
+			p.AddError(t, errors.CodeInvalidCSSConfig, fmt.Errorf("Expected \"true\" or \"false\" after \"%s\".", t.String()))
 			return false, false
 		}
 		return value, ok
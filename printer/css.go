@@ -16,6 +16,11 @@ func PrettyCSS(node *data.CSSDefinition) string {
 }
 
 func (gen *Generator) WriteCSSRuleNode(node *data.CSSRule) {
+	gen.writeCSSRule(node)
+	gen.WriteLine()
+}
+
+func (gen *Generator) writeCSSRule(node *data.CSSRule) {
 	selectors := node.Selectors()
 	if len(selectors) == 0 {
 		panic("getCSSRuleNode(): CSSRule with no selectors???")
@@ -44,6 +49,8 @@ func (gen *Generator) WriteCSSRuleNode(node *data.CSSRule) {
 					gen.WriteByte('"')
 				}
 				gen.WriteByte(']')
+			case data.SelectorPartKindParenthesis:
+				gen.WriteString(node.String())
 			// todo(Jake): Fix this, this is used for paren'd component values. ie ([controls])
 			/*case data.CSSSelector:
 			if i != 0 && lastSelectorWasOperator == false {
@@ -66,28 +73,22 @@ func (gen *Generator) WriteCSSRuleNode(node *data.CSSRule) {
 	gen.WriteByte(' ')
 	gen.WriteByte('{')
 	gen.indent++
-	gen.WriteLine()
 
 	// Print properties
-	for i, property := range node.Properties() {
-		if i != 0 {
-			gen.WriteLine()
-		}
+	for _, property := range node.Properties() {
+		gen.WriteLine()
 		gen.WriteString(property.String())
 	}
 
-	// Print nested rules
-	for i, rule := range node.Rules() {
-		if i != 0 {
-			gen.WriteLine()
-		}
-		gen.WriteCSSRuleNode(rule)
+	// Print nested rules, ie. "@media print { .a {} }"
+	for _, rule := range node.Rules() {
+		gen.WriteLine()
+		gen.writeCSSRule(rule)
 	}
 
 	gen.indent--
 	gen.WriteLine()
 	gen.WriteByte('}')
-	gen.WriteLine()
 }
 
 /*func (gen *Generator) getHTMLNode(node *data.HTMLNode) {
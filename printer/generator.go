@@ -5,9 +5,13 @@ import "bytes"
 type Generator struct {
 	bytes.Buffer
 	indent int
+	minify bool
 }
 
 func (gen *Generator) WriteLine() {
+	if gen.minify {
+		return
+	}
 	gen.WriteByte('\n')
 	for i := 0; i < gen.indent; i++ {
 		gen.WriteString("    ")
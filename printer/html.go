@@ -1,104 +1,171 @@
 package printer
 
-/*func PrettyHTML(nodes []data.Type) string {
-	gen := new(Generator)
+import (
+	"fmt"
+	"strings"
 
-	for _, itNode := range nodes {
-		switch childNode := itNode.(type) {
-		case *data.HTMLNode:
-			gen.WriteHTMLNode(childNode)
-		case *data.HTMLText:
-			gen.WriteString(childNode.String())
-		case *data.HTMLComponentNode:
-			gen.WriteHTMLComponentNode(childNode)
-		default:
-			panic(fmt.Sprintf("PrettyHTML(): Unhandled type: %T", itNode))
-		}
-	}
+	"github.com/silbinarywolf/compiler-fel/data"
+	"github.com/silbinarywolf/compiler-fel/util"
+)
 
-	gen.WriteByte('\n')
+// Escaping rules follow the HTML5 fragment serialization algorithm.
+// https://html.spec.whatwg.org/multipage/parsing.html#serialising-html-fragments
+//
+var (
+	htmlTextEscaper = strings.NewReplacer(
+		"&", "&amp;",
+		"\u00a0", "&nbsp;",
+		"<", "&lt;",
+		">", "&gt;",
+	)
+	htmlAttributeEscaper = strings.NewReplacer(
+		"&", "&amp;",
+		"\u00a0", "&nbsp;",
+		"\"", "&quot;",
+	)
+)
 
+// PrettyHTML serializes the node with each block element on its own line.
+// Whitespace is significant between text and inline elements, and inside
+// <pre> and <textarea>, so runs of inline content are kept on one line.
+func PrettyHTML(node *data.HTMLElement) string {
+	gen := new(Generator)
+	gen.WriteHTMLDocument(node)
+	gen.WriteByte('\n')
 	return gen.String()
 }
 
-func PrettyHTMLComponentNode(node *data.HTMLComponentNode) string {
-	return PrettyHTML([]data.Type{node})
+// MinifyHTML serializes the node without any insignificant whitespace.
+func MinifyHTML(node *data.HTMLElement) string {
+	gen := new(Generator)
+	gen.minify = true
+	gen.WriteHTMLDocument(node)
+	return gen.String()
 }
 
-func (gen *Generator) WriteHTMLComponentNode(node *data.HTMLComponentNode) {
-	componentName := node.Name
-	gen.WriteString(fmt.Sprintf("<!-- FEL Begin: %s -->", componentName))
-	gen.WriteLine()
-
-	childNodes := node.Nodes()
-	for i, itNode := range childNodes {
+// WriteHTMLDocument writes the node and prefixes the output with a
+// doctype if the top-level element is <html>.
+func (gen *Generator) WriteHTMLDocument(node *data.HTMLElement) {
+	nodes := flattenHTMLFragments(nil, node)
+	if len(nodes) > 0 &&
+		nodes[0].Kind() == data.HTMLKindElement &&
+		nodes[0].Name() == "html" {
+		gen.WriteString("<!DOCTYPE html>")
+		gen.WriteLine()
+	}
+	for i, node := range nodes {
 		if i > 0 {
 			gen.WriteLine()
 		}
-		switch childNode := itNode.(type) {
-		case *data.HTMLNode:
+		gen.WriteHTMLNode(node)
+	}
+}
+
+func (gen *Generator) WriteHTMLNode(node *data.HTMLElement) {
+	switch node.Kind() {
+	case data.HTMLKindElement:
+		gen.writeHTMLElement(node)
+	case data.HTMLKindText:
+		gen.WriteString(htmlTextEscaper.Replace(node.Text()))
+	case data.HTMLKindRaw:
+		gen.WriteString(node.Text())
+	case data.HTMLKindFragment:
+		for i, childNode := range flattenHTMLFragments(nil, node) {
+			if i > 0 {
+				gen.WriteLine()
+			}
 			gen.WriteHTMLNode(childNode)
-		case *data.HTMLText:
-			gen.WriteString(childNode.String())
-		case *data.HTMLComponentNode:
-			gen.WriteHTMLComponentNode(childNode)
-		default:
-			panic(fmt.Sprintf("WriteHTMLComponentNode(): Unhandled type: %T", itNode))
 		}
+	default:
+		panic(fmt.Sprintf("WriteHTMLNode(): Unhandled kind: %v", node.Kind()))
 	}
-
-	gen.WriteLine()
-	gen.WriteString(fmt.Sprintf("<!-- FEL End: %s -->", componentName))
 }
 
-func (gen *Generator) WriteHTMLNode(node *data.HTMLNode) {
-	isSelfClosing := util.IsSelfClosingTagName(node.Name)
-	childNodes := node.Nodes()
-
+func (gen *Generator) writeHTMLElement(node *data.HTMLElement) {
+	name := node.Name()
 	gen.WriteByte('<')
-	gen.WriteString(node.Name)
-	for _, attribute := range node.Attributes {
+	gen.WriteString(name)
+	for _, attribute := range node.GetAttributes() {
 		gen.WriteByte(' ')
 		gen.WriteString(attribute.Name)
+		if attribute.Boolean {
+			continue
+		}
 		gen.WriteString("=\"")
-		gen.WriteString(attribute.Value)
+		gen.WriteString(htmlAttributeEscaper.Replace(attribute.Value))
 		gen.WriteByte('"')
 	}
-	if isSelfClosing {
-		gen.WriteByte('/')
-	}
 	gen.WriteByte('>')
-
-	if !isSelfClosing && len(childNodes) > 0 {
-		gen.indent++
+	if util.IsSelfClosingTagName(name) {
+		// Void elements cannot have children or an end tag.
+		return
 	}
 
-	if len(childNodes) == 0 && !isSelfClosing {
-		gen.WriteLine()
-	} else {
-		for _, itNode := range childNodes {
-			gen.WriteLine()
-			switch childNode := itNode.(type) {
-			case *data.HTMLNode:
+	childNodes := make([]*data.HTMLElement, 0, len(node.ChildNodes()))
+	for _, childNode := range node.ChildNodes() {
+		childNodes = flattenHTMLFragments(childNodes, childNode)
+	}
+	if len(childNodes) > 0 {
+		minify := gen.minify
+		if name == "pre" || name == "textarea" || !hasBlockNode(childNodes) {
+			gen.minify = true
+		}
+		isRawText := util.IsRawTextTagName(name)
+		gen.indent++
+		for i, childNode := range childNodes {
+			// Runs of text and inline elements are kept on one line
+			isInline := isInlineNode(childNode)
+			if i == 0 || !isInline || !isInlineNode(childNodes[i-1]) {
+				gen.WriteLine()
+			}
+			if isRawText && childNode.Kind() == data.HTMLKindText {
+				gen.WriteString(childNode.Text())
+				continue
+			}
+			if !isInline {
 				gen.WriteHTMLNode(childNode)
-			case *data.HTMLText:
-				gen.WriteString(childNode.String())
-			case *data.HTMLComponentNode:
-				gen.WriteHTMLComponentNode(childNode)
-			default:
-				panic(fmt.Sprintf("getHTMLNode(): Unhandled type: %T", itNode))
+				continue
 			}
+			runMinify := gen.minify
+			gen.minify = true
+			gen.WriteHTMLNode(childNode)
+			gen.minify = runMinify
 		}
+		gen.indent--
+		gen.WriteLine()
+		gen.minify = minify
 	}
+	gen.WriteString("</")
+	gen.WriteString(name)
+	gen.WriteByte('>')
+}
 
-	if !isSelfClosing {
-		if len(childNodes) > 0 {
-			gen.indent--
-			gen.WriteLine()
+// isInlineNode is true for text and inline elements, as whitespace
+// between them changes how they're rendered.
+func isInlineNode(node *data.HTMLElement) bool {
+	return node.Kind() != data.HTMLKindElement ||
+		util.IsInlineTagName(node.Name())
+}
+
+// hasBlockNode is true if any of the nodes can be put on their own
+// line without changing how the element is rendered.
+func hasBlockNode(nodes []*data.HTMLElement) bool {
+	for _, node := range nodes {
+		if !isInlineNode(node) {
+			return true
 		}
-		gen.WriteString("</")
-		gen.WriteString(node.Name)
-		gen.WriteByte('>')
 	}
+	return false
+}
+
+// flattenHTMLFragments appends the node to nodes, replacing fragments
+// (ie. component return values and "children") with their child nodes.
+func flattenHTMLFragments(nodes []*data.HTMLElement, node *data.HTMLElement) []*data.HTMLElement {
+	if node.Kind() != data.HTMLKindFragment {
+		return append(nodes, node)
+	}
+	for _, childNode := range node.ChildNodes() {
+		nodes = flattenHTMLFragments(nodes, childNode)
+	}
+	return nodes
 }
-*/
@@ -0,0 +1,109 @@
+package printer
+
+import (
+	"testing"
+
+	"github.com/silbinarywolf/compiler-fel/data"
+)
+
+func TestHTMLDoctypeAndVoidElements(t *testing.T) {
+	fragment := data.NewHTMLFragment()
+	html := data.NewHTMLElement("html")
+	html.SetParent(fragment)
+	head := data.NewHTMLElement("head")
+	head.SetParent(html)
+	meta := data.NewHTMLElement("meta")
+	meta.SetAttribute("charset", "utf-8")
+	meta.SetParent(head)
+
+	expected := `<!DOCTYPE html><html><head><meta charset="utf-8"></head></html>`
+	if result := MinifyHTML(fragment); result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+	expected = `<!DOCTYPE html>
+<html>
+    <head>
+        <meta charset="utf-8">
+    </head>
+</html>
+`
+	if result := PrettyHTML(fragment); result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestHTMLEscaping(t *testing.T) {
+	div := data.NewHTMLElement("div")
+	div.SetAttribute("title", `"Tom" & <Jerry>`)
+	data.NewHTMLText("Tom & <Jerry>").SetParent(div)
+	script := data.NewHTMLElement("script")
+	script.SetParent(div)
+	data.NewHTMLText("if (a < b && c) {}").SetParent(script)
+	data.NewHTMLRaw("<b>Bold</b>").SetParent(div)
+
+	expected := `<div title="&quot;Tom&quot; &amp; <Jerry>">Tom &amp; &lt;Jerry&gt;<script>if (a < b && c) {}</script><b>Bold</b></div>`
+	if result := MinifyHTML(div); result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestPrettyHTMLWhitespace(t *testing.T) {
+	div := data.NewHTMLElement("div")
+	p := data.NewHTMLElement("p")
+	p.SetParent(div)
+	data.NewHTMLText("Hello ").SetParent(p)
+	b := data.NewHTMLElement("b")
+	b.SetParent(p)
+	data.NewHTMLText("world").SetParent(b)
+	pre := data.NewHTMLElement("pre")
+	pre.SetParent(div)
+	code := data.NewHTMLElement("code")
+	code.SetParent(pre)
+	data.NewHTMLText("a := 1\n  b := 2").SetParent(code)
+	nav := data.NewHTMLElement("nav")
+	nav.SetParent(div)
+	for _, label := range []string{"One", "Two"} {
+		a := data.NewHTMLElement("a")
+		a.SetParent(nav)
+		data.NewHTMLText(label).SetParent(a)
+	}
+
+	expected := `<div>
+    <p>Hello <b>world</b></p>
+    <pre><code>a := 1
+  b := 2</code></pre>
+    <nav><a>One</a><a>Two</a></nav>
+</div>
+`
+	if result := PrettyHTML(div); result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestPrettyHTMLMixedContent(t *testing.T) {
+	div := data.NewHTMLElement("div")
+	h1 := data.NewHTMLElement("h1")
+	h1.SetParent(div)
+	data.NewHTMLText("Title").SetParent(h1)
+	ul := data.NewHTMLElement("ul")
+	ul.SetParent(div)
+	li := data.NewHTMLElement("li")
+	li.SetParent(ul)
+	data.NewHTMLText("Item").SetParent(li)
+	data.NewHTMLText("Hello ").SetParent(div)
+	span := data.NewHTMLElement("span")
+	span.SetParent(div)
+	data.NewHTMLText("world").SetParent(span)
+
+	expected := `<div>
+    <h1>Title</h1>
+    <ul>
+        <li>Item</li>
+    </ul>
+    Hello <span>world</span>
+</div>
+`
+	if result := PrettyHTML(div); result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
@@ -277,6 +277,41 @@ func (scanner *Scanner) scanIdentifier() {
 	}
 }
 
+// scanNumber scans the rest of a number and its unit if it has one,
+// ie. "30", "1.462", "100%", "32px"
+func (scanner *Scanner) scanNumber() token.Kind {
+	for {
+		lastIndex := scanner.index
+		C := scanner.nextRune()
+		if isNumber(C) || C == '.' {
+			continue
+		}
+		scanner.index = lastIndex
+		break
+	}
+
+	// Handle %, rem, px
+	lastIndex := scanner.index
+	if C := scanner.nextRune(); !isAlpha(C) && C != '%' {
+		scanner.index = lastIndex
+		return token.Number
+	}
+	for {
+		lastIndex := scanner.index
+		C := scanner.nextRune()
+		if isAlpha(C) || C == '%' {
+			continue
+		}
+		scanner.index = lastIndex
+		break
+	}
+	return token.NumberWithUnit
+}
+
+func isHex(C rune) bool {
+	return isNumber(C) || (C >= 'a' && C <= 'f') || (C >= 'A' && C <= 'F')
+}
+
 func (scanner *Scanner) _getNextToken() token.Token {
 	t := token.Token{}
 	t.Kind = token.Unknown
@@ -315,6 +350,12 @@ func (scanner *Scanner) _getNextToken() token.Token {
 		t.Kind = token.BraceClose
 	case '%':
 		t.Kind = token.Modulo
+		switch lastIndex := scanner.index; scanner.nextRune() {
+		case '=':
+			t.Kind = token.ModuloEqual
+		default:
+			scanner.index = lastIndex
+		}
 	case ',':
 		t.Kind = token.Comma
 	case ';':
@@ -401,13 +442,73 @@ func (scanner *Scanner) _getNextToken() token.Token {
 		default:
 			scanner.index = lastIndex
 		}
-	// todo(Jake): Handle subtract again (identifiers have '-' so needs extra work)
-	//case '-':
-	///./.;'>">">'	t.Kind = token.Subtract
+	case '-':
+		// Identifiers can contain and start with '-', so only treat
+		// it as subtract if it's followed by whitespace or a number.
+		// In CSS, a number means it's negative, ie. "-10px"
+		lastIndex := scanner.index
+		nextC := scanner.nextRune()
+		scanner.index = lastIndex
+		switch {
+		case isWhitespace(nextC) || isEndOfLine(nextC) || nextC == '(':
+			t.Kind = token.Subtract
+		case nextC == '=' && scanner.scanmode != ModeCSS:
+			scanner.nextRune()
+			t.Kind = token.SubtractEqual
+		case isAlpha(nextC) && scanner.scanmode != ModeCSS:
+			// Outside of CSS, ie. "-width" is negating a variable
+			t.Kind = token.Subtract
+		case isNumber(nextC) || nextC == '.':
+			if scanner.scanmode == ModeCSS {
+				t.Kind = scanner.scanNumber()
+				break
+			}
+			t.Kind = token.Subtract
+		default:
+			t.Kind = token.Identifier
+			scanner.scanIdentifier()
+		}
+	case '#':
+		if scanner.scanmode == ModeCSS {
+			// ID selector, ie. "#main"
+			t.Kind = token.Identifier
+			scanner.scanIdentifier()
+			break
+		}
+		t.Kind = token.Hash
+		if lastIndex := scanner.index; isHex(scanner.nextRune()) {
+			// Color, ie. "#fff", "#3366ff"
+			t.Kind = token.Color
+			for {
+				lastIndex := scanner.index
+				if !isHex(scanner.nextRune()) {
+					scanner.index = lastIndex
+					break
+				}
+			}
+		} else {
+			scanner.index = lastIndex
+		}
 	case '/':
 		t.Kind = token.Divide
+		if scanner.scanmode != ModeCSS {
+			switch lastIndex := scanner.index; scanner.nextRune() {
+			case '=':
+				t.Kind = token.DivideEqual
+			default:
+				scanner.index = lastIndex
+			}
+		}
 	case '*':
 		t.Kind = token.Multiply
+		if scanner.scanmode != ModeCSS {
+			switch lastIndex := scanner.index; scanner.nextRune() {
+			case '=':
+				t.Kind = token.MultiplyEqual
+			default:
+				scanner.index = lastIndex
+			}
+		}
 	case '!':
 		t.Kind = token.Not
 		switch lastIndex := scanner.index; scanner.nextRune() {
@@ -426,8 +527,22 @@ func (scanner *Scanner) _getNextToken() token.Token {
 		}
 	case '>':
 		t.Kind = token.GreaterThan
+		if scanner.scanmode != ModeCSS {
+			switch lastIndex := scanner.index; scanner.nextRune() {
+			case '=':
+				t.Kind = token.GreaterThanOrEqual
+			default:
+				scanner.index = lastIndex
+			}
+		}
 	case '<':
 		t.Kind = token.LessThan
+		switch lastIndex := scanner.index; scanner.nextRune() {
+		case '=':
+			t.Kind = token.LessThanOrEqual
+		default:
+			scanner.index = lastIndex
+		}
 	case '?':
 		t.Kind = token.Ternary
 	case '&':
@@ -494,36 +609,7 @@ func (scanner *Scanner) _getNextToken() token.Token {
 			if C == '.' && nextIsNotNumber {
 				t.Kind = token.Dot
 			} else {
-				// Regular number
-				t.Kind = token.Number
-				for {
-					lastIndex := scanner.index
-					C := scanner.nextRune()
-					if isNumber(C) || C == '.' {
-						continue
-					}
-					scanner.index = lastIndex
-					break
-				}
-
-				// Handle %, rem, px, additional non-whitespace text in CSS
-				if scanner.scanmode == ModeCSS {
-					lastIndex := scanner.index
-					C := scanner.nextRune()
-					if !isWhitespace(C) && !isEndOfLine(C) && (isAlpha(C) || C == '%') {
-						for {
-							lastIndex := scanner.index
-							C := scanner.nextRune()
-							if !isWhitespace(C) && !isEndOfLine(C) && (isAlpha(C) || C == '%') {
-								continue
-							}
-							scanner.index = lastIndex
-							break
-						}
-					} else {
-						scanner.index = lastIndex
-					}
-				}
+				t.Kind = scanner.scanNumber()
 			}
 		} else {
 			panic(fmt.Sprintf("Unknown token type found in getToken(): %q (%v), at Line %d (%s)", C, C, scanner.lineNumber, scanner.Filepath))
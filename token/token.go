@@ -31,6 +31,7 @@ const (
 	InteropVariable // $var
 	Number          // 30, 1.462
 	NumberWithUnit  // 100%, 32px, 5.5em
+	Color           // #fff, #3366ff
 	Character       // 'C'
 	String          // "ABunchOfQuotedLetters"
 
@@ -43,6 +44,7 @@ const (
 	KeywordFor
 	KeywordTrue
 	KeywordFalse
+	KeywordNil
 	//KeywordConfig
 	//KeywordHTML
 
@@ -54,9 +56,14 @@ const (
 	Add                 // +
 	AddEqual            // +=
 	Subtract            // -
+	SubtractEqual       // -=
 	Divide              // /
+	DivideEqual         // /=
 	Multiply            // *
+	MultiplyEqual       // *=
 	Modulo              // %
+	ModuloEqual         // %=
+	Negate              // -, unary ie. "-width"
 	Ternary             // ?
 	Equal               // =
 	Power               // ^
@@ -70,6 +77,8 @@ const (
 	ConditionalOr       // ||
 	GreaterThan         // >
 	LessThan            // <
+	GreaterThanOrEqual  // >=
+	LessThanOrEqual     // <=
 	operator_end
 )
 
@@ -78,13 +87,20 @@ var precedence = []int{
 	ParenOpen:           1,
 	ParenClose:          1,
 	ConditionalOr:       2,
-	ConditionalAnd:      2,
-	ConditionalEqual:    3,
-	ConditionalNotEqual: 3, // NOTE(Jake): Didn't check this against other langs
-	Add:                 4,
-	Subtract:            4,
-	Divide:              4,
-	Multiply:            4,
+	ConditionalAnd:      3,
+	ConditionalEqual:    4,
+	ConditionalNotEqual: 4,
+	GreaterThan:         5,
+	LessThan:            5,
+	GreaterThanOrEqual:  5,
+	LessThanOrEqual:     5,
+	Add:                 6,
+	Subtract:            6,
+	Divide:              7,
+	Multiply:            7,
+	Modulo:              7,
+	Not:                 8, // unary, ie. "!isBlue"
+	Negate:              8, // unary, ie. "-width"
 }
 
 var kindToString = []string{
@@ -100,6 +116,7 @@ var kindToString = []string{
 
 	Number:         "number",
 	NumberWithUnit: "number with unit",
+	Color:          "color",
 	ParenOpen:      "(",
 	ParenClose:     ")",
 	BraceOpen:      "{",
@@ -125,6 +142,7 @@ var kindToString = []string{
 	KeywordFor:   "for",
 	KeywordTrue:  "true",
 	KeywordFalse: "false",
+	KeywordNil:   "nil",
 	//KeywordConfig: "config",
 	//KeywordHTML:   "html",
 
@@ -133,9 +151,14 @@ var kindToString = []string{
 	Add:                 "+",
 	AddEqual:            "+=",
 	Subtract:            "-",
+	SubtractEqual:       "-=",
 	Divide:              "/",
+	DivideEqual:         "/=",
 	Multiply:            "*",
+	MultiplyEqual:       "*=",
 	Modulo:              "%",
+	ModuloEqual:         "%=",
+	Negate:              "-",
 	Equal:               "=",
 	Power:               "^",
 	PowerEqual:          "^=",
@@ -147,6 +170,8 @@ var kindToString = []string{
 	ConditionalOr:       "||",
 	GreaterThan:         ">",
 	LessThan:            "<",
+	GreaterThanOrEqual:  ">=",
+	LessThanOrEqual:     "<=",
 }
 
 type Token struct {
@@ -180,6 +205,24 @@ func (token Token) HasUniqueData() bool {
 	return token.Kind > unique_begin && token.Kind < unique_end
 }
 
+// AssignOperator gets the operator an assignment applies,
+// ie. Add for "+=". Unknown if it's not an assignment operator.
+func (kind Kind) AssignOperator() Kind {
+	switch kind {
+	case AddEqual:
+		return Add
+	case SubtractEqual:
+		return Subtract
+	case MultiplyEqual:
+		return Multiply
+	case DivideEqual:
+		return Divide
+	case ModuloEqual:
+		return Modulo
+	}
+	return Unknown
+}
+
 func (kind Kind) String() string {
 	return kindToString[kind]
 }
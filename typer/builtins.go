@@ -0,0 +1,123 @@
+package typer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/silbinarywolf/compiler-fel/ast"
+	"github.com/silbinarywolf/compiler-fel/builtins"
+	"github.com/silbinarywolf/compiler-fel/errors"
+	"github.com/silbinarywolf/compiler-fel/token"
+	"github.com/silbinarywolf/compiler-fel/types"
+)
+
+// isBuiltin is true if the name is a procedure in the builtins package,
+// ie. upper(title)
+func (p *Typer) isBuiltin(name string) bool {
+	if _, ok := builtins.Lookup(name); !ok {
+		return false
+	}
+	// User-defined procedures take precedence
+	return p.typeinfo.getByName(name) == nil
+}
+
+func (p *Typer) typerBuiltinCall(scope *Scope, node *ast.Call) {
+	name := node.Name.String()
+	procedure, _ := builtins.Lookup(name)
+
+	parameters := node.Parameters
+	for _, parameter := range parameters {
+		p.typerExpression(scope, &parameter.Expression)
+	}
+	if len(parameters) > 0 && parameters[0].Name.Kind != token.Unknown {
+		p.AddError(parameters[0].Name, errors.CodeInvalidParameter, fmt.Errorf("Cannot use named parameters with built-in procedure \"%s()\".", name))
+		return
+	}
+
+	// Type parameters, ie. "T" in "first(items []T) T", are decided by
+	// the first value they match.
+	typeParameters := make(map[string]types.TypeInfo)
+	hasMismatchingTypes := len(parameters) != len(procedure.Parameters)
+	for i, parameter := range parameters {
+		if hasMismatchingTypes || parameter.TypeInfo == nil {
+			continue
+		}
+		if !p.matchBuiltinType(procedure.Parameters[i].Type, parameter.TypeInfo, typeParameters) {
+			hasMismatchingTypes = true
+		}
+	}
+	if hasMismatchingTypes {
+		have := make([]string, 0, len(parameters))
+		for _, parameter := range parameters {
+			if parameter.TypeInfo == nil {
+				have = append(have, "missing")
+				continue
+			}
+			have = append(have, parameter.TypeInfo.String())
+		}
+		want := make([]string, 0, len(procedure.Parameters))
+		for _, parameter := range procedure.Parameters {
+			want = append(want, parameter.Type)
+		}
+		code := errors.CodeTypeMismatch
+		if len(parameters) != len(procedure.Parameters) {
+			code = errors.CodeArgumentCount
+		}
+		p.AddError(node.Name, code, fmt.Errorf("Mismatching types for %s(), expected (%s) but got (%s).", name, strings.Join(want, ", "), strings.Join(have, ", ")))
+	}
+	node.Builtin = p.resolveBuiltinType(procedure.Returns, typeParameters)
+}
+
+// matchBuiltinType checks if a type matches a type name from the builtins
+// package, ie. "[]T" matches "[]string"
+func (p *Typer) matchBuiltinType(typeName string, typeInfo types.TypeInfo, typeParameters map[string]types.TypeInfo) bool {
+	if strings.HasPrefix(typeName, "[]") {
+		array, ok := typeInfo.(*types.Array)
+		if !ok {
+			return false
+		}
+		return p.matchBuiltinType(typeName[2:], array.Underlying(), typeParameters)
+	}
+	if !builtins.IsTypeParameter(typeName) {
+		return TypeEquals(p.typeinfo.getByName(typeName), typeInfo)
+	}
+	if existing, ok := typeParameters[typeName]; ok {
+		return TypeEquals(existing, typeInfo)
+	}
+	switch typeName {
+	case "N":
+		switch typeInfo.(type) {
+		case *types.Int, *types.Float:
+		default:
+			return false
+		}
+	case "S":
+		switch typeInfo.(type) {
+		case *types.Int, *types.Float, *types.String:
+		default:
+			return false
+		}
+	}
+	typeParameters[typeName] = typeInfo
+	return true
+}
+
+// resolveBuiltinType gets the type for a type name from the builtins
+// package. It returns nil if it uses a type parameter that wasn't matched.
+func (p *Typer) resolveBuiltinType(typeName string, typeParameters map[string]types.TypeInfo) types.TypeInfo {
+	if strings.HasPrefix(typeName, "[]") {
+		underlying := p.resolveBuiltinType(typeName[2:], typeParameters)
+		if underlying == nil {
+			return nil
+		}
+		return p.typeinfo.NewTypeInfoArray(underlying)
+	}
+	if builtins.IsTypeParameter(typeName) {
+		return typeParameters[typeName]
+	}
+	typeInfo := p.typeinfo.getByName(typeName)
+	if typeInfo == nil {
+		panic(fmt.Sprintf("resolveBuiltinType: Unknown type \"%s\".", typeName))
+	}
+	return typeInfo
+}
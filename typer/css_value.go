@@ -0,0 +1,306 @@
+package typer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/silbinarywolf/compiler-fel/ast"
+	"github.com/silbinarywolf/compiler-fel/errors"
+	"github.com/silbinarywolf/compiler-fel/token"
+	"github.com/silbinarywolf/compiler-fel/types"
+)
+
+// cssBuiltins are procedures that can be used without being
+// declared, ie. darken(bg_color, 10%)
+var cssBuiltins = map[string]struct {
+	parameters []string
+	returns    string
+}{
+	"darken":  {parameters: []string{"color", "percentage"}, returns: "color"},
+	"lighten": {parameters: []string{"color", "percentage"}, returns: "color"},
+}
+
+func (p *Typer) getCSSTypeByName(name string) types.TypeInfo {
+	switch name {
+	case "color":
+		return p.typeinfo.NewTypeInfoColor()
+	case "percentage":
+		return p.typeinfo.NewTypeInfoPercentage()
+	}
+	panic(fmt.Sprintf("getCSSTypeByName: Unhandled type \"%s\"", name))
+}
+
+func (p *Typer) isCSSBuiltin(name string) bool {
+	if _, ok := cssBuiltins[name]; !ok {
+		return false
+	}
+	// User-defined procedures take precedence
+	return p.typeinfo.getByName(name) == nil
+}
+
+func (p *Typer) typerCSSBuiltinCall(scope *Scope, node *ast.Call) {
+	name := node.Name.String()
+	builtin := cssBuiltins[name]
+
+	parameters := node.Parameters
+	hasMismatchingTypes := len(parameters) != len(builtin.parameters)
+	for i, parameter := range parameters {
+		p.typerExpression(scope, &parameter.Expression)
+		if hasMismatchingTypes || parameter.TypeInfo == nil {
+			continue
+		}
+		if !TypeEquals(parameter.TypeInfo, p.getCSSTypeByName(builtin.parameters[i])) {
+			hasMismatchingTypes = true
+		}
+	}
+	if hasMismatchingTypes {
+		have := make([]string, 0, len(parameters))
+		for _, parameter := range parameters {
+			if parameter.TypeInfo == nil {
+				have = append(have, "missing")
+				continue
+			}
+			have = append(have, parameter.TypeInfo.String())
+		}
+		p.AddError(node.Name, errors.CodeTypeMismatch, fmt.Errorf("Mismatching types for %s(), expected (%s) but got (%s).", name, strings.Join(builtin.parameters, ", "), strings.Join(have, ", ")))
+	}
+	node.Builtin = p.getCSSTypeByName(builtin.returns)
+}
+
+// hasCSSValue checks if an expression uses any CSS values, ie. 10px, #fff, darken()
+func (p *Typer) hasCSSValue(scope *Scope, nodes []ast.Node, allowKeywords bool) bool {
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *ast.Token:
+			switch node.Kind {
+			case token.NumberWithUnit, token.Color:
+				return true
+			case token.Identifier:
+				symbol := scope.GetSymbol(node.String())
+				if symbol == nil || symbol.variable == nil {
+					if allowKeywords {
+						return true
+					}
+					continue
+				}
+				if types.IsCSSValue(symbol.variable) {
+					return true
+				}
+			}
+		case *ast.Call:
+			if node.Kind() == ast.CallProcedure && p.isCSSBuiltin(node.Name.String()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// typerCSSExpression type checks expressions that use CSS values,
+// ie. "base * 2", "100% - 20px", "darken(bg_color, 10%)". It returns false
+// if the expression has no CSS values.
+func (p *Typer) typerCSSExpression(scope *Scope, expression *ast.Expression, allowKeywords bool) bool {
+	nodes := expression.Nodes()
+	if !p.hasCSSValue(scope, nodes, allowKeywords) {
+		return false
+	}
+
+	// Expressions are stored in postfix order, so we can determine
+	// the type of each operation with a stack.
+	//
+	// A nil type means an error was already reported.
+	stack := make([]types.TypeInfo, 0, len(nodes))
+	for _, itNode := range nodes {
+		var typeInfo types.TypeInfo
+		switch node := itNode.(type) {
+		case *ast.Call:
+			p.typerCall(scope, node)
+			switch {
+			case node.Builtin != nil:
+				typeInfo = node.Builtin
+			case node.TypeConversion != nil:
+				typeInfo = node.TypeConversion
+			case node.Definition != nil:
+				typeInfo = node.Definition.TypeInfo
+			}
+		case *ast.TokenList:
+			typeInfo = p.getTypeFromLeftHandSide(node.Tokens(), scope)
+		case *ast.Token:
+			t := node.Token
+			if t.IsOperator() {
+				if len(stack) < 2 {
+					p.AddError(t, errors.CodeInvalidCSS, fmt.Errorf("Expected value on both sides of \"%s\".", t.String()))
+					return true
+				}
+				left := stack[len(stack)-2]
+				right := stack[len(stack)-1]
+				stack = stack[:len(stack)-2]
+				if left != nil && right != nil {
+					var err error
+					typeInfo, err = p.typerCSSArithmetic(t, left, right)
+					if err != nil {
+						p.AddError(t, errors.CodeTypeMismatch, err)
+					}
+				}
+				break
+			}
+			switch t.Kind {
+			case token.NumberWithUnit:
+				value := t.String()
+				unit := strings.TrimLeft(value, "-.0123456789")
+				if unit == "%" {
+					typeInfo = p.typeinfo.NewTypeInfoPercentage()
+					break
+				}
+				if types.CSSUnitCategory(unit) == "" {
+					p.AddError(t, errors.CodeInvalidCSS, fmt.Errorf("Unknown CSS unit \"%s\" in \"%s\".", unit, value))
+					break
+				}
+				typeInfo = p.typeinfo.NewTypeInfoLength(unit)
+			case token.Number:
+				typeInfo = p.typeinfo.NewTypeInfoInt()
+				if strings.ContainsRune(t.Data, '.') {
+					typeInfo = p.typeinfo.NewTypeInfoFloat()
+				}
+			case token.Color:
+				switch len(t.String()) - 1 {
+				case 3, 4, 6, 8:
+					typeInfo = p.typeinfo.NewTypeInfoColor()
+				default:
+					p.AddError(t, errors.CodeInvalidCSS, fmt.Errorf("Invalid color \"%s\", expected 3, 4, 6 or 8 hex digits. ie. \"#fff\", \"#3366ff\"", t.String()))
+				}
+			case token.String:
+				typeInfo = p.typeinfo.NewTypeInfoString()
+			case token.KeywordTrue, token.KeywordFalse:
+				typeInfo = p.typeinfo.NewTypeInfoBool()
+			case token.KeywordNil:
+				p.AddError(t, errors.CodeTypeMismatch, fmt.Errorf("Cannot use nil in a CSS value."))
+			case token.Identifier:
+				name := t.String()
+				symbol := scope.GetSymbol(name)
+				if symbol != nil && symbol.variable != nil {
+					typeInfo = symbol.variable
+					break
+				}
+				if allowKeywords {
+					typeInfo = p.typeinfo.NewTypeInfoKeyword()
+					break
+				}
+				p.AddError(t, errors.CodeUndeclaredIdentifier, fmt.Errorf("Undeclared identifier \"%s\".", name))
+			default:
+				panic(fmt.Sprintf("typerCSSExpression: Unhandled token kind: \"%s\" with value: %s", t.Kind.String(), t.String()))
+			}
+		default:
+			panic(fmt.Sprintf("typerCSSExpression: Unhandled type %T", itNode))
+		}
+		stack = append(stack, typeInfo)
+	}
+	if len(stack) != 1 {
+		panic(fmt.Sprintf("typerCSSExpression: Expected 1 value left on stack, not %d.", len(stack)))
+	}
+	resultTypeInfo := stack[0]
+
+	// Check against declared type, ie. "padding: string = 10px"
+	if typeIdent := expression.TypeIdentifier.Name; typeIdent.Kind != token.Unknown && resultTypeInfo != nil {
+		expectedTypeInfo := p.DetermineType(&expression.TypeIdentifier)
+		if expectedTypeInfo == nil {
+			p.AddError(typeIdent, errors.CodeUndeclaredType, fmt.Errorf("Undeclared type %s", typeIdent.String()))
+			return true
+		}
+		if !TypeEquals(resultTypeInfo, expectedTypeInfo) {
+			p.AddError(typeIdent, errors.CodeTypeMismatch, fmt.Errorf("Cannot use %s as %s.", resultTypeInfo.String(), expectedTypeInfo.String()))
+		}
+		resultTypeInfo = expectedTypeInfo
+	}
+	expression.TypeInfo = resultTypeInfo
+	return true
+}
+
+func isNumberTypeInfo(typeInfo types.TypeInfo) bool {
+	switch typeInfo.(type) {
+	case *types.Int, *types.Float:
+		return true
+	}
+	return false
+}
+
+// getCSSDimension returns the unit and unit category of lengths and percentages.
+func getCSSDimension(typeInfo types.TypeInfo) (string, string, bool) {
+	switch typeInfo := typeInfo.(type) {
+	case *types.Length:
+		return typeInfo.Unit(), typeInfo.Category(), true
+	case *types.Percentage:
+		return "%", types.CSSUnitCategory("%"), true
+	}
+	return "", "", false
+}
+
+// typerCSSArithmetic determines the resulting type of an operation, ie.
+// "10px * 2" is a length(px), "100% - 20px" uses calc().
+func (p *Typer) typerCSSArithmetic(operator token.Token, left types.TypeInfo, right types.TypeInfo) (types.TypeInfo, error) {
+	var verb string
+	switch operator.Kind {
+	case token.Add:
+		verb = "add"
+	case token.Subtract:
+		verb = "subtract"
+	case token.Multiply:
+		verb = "multiply"
+	case token.Divide:
+		verb = "divide"
+	default:
+		return nil, fmt.Errorf("Operator \"%s\" cannot be used with CSS values.", operator.String())
+	}
+	for _, typeInfo := range []types.TypeInfo{left, right} {
+		switch typeInfo.(type) {
+		case *types.Color:
+			return nil, fmt.Errorf("Cannot %s a color. Use darken() or lighten() instead.", verb)
+		case *types.Keyword:
+			return nil, fmt.Errorf("Cannot %s a keyword, it must be a number or length.", verb)
+		}
+	}
+	if isNumberTypeInfo(left) && isNumberTypeInfo(right) {
+		if _, ok := left.(*types.Float); ok {
+			return left, nil
+		}
+		return right, nil
+	}
+
+	leftUnit, leftCategory, leftIsDimension := getCSSDimension(left)
+	rightUnit, rightCategory, rightIsDimension := getCSSDimension(right)
+	switch operator.Kind {
+	case token.Add, token.Subtract:
+		if leftIsDimension && rightIsDimension {
+			if leftCategory != rightCategory {
+				return nil, fmt.Errorf("Cannot %s %s and %s, incompatible units.", verb, left.String(), right.String())
+			}
+			if leftUnit == rightUnit && leftUnit != "" {
+				return left, nil
+			}
+			// ie. "100% - 20px" becomes "calc(100% - 20px)"
+			return p.typeinfo.NewTypeInfoCalcLength(leftCategory), nil
+		}
+		if (leftIsDimension && isNumberTypeInfo(right)) ||
+			(isNumberTypeInfo(left) && rightIsDimension) {
+			return nil, fmt.Errorf("Cannot %s %s and %s, the number is missing a unit.", verb, left.String(), right.String())
+		}
+	case token.Multiply:
+		if leftIsDimension && isNumberTypeInfo(right) {
+			return left, nil
+		}
+		if isNumberTypeInfo(left) && rightIsDimension {
+			return right, nil
+		}
+		if leftIsDimension && rightIsDimension {
+			return nil, fmt.Errorf("Cannot multiply %s by %s, only one side can have a unit.", left.String(), right.String())
+		}
+	case token.Divide:
+		if leftIsDimension && isNumberTypeInfo(right) {
+			return left, nil
+		}
+		if rightIsDimension {
+			return nil, fmt.Errorf("Cannot divide %s by %s, can only divide by a number.", left.String(), right.String())
+		}
+	}
+	return nil, fmt.Errorf("Cannot %s %s and %s, mismatching types.", verb, left.String(), right.String())
+}
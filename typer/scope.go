@@ -27,6 +27,9 @@ type Symbol struct {
 
 	// For variables
 	variable types.TypeInfo
+	// The declared type of an optional variable that was checked
+	// against nil, ie. "title" in "if title != nil { }"
+	nonNilOptional *types.Optional
 
 	// For combined symbol (Component-pieces)
 	cssDefinition       *ast.CSSDefinition
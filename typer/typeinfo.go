@@ -14,9 +14,17 @@ type TypeInfoManager struct {
 	intInfo      types.Int
 	floatInfo    types.Float
 	stringInfo   types.String
+	rawHTMLInfo  types.RawHTML
 	boolInfo     types.Bool
+	nilInfo      types.Nil
 	htmlNodeInfo types.HTMLNode
 
+	// css values
+	lengthInfo     map[string]*types.Length
+	percentageInfo types.Percentage
+	colorInfo      types.Color
+	keywordInfo    types.Keyword
+
 	// built-in structs
 	workspaceInfo *types.Struct
 }
@@ -26,12 +34,14 @@ func (manager *TypeInfoManager) Init() {
 		panic("Cannot initialize TypeInfoManager twice.")
 	}
 	manager.registeredTypes = make(map[types.Identifier]types.TypeInfo)
+	manager.lengthInfo = make(map[string]*types.Length)
 
 	// Primitives
 	manager.register("bool", manager.NewTypeInfoBool())
 	manager.register("int", manager.NewTypeInfoInt())
 	manager.register("string", manager.NewTypeInfoString())
 	manager.register("float", manager.NewTypeInfoFloat())
+	manager.register("rawhtml", manager.NewTypeInfoRawHTML())
 
 	// Internal types
 	manager.workspaceInfo = types.NewInternalStruct(
@@ -94,10 +104,41 @@ func (manager *TypeInfoManager) get(identifier types.Identifier) types.TypeInfo
 	return resultType
 }
 
-func (manager *TypeInfoManager) NewTypeInfoBool() *types.Bool     { return &manager.boolInfo }
-func (manager *TypeInfoManager) NewTypeInfoInt() *types.Int       { return &manager.intInfo }
-func (manager *TypeInfoManager) NewTypeInfoFloat() *types.Float   { return &manager.floatInfo }
-func (manager *TypeInfoManager) NewTypeInfoString() *types.String { return &manager.stringInfo }
+func (manager *TypeInfoManager) NewTypeInfoBool() *types.Bool       { return &manager.boolInfo }
+func (manager *TypeInfoManager) NewTypeInfoInt() *types.Int         { return &manager.intInfo }
+func (manager *TypeInfoManager) NewTypeInfoFloat() *types.Float     { return &manager.floatInfo }
+func (manager *TypeInfoManager) NewTypeInfoString() *types.String   { return &manager.stringInfo }
+func (manager *TypeInfoManager) NewTypeInfoRawHTML() *types.RawHTML { return &manager.rawHTMLInfo }
+func (manager *TypeInfoManager) NewTypeInfoNil() *types.Nil         { return &manager.nilInfo }
+
+// CSS Value Types
+func (manager *TypeInfoManager) NewTypeInfoPercentage() *types.Percentage {
+	return &manager.percentageInfo
+}
+func (manager *TypeInfoManager) NewTypeInfoColor() *types.Color     { return &manager.colorInfo }
+func (manager *TypeInfoManager) NewTypeInfoKeyword() *types.Keyword { return &manager.keywordInfo }
+
+// NewTypeInfoLength returns the length type for the unit, ie. "px".
+// Lengths with different units (ie. "10px + 2em") use calc() and are
+// stored per-category, ie. "length".
+func (manager *TypeInfoManager) NewTypeInfoLength(unit string) *types.Length {
+	if result, ok := manager.lengthInfo[unit]; ok {
+		return result
+	}
+	result := types.NewLength(unit)
+	manager.lengthInfo[unit] = result
+	return result
+}
+
+func (manager *TypeInfoManager) NewTypeInfoCalcLength(category string) *types.Length {
+	key := "calc " + category
+	if result, ok := manager.lengthInfo[key]; ok {
+		return result
+	}
+	result := types.NewCalcLength(category)
+	manager.lengthInfo[key] = result
+	return result
+}
 
 // Internal Struct Types
 func (manager *TypeInfoManager) InternalWorkspaceStruct() *types.Struct { return manager.workspaceInfo }
@@ -106,6 +147,14 @@ func (_ *TypeInfoManager) NewTypeInfoArray(underlying types.TypeInfo) *types.Arr
 	return types.NewArray(underlying)
 }
 
+func (_ *TypeInfoManager) NewTypeInfoMap(underlying types.TypeInfo) *types.Map {
+	return types.NewMap(underlying)
+}
+
+func (_ *TypeInfoManager) NewTypeInfoOptional(underlying types.TypeInfo) *types.Optional {
+	return types.NewOptional(underlying)
+}
+
 func (_ *TypeInfoManager) NewProcedureInfo(definiton *ast.ProcedureDefinition) *types.Procedure {
 	return types.NewProcedure(definiton)
 }
@@ -146,6 +195,28 @@ func (manager *TypeInfoManager) NewInternalStructField(name string, typeIdentNam
 
 // Functions
 func (p *Typer) DetermineType(node *ast.TypeIdent) types.TypeInfo {
+	if node.Optional {
+		// ie. ?string, ?[]string
+		typeIdent := *node
+		typeIdent.Optional = false
+		underlying := p.DetermineType(&typeIdent)
+		if underlying == nil {
+			return nil
+		}
+		return p.typeinfo.NewTypeInfoOptional(underlying)
+	}
+	if node.MapValue != nil {
+		// ie. map[string]int, []map[string]int
+		underlying := p.DetermineType(node.MapValue)
+		if underlying == nil {
+			return nil
+		}
+		var resultType types.TypeInfo = p.typeinfo.NewTypeInfoMap(underlying)
+		for i := 0; i < node.ArrayDepth; i++ {
+			resultType = p.typeinfo.NewTypeInfoArray(resultType)
+		}
+		return resultType
+	}
 	return p.typeinfo.get(types.Identifier{
 		Name:       node.Name.String(),
 		ArrayDepth: node.ArrayDepth,
@@ -158,8 +229,34 @@ func TypeEquals(a types.TypeInfo, b types.TypeInfo) bool {
 	if aOk && bOk {
 		return TypeEquals(aAsArray.Underlying(), bAsArray.Underlying())
 	}
+	aAsMap, aOk := a.(*types.Map)
+	bAsMap, bOk := b.(*types.Map)
+	if aOk && bOk {
+		return TypeEquals(aAsMap.Underlying(), bAsMap.Underlying())
+	}
+	aAsOptional, aOk := a.(*types.Optional)
+	bAsOptional, bOk := b.(*types.Optional)
+	if aOk && bOk {
+		return TypeEquals(aAsOptional.Underlying(), bAsOptional.Underlying())
+	}
 	if a == b {
 		return true
 	}
 	return false
 }
+
+// TypeAssignable is true if a value of type "from" can be stored as "to",
+// ie. nil or a string can be stored as a ?string
+func TypeAssignable(to types.TypeInfo, from types.TypeInfo) bool {
+	if TypeEquals(to, from) {
+		return true
+	}
+	optional, ok := to.(*types.Optional)
+	if !ok {
+		return false
+	}
+	if _, isNil := from.(*types.Nil); isNil {
+		return true
+	}
+	return TypeEquals(optional.Underlying(), from)
+}
@@ -14,6 +14,7 @@ import (
 
 type Typer struct {
 	errors.ErrorHandler
+	inProcedure                   bool // procedure call results aren't output, ie. "logIt(title)"
 	typeinfo                      TypeInfoManager
 	typecheckHtmlNodeDependencies map[string]*ast.Call
 	htmlComponentsUsed            []*ast.HTMLComponentDefinition // track used components for emitting css
@@ -45,16 +46,16 @@ func (p *Typer) typerStructLiteral(scope *Scope, literal *ast.StructLiteral) {
 	name := literal.Name.String()
 	symbol := scope.GetSymbol(name)
 	if symbol == nil {
-		p.AddError(literal.Name, fmt.Errorf("Undeclared \"%s :: struct\"", name))
+		p.AddError(literal.Name, errors.CodeUndeclaredType, fmt.Errorf("Undeclared \"%s :: struct\"", name))
 		return
 	}
 	def := symbol.structDefinition
 	if def == nil {
-		p.AddError(literal.Name, fmt.Errorf("Expected \"%s\" to be \"%s :: struct\", not \"%s\".", name, name, symbol.GetType()))
+		p.AddError(literal.Name, errors.CodeWrongSymbolKind, fmt.Errorf("Expected \"%s\" to be \"%s :: struct\", not \"%s\".", name, name, symbol.GetType()))
 		return
 	}
 	if len(def.Fields) == 0 && len(literal.Fields) > 0 {
-		p.AddError(literal.Name, fmt.Errorf("Struct %s does not have any fields.", name))
+		p.AddError(literal.Name, errors.CodeUnknownField, fmt.Errorf("Struct %s does not have any fields.", name))
 		return
 	}
 	literal.TypeInfo = p.typeinfo.getByName(name)
@@ -78,8 +79,8 @@ func (p *Typer) typerStructLiteral(scope *Scope, literal *ast.StructLiteral) {
 			}
 			p.typerExpression(scope, &property.Expression)
 			litTypeInfo := property.Expression.TypeInfo
-			if litTypeInfo != defTypeInfo {
-				p.AddError(property.Name, fmt.Errorf("Mismatching type, expected \"%s\" but got \"%s\"", defField.TypeIdentifier.Name.String(), property.Expression.TypeInfo.String()))
+			if !TypeAssignable(defTypeInfo, litTypeInfo) && !p.addImplicitRawHTMLError(defTypeInfo, &property.Expression) {
+				p.AddError(property.Name, errors.CodeTypeMismatch, fmt.Errorf("Mismatching type, expected \"%s\" but got \"%s\"", defTypeInfo.String(), property.Expression.TypeInfo.String()))
 			}
 		}
 	}
@@ -91,7 +92,7 @@ func (p *Typer) typerStructLiteral(scope *Scope, literal *ast.StructLiteral) {
 			hasFieldNameOnDef = hasFieldNameOnDef || defField.Name.String() == propertyName
 		}
 		if !hasFieldNameOnDef {
-			p.AddError(property.Name, fmt.Errorf("Field \"%s\" does not exist on \"%s :: struct\"", propertyName, name))
+			p.AddError(property.Name, errors.CodeUnknownField, fmt.Errorf("Field \"%s\" does not exist on \"%s :: struct\"", propertyName, name))
 		}
 	}
 }
@@ -108,7 +109,7 @@ func (p *Typer) typerArrayLiteral(scope *Scope, literal *ast.ArrayLiteral) {
 	typeIdentString := typeIdentName.String()
 	typeInfo := p.DetermineType(&literal.TypeIdentifier)
 	if typeInfo == nil {
-		p.AddError(typeIdentName, fmt.Errorf("Undeclared type \"%s\" used for array literal", typeIdentString))
+		p.AddError(typeIdentName, errors.CodeUndeclaredType, fmt.Errorf("Undeclared type \"%s\" used for array literal", typeIdentString))
 		return
 	}
 	literal.TypeInfo = typeInfo
@@ -140,7 +141,139 @@ func (p *Typer) typerArrayLiteral(scope *Scope, literal *ast.ArrayLiteral) {
 	}
 }
 
+func (p *Typer) typerMapLiteral(scope *Scope, literal *ast.MapLiteral) {
+	typeIdentName := literal.TypeIdentifier.Name
+	typeInfo := p.DetermineType(&literal.TypeIdentifier)
+	if typeInfo == nil {
+		p.AddError(typeIdentName, errors.CodeUndeclaredType, fmt.Errorf("Undeclared type \"%s\" used for map literal", literal.TypeIdentifier.String()))
+		return
+	}
+	mapTypeInfo, ok := typeInfo.(*types.Map)
+	if !ok {
+		p.PanicError(typeIdentName, fmt.Errorf("Expected map type but got \"%s\".", typeInfo.String()))
+		return
+	}
+	literal.TypeInfo = mapTypeInfo
+
+	keys := make(map[string]bool, len(literal.Entries))
+	for i := range literal.Entries {
+		entry := &literal.Entries[i]
+		// Set the expected types so number literals are inferred, ie. "1" as a float
+		entry.Key.TypeInfo = p.typeinfo.NewTypeInfoString()
+		entry.Value.TypeInfo = mapTypeInfo.Underlying()
+		p.typerExpression(scope, &entry.Key)
+		p.typerExpression(scope, &entry.Value)
+
+		// Catch duplicate keys, ie. {"home": "a", "home": "b"}
+		if nodes := entry.Key.Nodes(); len(nodes) == 1 {
+			if t, ok := nodes[0].(*ast.Token); ok && t.Kind == token.String {
+				key := t.String()
+				if keys[key] {
+					p.AddError(t.Token, errors.CodeRedeclared, fmt.Errorf("Duplicate key \"%s\" in map literal.", key))
+				}
+				keys[key] = true
+			}
+		}
+	}
+}
+
+// typerIndex checks an index expression, ie. icons["home"]
+func (p *Typer) typerIndex(scope *Scope, node *ast.Index) {
+	p.typerExpression(scope, &node.Value)
+	if len(node.Index.Nodes()) == 0 {
+		p.AddError(node.Bracket, errors.CodeSyntax, fmt.Errorf("Expected value between [ and ]."))
+		return
+	}
+	p.typerExpression(scope, &node.Index)
+	valueTypeInfo := node.Value.TypeInfo
+	indexTypeInfo := node.Index.TypeInfo
+	if valueTypeInfo == nil || indexTypeInfo == nil {
+		// Error should be reported in typerExpression()
+		return
+	}
+	switch valueTypeInfo := valueTypeInfo.(type) {
+	case *types.Map:
+		if _, ok := indexTypeInfo.(*types.String); !ok {
+			p.AddError(node.Bracket, errors.CodeTypeMismatch, fmt.Errorf("Cannot use %s as map key, expected string.", indexTypeInfo.String()))
+			return
+		}
+		node.TypeInfo = valueTypeInfo.Underlying()
+	case *types.Array:
+		if _, ok := indexTypeInfo.(*types.Int); !ok {
+			p.AddError(node.Bracket, errors.CodeTypeMismatch, fmt.Errorf("Cannot use %s as array index, expected int.", indexTypeInfo.String()))
+			return
+		}
+		node.TypeInfo = valueTypeInfo.Underlying()
+	default:
+		p.AddError(node.Bracket, errors.CodeTypeMismatch, fmt.Errorf("Cannot index %s, expected array or map.", valueTypeInfo.String()))
+	}
+}
+
+// typerSlice checks a slice expression, ie. items[1:3]
+func (p *Typer) typerSlice(scope *Scope, node *ast.Slice) {
+	p.typerExpression(scope, &node.Value)
+	hasError := false
+	for _, bound := range []*ast.Expression{&node.Low, &node.High} {
+		if len(bound.Nodes()) == 0 {
+			continue
+		}
+		p.typerExpression(scope, bound)
+		if bound.TypeInfo == nil {
+			hasError = true
+			continue
+		}
+		if _, ok := bound.TypeInfo.(*types.Int); !ok {
+			p.AddError(node.Bracket, errors.CodeTypeMismatch, fmt.Errorf("Cannot use %s as slice index, expected int.", bound.TypeInfo.String()))
+			hasError = true
+		}
+	}
+	valueTypeInfo := node.Value.TypeInfo
+	if valueTypeInfo == nil || hasError {
+		return
+	}
+	if _, ok := valueTypeInfo.(*types.Array); !ok {
+		p.AddError(node.Bracket, errors.CodeTypeMismatch, fmt.Errorf("Cannot slice %s, expected array.", valueTypeInfo.String()))
+		return
+	}
+	node.TypeInfo = valueTypeInfo
+}
+
+// typerSelector checks fields on a value that isn't a variable, ie. items[i].title
+func (p *Typer) typerSelector(scope *Scope, node *ast.Selector) {
+	p.typerExpression(scope, &node.Value)
+	typeInfo := node.Value.TypeInfo
+	if typeInfo == nil {
+		return
+	}
+	for _, name := range node.Fields {
+		structInfo, ok := typeInfo.(*types.Struct)
+		if !ok {
+			p.AddError(name, errors.CodeUnknownField, fmt.Errorf("Property \"%s\" does not exist on type \"%s\".", name.String(), typeInfo.String()))
+			return
+		}
+		field := structInfo.GetFieldByName(name.String())
+		if field == nil {
+			p.AddError(name, errors.CodeUnknownField, fmt.Errorf("Property \"%s\" does not exist on \"%s :: struct\".", name.String(), structInfo.Name()))
+			return
+		}
+		typeInfo = field.TypeInfo
+	}
+	node.TypeInfo = typeInfo
+}
+
 func (p *Typer) typerCall(scope *Scope, node *ast.Call) {
+	// Calls with named parameters, ie. formatPrice(amount = 5), are
+	// parsed as HTML nodes as the parser can't tell them apart
+	// from an element, ie. img(src = "logo.png").
+	if node.Kind() == ast.CallHTMLNode &&
+		len(node.ChildNodes) == 0 &&
+		len(node.IfExpression.Nodes()) == 0 &&
+		len(node.ElseNodes) == 0 {
+		name := node.Name.String()
+		if _, ok := p.typeinfo.getByName(name).(*types.Procedure); ok || p.isBuiltin(name) {
+			node.SetProcedureCall()
+		}
+	}
 	switch node.Kind() {
 	case ast.CallProcedure:
 		p.typerProcedureCall(scope, node)
@@ -154,13 +287,25 @@ func (p *Typer) typerCall(scope *Scope, node *ast.Call) {
 func (p *Typer) typerProcedureCall(scope *Scope, node *ast.Call) {
 	typeInfo := p.typeinfo.getByName(node.Name.String())
 	callTypeInfo, ok := typeInfo.(*types.Procedure)
+	if _, isRawHTML := typeInfo.(*types.RawHTML); isRawHTML {
+		p.typerTypeConversion(scope, node, typeInfo)
+		return
+	}
+	if typeInfo == nil && p.isCSSBuiltin(node.Name.String()) {
+		p.typerCSSBuiltinCall(scope, node)
+		return
+	}
+	if typeInfo == nil && p.isBuiltin(node.Name.String()) {
+		p.typerBuiltinCall(scope, node)
+		return
+	}
 	if !ok {
 		// todo(Jake): 2018-01-14
 		//
 		//
 		//
 		if typeInfo == nil {
-			p.AddError(node.Name, fmt.Errorf("Procedure \"%s()\" is not defined.", node.Name.String()))
+			p.AddError(node.Name, errors.CodeUndeclaredIdentifier, fmt.Errorf("Procedure \"%s()\" is not defined.", node.Name.String()))
 			return
 		}
 		p.PanicError(node.Name, fmt.Errorf("Expected %s to be a procedure, instead got %T.", node.Name.String(), typeInfo))
@@ -171,13 +316,50 @@ func (p *Typer) typerProcedureCall(scope *Scope, node *ast.Call) {
 
 	parameters := node.Parameters
 	definitionParameters := procDefinition.Parameters
+	if len(parameters) > 0 && parameters[0].Name.Kind != token.Unknown {
+		// Put named parameters in the order they're defined in so
+		// they can be emitted the same as unnamed ones.
+		orderedParameters := make([]*ast.Parameter, len(definitionParameters))
+		hasErrors := false
+	ParameterLoop:
+		for _, parameter := range parameters {
+			name := parameter.Name.String()
+			for i, definitionParameter := range definitionParameters {
+				if definitionParameter.Name.String() != name {
+					continue
+				}
+				if orderedParameters[i] != nil {
+					p.AddError(parameter.Name, errors.CodeInvalidParameter, fmt.Errorf("Parameter \"%s\" is set more than once on call \"%s\".", name, node.Name.String()))
+					hasErrors = true
+				}
+				orderedParameters[i] = parameter
+				continue ParameterLoop
+			}
+			p.AddError(parameter.Name, errors.CodeInvalidParameter, fmt.Errorf("\"%s\" has no parameter named \"%s\".", node.Name.String(), name))
+			hasErrors = true
+		}
+		for i, parameter := range orderedParameters {
+			if parameter == nil && !hasErrors {
+				p.AddError(node.Name, errors.CodeArgumentCount, fmt.Errorf("Missing parameter \"%s\" on call \"%s\".", definitionParameters[i].Name.String(), node.Name.String()))
+				hasErrors = true
+			}
+		}
+		if hasErrors {
+			for _, parameter := range parameters {
+				p.typerExpression(scope, &parameter.Expression)
+			}
+			return
+		}
+		node.Parameters = orderedParameters
+		parameters = orderedParameters
+	}
 	hasMismatchingTypes := len(definitionParameters) != len(parameters)
 	for i := 0; i < len(parameters); i++ {
 		parameter := parameters[i]
 		p.typerExpression(scope, &parameter.Expression)
 		if hasMismatchingTypes == false && i < len(definitionParameters) {
 			definitionParameter := definitionParameters[i]
-			if !TypeEquals(parameter.TypeInfo, definitionParameter.TypeInfo) {
+			if !TypeAssignable(definitionParameter.TypeInfo, parameter.TypeInfo) {
 				hasMismatchingTypes = true
 			}
 		}
@@ -211,7 +393,7 @@ func (p *Typer) typerProcedureCall(scope *Scope, node *ast.Call) {
 				//
 				// This should be applied in p.typerExpression(scope, &parameter.Expression)
 				//
-				haveStr += "missing"
+				wantStr += "missing"
 				continue
 			}
 			wantStr += parameter.TypeInfo.String()
@@ -219,13 +401,34 @@ func (p *Typer) typerProcedureCall(scope *Scope, node *ast.Call) {
 		wantStr += ")"
 		callStr := node.Name.String()
 		if len(definitionParameters) != len(parameters) {
-			p.AddError(node.Name, fmt.Errorf("Expected %d parameters, instead got %d parameters on call \"%s\".\nhave %s\nwant %s", len(definitionParameters), len(parameters), callStr, haveStr, wantStr))
+			p.AddError(node.Name, errors.CodeArgumentCount, fmt.Errorf("Expected %d parameters, instead got %d parameters on call \"%s\".\nhave %s\nwant %s", len(definitionParameters), len(parameters), callStr, haveStr, wantStr))
 		} else {
-			p.AddError(node.Name, fmt.Errorf("Mismatching types on call \"%s\".\nhave %s\nwant %s", callStr, haveStr, wantStr))
+			p.AddError(node.Name, errors.CodeTypeMismatch, fmt.Errorf("Mismatching types on call \"%s\".\nhave %s\nwant %s", callStr, haveStr, wantStr))
 		}
 	}
 }
 
+// typerTypeConversion checks explicit conversions, ie. rawhtml("<b>Bold</b>")
+func (p *Typer) typerTypeConversion(scope *Scope, node *ast.Call, typeInfo types.TypeInfo) {
+	name := node.Name.String()
+	if len(node.Parameters) != 1 {
+		p.AddError(node.Name, errors.CodeArgumentCount, fmt.Errorf("Expected 1 parameter for %s(), instead got %d.", name, len(node.Parameters)))
+		return
+	}
+	parameter := node.Parameters[0]
+	p.typerExpression(scope, &parameter.Expression)
+	parameterTypeInfo := parameter.TypeInfo
+	if parameterTypeInfo == nil {
+		return
+	}
+	if !TypeEquals(parameterTypeInfo, p.typeinfo.NewTypeInfoString()) &&
+		!TypeEquals(parameterTypeInfo, typeInfo) {
+		p.AddError(node.Name, errors.CodeTypeMismatch, fmt.Errorf("Cannot convert %s to %s.", parameterTypeInfo.String(), typeInfo.String()))
+		return
+	}
+	node.TypeConversion = typeInfo
+}
+
 func (p *Typer) typerExpression(scope *Scope, expression *ast.Expression) {
 	resultTypeInfo := expression.TypeInfo
 
@@ -234,169 +437,429 @@ func (p *Typer) typerExpression(scope *Scope, expression *ast.Expression) {
 		typeIdentString := typeIdent.String()
 		resultTypeInfo = p.DetermineType(&expression.TypeIdentifier)
 		if resultTypeInfo == nil {
-			p.AddError(typeIdent, fmt.Errorf("Undeclared type %s", typeIdentString))
+			p.AddError(typeIdent, errors.CodeUndeclaredType, fmt.Errorf("Undeclared type %s", typeIdentString))
 			return
 		}
 	}
 
-	var leftToken token.Token
+	if p.typerCSSExpression(scope, expression, false) {
+		return
+	}
+
 	nodes := expression.Nodes()
-	for i, itNode := range nodes {
-		switch node := itNode.(type) {
-		case *ast.StructLiteral:
-			p.typerStructLiteral(scope, node)
-			expectedTypeInfo := node.TypeInfo
-			if expectedTypeInfo == nil {
-				p.PanicError(node.Name, fmt.Errorf("Missing type info for \"%s :: struct\".", node.Name.String()))
-				continue
-			}
-			if resultTypeInfo == nil {
-				resultTypeInfo = expectedTypeInfo
-			}
-			if !TypeEquals(resultTypeInfo, expectedTypeInfo) {
-				p.AddError(node.Name, fmt.Errorf("Cannot mix struct literal %s with %s", expectedTypeInfo.String(), resultTypeInfo.String()))
+	if len(nodes) == 0 {
+		expression.TypeInfo = resultTypeInfo
+		return
+	}
+
+	// Expressions are stored in postfix order, so we can determine
+	// the type of each operation with a stack.
+	//
+	// A nil type means an error was already reported.
+	operandTypeInfo := resultTypeInfo
+	if optional, ok := operandTypeInfo.(*types.Optional); ok {
+		// ie. "1" is a float in "x: ?float = 1"
+		operandTypeInfo = optional.Underlying()
+	}
+	stack := make([]types.TypeInfo, 0, len(nodes))
+	numberStack := make([]*ast.Token, 0, len(nodes)) // number literal operands, otherwise nil
+	for _, itNode := range nodes {
+		node, ok := itNode.(*ast.Token)
+		if !ok || !node.IsOperator() {
+			var number *ast.Token
+			if ok && node.Kind == token.Number {
+				number = node
 			}
+			stack = append(stack, p.typerExpressionOperand(scope, itNode, operandTypeInfo))
+			numberStack = append(numberStack, number)
 			continue
-		case *ast.ArrayLiteral:
-			p.typerArrayLiteral(scope, node)
-			expectedTypeInfo := node.TypeInfo
-			if resultTypeInfo == nil {
-				resultTypeInfo = expectedTypeInfo
-			}
-			if !TypeEquals(resultTypeInfo, expectedTypeInfo) {
-				p.AddError(node.TypeIdentifier.Name, fmt.Errorf("Cannot mix array literal %s with %s", expectedTypeInfo.String(), resultTypeInfo.String()))
+		}
+		if node.Kind == token.Not {
+			if len(stack) < 1 {
+				p.AddError(node.Token, errors.CodeSyntax, fmt.Errorf("Expected value after \"%s\".", node.String()))
+				return
 			}
-			continue
-		case *ast.Call:
-			p.typerCall(scope, node)
-			switch node.Kind() {
-			case ast.CallProcedure:
-				if node.Definition != nil {
-					expectedTypeInfo := node.Definition.TypeInfo
-					if resultTypeInfo == nil {
-						resultTypeInfo = expectedTypeInfo
-					}
-					if !TypeEquals(resultTypeInfo, expectedTypeInfo) {
-						p.AddError(node.Name, fmt.Errorf("Cannot mix call type %s with %s", expectedTypeInfo.String(), resultTypeInfo.String()))
-					}
+			if typeInfo := stack[len(stack)-1]; typeInfo != nil {
+				stack[len(stack)-1] = nil
+				if _, ok := typeInfo.(*types.Bool); ok {
+					node.TypeInfo = typeInfo
+					stack[len(stack)-1] = typeInfo
+				} else {
+					p.AddError(node.Token, errors.CodeTypeMismatch, fmt.Errorf("Cannot use \"%s\" with %s, expected bool.", node.String(), typeInfo.String()))
 				}
-			case ast.CallHTMLNode:
-				p.AddError(node.Name, fmt.Errorf("Cannot use HTML node in expression."))
-			default:
-				panic(fmt.Sprintf("typerExpression:Call: Unhandled call kind: %s", node.Kind()))
 			}
-
+			numberStack[len(numberStack)-1] = nil
 			continue
-		case *ast.HTMLBlock:
-			panic("typerExpression: todo(Jake): Fix HTMLBlock")
-			/*variableType := data.KindHTMLNode
-			if exprType == data.KindUnknown {
-				exprType = variableType
+		}
+		if node.Kind == token.Negate {
+			if len(stack) < 1 {
+				p.AddError(node.Token, errors.CodeSyntax, fmt.Errorf("Expected value after \"%s\".", node.String()))
+				return
 			}
-			if exprType != variableType {
-				p.addErrorToken(fmt.Errorf("\":: html\" must be a %s not %s.", exprType.String(), variableType.String()), node.HTMLKeyword)
+			if typeInfo := stack[len(stack)-1]; typeInfo != nil {
+				stack[len(stack)-1] = nil
+				switch typeInfo.(type) {
+				case *types.Int, *types.Float:
+					node.TypeInfo = typeInfo
+					stack[len(stack)-1] = typeInfo
+				default:
+					p.AddError(node.Token, errors.CodeTypeMismatch, fmt.Errorf("Cannot use \"%s\" with %s, expected int or float.", node.String(), typeInfo.String()))
+				}
 			}
-			p.typerHTMLBlock(node, scope)*/
-		case *ast.TokenList:
-			tokens := node.Tokens()
-			expectedTypeInfo := p.getTypeFromLeftHandSide(tokens, scope)
-			if expectedTypeInfo == nil {
-				continue
+			numberStack[len(numberStack)-1] = nil
+			continue
+		}
+		if len(stack) < 2 {
+			p.AddError(node.Token, errors.CodeSyntax, fmt.Errorf("Expected value on both sides of \"%s\".", node.String()))
+			return
+		}
+		left, right := stack[len(stack)-2], stack[len(stack)-1]
+		leftNumber, rightNumber := numberStack[len(numberStack)-2], numberStack[len(numberStack)-1]
+		stack = stack[:len(stack)-2]
+		numberStack = numberStack[:len(numberStack)-2]
+
+		var typeInfo types.TypeInfo
+		if left != nil && right != nil {
+			// Allow int literals to be used as floats, ie. "width * 2"
+			floatTypeInfo := p.typeinfo.NewTypeInfoFloat()
+			intTypeInfo := p.typeinfo.NewTypeInfoInt()
+			if leftNumber != nil && left == intTypeInfo && right == floatTypeInfo {
+				left = floatTypeInfo
+				leftNumber.TypeInfo = floatTypeInfo
 			}
-			if resultTypeInfo == nil {
-				resultTypeInfo = expectedTypeInfo
+			if rightNumber != nil && right == intTypeInfo && left == floatTypeInfo {
+				right = floatTypeInfo
+				rightNumber.TypeInfo = floatTypeInfo
 			}
-			if !TypeEquals(resultTypeInfo, expectedTypeInfo) {
-				p.AddError(tokens[0], fmt.Errorf("Cannot mix variable \"%s\" type %s with %s", node.String(), expectedTypeInfo.String(), resultTypeInfo.String()))
+			var err error
+			typeInfo, err = p.typerOperator(node, left, right)
+			if err != nil {
+				p.AddError(node.Token, errors.CodeTypeMismatch, err)
 			}
+		}
+		stack = append(stack, typeInfo)
+		numberStack = append(numberStack, nil)
+	}
+	if len(stack) != 1 {
+		panic(fmt.Sprintf("typerExpression: Expected 1 value left on stack, not %d.", len(stack)))
+	}
+	expressionTypeInfo := stack[0]
+	if resultTypeInfo == nil {
+		expression.TypeInfo = expressionTypeInfo
+		return
+	}
+	if expressionTypeInfo != nil && !TypeAssignable(resultTypeInfo, expressionTypeInfo) {
+		t := getExpressionNodeToken(nodes[0])
+		switch {
+		case len(nodes) == 1 && t.Kind == token.Identifier:
+			p.AddError(t, errors.CodeTypeMismatch, fmt.Errorf("Identifier \"%s\" must be a %s not %s.", t.String(), resultTypeInfo.String(), expressionTypeInfo.String()))
+		case p.addImplicitRawHTMLError(resultTypeInfo, expression):
+			// Error added with a suggestion
+		default:
+			p.AddError(t, errors.CodeTypeMismatch, fmt.Errorf("Cannot use %s as %s.", expressionTypeInfo.String(), resultTypeInfo.String()))
+		}
+	}
+	expression.TypeInfo = resultTypeInfo
+}
+
+// addImplicitRawHTMLError adds an error if a string literal is used where
+// rawhtml is expected, ie. body: "<b>Bold</b>", and returns true if it did.
+func (p *Typer) addImplicitRawHTMLError(resultTypeInfo types.TypeInfo, expression *ast.Expression) bool {
+	if _, ok := resultTypeInfo.(*types.RawHTML); !ok {
+		return false
+	}
+	nodes := expression.Nodes()
+	if len(nodes) != 1 {
+		return false
+	}
+	t := getExpressionNodeToken(nodes[0])
+	if t.Kind != token.String {
+		return false
+	}
+	p.AddError(t, errors.CodeTypeMismatch, fmt.Errorf("Cannot implicitly use string (\"%s\") as %s.", t.String(), resultTypeInfo.String())).
+		AddSuggestion(fmt.Sprintf("Use rawhtml(\"%s\") to output unescaped HTML.", t.String()))
+	return true
+}
+
+// nonNilIdentifiers gets the variables that can't be nil if a condition is
+// true, ie. "title" in "title != nil && isVisible", or if it's false when
+// isElse is set, ie. "title" in "title == nil || !isVisible". Conditions that
+// can also be met in another way when a variable is nil are ignored.
+func nonNilIdentifiers(condition *ast.Expression, isElse bool) []string {
+	compareKind, ignoreKind := token.ConditionalNotEqual, token.ConditionalOr
+	if isElse {
+		compareKind, ignoreKind = token.ConditionalEqual, token.ConditionalAnd
+	}
+	nodes := condition.Nodes()
+	for _, node := range nodes {
+		if t, ok := node.(*ast.Token); ok && (t.Kind == ignoreKind || t.Kind == token.Not) {
+			return nil
+		}
+	}
+	var result []string
+	for i := 2; i < len(nodes); i++ {
+		// Expressions are stored in postfix order, ie. "title nil !="
+		operator, ok := nodes[i].(*ast.Token)
+		if !ok || operator.Kind != compareKind {
 			continue
-		case *ast.Token:
-			if node.IsOperator() {
-				continue
-			}
+		}
+		left, leftOk := nodes[i-2].(*ast.Token)
+		right, rightOk := nodes[i-1].(*ast.Token)
+		if !leftOk || !rightOk {
+			continue
+		}
+		if left.Kind == token.Identifier && right.Kind == token.KeywordNil {
+			result = append(result, left.String())
+		}
+	}
+	return result
+}
 
-			switch node.Kind {
-			case token.Identifier:
-				name := node.String()
-				symbol := scope.GetSymbol(name)
-				if symbol == nil {
-					p.AddError(node.Token, fmt.Errorf("Undeclared identifier \"%s\".", name))
-					continue
-				}
-				variableTypeInfo := symbol.variable
-				if variableTypeInfo == nil {
-					if htmlComponentDefinition := symbol.htmlDefinition; htmlComponentDefinition != nil {
-						p.AddError(node.Token, fmt.Errorf("Undeclared identifier \"%s\". Did you mean \"%s()\" or \"%s{ }\" to reference the \"%s :: html\" component?", name, name, name, name))
-						continue
-					}
-					p.AddError(node.Token, fmt.Errorf("Identifier \"%s\" is not a variable", name))
-					continue
-				}
-				if resultTypeInfo == nil {
-					resultTypeInfo = variableTypeInfo
-				}
-				if !TypeEquals(resultTypeInfo, variableTypeInfo) {
-					if variableTypeInfo == nil {
-						p.PanicError(node.Token, fmt.Errorf("Unable to determine type, typerer must have failed."))
-						return
-					}
-					p.AddError(node.Token, fmt.Errorf("Identifier \"%s\" must be a %s not %s.", name, resultTypeInfo.String(), variableTypeInfo.String()))
-				}
-			case token.String:
-				expectedTypeInfo := p.typeinfo.NewTypeInfoString()
-				if resultTypeInfo == nil {
-					resultTypeInfo = expectedTypeInfo
-				}
-				if !TypeEquals(resultTypeInfo, expectedTypeInfo) {
-					// NOTE(Jake): 2018-01-04
-					//
-					// Need to make mixing types error messages consistent. Either by removing
-					// the look-ahead for an operator token or by improving the check.
-					//
-					var opToken token.Token
-					if i+1 < len(nodes) {
-						switch node := nodes[i+1].(type) {
-						case *ast.Token:
-							opToken = node.Token
-						case *ast.TokenList:
-							opToken = node.Tokens()[0]
-						default:
-							panic(fmt.Sprintf("Expected *ast.Token or *ast.TokenList, not %T", node))
-						}
-					}
-					p.AddError(node.Token, fmt.Errorf("Cannot %s (%s) %s %s (\"%s\"), mismatching types.", resultTypeInfo.String(), leftToken.String(), opToken.String(), expectedTypeInfo.String(), node.String()))
-				}
-			case token.Number:
-				IntTypeInfo := p.typeinfo.NewTypeInfoInt()
-				FloatTypeInfo := p.typeinfo.NewTypeInfoFloat()
+// setNonNil makes optional variables that were checked against nil use
+// their underlying type in the scope, ie. "title" in "if title != nil { }"
+func setNonNil(scope *Scope, names []string) {
+	for _, name := range names {
+		symbol := scope.GetSymbol(name)
+		if symbol == nil {
+			continue
+		}
+		if optional, ok := symbol.variable.(*types.Optional); ok {
+			scope.SetVariable(name, optional.Underlying())
+			scope.GetSymbolFromThisScope(name).nonNilOptional = optional
+		}
+	}
+}
 
-				if resultTypeInfo == nil {
-					resultTypeInfo = IntTypeInfo
-					if strings.ContainsRune(node.Data, '.') {
-						resultTypeInfo = FloatTypeInfo
-					}
-				}
-				if !TypeEquals(resultTypeInfo, IntTypeInfo) && !TypeEquals(resultTypeInfo, FloatTypeInfo) {
-					p.AddError(node.Token, fmt.Errorf("Cannot use %s with number \"%s\"", resultTypeInfo.String(), node.String()))
-				}
-			case token.KeywordTrue, token.KeywordFalse:
-				expectedTypeInfo := p.typeinfo.NewTypeInfoBool()
-				if resultTypeInfo == nil {
-					resultTypeInfo = expectedTypeInfo
+// addOptionalOutputError adds an error for outputting an optional value as HTML,
+// ie. "subtitle" where "subtitle: ?string"
+func (p *Typer) addOptionalOutputError(t token.Token, typeInfo types.TypeInfo) {
+	diagnostic := p.AddError(t, errors.CodeTypeMismatch, fmt.Errorf("Cannot output %s as HTML as it can be nil.", typeInfo))
+	if t.Kind == token.Identifier {
+		diagnostic.AddSuggestion(fmt.Sprintf("Check it isn't nil first, ie. \"if %s != nil { %s }\".", t.String(), t.String()))
+	}
+}
+
+// callResultType is the type returned by a procedure call or conversion
+func callResultType(node *ast.Call) types.TypeInfo {
+	switch {
+	case node.TypeConversion != nil:
+		return node.TypeConversion
+	case node.Builtin != nil:
+		return node.Builtin
+	case node.Definition != nil:
+		return node.Definition.TypeInfo
+	}
+	return nil
+}
+
+// typerCondition checks that an if-statement condition is a bool.
+func (p *Typer) typerCondition(scope *Scope, expression *ast.Expression) {
+	p.typerExpression(scope, expression)
+	typeInfo := expression.TypeInfo
+	if typeInfo == nil {
+		return
+	}
+	if _, ok := typeInfo.(*types.Bool); !ok {
+		t := getExpressionNodeToken(expression.Nodes()[0])
+		p.AddError(t, errors.CodeTypeMismatch, fmt.Errorf("Cannot use %s as if-statement condition, expected bool.", typeInfo.String()))
+	}
+}
+
+// typerExpressionOperand returns the type of a value in an expression. The
+// expected type is used for number literals, ie. "x: float = 1"
+func (p *Typer) typerExpressionOperand(scope *Scope, itNode ast.Node, expectedTypeInfo types.TypeInfo) types.TypeInfo {
+	switch node := itNode.(type) {
+	case *ast.StructLiteral:
+		p.typerStructLiteral(scope, node)
+		if node.TypeInfo == nil {
+			p.PanicError(node.Name, fmt.Errorf("Missing type info for \"%s :: struct\".", node.Name.String()))
+		}
+		return node.TypeInfo
+	case *ast.ArrayLiteral:
+		p.typerArrayLiteral(scope, node)
+		return node.TypeInfo
+	case *ast.MapLiteral:
+		p.typerMapLiteral(scope, node)
+		return node.TypeInfo
+	case *ast.Index:
+		p.typerIndex(scope, node)
+		return node.TypeInfo
+	case *ast.Slice:
+		p.typerSlice(scope, node)
+		return node.TypeInfo
+	case *ast.Selector:
+		p.typerSelector(scope, node)
+		return node.TypeInfo
+	case *ast.Call:
+		p.typerCall(scope, node)
+		switch node.Kind() {
+		case ast.CallProcedure:
+			switch {
+			case node.TypeConversion != nil:
+				return node.TypeConversion
+			case node.Builtin != nil:
+				return node.Builtin
+			case node.Definition != nil:
+				if node.Definition.TypeIdentifier.Name.Kind == token.Unknown {
+					p.AddError(node.Name, errors.CodeTypeMismatch, fmt.Errorf("Cannot use \"%s\" as a value as it doesn't return anything.", node.Name.String()))
+					return nil
 				}
-				if !TypeEquals(resultTypeInfo, expectedTypeInfo) {
-					p.AddError(node.Token, fmt.Errorf("Cannot use %s with %s \"%s\"", resultTypeInfo.String(), expectedTypeInfo.String(), node.String()))
+				return node.Definition.TypeInfo
+			}
+			return nil
+		case ast.CallHTMLNode:
+			p.AddError(node.Name, errors.CodeWrongSymbolKind, fmt.Errorf("Cannot use HTML node in expression."))
+			return nil
+		}
+		panic(fmt.Sprintf("typerExpression:Call: Unhandled call kind: %s", node.Kind()))
+	case *ast.HTMLBlock:
+		panic("typerExpression: todo(Jake): Fix HTMLBlock")
+	case *ast.TokenList:
+		return p.getTypeFromLeftHandSide(node.Tokens(), scope)
+	case *ast.Token:
+		switch node.Kind {
+		case token.Identifier:
+			name := node.String()
+			symbol := scope.GetSymbol(name)
+			if symbol == nil {
+				p.AddError(node.Token, errors.CodeUndeclaredIdentifier, fmt.Errorf("Undeclared identifier \"%s\".", name))
+				return nil
+			}
+			variableTypeInfo := symbol.variable
+			if variableTypeInfo == nil {
+				if htmlComponentDefinition := symbol.htmlDefinition; htmlComponentDefinition != nil {
+					p.AddError(node.Token, errors.CodeUndeclaredIdentifier, fmt.Errorf("Undeclared identifier \"%s\".", name)).
+						AddRelated(htmlComponentDefinition.Name, fmt.Sprintf("\"%s :: html\" is declared here", name)).
+						AddSuggestion(fmt.Sprintf("Did you mean \"%s()\" or \"%s{ }\" to reference the \"%s :: html\" component?", name, name, name))
+					return nil
 				}
-			default:
-				panic(fmt.Sprintf("typerExpression: Unhandled token kind: \"%s\" with value: %s", node.Kind.String(), node.String()))
+				p.AddError(node.Token, errors.CodeWrongSymbolKind, fmt.Errorf("Identifier \"%s\" is not a variable", name))
+				return nil
 			}
-			leftToken = node.Token
-			continue
+			return variableTypeInfo
+		case token.String:
+			return p.typeinfo.NewTypeInfoString()
+		case token.Number:
+			node.TypeInfo = p.typeinfo.NewTypeInfoInt()
+			if _, isFloat := expectedTypeInfo.(*types.Float); isFloat ||
+				strings.ContainsRune(node.Data, '.') {
+				node.TypeInfo = p.typeinfo.NewTypeInfoFloat()
+			}
+			return node.TypeInfo
+		case token.KeywordTrue, token.KeywordFalse:
+			return p.typeinfo.NewTypeInfoBool()
+		case token.KeywordNil:
+			return p.typeinfo.NewTypeInfoNil()
 		}
-		panic(fmt.Sprintf("typerExpression: Unhandled type %T", itNode))
+		panic(fmt.Sprintf("typerExpression: Unhandled token kind: \"%s\" with value: %s", node.Kind.String(), node.String()))
 	}
+	panic(fmt.Sprintf("typerExpression: Unhandled type %T", itNode))
+}
 
-	expression.TypeInfo = resultTypeInfo
+// typerOperator returns the resulting type of a binary operation, ie. "1 + 2" is an int
+// and "1 < 2" is a bool.
+func (p *Typer) typerOperator(operator *ast.Token, left types.TypeInfo, right types.TypeInfo) (types.TypeInfo, error) {
+	op := operator.String()
+	switch operator.Kind {
+	case token.ConditionalAnd, token.ConditionalOr:
+		_, leftOk := left.(*types.Bool)
+		_, rightOk := right.(*types.Bool)
+		if !leftOk || !rightOk {
+			return nil, fmt.Errorf("Cannot use \"%s\" with %s and %s, expected bool.", op, left.String(), right.String())
+		}
+		operator.TypeInfo = left
+		return left, nil
+	case token.Add,
+		token.Subtract,
+		token.Multiply,
+		token.Divide,
+		token.Modulo,
+		token.ConditionalEqual,
+		token.ConditionalNotEqual,
+		token.GreaterThan,
+		token.LessThan,
+		token.GreaterThanOrEqual,
+		token.LessThanOrEqual:
+		// Handled below
+	default:
+		return nil, fmt.Errorf("Operator \"%s\" cannot be used in an expression.", op)
+	}
+	if operator.Kind == token.ConditionalEqual || operator.Kind == token.ConditionalNotEqual {
+		// ie. "title != nil"
+		_, leftIsNil := left.(*types.Nil)
+		_, rightIsNil := right.(*types.Nil)
+		_, leftIsOptional := left.(*types.Optional)
+		_, rightIsOptional := right.(*types.Optional)
+		if (leftIsOptional && rightIsNil) || (leftIsNil && rightIsOptional) {
+			operator.TypeInfo = left
+			return p.typeinfo.NewTypeInfoBool(), nil
+		}
+		if leftIsOptional || rightIsOptional {
+			return nil, fmt.Errorf("Cannot use \"%s\" with %s and %s, optional values can only be compared with nil.", op, left.String(), right.String())
+		}
+	}
+	if !TypeEquals(left, right) {
+		return nil, fmt.Errorf("Cannot use \"%s\" with %s and %s, mismatching types.", op, left.String(), right.String())
+	}
+	isSupported := false
+	switch left.(type) {
+	case *types.Int:
+		isSupported = true
+	case *types.Float:
+		isSupported = true
+	case *types.String:
+		switch operator.Kind {
+		case token.Add,
+			token.ConditionalEqual,
+			token.ConditionalNotEqual,
+			token.GreaterThan,
+			token.LessThan,
+			token.GreaterThanOrEqual,
+			token.LessThanOrEqual:
+			isSupported = true
+		}
+	case *types.Bool:
+		isSupported = operator.Kind == token.ConditionalEqual ||
+			operator.Kind == token.ConditionalNotEqual
+	}
+	if !isSupported {
+		return nil, fmt.Errorf("Cannot use \"%s\" with %s.", op, left.String())
+	}
+	operator.TypeInfo = left
+	switch operator.Kind {
+	case token.Add,
+		token.Subtract,
+		token.Multiply,
+		token.Divide,
+		token.Modulo:
+		return left, nil
+	}
+	return p.typeinfo.NewTypeInfoBool(), nil
+}
+
+// getExpressionNodeToken returns the token to use for error messages
+func getExpressionNodeToken(node ast.Node) token.Token {
+	switch node := node.(type) {
+	case *ast.Token:
+		return node.Token
+	case *ast.TokenList:
+		return node.Tokens()[0]
+	case *ast.Call:
+		return node.Name
+	case *ast.StructLiteral:
+		return node.Name
+	case *ast.ArrayLiteral:
+		return node.TypeIdentifier.Name
+	case *ast.MapLiteral:
+		return node.TypeIdentifier.Name
+	case *ast.Index:
+		return getExpressionNodeToken(node.Value.Nodes()[0])
+	case *ast.Slice:
+		return getExpressionNodeToken(node.Value.Nodes()[0])
+	case *ast.Selector:
+		return getExpressionNodeToken(node.Value.Nodes()[0])
+	}
+	panic(fmt.Sprintf("getExpressionNodeToken: Unhandled type %T", node))
 }
 
 //func (p *Typer) typerHTMLBlock(htmlBlock *ast.HTMLBlock, scope *Scope) {
@@ -413,12 +876,12 @@ func (p *Typer) getTypeFromLeftHandSide(leftHandSideTokens []token.Token, scope
 	name := nameToken.String()
 	symbol := scope.GetSymbol(name)
 	if symbol == nil {
-		p.AddError(nameToken, fmt.Errorf("Undeclared variable \"%s\".", name))
+		p.AddError(nameToken, errors.CodeUndeclaredIdentifier, fmt.Errorf("Undeclared variable \"%s\".", name))
 		return nil
 	}
 	variableTypeInfo := symbol.variable
 	if variableTypeInfo == nil {
-		p.AddError(nameToken, fmt.Errorf("Identifier \"%s\" is not a variable", name))
+		p.AddError(nameToken, errors.CodeWrongSymbolKind, fmt.Errorf("Identifier \"%s\" is not a variable", name))
 		return nil
 	}
 	if variableTypeInfo == nil {
@@ -433,12 +896,12 @@ func (p *Typer) getTypeFromLeftHandSide(leftHandSideTokens []token.Token, scope
 		concatPropertyName.WriteString(propertyName)
 		structInfo, ok := variableTypeInfo.(*types.Struct)
 		if !ok {
-			p.AddError(nameToken, fmt.Errorf("Property \"%s\" does not exist on type \"%s\".", concatPropertyName.String(), variableTypeInfo.String()))
+			p.AddError(nameToken, errors.CodeUnknownField, fmt.Errorf("Property \"%s\" does not exist on type \"%s\".", concatPropertyName.String(), variableTypeInfo.String()))
 			return nil
 		}
 		structField := structInfo.GetFieldByName(propertyName)
 		if structField == nil {
-			p.AddError(nameToken, fmt.Errorf("Property \"%s\" does not exist on \"%s :: struct\".", concatPropertyName.String(), structInfo.Name()))
+			p.AddError(nameToken, errors.CodeUnknownField, fmt.Errorf("Property \"%s\" does not exist on \"%s :: struct\".", concatPropertyName.String(), structInfo.Name()))
 			return nil
 		}
 		variableTypeInfo = structField.TypeInfo
@@ -447,7 +910,9 @@ func (p *Typer) getTypeFromLeftHandSide(leftHandSideTokens []token.Token, scope
 }
 
 func (p *Typer) typerHTMLNode(scope *Scope, node *ast.Call) {
-	p.typerExpression(scope, &node.IfExpression)
+	if len(node.IfExpression.Nodes()) > 0 {
+		p.typerCondition(scope, &node.IfExpression)
+	}
 
 	for i, _ := range node.Parameters {
 		p.typerExpression(scope, &node.Parameters[i].Expression)
@@ -456,20 +921,38 @@ func (p *Typer) typerHTMLNode(scope *Scope, node *ast.Call) {
 	name := node.Name.String()
 	isValidHTML5TagName := util.IsValidHTML5TagName(name)
 	if isValidHTML5TagName {
+		for _, parameterNode := range node.Parameters {
+			parameterType := parameterNode.TypeInfo
+			if parameterType == nil {
+				continue
+			}
+			// nil removes the attribute and bools are boolean
+			// attributes, ie. "disabled=true" outputs "disabled"
+			attributeTypeInfo := parameterType
+			if optional, ok := attributeTypeInfo.(*types.Optional); ok {
+				attributeTypeInfo = optional.Underlying()
+			}
+			switch attributeTypeInfo.(type) {
+			case *types.String, *types.Bool, *types.Nil:
+			default:
+				p.AddError(parameterNode.Name, errors.CodeTypeMismatch, fmt.Errorf("Attribute \"%s\" must be of type string or bool, not %s.", parameterNode.Name.String(), parameterType.String()))
+			}
+		}
 		return
 	}
 	symbol := scope.GetSymbol(name)
 	if symbol == nil {
 		if name != strings.ToLower(name) {
-			p.AddError(node.Name, fmt.Errorf("\"%s\" is an undefined component. If you want to use a standard HTML5 element, the name must all be in lowercase.", name))
+			p.AddError(node.Name, errors.CodeUnknownElement, fmt.Errorf("\"%s\" is an undefined component.", name)).
+				AddSuggestion(fmt.Sprintf("If you want to use a standard HTML5 element, the name must all be in lowercase, ie. \"%s\".", strings.ToLower(name)))
 			return
 		}
-		p.AddError(node.Name, fmt.Errorf("\"%s\" is not a valid HTML5 element or defined component.", name))
+		p.AddError(node.Name, errors.CodeUnknownElement, fmt.Errorf("\"%s\" is not a valid HTML5 element or defined component.", name))
 		return
 	}
 	htmlDefinition := symbol.htmlDefinition
 	if htmlDefinition == nil {
-		p.AddError(node.Name, fmt.Errorf("Expected \"%s\" to be \"%s :: html\", not \"%s\".", name, name, symbol.GetType()))
+		p.AddError(node.Name, errors.CodeWrongSymbolKind, fmt.Errorf("Expected \"%s\" to be \"%s :: html\", not \"%s\".", name, name, symbol.GetType()))
 		return
 	}
 	//fmt.Printf("%s -- %d\n", htmlComponentDefinition.Name.String(), len(p.typerHtmlDefinitionStack))
@@ -508,17 +991,20 @@ func (p *Typer) typerHTMLNode(scope *Scope, node *ast.Call) {
 				if paramName == field.Name.String() {
 					parameterType := parameterNode.TypeInfo
 					componentStructType := field.TypeInfo
-					if parameterType != componentStructType {
+					if !TypeAssignable(componentStructType, parameterType) {
 						if field.TypeInfo == nil {
 							p.PanicMessage(fmt.Errorf("Struct field \"%s\" is missing type info.", paramName))
 							return
 						}
-						p.AddError(parameterNode.Name, fmt.Errorf("\"%s\" must be of type %s, not %s", paramName, componentStructType.String(), parameterType.String()))
+						if p.addImplicitRawHTMLError(componentStructType, &parameterNode.Expression) {
+							continue ParameterCheckLoop
+						}
+						p.AddError(parameterNode.Name, errors.CodeTypeMismatch, fmt.Errorf("\"%s\" must be of type %s, not %s", paramName, componentStructType.String(), parameterType.String()))
 					}
 					continue ParameterCheckLoop
 				}
 			}
-			p.AddError(parameterNode.Name, fmt.Errorf("\"%s\" is not a property on \"%s :: html\"", paramName, name))
+			p.AddError(parameterNode.Name, errors.CodeUnknownField, fmt.Errorf("\"%s\" is not a property on \"%s :: html\"", paramName, name))
 			continue
 		}
 	}
@@ -527,6 +1013,114 @@ func (p *Typer) typerHTMLNode(scope *Scope, node *ast.Call) {
 func (p *Typer) typerCSSDefinition(cssDef *ast.CSSDefinition) {
 	scope := NewScope(nil)
 	p.typerStatements(cssDef, scope)
+	p.typerCSSRules(cssDef.Nodes(), false, "")
+}
+
+// typerCSSRules checks that & is only used in nested rules and that
+// at-rules are used in valid places.
+func (p *Typer) typerCSSRules(nodes []ast.Node, hasParentRule bool, parentAtKeyword string) {
+	for _, node := range nodes {
+		rule, ok := node.(*ast.CSSRule)
+		if !ok {
+			continue
+		}
+		if !hasParentRule || rule.Kind() == ast.CSSKindAtKeyword {
+			for _, selector := range rule.Selectors() {
+				for _, selectorPart := range selector.Nodes() {
+					if t, ok := selectorPart.(*ast.Token); ok && t.Kind == token.And {
+						p.AddError(t.Token, errors.CodeInvalidCSS, fmt.Errorf("Cannot use & outside of a nested CSS rule."))
+					}
+				}
+			}
+		}
+		switch parentAtKeyword {
+		case "@font-face":
+			p.AddError(getCSSRuleToken(rule), errors.CodeInvalidCSS, fmt.Errorf("Cannot have nested rules inside @font-face."))
+			continue
+		case "@keyframes":
+			p.typerCSSKeyframeSelectors(rule)
+			for _, node := range rule.Nodes() {
+				if node, ok := node.(*ast.CSSRule); ok {
+					p.AddError(getCSSRuleToken(node), errors.CodeInvalidCSS, fmt.Errorf("Cannot have nested rules inside a keyframe."))
+				}
+			}
+			continue
+		}
+		if rule.Kind() == ast.CSSKindRule {
+			p.typerCSSRules(rule.Nodes(), true, parentAtKeyword)
+			continue
+		}
+
+		t := getCSSRuleToken(rule)
+		atKeyword := t.String()
+		switch atKeyword {
+		case "@media", "@supports":
+			p.typerCSSRules(rule.Nodes(), hasParentRule, parentAtKeyword)
+		case "@font-face", "@keyframes":
+			if hasParentRule || parentAtKeyword != "" {
+				p.AddError(t, errors.CodeInvalidCSS, fmt.Errorf("Cannot nest %s, it must be at the top-level of a \":: css\" block.", atKeyword))
+				continue
+			}
+			if atKeyword == "@keyframes" {
+				if selectors := rule.Selectors(); len(selectors) != 1 || !hasCSSIdentifier(selectors[0]) {
+					p.AddError(t, errors.CodeInvalidCSS, fmt.Errorf("Expected name after @keyframes, ie. \"@keyframes fade-in\"."))
+				}
+				for _, node := range rule.Nodes() {
+					if node, ok := node.(*ast.CSSProperty); ok {
+						p.AddError(node.Name, errors.CodeInvalidCSS, fmt.Errorf("Cannot have property \"%s\" directly inside @keyframes, expected keyframe selector. ie. \"from\", \"to\" or \"50%%\".", node.Name.String()))
+					}
+				}
+			}
+			p.typerCSSRules(rule.Nodes(), false, atKeyword)
+		default:
+			p.AddError(t, errors.CodeInvalidCSS, fmt.Errorf("Unknown or unsupported at-rule \"%s\". Expected @media, @supports, @font-face or @keyframes.", atKeyword))
+		}
+	}
+}
+
+// typerCSSKeyframeSelectors checks a keyframe only uses "from", "to"
+// or percentages as selectors.
+func (p *Typer) typerCSSKeyframeSelectors(rule *ast.CSSRule) {
+	for _, selector := range rule.Selectors() {
+		for _, selectorPart := range selector.Nodes() {
+			t, ok := selectorPart.(*ast.Token)
+			if ok {
+				value := t.String()
+				switch {
+				case t.Kind == token.Whitespace,
+					t.Kind == token.Identifier && (value == "from" || value == "to"),
+					t.Kind == token.NumberWithUnit && strings.HasSuffix(value, "%"):
+					continue
+				}
+			}
+			p.AddError(getCSSRuleToken(rule), errors.CodeInvalidCSS, fmt.Errorf("Invalid keyframe selector, expected \"from\", \"to\" or a percentage. ie. \"50%%\"."))
+			return
+		}
+	}
+}
+
+func hasCSSIdentifier(selector ast.CSSSelector) bool {
+	for _, selectorPart := range selector.Nodes() {
+		if t, ok := selectorPart.(*ast.Token); ok && t.Kind == token.Identifier {
+			return true
+		}
+	}
+	return false
+}
+
+// getCSSRuleToken gets the first token of a CSS rule for error reporting.
+func getCSSRuleToken(rule *ast.CSSRule) token.Token {
+	for _, selector := range rule.Selectors() {
+		for _, selectorPart := range selector.Nodes() {
+			switch selectorPart := selectorPart.(type) {
+			case *ast.Token:
+				return selectorPart.Token
+			case *ast.CSSAttributeSelector:
+				return selectorPart.Name
+			}
+		}
+	}
+	return token.Token{}
 }
 
 func (p *Typer) typerHTMLDefinition(htmlDefinition *ast.HTMLComponentDefinition, parentScope *Scope) {
@@ -550,7 +1144,7 @@ func (p *Typer) typerHTMLDefinition(htmlDefinition *ast.HTMLComponentDefinition,
 	if structDef := symbol.structDefinition; structDef != nil {
 		if htmlDefinition.Struct != nil {
 			anonymousStructDef := htmlDefinition.Struct
-			p.AddError(anonymousStructDef.Name, fmt.Errorf("Cannot have \"%s :: struct\" and embedded \":: struct\" inside \"%s :: html\"", structDef.Name.String(), htmlDefinition.Name.String()))
+			p.AddError(anonymousStructDef.Name, errors.CodeInvalidDefinition, fmt.Errorf("Cannot have \"%s :: struct\" and embedded \":: struct\" inside \"%s :: html\"", structDef.Name.String(), htmlDefinition.Name.String()))
 		} else {
 			htmlDefinition.Struct = structDef
 		}
@@ -576,19 +1170,19 @@ func (p *Typer) typerHTMLDefinition(htmlDefinition *ast.HTMLComponentDefinition,
 			name := propertyNode.Name.String()
 			if symbol := scope.GetSymbol(name); symbol != nil {
 				if name == "children" {
-					p.AddError(propertyNode.Name, fmt.Errorf("Cannot use \"children\" as it's a reserved property."))
+					p.AddError(propertyNode.Name, errors.CodeReservedName, fmt.Errorf("Cannot use \"children\" as it's a reserved property."))
 					continue
 				}
-				p.AddError(propertyNode.Name, fmt.Errorf("Property \"%s\" declared twice.", name))
+				p.AddError(propertyNode.Name, errors.CodeRedeclared, fmt.Errorf("Property \"%s\" declared twice.", name))
 				continue
 			}
 			/*_, ok := scope.GetVariable(name)
 			if ok {
 				if name == "children" {
-					p.AddError(propertyNode.Name, fmt.Errorf("Cannot use \"children\" as it's a reserved property."))
+					p.AddError(propertyNode.Name, errors.CodeReservedName, fmt.Errorf("Cannot use \"children\" as it's a reserved property."))
 					continue
 				}
-				p.AddError(propertyNode.Name, fmt.Errorf("Property \"%s\" declared twice.", name))
+				p.AddError(propertyNode.Name, errors.CodeRedeclared, fmt.Errorf("Property \"%s\" declared twice.", name))
 				continue
 			}*/
 			scope.SetVariable(name, propertyNode.TypeInfo)
@@ -605,8 +1199,33 @@ func (p *Typer) typerHTMLDefinition(htmlDefinition *ast.HTMLComponentDefinition,
 }
 
 func (p *Typer) typerCSSProperty(property *ast.CSSProperty, scope *Scope) {
+	p.typerCSSPropertyValue(property.Nodes(), scope)
 	for _, node := range property.Nodes() {
+		expression, ok := node.(*ast.Expression)
+		if !ok || expression.TypeInfo == nil {
+			continue
+		}
+		switch typeInfo := expression.TypeInfo; typeInfo.(type) {
+		case *types.String, *types.Int, *types.Float,
+			*types.Length, *types.Percentage, *types.Color:
+			// no-op, valid property value type
+		default:
+			p.AddError(property.Name, errors.CodeTypeMismatch, fmt.Errorf("Cannot use %s as the value of CSS property \"%s\".", typeInfo.String(), property.Name.String()))
+		}
+	}
+}
+
+func (p *Typer) typerCSSPropertyValue(nodes []ast.Node, scope *Scope) {
+	for _, node := range nodes {
 		switch node := node.(type) {
+		case *ast.CSSSelector:
+			// ie. "url("font.woff")"
+			p.typerCSSPropertyValue(node.Nodes(), scope)
+		case *ast.Expression:
+			// ie. "padding: base * 2"
+			if !p.typerCSSExpression(scope, node, true) {
+				p.typerExpression(scope, node)
+			}
 		case *ast.TokenList:
 			panic("todo: Handle typechecking of property vars, ie. myval.property")
 			//opcodes, _ = emit.emitVariableIdentWithProperty(opcodes, node.Tokens())
@@ -617,7 +1236,12 @@ func (p *Typer) typerCSSProperty(property *ast.CSSProperty, scope *Scope) {
 			switch t.Kind {
 			case token.Identifier,
 				token.Number,
-				token.String:
+				token.NumberWithUnit,
+				token.String,
+				token.Divide,
+				token.Whitespace,
+				token.Comma,
+				token.Colon:
 				// no-op, valid token kind
 			default: // ie. number, string
 				panic(fmt.Sprintf("emitCSSProperty: Unhandled token kind: %s", node.Kind.String()))
@@ -630,6 +1254,7 @@ func (p *Typer) typerCSSProperty(property *ast.CSSProperty, scope *Scope) {
 
 func (p *Typer) typerStatements(topNode ast.Node, scope *Scope) {
 	nodeStack := make([]ast.Node, 0, 50)
+	nonNilScopes := make(map[ast.Node][]string) // variables that can't be nil in a node's scope
 	nodes := topNode.Nodes()
 	for i := len(nodes) - 1; i >= 0; i-- {
 		node := nodes[i]
@@ -666,8 +1291,27 @@ func (p *Typer) typerStatements(topNode ast.Node, scope *Scope) {
 				continue
 			}
 			p.typerWorkspaceDefinition(scope, node)
+			// Child nodes were checked in the workspace's own scope
+			continue
 		case *ast.Call:
 			p.typerCall(scope, node)
+			if len(node.IfExpression.Nodes()) > 0 {
+				// ie. "div if title != nil { title }"
+				nonNilScopes[node] = nonNilIdentifiers(&node.IfExpression, false)
+			}
+			if len(node.ElseNodes) > 0 {
+				// Typecheck else nodes in their own scope after this HTML node
+				elseBlock := new(ast.Block)
+				elseBlock.ChildNodes = node.ElseNodes
+				nodeStack = append(nodeStack, elseBlock)
+				nonNilScopes[elseBlock] = nonNilIdentifiers(&node.IfExpression, true)
+			}
+			if node.Kind() == ast.CallProcedure && !p.inProcedure {
+				// The result is output, ie. "span { formatTitle(title) }"
+				if typeInfo, ok := callResultType(node).(*types.Optional); ok {
+					p.addOptionalOutputError(node.Name, typeInfo)
+				}
+			}
 		case *ast.HTMLBlock:
 			panic("todo(Jake): Remove below commented out line if this is unused.")
 			//p.typerHTMLBlock(node, scope)
@@ -687,18 +1331,32 @@ func (p *Typer) typerStatements(topNode ast.Node, scope *Scope) {
 				//
 				// Retrieve the whole string for the token list and use instead of "name"
 				//
-				p.AddError(nameToken, fmt.Errorf("Cannot do \"%s []=\" as it's not an array type.", name))
+				p.AddError(nameToken, errors.CodeTypeMismatch, fmt.Errorf("Cannot do \"%s []=\" as it's not an array type.", name))
 				continue
 			}
 			p.typerExpression(scope, &node.Expression)
 			resultTypeInfo := node.Expression.TypeInfo
-			if !TypeEquals(variableTypeInfo.Underlying(), resultTypeInfo) {
+			if !TypeAssignable(variableTypeInfo.Underlying(), resultTypeInfo) {
 				// todo(Jake): 2017-12-25
 				//
 				// test these... as the error messages are untested
 				//
-				p.AddError(nameToken, fmt.Errorf("Cannot change \"%s\" from %s to %s", name, variableTypeInfo, resultTypeInfo.String()))
-				p.AddError(nameToken, fmt.Errorf("Cannot change \"%s\" from %s to %s", name, variableTypeInfo, resultTypeInfo.String()))
+				p.AddError(nameToken, errors.CodeTypeMismatch, fmt.Errorf("Cannot change \"%s\" from %s to %s", name, variableTypeInfo, resultTypeInfo.String()))
+			}
+			continue
+		case *ast.IndexAssignStatement:
+			p.typerIndex(scope, node.Index)
+			// Set the expected type so number literals are inferred, ie. "1" as a float
+			node.Expression.TypeInfo = node.Index.TypeInfo
+			p.typerExpression(scope, &node.Expression)
+			elementTypeInfo := node.Index.TypeInfo
+			resultTypeInfo := node.Expression.TypeInfo
+			if elementTypeInfo == nil || resultTypeInfo == nil {
+				// Error should be reported in typerIndex() or typerExpression()
+				continue
+			}
+			if !TypeAssignable(elementTypeInfo, resultTypeInfo) {
+				p.AddError(node.Index.Bracket, errors.CodeTypeMismatch, fmt.Errorf("Cannot assign %s to an element of %s.", resultTypeInfo.String(), node.Index.Value.TypeInfo.String()))
 			}
 			continue
 		case *ast.OpStatement:
@@ -706,9 +1364,20 @@ func (p *Typer) typerStatements(topNode ast.Node, scope *Scope) {
 			if variableTypeInfo == nil {
 				continue
 			}
+			if operatorKind := node.Operator.Kind.AssignOperator(); operatorKind != token.Unknown {
+				// ie. "+=" is checked like "+"
+				operator := &ast.Token{Token: node.Operator}
+				operator.Kind = operatorKind
+				if _, err := p.typerOperator(operator, variableTypeInfo, variableTypeInfo); err != nil {
+					p.AddError(node.Operator, errors.CodeTypeMismatch, err)
+					continue
+				}
+				// ie. "1" is a float in "width += 1"
+				node.Expression.TypeInfo = variableTypeInfo
+			}
 			p.typerExpression(scope, &node.Expression)
 			resultTypeInfo := node.Expression.TypeInfo
-			if !TypeEquals(variableTypeInfo, resultTypeInfo) {
+			if !TypeAssignable(variableTypeInfo, resultTypeInfo) {
 				nameToken := node.LeftHandSide[0]
 				name := nameToken.String()
 				for i := 1; i < len(node.LeftHandSide); i++ {
@@ -722,30 +1391,54 @@ func (p *Typer) typerStatements(topNode ast.Node, scope *Scope) {
 					p.PanicError(nameToken, fmt.Errorf("\"resultTypeInfo\" is nil, right-side of \"%s\" should have type info.", name))
 					continue
 				}
-				p.AddError(nameToken, fmt.Errorf("Cannot change \"%s\" from %s to %s", name, variableTypeInfo, resultTypeInfo.String()))
+				// NOTE: Setting it to nil would need the variable to be optional again
+				// for the rest of the block, including earlier statements in a loop,
+				// so this isn't supported.
+				if symbol := scope.GetSymbol(name); symbol != nil && symbol.nonNilOptional != nil &&
+					TypeAssignable(symbol.nonNilOptional, resultTypeInfo) {
+					p.AddError(nameToken, errors.CodeTypeMismatch, fmt.Errorf("Cannot set \"%s\" to %s here as it was checked against nil.", name, resultTypeInfo.String())).
+						AddSuggestion(fmt.Sprintf("Set \"%s\" outside of the if-statement that checks it.", name))
+					continue
+				}
+				p.AddError(nameToken, errors.CodeTypeMismatch, fmt.Errorf("Cannot change \"%s\" from %s to %s", name, variableTypeInfo, resultTypeInfo.String()))
 			}
 			continue
 		case *ast.DeclareStatement:
 			expr := &node.Expression
 			p.typerExpression(scope, expr)
 			name := node.Name.String()
+			if _, ok := expr.TypeInfo.(*types.Nil); ok {
+				p.AddError(node.Name, errors.CodeTypeMismatch, fmt.Errorf("Cannot infer the type of \"%s\" from nil.", name)).
+					AddSuggestion(fmt.Sprintf("Declare it with an optional type, ie. \"%s : ?string = nil\".", name))
+			}
 			if symbol := scope.GetSymbolFromThisScope(name); symbol != nil {
-				p.AddError(node.Name, fmt.Errorf("Cannot redeclare \"%s\".", name))
+				p.AddError(node.Name, errors.CodeRedeclared, fmt.Errorf("Cannot redeclare \"%s\".", name))
 				continue
 			}
 			scope.SetVariable(name, expr.TypeInfo)
 			continue
 		case *ast.Expression:
 			p.typerExpression(scope, node)
+			if typeInfo, ok := node.TypeInfo.(*types.Optional); ok {
+				p.addOptionalOutputError(getExpressionNodeToken(node.Nodes()[0]), typeInfo)
+			}
 			continue
 		case *ast.If:
-			expr := &node.Condition
-			//expr.TypeInfo = types.Bool()
-			p.typerExpression(scope, expr)
+			p.typerCondition(scope, &node.Condition)
+
+			// Add else children, these are typechecked in their own scope
+			// after the if true children.
+			if len(node.ElseNodes) > 0 {
+				elseBlock := new(ast.Block)
+				elseBlock.ChildNodes = node.ElseNodes
+				nodeStack = append(nodeStack, elseBlock)
+				nonNilScopes[elseBlock] = nonNilIdentifiers(&node.Condition, true)
+			}
 
 			scope = NewScope(scope)
 			nodeStack = append(nodeStack, nil)
 			avoidNestingScopeThisIteration = true
+			setNonNil(scope, nonNilIdentifiers(&node.Condition, false))
 
 			// Add if true children
 			{
@@ -754,13 +1447,6 @@ func (p *Typer) typerStatements(topNode ast.Node, scope *Scope) {
 					nodeStack = append(nodeStack, nodes[i])
 				}
 			}
-			{
-				// Add else children
-				nodes := node.ElseNodes
-				for i := len(nodes) - 1; i >= 0; i-- {
-					nodeStack = append(nodeStack, nodes[i])
-				}
-			}
 			continue
 		case *ast.CSSProperty:
 			p.typerCSSProperty(node, scope)
@@ -774,9 +1460,21 @@ func (p *Typer) typerStatements(topNode ast.Node, scope *Scope) {
 			}
 			p.typerExpression(scope, &node.Array)
 			iTypeInfo := node.Array.TypeInfo
-			typeInfo, ok := iTypeInfo.(*types.Array)
-			if !ok {
-				p.AddError(node.RecordName, fmt.Errorf("Cannot use type %s as array.", iTypeInfo.String()))
+			if iTypeInfo == nil {
+				// Error should be reported in typerExpression()
+				continue
+			}
+			// ie. "for i, record := array" or "for key, value := map"
+			var indexTypeInfo, recordTypeInfo types.TypeInfo
+			switch typeInfo := iTypeInfo.(type) {
+			case *types.Array:
+				indexTypeInfo = p.typeinfo.NewTypeInfoInt()
+				recordTypeInfo = typeInfo.Underlying()
+			case *types.Map:
+				indexTypeInfo = p.typeinfo.NewTypeInfoString()
+				recordTypeInfo = typeInfo.Underlying()
+			default:
+				p.AddError(node.RecordName, errors.CodeTypeMismatch, fmt.Errorf("Cannot use type %s as array or map.", iTypeInfo.String()))
 				continue
 			}
 			if node.IsDeclareSet {
@@ -795,19 +1493,19 @@ func (p *Typer) typerStatements(topNode ast.Node, scope *Scope) {
 			if node.IndexName.Kind != token.Unknown {
 				indexName := node.IndexName.String()
 				if scope := scope.GetSymbolFromThisScope(indexName); scope != nil {
-					p.AddError(node.IndexName, fmt.Errorf("Cannot redeclare \"%s\" in for-loop.", indexName))
+					p.AddError(node.IndexName, errors.CodeRedeclared, fmt.Errorf("Cannot redeclare \"%s\" in for-loop.", indexName))
 					continue
 				}
-				scope.SetVariable(indexName, p.typeinfo.NewTypeInfoInt())
+				scope.SetVariable(indexName, indexTypeInfo)
 			}
 
 			// Set left-hand value type
 			name := node.RecordName.String()
 			if scope := scope.GetSymbolFromThisScope(name); scope != nil {
-				p.AddError(node.RecordName, fmt.Errorf("Cannot redeclare \"%s\" in for-loop.", name))
+				p.AddError(node.RecordName, errors.CodeRedeclared, fmt.Errorf("Cannot redeclare \"%s\" in for-loop.", name))
 				continue
 			}
-			scope.SetVariable(name, typeInfo.Underlying())
+			scope.SetVariable(name, recordTypeInfo)
 		default:
 			panic(fmt.Sprintf("TypecheckStatements: Unknown type %T", node))
 		}
@@ -816,6 +1514,7 @@ func (p *Typer) typerStatements(topNode ast.Node, scope *Scope) {
 		if !avoidNestingScopeThisIteration {
 			scope = NewScope(scope)
 			nodeStack = append(nodeStack, nil)
+			setNonNil(scope, nonNilScopes[itNode])
 		}
 
 		// Add children
@@ -832,13 +1531,13 @@ func (p *Typer) typerStruct(node *ast.StructDefinition, scope *Scope) {
 		structField := &node.Fields[i]
 		typeIdent := structField.TypeIdentifier.Name
 		if typeIdent.Kind == token.Unknown {
-			p.AddError(structField.Name, fmt.Errorf("Missing type identifier on \"%s :: struct\" field \"%s\"", structField.Name, node.Name.String()))
+			p.AddError(structField.Name, errors.CodeInvalidDefinition, fmt.Errorf("Missing type identifier on \"%s :: struct\" field \"%s\"", structField.Name, node.Name.String()))
 			continue
 		}
 		typeIdentString := typeIdent.String()
 		resultTypeInfo := p.DetermineType(&structField.TypeIdentifier)
 		if resultTypeInfo == nil {
-			p.AddError(typeIdent, fmt.Errorf("Undeclared type %s", typeIdentString))
+			p.AddError(typeIdent, errors.CodeUndeclaredType, fmt.Errorf("Undeclared type %s", typeIdentString))
 			return
 		}
 		structField.TypeInfo = resultTypeInfo
@@ -853,37 +1552,65 @@ func (p *Typer) typerStruct(node *ast.StructDefinition, scope *Scope) {
 	}
 }
 
-func (p *Typer) typerProcedureDefinition(node *ast.ProcedureDefinition, scope *Scope) {
+// typerProcedureSignature determines the parameter and return types of a
+// procedure and declares it, so it can be called before it's defined or
+// recursively. It returns false if the procedure couldn't be declared.
+func (p *Typer) typerProcedureSignature(node *ast.ProcedureDefinition, scope *Scope) bool {
 	name := node.Name.String()
 	symbol := scope.getOrCreateSymbol(name)
 	if typeInfo := symbol.variable; typeInfo != nil {
 		errorMessage := fmt.Errorf("Cannot redeclare \"%s :: ()\" more than once in global scope.", name)
-		//p.AddError(symbol..Name, errorMessage)
-		p.AddError(node.Name, errorMessage)
-		return
+		p.AddError(node.Name, errors.CodeRedeclared, errorMessage)
+		return false
 	}
 
-	scope = NewScope(scope)
+	hasErrors := false
 	for i := 0; i < len(node.Parameters); i++ {
 		parameter := &node.Parameters[i]
 		typeinfo := p.DetermineType(&parameter.TypeIdentifier)
 		if typeinfo == nil {
-			p.AddError(parameter.TypeIdentifier.Name, fmt.Errorf("Unknown type %s on parameter %s", parameter.TypeIdentifier.String(), parameter.Name))
+			p.AddError(parameter.TypeIdentifier.Name, errors.CodeUndeclaredType, fmt.Errorf("Unknown type %s on parameter %s", parameter.TypeIdentifier.String(), parameter.Name))
+			hasErrors = true
 			continue
 		}
 		parameter.TypeInfo = typeinfo
-		scope.SetVariable(parameter.Name.String(), typeinfo)
 	}
-	if p.HasErrors() {
-		return
+	if node.TypeIdentifier.Name.Kind != token.Unknown {
+		node.TypeInfo = p.DetermineType(&node.TypeIdentifier)
+		if node.TypeInfo == nil {
+			p.AddError(node.TypeIdentifier.Name, errors.CodeUndeclaredType, fmt.Errorf("Unknown return type %s on \"%s\"", node.TypeIdentifier.String(), name))
+			hasErrors = true
+		}
+	}
+	if hasErrors {
+		return false
+	}
+
+	functionType := p.typeinfo.NewProcedureInfo(node)
+	symbol.variable = functionType
+	p.typeinfo.register(name, functionType)
+	return true
+}
+
+func (p *Typer) typerProcedureDefinition(node *ast.ProcedureDefinition, scope *Scope) {
+	oldInProcedure := p.inProcedure
+	p.inProcedure = true
+	defer func() {
+		p.inProcedure = oldInProcedure
+	}()
+	scope = NewScope(scope)
+	for i := 0; i < len(node.Parameters); i++ {
+		parameter := &node.Parameters[i]
+		scope.SetVariable(parameter.Name.String(), parameter.TypeInfo)
 	}
 	p.typerStatements(node, scope)
+	p.checkProcedureOutput(node.Nodes())
 
-	var returnType types.TypeInfo
-	if node.TypeIdentifier.Name.Kind != token.Unknown {
-		returnType = p.DetermineType(&node.TypeIdentifier)
-		node.TypeInfo = returnType
+	returnType := node.TypeInfo
+	if returnType != nil && !statementsReturn(node.Nodes()) {
+		p.AddError(node.Name, errors.CodeReturnMismatch, fmt.Errorf("Missing return at end of \"%s\", expected to return %s.", node.Name.String(), returnType.String()))
 	}
+
 	// Check return statements
 	// NOTE(Jake): 2017-12-30
 	//
@@ -902,19 +1629,23 @@ func (p *Typer) typerProcedureDefinition(node *ast.ProcedureDefinition, scope *S
 
 		returnNode, ok := node.(*ast.Return)
 		if ok {
-			if TypeEquals(returnType, returnNode.TypeInfo) {
+			if TypeAssignable(returnType, returnNode.TypeInfo) {
+				continue
+			}
+			if returnNode.TypeInfo == nil && len(returnNode.Nodes()) > 0 {
+				// An error was already reported for the expression
 				continue
 			}
 			t := returnNode.TypeIdentifier.Name
 			if returnType == nil {
-				p.AddError(t, fmt.Errorf("Return statement %s doesn't match procedure type void", returnNode.TypeInfo.String()))
+				p.AddError(t, errors.CodeReturnMismatch, fmt.Errorf("Return statement %s doesn't match procedure type void", returnNode.TypeInfo.String()))
 				continue
 			}
 			if returnNode.TypeInfo == nil {
-				p.AddError(t, fmt.Errorf("Return statement void doesn't match procedure type %s", returnType.String()))
+				p.AddError(t, errors.CodeReturnMismatch, fmt.Errorf("Return statement void doesn't match procedure type %s", returnType.String()))
 				continue
 			}
-			p.AddError(t, fmt.Errorf("Return statement %s doesn't match procedure type %s", returnNode.TypeInfo.String(), returnType.String()))
+			p.AddError(t, errors.CodeReturnMismatch, fmt.Errorf("Return statement %s doesn't match procedure type %s", returnNode.TypeInfo.String(), returnType.String()))
 			continue
 		}
 
@@ -923,13 +1654,56 @@ func (p *Typer) typerProcedureDefinition(node *ast.ProcedureDefinition, scope *S
 			nodeStack = append(nodeStack, nodes[i])
 		}
 	}
+}
 
-	functionType := p.typeinfo.NewProcedureInfo(node)
-	symbol.variable = functionType
-	p.typeinfo.register(node.Name.String(), functionType)
+// statementsReturn is true if every path through the statements
+// ends with a return, ie. "if x { return a } else { return b }"
+func statementsReturn(nodes []ast.Node) bool {
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *ast.Return:
+			return true
+		case *ast.Block:
+			if statementsReturn(node.Nodes()) {
+				return true
+			}
+		case *ast.If:
+			if len(node.ElseNodes) > 0 &&
+				statementsReturn(node.Nodes()) &&
+				statementsReturn(node.ElseNodes) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkProcedureOutput adds an error for HTML nodes and text in a procedure
+// body as procedures can only return values.
+func (p *Typer) checkProcedureOutput(nodes []ast.Node) {
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *ast.Call:
+			if node.Kind() == ast.CallHTMLNode {
+				p.AddError(node.Name, errors.CodeInvalidDefinition, fmt.Errorf("Cannot output HTML in a procedure, return a value instead."))
+			}
+		case *ast.Expression:
+			t := getExpressionNodeToken(node.Nodes()[0])
+			p.AddError(t, errors.CodeInvalidDefinition, fmt.Errorf("Cannot output HTML in a procedure, return a value instead."))
+		case *ast.Block:
+			p.checkProcedureOutput(node.Nodes())
+		case *ast.For:
+			p.checkProcedureOutput(node.Nodes())
+		case *ast.If:
+			p.checkProcedureOutput(node.Nodes())
+			p.checkProcedureOutput(node.ElseNodes)
+		}
+	}
 }
 
 func (p *Typer) typerWorkspaceDefinition(scope *Scope, node *ast.WorkspaceDefinition) {
+	// Each workspace has its own "workspace" variable
+	scope = NewScope(scope)
 	node.WorkspaceTypeInfo = p.typeinfo.InternalWorkspaceStruct()
 	scope.SetVariable("workspace", node.WorkspaceTypeInfo)
 	p.typerStatements(node, scope)
@@ -945,6 +1719,8 @@ func (p *Typer) ApplyTypeInfoAndTypecheck(files []*ast.File) {
 
 	//
 	globalScopeHtmlDefinitions := make([]*ast.HTMLComponentDefinition, 0, 10)
+	globalScopeProcedureDefinitions := make([]*ast.ProcedureDefinition, 0, 10)
+	globalScopeCssDefinitions := make([]*ast.CSSDefinition, 0, 10)
 	globalScopeCssConfigDefinitions := make([]*ast.CSSConfigDefinition, 0, 10)
 
 	// Get all global/top-level identifiers
@@ -955,6 +1731,7 @@ func (p *Typer) ApplyTypeInfoAndTypecheck(files []*ast.File) {
 			case *ast.DeclareStatement,
 				*ast.OpStatement,
 				*ast.ArrayAppendStatement,
+				*ast.IndexAssignStatement,
 				*ast.Expression,
 				*ast.If,
 				*ast.For,
@@ -969,22 +1746,22 @@ func (p *Typer) ApplyTypeInfoAndTypecheck(files []*ast.File) {
 					p.PanicMessage(fmt.Errorf("Found nil top-level %T.", node))
 					continue
 				}
-				p.typerProcedureDefinition(node, scope)
+				globalScopeProcedureDefinitions = append(globalScopeProcedureDefinitions, node)
 			case *ast.StructDefinition:
 				if node == nil {
 					p.PanicMessage(fmt.Errorf("Found nil top-level %T.", node))
 					continue
 				}
 				if node.Name.Kind == token.Unknown {
-					p.AddError(node.Name, fmt.Errorf("Cannot declare anonymous \":: struct\" block."))
+					p.AddError(node.Name, errors.CodeInvalidDefinition, fmt.Errorf("Cannot declare anonymous \":: struct\" block."))
 					continue
 				}
 				name := node.Name.String()
 				symbol := scope.getOrCreateSymbol(name)
 				if definition := symbol.structDefinition; definition != nil {
 					errorMessage := fmt.Errorf("Cannot redeclare \"%s :: struct\" more than once in global scope.", name)
-					p.AddError(definition.Name, errorMessage)
-					p.AddError(node.Name, errorMessage)
+					p.AddError(definition.Name, errors.CodeRedeclared, errorMessage).AddRelated(node.Name, "redeclared here")
+					p.AddError(node.Name, errors.CodeRedeclared, errorMessage).AddRelated(definition.Name, "previously declared here")
 					continue
 				}
 				p.typerStruct(node, scope)
@@ -996,15 +1773,15 @@ func (p *Typer) ApplyTypeInfoAndTypecheck(files []*ast.File) {
 					continue
 				}
 				if node.Name.Kind == token.Unknown {
-					p.AddError(node.Name, fmt.Errorf("Cannot declare anonymous \":: html\" block."))
+					p.AddError(node.Name, errors.CodeInvalidDefinition, fmt.Errorf("Cannot declare anonymous \":: html\" block."))
 					continue
 				}
 				name := node.Name.String()
 				symbol := scope.getOrCreateSymbol(name)
 				if definition := symbol.htmlDefinition; definition != nil {
 					errorMessage := fmt.Errorf("Cannot redeclare \"%s :: html\" more than once in global scope.", name)
-					p.AddError(definition.Name, errorMessage)
-					p.AddError(node.Name, errorMessage)
+					p.AddError(definition.Name, errors.CodeRedeclared, errorMessage).AddRelated(node.Name, "redeclared here")
+					p.AddError(node.Name, errors.CodeRedeclared, errorMessage).AddRelated(definition.Name, "previously declared here")
 					continue
 				}
 				symbol.htmlDefinition = node
@@ -1015,33 +1792,35 @@ func (p *Typer) ApplyTypeInfoAndTypecheck(files []*ast.File) {
 					continue
 				}
 				if node.Name.Kind == token.Unknown {
+					// Anonymous ":: css" block, ie. in a template
+					globalScopeCssDefinitions = append(globalScopeCssDefinitions, node)
 					continue
 				}
 				name := node.Name.String()
 				symbol := scope.getOrCreateSymbol(name)
 				if definition := symbol.cssDefinition; definition != nil {
 					errorMessage := fmt.Errorf("Cannot redeclare \"%s :: html\" more than once in global scope.", name)
-					p.AddError(definition.Name, errorMessage)
-					p.AddError(node.Name, errorMessage)
+					p.AddError(definition.Name, errors.CodeRedeclared, errorMessage).AddRelated(node.Name, "redeclared here")
+					p.AddError(node.Name, errors.CodeRedeclared, errorMessage).AddRelated(definition.Name, "previously declared here")
 					continue
 				}
-				p.typerCSSDefinition(node)
 				symbol.cssDefinition = node
+				globalScopeCssDefinitions = append(globalScopeCssDefinitions, node)
 			case *ast.CSSConfigDefinition:
 				if node == nil {
 					p.PanicMessage(fmt.Errorf("Found nil top-level %T.", node))
 					continue
 				}
 				if node.Name.Kind == token.Unknown {
-					p.AddError(node.Name, fmt.Errorf("Cannot declare anonymous \":: css_config\" block."))
+					p.AddError(node.Name, errors.CodeInvalidDefinition, fmt.Errorf("Cannot declare anonymous \":: css_config\" block."))
 					continue
 				}
 				name := node.Name.String()
 				symbol := scope.getOrCreateSymbol(name)
 				if definition := symbol.cssConfigDefinition; definition != nil {
 					errorMessage := fmt.Errorf("Cannot redeclare \"%s :: css_config\" more than once in global scope.", name)
-					p.AddError(definition.Name, errorMessage)
-					p.AddError(node.Name, errorMessage)
+					p.AddError(definition.Name, errors.CodeRedeclared, errorMessage).AddRelated(node.Name, "redeclared here")
+					p.AddError(node.Name, errors.CodeRedeclared, errorMessage).AddRelated(definition.Name, "previously declared here")
 					continue
 				}
 				symbol.cssConfigDefinition = node
@@ -1052,6 +1831,25 @@ func (p *Typer) ApplyTypeInfoAndTypecheck(files []*ast.File) {
 		}
 	}
 
+	// Declare procedures once all structs are known, then check their
+	// bodies so they can call each other in any order.
+	{
+		declared := make([]*ast.ProcedureDefinition, 0, len(globalScopeProcedureDefinitions))
+		for _, procedureDefinition := range globalScopeProcedureDefinitions {
+			if p.typerProcedureSignature(procedureDefinition, globalScope) {
+				declared = append(declared, procedureDefinition)
+			}
+		}
+		for _, procedureDefinition := range declared {
+			p.typerProcedureDefinition(procedureDefinition, globalScope)
+		}
+	}
+
+	// CSS property values can call procedures
+	for _, cssDefinition := range globalScopeCssDefinitions {
+		p.typerCSSDefinition(cssDefinition)
+	}
+
 	//
 	for _, htmlDefinition := range globalScopeHtmlDefinitions {
 		p.typerHTMLDefinition(htmlDefinition, globalScope)
@@ -1067,7 +1865,7 @@ func (p *Typer) ApplyTypeInfoAndTypecheck(files []*ast.File) {
 		hasCSSDefinition := symbol != nil &&
 			symbol.cssDefinition != nil
 		if !hasCSSDefinition || !hasHTMLDefinition {
-			p.AddError(cssConfigDefinition.Name, fmt.Errorf("\"%s :: css_config\" requires both a matching \":: css\" or \":: html\" definition.", name))
+			p.AddError(cssConfigDefinition.Name, errors.CodeInvalidCSSConfig, fmt.Errorf("\"%s :: css_config\" requires both a matching \":: css\" or \":: html\" definition.", name))
 		}
 	}
 
@@ -1113,7 +1911,7 @@ func (p *Typer) ApplyTypeInfoAndTypecheck(files []*ast.File) {
 		// Add a better dependency solver wherein it has detailed information
 		// about what isn't allowed, rather than a vague message.
 		//
-		p.AddError(node.Name, fmt.Errorf("Cannot use \"%s\". Cyclic references are not allowed.", name))
+		p.AddError(node.Name, errors.CodeCyclicReference, fmt.Errorf("Cannot use \"%s\". Cyclic references are not allowed.", name))
 	}
 
 	// Typecheck
@@ -1127,13 +1925,13 @@ func (p *Typer) ApplyTypeInfoAndTypecheck(files []*ast.File) {
 	if symbol := scope.GetSymbolFromThisScope(name); symbol != nil {
 		errorMessage := fmt.Errorf("Cannot redeclare \"%s\" more than once in global scope.", name)
 		if structDef := symbol.structDefinition; structDef != nil {
-			p.AddError(structDef.Name, errorMessage)
+			p.AddError(structDef.Name, errors.CodeRedeclared, errorMessage)
 		} else if htmlDef := symbol.htmlDefinition; htmlDef != nil {
-			p.AddError(htmlDef.Name, errorMessage)
+			p.AddError(htmlDef.Name, errors.CodeRedeclared, errorMessage)
 		} else if cssDef := symbol.cssDefinition; cssDef != nil {
-			p.AddError(cssDef.Name, errorMessage)
+			p.AddError(cssDef.Name, errors.CodeRedeclared, errorMessage)
 		}
-		p.AddError(nameToken, errorMessage)
+		p.AddError(nameToken, errors.CodeRedeclared, errorMessage)
 		return true
 	}
 	return false
@@ -0,0 +1,118 @@
+package types
+
+//
+// Length
+//
+
+// Length is a number with a unit, ie. 10px, 2em, 0.5s
+type Length struct {
+	unit     string
+	category string
+}
+
+func NewLength(unit string) *Length {
+	info := new(Length)
+	info.unit = unit
+	info.category = CSSUnitCategory(unit)
+	return info
+}
+
+// NewCalcLength is a length that mixes units and must be
+// output with calc(), ie. "100% - 20px"
+func NewCalcLength(category string) *Length {
+	info := new(Length)
+	info.category = category
+	return info
+}
+
+func (info *Length) String() string {
+	if info.unit == "" {
+		return "length"
+	}
+	return "length(" + info.unit + ")"
+}
+func (info *Length) Unit() string     { return info.unit }
+func (info *Length) Category() string { return info.category }
+func (info *Length) IsCalc() bool     { return info.unit == "" }
+func (_ *Length) ImplementsTypeInfo() {}
+
+//
+// Percentage
+//
+
+type Percentage struct{}
+
+func (_ *Percentage) String() string      { return "percentage" }
+func (_ *Percentage) ImplementsTypeInfo() {}
+
+//
+// Color
+//
+
+type Color struct{}
+
+func (_ *Color) String() string      { return "color" }
+func (_ *Color) ImplementsTypeInfo() {}
+
+//
+// Keyword
+//
+
+// Keyword is an unquoted CSS identifier, ie. auto, solid, inherit
+type Keyword struct{}
+
+func (_ *Keyword) String() string      { return "keyword" }
+func (_ *Keyword) ImplementsTypeInfo() {}
+
+var cssUnitCategories = map[string]string{
+	// Length
+	"px":   "length",
+	"em":   "length",
+	"rem":  "length",
+	"ex":   "length",
+	"ch":   "length",
+	"vw":   "length",
+	"vh":   "length",
+	"vmin": "length",
+	"vmax": "length",
+	"cm":   "length",
+	"mm":   "length",
+	"q":    "length",
+	"in":   "length",
+	"pt":   "length",
+	"pc":   "length",
+	"%":    "length",
+	// Time
+	"s":  "time",
+	"ms": "time",
+	// Angle
+	"deg":  "angle",
+	"rad":  "angle",
+	"grad": "angle",
+	"turn": "angle",
+	// Frequency
+	"hz":  "frequency",
+	"khz": "frequency",
+	// Resolution
+	"dpi":  "resolution",
+	"dpcm": "resolution",
+	"dppx": "resolution",
+	// Flex
+	"fr": "flex",
+}
+
+// CSSUnitCategory returns what kind of unit it is, ie. "length", "time", "angle".
+// Units of the same category can be mixed with calc(). Returns an empty
+// string if the unit is unknown.
+func CSSUnitCategory(unit string) string {
+	return cssUnitCategories[unit]
+}
+
+// IsCSSValue returns true if the type is a CSS value type.
+func IsCSSValue(typeInfo TypeInfo) bool {
+	switch typeInfo.(type) {
+	case *Length, *Percentage, *Color, *Keyword:
+		return true
+	}
+	return false
+}
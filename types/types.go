@@ -45,6 +45,15 @@ type String struct{}
 func (_ *String) String() string      { return "string" }
 func (_ *String) ImplementsTypeInfo() {}
 
+//
+// Raw HTML
+//
+
+type RawHTML struct{}
+
+func (_ *RawHTML) String() string      { return "rawhtml" }
+func (_ *RawHTML) ImplementsTypeInfo() {}
+
 //
 // Array
 //
@@ -63,6 +72,52 @@ func (info *Array) String() string       { return "[]" + info.underlying.String(
 func (info *Array) Underlying() TypeInfo { return info.underlying }
 func (_ *Array) ImplementsTypeInfo()     {}
 
+//
+// Map
+//
+
+type Map struct {
+	underlying TypeInfo
+}
+
+func NewMap(underlying TypeInfo) *Map {
+	info := new(Map)
+	info.underlying = underlying
+	return info
+}
+
+func (info *Map) String() string       { return "map[string]" + info.underlying.String() }
+func (info *Map) Underlying() TypeInfo { return info.underlying }
+func (_ *Map) ImplementsTypeInfo()     {}
+
+//
+// Optional
+//
+
+// Optional is a value that can be nil, ie. "?string"
+type Optional struct {
+	underlying TypeInfo
+}
+
+func NewOptional(underlying TypeInfo) *Optional {
+	info := new(Optional)
+	info.underlying = underlying
+	return info
+}
+
+func (info *Optional) String() string       { return "?" + info.underlying.String() }
+func (info *Optional) Underlying() TypeInfo { return info.underlying }
+func (_ *Optional) ImplementsTypeInfo()     {}
+
+//
+// Nil
+//
+
+type Nil struct{}
+
+func (_ *Nil) String() string      { return "nil" }
+func (_ *Nil) ImplementsTypeInfo() {}
+
 //
 // Procedure
 //
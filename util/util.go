@@ -9,3 +9,43 @@ func IsSelfClosingTagName(name string) bool {
 		name == "meta" || name == "param" || name == "source" ||
 		name == "track" || name == "wbr"
 }
+
+func IsRawTextTagName(name string) bool {
+	// Source: https://html.spec.whatwg.org/multipage/parsing.html#serialising-html-fragments
+	return name == "style" || name == "script" ||
+		name == "xmp" || name == "iframe" || name == "noembed" ||
+		name == "noframes" || name == "plaintext"
+}
+
+func IsInlineTagName(name string) bool {
+	// Source: https://html.spec.whatwg.org/multipage/dom.html#phrasing-content
+	switch name {
+	case "a", "abbr", "audio", "b", "bdi", "bdo", "br", "button", "canvas",
+		"cite", "code", "data", "datalist", "del", "dfn", "em", "embed",
+		"i", "iframe", "img", "input", "ins", "kbd", "label", "map", "mark",
+		"meter", "object", "output", "picture", "progress", "q", "ruby",
+		"s", "samp", "select", "small", "span", "strong", "sub", "sup",
+		"svg", "textarea", "time", "u", "var", "video", "wbr":
+		return true
+	}
+	return false
+}
+
+func IsCSSFunctionName(name string) bool {
+	// Source: https://developer.mozilla.org/en-US/docs/Web/CSS/CSS_Functions
+	switch name {
+	case "attr", "calc", "clamp", "counter", "counters", "env", "max", "min", "var",
+		"url", "format", "local", "image-set", "element", "cross-fade",
+		"rgb", "rgba", "hsl", "hsla",
+		"linear-gradient", "radial-gradient", "repeating-linear-gradient", "repeating-radial-gradient", "conic-gradient",
+		"matrix", "matrix3d", "perspective",
+		"rotate", "rotate3d", "rotateX", "rotateY", "rotateZ",
+		"scale", "scale3d", "scaleX", "scaleY", "scaleZ",
+		"skew", "skewX", "skewY",
+		"translate", "translate3d", "translateX", "translateY", "translateZ",
+		"blur", "brightness", "contrast", "drop-shadow", "grayscale", "hue-rotate", "invert", "opacity", "saturate", "sepia",
+		"cubic-bezier", "steps", "minmax", "repeat", "fit-content":
+		return true
+	}
+	return false
+}
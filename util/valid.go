@@ -152,3 +152,8 @@ func IsValidHTML5TagName(tagName string) bool {
 	}
 	return false
 }
+
+// ValidHTML5TagNames returns the tag names accepted by IsValidHTML5TagName
+func ValidHTML5TagNames() []string {
+	return validHtml5Tags
+}
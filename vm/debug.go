@@ -3,8 +3,8 @@ package vm
 import (
 	"bytes"
 	"fmt"
+	"os"
 
-	"github.com/silbinarywolf/compiler-fel/bytecode"
 	"github.com/silbinarywolf/compiler-fel/data"
 )
 
@@ -21,18 +21,6 @@ func (printer *DebugPrinter) writeLine() {
 	}
 }
 
-func debugOpcodes(opcodes []bytecode.Code, offset int) {
-	fmt.Printf("Opcode Debug:\n-----------\n")
-	for i, code := range opcodes {
-		if offset == i {
-			fmt.Printf("**%d** - %s\n", i, code.String())
-			continue
-		}
-		fmt.Printf("%d - %s\n", i, code.String())
-	}
-	fmt.Printf("-----------\n")
-}
-
 func (printer *DebugPrinter) writeValue(value interface{}) {
 	switch value := value.(type) {
 	case *data.HTMLElement:
@@ -69,13 +57,15 @@ func (printer *DebugPrinter) writeValue(value interface{}) {
 	}
 }
 
+// debugPrintStack writes the values on a stack to stderr, it's
+// not called anywhere by default so stdout isn't corrupted.
 func debugPrintStack(message string, stack []interface{}) {
 	printer := new(DebugPrinter)
 	printer.seenPointer = make(map[string]bool)
-	fmt.Printf("----------------\n%s:\n----------------\n", message)
+	fmt.Fprintf(os.Stderr, "----------------\n%s:\n----------------\n", message)
 	defer func() {
-		fmt.Print(printer.String())
-		fmt.Printf("----------------\n")
+		fmt.Fprint(os.Stderr, printer.String())
+		fmt.Fprintf(os.Stderr, "----------------\n")
 	}()
 	for i := 0; i < len(stack); i++ {
 		printer.writeValue(stack[i])
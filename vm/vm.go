@@ -1,32 +1,143 @@
 package vm
 
 import (
+	"bytes"
 	"fmt"
+	"math"
+	"strings"
 
+	"github.com/silbinarywolf/compiler-fel/builtins"
 	"github.com/silbinarywolf/compiler-fel/bytecode"
 	"github.com/silbinarywolf/compiler-fel/data"
+	"github.com/silbinarywolf/compiler-fel/errors"
+	"github.com/silbinarywolf/compiler-fel/token"
 	"github.com/silbinarywolf/compiler-fel/types"
 )
 
+// MaxCallDepth is how many calls can be nested before execution stops,
+// so that infinite recursion is reported as an error.
+const MaxCallDepth = 1000
+
+// maxStackTraceFrames is how many frames are shown from each end of a
+// stack trace, recursive calls can make them very long.
+const maxStackTraceFrames = 10
+
 type Program struct {
 	stack         []interface{}
 	registerStack []interface{}
+	callDepth     int
 
 	//htmlNodeStack   []*data.HTMLElement
 	returnHTMLNodes []*data.HTMLElement // used only in ":: html" blocks / template files
 	//nodeStackContext []interface{}           // stack of node contexts for tracking CSS rules / current HTML node.
 }
 
-func ExecuteNewProgram(codeBlock *bytecode.Block) interface{} {
+// RuntimeError is an error that occurred while executing bytecode
+type RuntimeError struct {
+	Message string
+	Frames  []Frame // blocks being executed, innermost first
+}
+
+// Frame is a block being executed and the offset of its current opcode
+type Frame struct {
+	Block  *bytecode.Block
+	Offset int
+}
+
+// Token is the source position of the opcode being executed
+func (frame *Frame) Token() token.Token {
+	return frame.Block.TokenAt(frame.Offset)
+}
+
+func newRuntimeError(codeBlock *bytecode.Block, offset int, format string, args ...interface{}) *RuntimeError {
+	return &RuntimeError{
+		Message: fmt.Sprintf(format, args...),
+		Frames: []Frame{
+			{
+				Block:  codeBlock,
+				Offset: offset,
+			},
+		},
+	}
+}
+
+// Token is the source position where the error occurred
+func (err *RuntimeError) Token() token.Token {
+	return err.Frames[0].Token()
+}
+
+func (err *RuntimeError) Error() string {
+	return err.Message + "\n" + err.StackTrace()
+}
+
+// StackTrace lists where each block was up to, ie.
+//
+// at Layout (templates/Layout.fel:12)
+// at templates/HomePage.fel (templates/HomePage.fel:4)
+func (err *RuntimeError) StackTrace() string {
+	var buffer bytes.Buffer
+	for i := range err.Frames {
+		if skipped := err.skippedFrames(i); skipped != 0 {
+			if skipped != -1 {
+				fmt.Fprintf(&buffer, "\t... %d more calls\n", skipped)
+			}
+			continue
+		}
+		frame := &err.Frames[i]
+		t := frame.Token()
+		fmt.Fprintf(&buffer, "\tat %s (%s:%d)\n", frame.Block.Name(), t.Filepath, t.Line)
+	}
+	return buffer.String()
+}
+
+// skippedFrames is used to shorten long stack traces to the innermost and
+// outermost frames. It returns how many frames are skipped if i is the
+// first frame skipped, -1 for the other skipped frames or 0 if the frame
+// should be shown.
+func (err *RuntimeError) skippedFrames(i int) int {
+	if len(err.Frames) <= maxStackTraceFrames*2 ||
+		i < maxStackTraceFrames ||
+		i >= len(err.Frames)-maxStackTraceFrames {
+		return 0
+	}
+	if i == maxStackTraceFrames {
+		return len(err.Frames) - maxStackTraceFrames*2
+	}
+	return -1
+}
+
+// Diagnostic reports the error where it occurred, with each call
+// that led to it as a related location.
+func (err *RuntimeError) Diagnostic() *errors.Diagnostic {
+	diagnostic := errors.NewDiagnostic(err.Token(), errors.CodeRuntime, fmt.Errorf("%s", err.Message))
+	for i := 1; i < len(err.Frames); i++ {
+		if skipped := err.skippedFrames(i); skipped != 0 {
+			if skipped != -1 {
+				diagnostic.AddRelated(err.Frames[i].Token(), fmt.Sprintf("... %d more calls", skipped))
+			}
+			continue
+		}
+		callee := &err.Frames[i-1]
+		frame := &err.Frames[i]
+		diagnostic.AddRelated(frame.Token(), fmt.Sprintf("in \"%s\", called from \"%s\"", callee.Block.Name(), frame.Block.Name()))
+	}
+	return diagnostic
+}
+
+func ExecuteNewProgram(codeBlock *bytecode.Block) (interface{}, error) {
 	program := new(Program)
-	program.stack = make([]interface{}, 32)
+	// Calls use the stack after the caller's variables, and grow it if
+	// they need more, so leave some room for them.
+	program.stack = make([]interface{}, codeBlock.StackSize+32)
 	program.registerStack = make([]interface{}, 0, 4)
 
-	program.executeBytecode(codeBlock)
+	if err := program.executeBytecode(codeBlock); err != nil {
+		return nil, err
+	}
 	if codeBlock.HasReturnValue {
-		return program.pop()
+		return program.pop(), nil
 	}
-	return nil
+	return nil, nil
 }
 
 func (program *Program) pop() interface{} {
@@ -35,9 +146,17 @@ func (program *Program) pop() interface{} {
 	return result
 }
 
-func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
+func (program *Program) executeBytecode(codeBlock *bytecode.Block) (err error) {
 	opcodes := codeBlock.Opcodes
 	offset := 0
+	defer func() {
+		// Last resort for bytecode that doesn't match what the VM expects
+		// (ie. a failed type assertion), which is a bug in the emitter.
+		// Report where it happened rather than crash.
+		if r := recover(); r != nil {
+			err = newRuntimeError(codeBlock, offset, "%v", r)
+		}
+	}()
 	for offset < len(opcodes) {
 		code := opcodes[offset]
 
@@ -60,7 +179,7 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 			program.registerStack = append(program.registerStack, value)
 		case bytecode.PushAllocArrayInt:
 			capacity := code.Value.(int)
-			value := make([]int, 0, capacity)
+			value := make([]int64, 0, capacity)
 			program.registerStack = append(program.registerStack, value)
 		case bytecode.PushAllocArrayFloat:
 			capacity := code.Value.(int)
@@ -68,11 +187,35 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 			program.registerStack = append(program.registerStack, value)
 		case bytecode.PushAllocArrayStruct:
 			capacity := code.Value.(int)
-			value := make([]data.Struct, 0, capacity)
+			value := make([]*data.Struct, 0, capacity)
 			program.registerStack = append(program.registerStack, value)
 		case bytecode.PushAllocHTMLFragment:
 			value := data.NewHTMLFragment()
 			program.registerStack = append(program.registerStack, value)
+		case bytecode.PushAllocMap:
+			capacity := code.Value.(int)
+			program.registerStack = append(program.registerStack, data.NewMap(capacity))
+		case bytecode.StorePopMapElement:
+			value := program.registerStack[len(program.registerStack)-1]
+			key := program.registerStack[len(program.registerStack)-2].(string)
+			program.registerStack = program.registerStack[:len(program.registerStack)-2]
+			m := program.registerStack[len(program.registerStack)-1].(*data.Map)
+			m.Set(key, value)
+		case bytecode.PushMapElement:
+			defaultValue := program.registerStack[len(program.registerStack)-3]
+			m := program.registerStack[len(program.registerStack)-2].(*data.Map)
+			key := program.registerStack[len(program.registerStack)-1].(string)
+			program.registerStack = program.registerStack[:len(program.registerStack)-3]
+			value, ok := m.Get(key)
+			if !ok {
+				value = defaultValue
+			}
+			program.registerStack = append(program.registerStack, value)
+		case bytecode.PushMapKey:
+			m := program.registerStack[len(program.registerStack)-2].(*data.Map)
+			index := program.registerStack[len(program.registerStack)-1].(int64)
+			program.registerStack = program.registerStack[:len(program.registerStack)-2]
+			program.registerStack = append(program.registerStack, m.Key(int(index)))
 		//
 		// CSS Structures
 		//
@@ -96,12 +239,20 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 		selector := program.registerStack[len(program.registerStack)-1].(*data.CSSSelector)
 		selector.AddPart(selectorPart)*/
 		case bytecode.PushAllocCSSDefinition:
-			value := code.Value.(*data.CSSDefinition)
+			// Allocate a copy so the bytecode can be executed more than once
+			value := data.NewCSSDefinition(code.Value.(*data.CSSDefinition).Name())
 			program.registerStack = append(program.registerStack, value)
 		case bytecode.PushAllocCSSRule:
-			cssDef := program.registerStack[len(program.registerStack)-1].(*data.CSSDefinition)
-			value := code.Value.(*data.CSSRule)
-			cssDef.AddRule(value)
+			value := data.NewCSSRule(code.Value.(*data.CSSRule).Selectors())
+			switch parent := program.registerStack[len(program.registerStack)-1].(type) {
+			case *data.CSSDefinition:
+				parent.AddRule(value)
+			case *data.CSSRule:
+				// Rule inside an at-rule, ie. "@media"
+				parent.AddRule(value)
+			default:
+				return newRuntimeError(codeBlock, offset, "Cannot add CSS rule to %T.", parent)
+			}
 			program.registerStack = append(program.registerStack, value)
 		case bytecode.AppendCSSPropertyToCSSRule:
 			parentNode := program.registerStack[len(program.registerStack)-2].(*data.CSSRule)
@@ -121,6 +272,122 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 
 			array = append(array, value)
 			program.registerStack[len(program.registerStack)-1] = array
+		case bytecode.AppendPopArrayInt:
+			array := program.registerStack[len(program.registerStack)-2].([]int64)
+			value := program.registerStack[len(program.registerStack)-1].(int64)
+
+			// Pop value
+			program.registerStack = program.registerStack[:len(program.registerStack)-1]
+
+			array = append(array, value)
+			program.registerStack[len(program.registerStack)-1] = array
+		case bytecode.AppendPopArrayFloat:
+			array := program.registerStack[len(program.registerStack)-2].([]float64)
+			value := program.registerStack[len(program.registerStack)-1].(float64)
+
+			// Pop value
+			program.registerStack = program.registerStack[:len(program.registerStack)-1]
+
+			array = append(array, value)
+			program.registerStack[len(program.registerStack)-1] = array
+		case bytecode.AppendPopArrayStruct:
+			array := program.registerStack[len(program.registerStack)-2].([]*data.Struct)
+			value := program.registerStack[len(program.registerStack)-1].(*data.Struct)
+
+			// Pop value
+			program.registerStack = program.registerStack[:len(program.registerStack)-1]
+
+			array = append(array, value)
+			program.registerStack[len(program.registerStack)-1] = array
+		//
+		// Array Iteration
+		//
+		case bytecode.PushArrayLength:
+			array := program.registerStack[len(program.registerStack)-1]
+			length, ok := arrayLength(array)
+			if !ok {
+				return newRuntimeError(codeBlock, offset, "Cannot get length of %T. This should be caught in the typechecker.", array)
+			}
+			program.registerStack[len(program.registerStack)-1] = int64(length)
+		case bytecode.PushArrayElement:
+			array := program.registerStack[len(program.registerStack)-2]
+			index := program.registerStack[len(program.registerStack)-1].(int64)
+			program.registerStack = program.registerStack[:len(program.registerStack)-2]
+			if length, _ := arrayLength(array); index < 0 || index >= int64(length) {
+				return newRuntimeError(codeBlock, offset, "Index %d is out of range for array of length %d.", index, length)
+			}
+
+			var value interface{}
+			switch array := array.(type) {
+			case []string:
+				value = array[index]
+			case []int64:
+				value = array[index]
+			case []float64:
+				value = array[index]
+			case []*data.Struct:
+				value = array[index]
+			case *data.Map:
+				// Iterating over the map, see PushMapKey
+				value, _ = array.Get(array.Key(int(index)))
+			default:
+				return newRuntimeError(codeBlock, offset, "Cannot get element of %T. This should be caught in the typechecker.", array)
+			}
+			program.registerStack = append(program.registerStack, value)
+		case bytecode.PushArraySlice:
+			hasHigh := code.Value.(bool)
+			high := int64(-1)
+			if hasHigh {
+				high = program.registerStack[len(program.registerStack)-1].(int64)
+				program.registerStack = program.registerStack[:len(program.registerStack)-1]
+			}
+			array := program.registerStack[len(program.registerStack)-2]
+			low := program.registerStack[len(program.registerStack)-1].(int64)
+			program.registerStack = program.registerStack[:len(program.registerStack)-2]
+			length, _ := arrayLength(array)
+			if !hasHigh {
+				high = int64(length)
+			}
+			if low < 0 || low > high || high > int64(length) {
+				return newRuntimeError(codeBlock, offset, "Slice [%d:%d] is out of range for array of length %d.", low, high, length)
+			}
+
+			// The capacity is limited to the end of the slice so appending
+			// to it copies rather than overwriting elements of the original.
+			var value interface{}
+			switch array := array.(type) {
+			case []string:
+				value = array[low:high:high]
+			case []int64:
+				value = array[low:high:high]
+			case []float64:
+				value = array[low:high:high]
+			case []*data.Struct:
+				value = array[low:high:high]
+			default:
+				return newRuntimeError(codeBlock, offset, "Cannot slice %T. This should be caught in the typechecker.", array)
+			}
+			program.registerStack = append(program.registerStack, value)
+		case bytecode.StorePopArrayElement:
+			value := program.registerStack[len(program.registerStack)-1]
+			index := program.registerStack[len(program.registerStack)-2].(int64)
+			program.registerStack = program.registerStack[:len(program.registerStack)-2]
+			array := program.registerStack[len(program.registerStack)-1]
+			if length, _ := arrayLength(array); index < 0 || index >= int64(length) {
+				return newRuntimeError(codeBlock, offset, "Index %d is out of range for array of length %d.", index, length)
+			}
+			switch array := array.(type) {
+			case []string:
+				array[index] = value.(string)
+			case []int64:
+				array[index] = value.(int64)
+			case []float64:
+				array[index] = value.(float64)
+			case []*data.Struct:
+				array[index] = value.(*data.Struct)
+			default:
+				return newRuntimeError(codeBlock, offset, "Cannot set element of %T. This should be caught in the typechecker.", array)
+			}
 		case bytecode.PushStackVar:
 			stackOffset := code.Value.(int)
 			program.registerStack = append(program.registerStack, program.stack[stackOffset])
@@ -141,7 +408,7 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 			structData := data.NewStruct(len(structTypeInfo.Fields()), structTypeInfo)
 			program.registerStack = append(program.registerStack, structData)
 		case bytecode.PushAllocInternalStruct:
-			panic("No support, to be removed.")
+			return newRuntimeError(codeBlock, offset, "%s is no longer supported.", kind.String())
 			/*internalType := code.Value.(reflect.Type)
 			structData := reflect.Indirect(reflect.New(internalType)).Interface()
 			program.registerStack = append(program.registerStack, structData)*/
@@ -157,12 +424,43 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 			switch value := value.(type) {
 			case string:
 				program.registerStack[len(program.registerStack)-1] = data.NewHTMLText(value)
+			case data.RawHTML:
+				program.registerStack[len(program.registerStack)-1] = data.NewHTMLRaw(string(value))
 			default:
-				panic(fmt.Sprintf("CastToHTMLText: Cannot convert from %T. This should be caught in the typechecker.", value))
+				return newRuntimeError(codeBlock, offset, "Cannot convert %T to HTML text. This should be caught in the typechecker.", value)
+			}
+		case bytecode.ReplaceCSSClassNames:
+			// Rename classes used by a component to their scoped
+			// name, ie. "header is-active" => "Header__header is-active"
+			classNameMap := code.Value.(map[string]string)
+			value, ok := program.registerStack[len(program.registerStack)-1].(string)
+			if !ok {
+				// ie. nil to remove the class attribute
+				break
 			}
+			classNames := strings.Fields(value)
+			for i, className := range classNames {
+				if scopedClassName, ok := classNameMap[className]; ok {
+					classNames[i] = scopedClassName
+				}
+			}
+			program.registerStack[len(program.registerStack)-1] = strings.Join(classNames, " ")
+		case bytecode.CastToRawHTML:
+			value := program.registerStack[len(program.registerStack)-1]
+			switch value := value.(type) {
+			case string:
+				program.registerStack[len(program.registerStack)-1] = data.RawHTML(value)
+			case data.RawHTML:
+				// no-op
+			default:
+				return newRuntimeError(codeBlock, offset, "Cannot convert %T to rawhtml. This should be caught in the typechecker.", value)
+			}
+		case bytecode.CastToCSSString:
+			value := program.registerStack[len(program.registerStack)-1]
+			program.registerStack[len(program.registerStack)-1] = data.CSSValueString(value)
 		case bytecode.AppendPopHTMLElementToHTMLElement:
 			if len(program.registerStack) < 2 {
-				panic(fmt.Sprintf("offset %d", offset))
+				return newRuntimeError(codeBlock, offset, "Missing parent HTML element to append to.")
 			}
 			parentNode := program.registerStack[len(program.registerStack)-2].(*data.HTMLElement)
 			node := program.registerStack[len(program.registerStack)-1].(*data.HTMLElement)
@@ -170,10 +468,11 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 			switch node.Kind() {
 			case data.HTMLKindElement,
 				data.HTMLKindText,
+				data.HTMLKindRaw,
 				data.HTMLKindFragment:
 				node.SetParent(parentNode)
 			default:
-				panic(fmt.Sprintf("StoreAppendToHTMLElement: Unsupported type %v.", node.Kind()))
+				return newRuntimeError(codeBlock, offset, "Cannot append HTML node of kind %v.", node.Kind())
 			}
 
 			// Pop
@@ -181,12 +480,45 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 		//
 		// Expressions
 		//
-		case bytecode.ConditionalEqual:
-			valueA := program.registerStack[len(program.registerStack)-2].(int64)
-			valueB := program.registerStack[len(program.registerStack)-1].(int64)
+		case bytecode.ConditionalEqual, bytecode.ConditionalNotEqual:
+			// Operands are always the same type (int64, float64, string, bool)
+			// or nil for optionals, so we can rely on interface{} equality.
+			valueA := program.registerStack[len(program.registerStack)-2]
+			valueB := program.registerStack[len(program.registerStack)-1]
+			program.registerStack = program.registerStack[:len(program.registerStack)-2]
+
+			result := valueA == valueB
+			if code.Kind == bytecode.ConditionalNotEqual {
+				result = !result
+			}
+			program.registerStack = append(program.registerStack, result)
+		case bytecode.LessThan, bytecode.GreaterThan, bytecode.LessThanOrEqual, bytecode.GreaterThanOrEqual:
+			valueA := program.registerStack[len(program.registerStack)-2]
+			valueB := program.registerStack[len(program.registerStack)-1]
 			program.registerStack = program.registerStack[:len(program.registerStack)-2]
 
-			program.registerStack = append(program.registerStack, valueA == valueB)
+			cmp, ok := compare(valueA, valueB)
+			if !ok {
+				return newRuntimeError(codeBlock, offset, "Cannot compare %T with %T.", valueA, valueB)
+			}
+			var result bool
+			switch code.Kind {
+			case bytecode.LessThan:
+				result = cmp < 0
+			case bytecode.GreaterThan:
+				result = cmp > 0
+			case bytecode.LessThanOrEqual:
+				result = cmp <= 0
+			case bytecode.GreaterThanOrEqual:
+				result = cmp >= 0
+			}
+			program.registerStack = append(program.registerStack, result)
+		case bytecode.Not:
+			value := program.registerStack[len(program.registerStack)-1].(bool)
+			program.registerStack[len(program.registerStack)-1] = !value
+		case bytecode.Jump:
+			offset = code.Value.(int)
+			continue
 		case bytecode.JumpIfFalse:
 			boolValue := program.registerStack[len(program.registerStack)-1].(bool)
 			program.registerStack = program.registerStack[:len(program.registerStack)-1]
@@ -194,12 +526,108 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 				offset = code.Value.(int)
 				continue
 			}
-		case bytecode.Add:
+		case bytecode.JumpIfFalseOrPop, bytecode.JumpIfTrueOrPop:
+			// Short-circuit evaluation, ie. "a && b" doesn't evaluate "b" if "a" is false
+			boolValue := program.registerStack[len(program.registerStack)-1].(bool)
+			if boolValue == (code.Kind == bytecode.JumpIfTrueOrPop) {
+				offset = code.Value.(int)
+				continue
+			}
+			program.registerStack = program.registerStack[:len(program.registerStack)-1]
+		case bytecode.Add, bytecode.Subtract, bytecode.Multiply, bytecode.Divide, bytecode.Modulo:
 			valueA := program.registerStack[len(program.registerStack)-2].(int64)
 			valueB := program.registerStack[len(program.registerStack)-1].(int64)
 			program.registerStack = program.registerStack[:len(program.registerStack)-2]
 
-			program.registerStack = append(program.registerStack, valueA+valueB)
+			var result int64
+			switch code.Kind {
+			case bytecode.Add:
+				result = valueA + valueB
+			case bytecode.Subtract:
+				result = valueA - valueB
+			case bytecode.Multiply:
+				result = valueA * valueB
+			case bytecode.Divide, bytecode.Modulo:
+				if valueB == 0 {
+					return newRuntimeError(codeBlock, offset, "Integer division by zero.")
+				}
+				result = valueA / valueB
+				if code.Kind == bytecode.Modulo {
+					result = valueA % valueB
+				}
+			}
+			program.registerStack = append(program.registerStack, result)
+		case bytecode.Negate:
+			value := program.registerStack[len(program.registerStack)-1].(int64)
+			program.registerStack[len(program.registerStack)-1] = -value
+		case bytecode.NegateFloat:
+			value := program.registerStack[len(program.registerStack)-1].(float64)
+			program.registerStack[len(program.registerStack)-1] = -value
+		case bytecode.AddFloat, bytecode.SubtractFloat, bytecode.MultiplyFloat, bytecode.DivideFloat, bytecode.ModuloFloat:
+			valueA := program.registerStack[len(program.registerStack)-2].(float64)
+			valueB := program.registerStack[len(program.registerStack)-1].(float64)
+			program.registerStack = program.registerStack[:len(program.registerStack)-2]
+
+			var result float64
+			switch code.Kind {
+			case bytecode.AddFloat:
+				result = valueA + valueB
+			case bytecode.SubtractFloat:
+				result = valueA - valueB
+			case bytecode.MultiplyFloat:
+				result = valueA * valueB
+			case bytecode.DivideFloat:
+				result = valueA / valueB
+			case bytecode.ModuloFloat:
+				result = math.Mod(valueA, valueB)
+			}
+			program.registerStack = append(program.registerStack, result)
+		case bytecode.CSSAdd, bytecode.CSSSubtract, bytecode.CSSMultiply, bytecode.CSSDivide:
+			valueA := program.registerStack[len(program.registerStack)-2]
+			valueB := program.registerStack[len(program.registerStack)-1]
+			program.registerStack = program.registerStack[:len(program.registerStack)-2]
+
+			var operator byte
+			switch code.Kind {
+			case bytecode.CSSAdd:
+				operator = '+'
+			case bytecode.CSSSubtract:
+				operator = '-'
+			case bytecode.CSSMultiply:
+				operator = '*'
+			case bytecode.CSSDivide:
+				operator = '/'
+			}
+			program.registerStack = append(program.registerStack, data.CSSArithmetic(operator, valueA, valueB))
+		case bytecode.CallCSSBuiltin:
+			name := code.Value.(string)
+			switch name {
+			case "darken", "lighten":
+				color := program.registerStack[len(program.registerStack)-2].(data.CSSColor)
+				amount := program.registerStack[len(program.registerStack)-1].(data.CSSLength).Value / 100
+				program.registerStack = program.registerStack[:len(program.registerStack)-2]
+				if name == "darken" {
+					amount = -amount
+				}
+				program.registerStack = append(program.registerStack, color.Lighten(amount))
+			default:
+				return newRuntimeError(codeBlock, offset, "Unknown built-in \"%s\". This should be caught in the typechecker.", name)
+			}
+		case bytecode.CallNative:
+			name := code.Value.(string)
+			procedure, ok := builtins.Lookup(name)
+			if !ok {
+				return newRuntimeError(codeBlock, offset, "Unknown built-in \"%s\". This should be caught in the typechecker.", name)
+			}
+			argumentCount := len(procedure.Parameters)
+			args := make([]interface{}, argumentCount)
+			copy(args, program.registerStack[len(program.registerStack)-argumentCount:])
+			program.registerStack = program.registerStack[:len(program.registerStack)-argumentCount]
+			result, err := procedure.Call(args)
+			if err != nil {
+				return newRuntimeError(codeBlock, offset, "%s", err.Error())
+			}
+			program.registerStack = append(program.registerStack, result)
 		case bytecode.AddString:
 			valueA := program.registerStack[len(program.registerStack)-2].(string)
 			valueB := program.registerStack[len(program.registerStack)-1].(string)
@@ -210,7 +638,7 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 
 			stackOffset := code.Value.(int)
 			if stackOffset >= len(program.stack) {
-				panic(fmt.Sprintf("Array out of bounds on index #%d, At opcode offset #%d", stackOffset, offset))
+				return newRuntimeError(codeBlock, offset, "Stack out of bounds on index #%d.", stackOffset)
 			}
 			program.stack[stackOffset] = value
 		case bytecode.StorePopHTMLAttribute:
@@ -221,26 +649,23 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 			program.registerStack = program.registerStack[:len(program.registerStack)-1]
 
 			// Convert expression result into string for HTML attribute
-			var attrValue string
-			switch attrValueInterface := attrValueInterface.(type) {
+			attrName := code.Value.(string)
+			switch attrValue := attrValueInterface.(type) {
 			case string:
-				attrValue = attrValueInterface
+				node.SetAttribute(attrName, attrValue)
+			case bool:
+				// Boolean attributes are on if they're set at all, so
+				// false removes it rather than outputting disabled="false"
+				if attrValue {
+					node.SetBooleanAttribute(attrName)
+				} else {
+					node.RemoveAttribute(attrName)
+				}
 			case nil:
-				// todo(Jake): 2018-01-16
-				//
-				// For null/nil values, we *probably* want then to mean
-				// that the attribute is no longer set, but we'll see.
-				//
-				//attrName := code.Value.(string)
-				//node.RemoveAttribute(attrName)
-				panic("executeBytecode:StorePopHTMLAttribute: Add logic to handle nil")
-				//continue
+				node.RemoveAttribute(attrName)
 			default:
-				panic(fmt.Sprintf("executeBytecode:StorePopHTMLAttribute: Unhandled attribute type cast for %T", attrValueInterface))
+				return newRuntimeError(codeBlock, offset, "Cannot set attribute \"%s\" to %T.", attrName, attrValue)
 			}
-
-			attrName := code.Value.(string)
-			node.SetAttribute(attrName, attrValue)
 		case bytecode.AppendPopHTMLNodeReturn:
 			value := program.registerStack[len(program.registerStack)-1].(*data.HTMLElement)
 			program.registerStack = program.registerStack[:len(program.registerStack)-1]
@@ -264,7 +689,7 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 			fieldOffset := code.Value.(int)
 			structData.SetField(fieldOffset, fieldData)
 		case bytecode.StoreInternalStructField:
-			panic("No longer supported")
+			return newRuntimeError(codeBlock, offset, "%s is no longer supported.", kind.String())
 			/*fieldData := registerStack[len(registerStack)-1]
 			structData := registerStack[len(registerStack)-2]
 
@@ -282,15 +707,33 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 			// Move the stack ahead of used areas by cutting a new slice
 			// and revert back so the stack can be reclaimed.
 			//
+			block := code.Value.(*bytecode.Block)
+			if program.callDepth >= MaxCallDepth {
+				return newRuntimeError(codeBlock, offset, "Exceeded the maximum call depth of %d calling \"%s\", it may be infinitely recursive.", MaxCallDepth, block.Name())
+			}
 			oldStack := program.stack
 			program.stack = program.stack[codeBlock.StackSize:]
+			if len(program.stack) <= block.StackSize {
+				// Recursive calls can use more than the initial stack,
+				// the caller's values stay in oldStack.
+				program.stack = make([]interface{}, 2*len(oldStack)+block.StackSize)
+			}
 			if value := program.stack[0]; value != nil {
-				debugPrintStack("VM Stack Values", program.stack)
-				panic("bytecode.Call: Stack already has items in it, need to make sure we dont break the stack.")
+				return newRuntimeError(codeBlock, offset, "Stack already has items in it, need to make sure we dont break the stack.")
 			}
 
-			block := code.Value.(*bytecode.Block)
-			program.executeBytecode(block)
+			program.callDepth++
+			err := program.executeBytecode(block)
+			program.callDepth--
+			if err != nil {
+				if err, ok := err.(*RuntimeError); ok {
+					err.Frames = append(err.Frames, Frame{
+						Block:  codeBlock,
+						Offset: offset,
+					})
+				}
+				return err
+			}
 			// Clear for better debuggability
 			for i := 0; i < block.StackSize; i++ {
 				program.stack[i] = nil
@@ -302,13 +745,11 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 			//	program.returnHTMLNodes = append(program.returnHTMLNodes, htmlNodes...)
 			//}
 
-			//debugPrintStack("VM Stack Values", program.stack)
-			//debugPrintStack("VM Register Stack", program.registerStack)
 			//panic("bytecode.Call debug")
 		case bytecode.Return:
-			return
+			return nil
 		default:
-			panic(fmt.Sprintf("executeBytecode: Unhandled kind in vm: \"%s\"", code.Kind.String()))
+			return newRuntimeError(codeBlock, offset, "Unhandled opcode \"%s\".", code.Kind.String())
 		}
 		offset++
 	}
@@ -319,17 +760,62 @@ func (program *Program) executeBytecode(codeBlock *bytecode.Block) {
 	}
 
 	if len(program.registerStack) > expectedRegisterStackSize {
-		debugPrintStack("VM Stack Values", program.stack)
-		debugPrintStack(fmt.Sprintf("VM Register Stack (Size: %d)", len(program.registerStack)), program.registerStack)
-		panic(fmt.Sprintf("Register Stack should have %d items.", expectedRegisterStackSize))
+		return newRuntimeError(codeBlock, offset, "Register Stack should have %d items, instead has %d.", expectedRegisterStackSize, len(program.registerStack))
+	}
+	return nil
+}
+
+// arrayLength gets the length of an array or map, ok is false for other values
+func arrayLength(array interface{}) (length int, ok bool) {
+	switch array := array.(type) {
+	case []string:
+		return len(array), true
+	case []int64:
+		return len(array), true
+	case []float64:
+		return len(array), true
+	case []*data.Struct:
+		return len(array), true
+	case *data.Map:
+		return array.Len(), true
 	}
+	return 0, false
+}
 
-	// Debug
-	/*debugPrintStack("VM Stack Values", program.stack)
-	if len(program.returnHTMLNodes) > 0 {
-		fmt.Printf("Result HTML Nodes:\n")
-		for _, node := range program.returnHTMLNodes {
-			fmt.Printf("- %s\n", node.Debug())
+// compare returns -1, 0 or 1 if a is less than, equal to or greater than b,
+// or false if they can't be compared.
+func compare(a interface{}, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case int64:
+		b, ok := b.(int64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
 		}
-	}*/
+		return 0, true
+	case float64:
+		b, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	}
+	return 0, false
 }
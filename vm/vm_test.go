@@ -0,0 +1,857 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/silbinarywolf/compiler-fel/ast"
+	"github.com/silbinarywolf/compiler-fel/bytecode"
+	"github.com/silbinarywolf/compiler-fel/data"
+	"github.com/silbinarywolf/compiler-fel/emitter"
+	"github.com/silbinarywolf/compiler-fel/parser"
+	"github.com/silbinarywolf/compiler-fel/printer"
+	"github.com/silbinarywolf/compiler-fel/typer"
+)
+
+func TestOperators(t *testing.T) {
+	expected := `<div>precedence parens int float not negative and or string</div>`
+	TemplateCheck(t, `
+		a := 7
+		b := 2
+		f := 1.5
+		zero := 0
+		div {
+			if a + b * 2 == 11 {
+				"precedence "
+			}
+			if (a + b) * 2 == 18 {
+				"parens "
+			}
+			if a / b == 3 && a % b == 1 {
+				"int "
+			}
+			if f * 2 > 2.9 && f - 0.5 <= 1 {
+				"float "
+			}
+			if !(a < b) && a != b {
+				"not "
+			}
+			if -1 < 0 {
+				"negative "
+			}
+			if false && a / zero == 1 {
+				"fail "
+			}
+			if !(false && a / zero == 1) {
+				"and "
+			}
+			if true || a / zero == 1 {
+				"or "
+			}
+			if "abc" < "abd" {
+				"string"
+			}
+		}
+	`, expected)
+}
+
+func TestAssignOperators(t *testing.T) {
+	expected := `<div>int float string unary</div>`
+	TemplateCheck(t, `
+		a := 7
+		a += 3
+		a -= 1
+		a *= 4
+		a /= 6
+		a %= 4
+		f := 1.5
+		f += 1
+		f -= 0.5
+		f *= 3
+		f /= 2
+		f %= 2
+		s := "a"
+		s += "b"
+		div {
+			if a == 2 {
+				"int "
+			}
+			if f == 1 && 7.5 % 2 == 1.5 {
+				"float "
+			}
+			if s == "ab" {
+				"string "
+			}
+			if -a == -2 && -f + 2 == 1 && -(a + 1) == -3 {
+				"unary"
+			}
+		}
+	`, expected)
+}
+
+func TestAssignOperatorTypeError(t *testing.T) {
+	_, typer := typecheckTemplate(t, `
+		isBlue := true
+		isBlue -= false
+		div {
+		}
+	`)
+	diagnostics := typer.Diagnostics()
+	if len(diagnostics) != 1 {
+		typer.PrintErrors()
+		t.Fatalf("Expected 1 type error, instead got %d", len(diagnostics))
+	}
+	if message := diagnostics[0].Message; message != `Cannot use "-" with bool.` {
+		t.Errorf("Unexpected message: %s", message)
+	}
+}
+
+func TestIfElse(t *testing.T) {
+	expected := `<div>two<span>blue</span><b>not active</b>else</div>`
+	TemplateCheck(t, `
+		count := 2
+		isBlue := true
+		isActive := false
+		div {
+			if count == 1 {
+				"one"
+			} else if count == 2 {
+				x := "two"
+				x
+			} else {
+				x := "many"
+				x
+			}
+			span if isBlue && !isActive {
+				"blue"
+			}
+			i if isActive {
+				"active"
+			} else {
+				b {
+					"not active"
+				}
+			}
+			if isActive {
+				"active"
+			}
+			else {
+				"else"
+			}
+		}
+	`, expected)
+}
+
+func TestIfStructField(t *testing.T) {
+	expected := `<div><span>active</span><a class="selected">Home</a><a>About</a></div>`
+	TemplateCheck(t, `
+		Item :: struct {
+			title: string
+			selected: bool
+		}
+		Page :: struct {
+			active: bool
+		}
+		page := Page{active: true}
+		items := []Item{Item{title: "Home", selected: true}, Item{title: "About", selected: false}}
+		div {
+			if page.active {
+				span {
+					"active"
+				}
+			}
+			for item := items {
+				a(class="selected") if item.selected {
+					item.title
+				} else {
+					a {
+						item.title
+					}
+				}
+			}
+		}
+	`, expected)
+}
+
+func TestForLoop(t *testing.T) {
+	expected := `<div><a>a</a><a class="second">b</a><span>small</span><span>big</span><p>First</p><p>Second</p>ax,ay,bx,by,</div>`
+	TemplateCheck(t, `
+		Post :: struct {
+			title: string
+		}
+		names := []string{"a", "b"}
+		numbers := []int{1, 2}
+		posts := []Post{Post{title: "First"}, Post{title: "Second"}}
+		div {
+			for i, name := names {
+				if i == 1 {
+					a(class="second") {
+						name
+					}
+				} else {
+					a {
+						name
+					}
+				}
+			}
+			for number := numbers {
+				span {
+					if number > 1 {
+						"big"
+					} else {
+						"small"
+					}
+				}
+			}
+			for post := posts {
+				p {
+					post.title
+				}
+			}
+			for outer := names {
+				for inner := []string{"x", "y"} {
+					outer + inner + ","
+				}
+			}
+		}
+	`, expected)
+}
+
+func TestForLoopStructField(t *testing.T) {
+	expected := `<ul><li>Home</li><li>About</li><li>Contact</li></ul>`
+	TemplateCheck(t, `
+		Page :: struct {
+			links: []string
+		}
+		page := Page{links: []string{"Home", "About", "Contact"}}
+		ul {
+			for i, link := page.links {
+				// Changing the index shouldn't skip items
+				i += 1
+				li {
+					link
+				}
+			}
+		}
+	`, expected)
+}
+
+func TestForLoopStackSize(t *testing.T) {
+	// Each loop uses hidden variables, so nested loops need
+	// more than a fixed size stack.
+	template := "items := []string{\"a\"}\ndiv {\n"
+	for i := 0; i < 12; i++ {
+		template += "for item := items {\n"
+	}
+	template += "item\n"
+	for i := 0; i < 12; i++ {
+		template += "}\n"
+	}
+	template += "}\n"
+	TemplateCheck(t, template, "<div>a</div>")
+}
+
+func TestRuntimeError(t *testing.T) {
+	_, err := executeTemplate(t, `
+		zero := 0
+		div {
+			if 1 / zero == 1 {
+				"fail"
+			}
+		}
+	`)
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("Expected *RuntimeError, instead got: %v", err)
+	}
+	if runtimeErr.Message != "Integer division by zero." {
+		t.Errorf("Unexpected message: %s", runtimeErr.Message)
+	}
+	if filepath := runtimeErr.Token().Filepath; filepath != "Layout.fel" {
+		t.Errorf("Expected error in Layout.fel, instead got: %s", filepath)
+	}
+}
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	_, err := executeTemplate(t, `
+		Broken :: html {
+			zero := 0
+			div {
+				if 1 / zero == 1 {
+					"fail"
+				}
+			}
+		}
+
+		div {
+			Broken {
+			}
+		}
+	`)
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("Expected *RuntimeError, instead got: %v", err)
+	}
+	if len(runtimeErr.Frames) != 2 {
+		t.Fatalf("Expected 2 frames, instead got:\n%s", runtimeErr.StackTrace())
+	}
+	if name := runtimeErr.Frames[0].Block.Name(); name != "Broken" {
+		t.Errorf("Expected innermost frame to be \"Broken\", instead got: %s", name)
+	}
+	if line := runtimeErr.Token().Line; line != 5 {
+		t.Errorf("Expected error on line 5, instead got: %d", line)
+	}
+	if line := runtimeErr.Frames[1].Token().Line; line != 12 {
+		t.Errorf("Expected call on line 12, instead got: %d", line)
+	}
+}
+
+func TestEmitterError(t *testing.T) {
+	emit, _ := emitTemplate(t, `
+		flags : []bool
+		div {
+			"a"
+		}
+	`)
+	diagnostics := emit.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("Expected 1 emitter error, instead got %d", len(diagnostics))
+	}
+	diagnostic := diagnostics[0]
+	if diagnostic.Message != "Arrays of bool are not supported." {
+		t.Errorf("Unexpected message: %s", diagnostic.Message)
+	}
+	if line := diagnostic.Location.Line; line != 2 {
+		t.Errorf("Expected error on line 2, instead got %d", line)
+	}
+}
+
+func TestRawHTMLImplicitString(t *testing.T) {
+	for _, template := range []string{
+		`
+		Post :: struct {
+			body: rawhtml
+		}
+		post := Post{body: "<b>Bold</b>"}
+		div {
+			post.body
+		}
+		`,
+		`
+		Article :: html {
+			:: struct {
+				body: rawhtml
+			}
+			div {
+				body
+			}
+		}
+		div {
+			Article(body="<b>Bold</b>")
+		}
+		`,
+		`
+		body : rawhtml = "<b>Bold</b>"
+		div {
+			body
+		}
+		`,
+	} {
+		_, typer := typecheckTemplate(t, template)
+		diagnostics := typer.Diagnostics()
+		if len(diagnostics) != 1 {
+			typer.PrintErrors()
+			t.Fatalf("Expected 1 type error, instead got %d", len(diagnostics))
+		}
+		if message := diagnostics[0].Message; message != `Cannot implicitly use string ("<b>Bold</b>") as rawhtml.` {
+			t.Errorf("Unexpected message: %s", message)
+		}
+	}
+}
+
+func TestComponentClassNames(t *testing.T) {
+	expected := `<div><div class="Header__header is-active title"></div><div class="plain"></div></div>`
+	TemplateCheck(t, `
+		Header :: css_config {
+			.is-* {
+				modify: false
+			}
+		}
+		Header :: css {
+			.header {
+				color: green;
+
+				&.is-active {
+					color: blue;
+				}
+			}
+		}
+		Header :: html {
+			div(class="header is-active title") {
+			}
+		}
+		div {
+			Header {
+			}
+			div(class="plain") {
+			}
+		}
+	`, expected)
+}
+
+func TestProcedureCall(t *testing.T) {
+	expected := `<div>$5<span>ababab</span></div>`
+	TemplateCheck(t, `
+		div {
+			formatPrice(amount = "5", currency = "$")
+			logIt("ignored")
+			span {
+				repeat("ab", 3)
+			}
+		}
+
+		repeat :: (text string, count int) string {
+			if count <= 0 {
+				return ""
+			}
+			return text + repeat(text, count - 1)
+		}
+
+		formatPrice :: (currency string, amount string) string {
+			return currency + amount
+		}
+
+		logIt :: (message string) {
+			x := message
+		}
+	`, expected)
+}
+
+func TestProcedureReturn(t *testing.T) {
+	expected := `<ul><li>a</li><li>b</li></ul><span>yes</span>`
+	TemplateCheck(t, `
+		names :: () []string {
+			return []string{"a", "b"}
+		}
+
+		label :: (value bool) string {
+			if value {
+				return "yes"
+			} else {
+				return "no"
+			}
+		}
+
+		div {
+			ul {
+				for name := names() {
+					li {
+						name
+					}
+				}
+			}
+			span {
+				label(true)
+			}
+		}
+	`, "<div>"+expected+"</div>")
+}
+
+func TestProcedureTypeErrors(t *testing.T) {
+	for _, test := range []struct {
+		template string
+		message  string
+	}{
+		{
+			`
+			label :: (value bool) string {
+				if value {
+					return "yes"
+				}
+			}
+			div {
+				label(true)
+			}
+			`,
+			`Missing return at end of "label", expected to return string.`,
+		},
+		{
+			`
+			logIt :: (message string) {
+				x := message
+			}
+			div {
+				x := logIt("a")
+			}
+			`,
+			`Cannot use "logIt" as a value as it doesn't return anything.`,
+		},
+		{
+			`
+			label :: (value bool) string {
+				if value {
+					span {
+						"yes"
+					}
+				}
+				return "no"
+			}
+			div {
+				label(true)
+			}
+			`,
+			`Cannot output HTML in a procedure, return a value instead.`,
+		},
+	} {
+		_, typer := typecheckTemplate(t, test.template)
+		diagnostics := typer.Diagnostics()
+		if len(diagnostics) != 1 {
+			typer.PrintErrors()
+			t.Fatalf("Expected 1 type error, instead got %d", len(diagnostics))
+		}
+		if message := diagnostics[0].Message; message != test.message {
+			t.Errorf("Expected message: %s\nGot: %s", test.message, message)
+		}
+	}
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	_, err := executeTemplate(t, `
+		forever :: (count int) string {
+			return forever(count + 1)
+		}
+
+		div {
+			forever(0)
+		}
+	`)
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("Expected *RuntimeError, instead got: %v", err)
+	}
+	if len(runtimeErr.Frames) != MaxCallDepth+1 {
+		t.Errorf("Expected %d frames, instead got %d", MaxCallDepth+1, len(runtimeErr.Frames))
+	}
+	if lines := strings.Count(runtimeErr.StackTrace(), "\n"); lines != maxStackTraceFrames*2+1 {
+		t.Errorf("Expected stack trace to be shortened to %d lines, instead got %d", maxStackTraceFrames*2+1, lines)
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	expected := `<div>HELLO, WORLD!<span>hello-world</span><span>a,b,c</span><span>cba</span>ok math a/b/c</div>`
+	TemplateCheck(t, `
+		title := "  Hello, World!  "
+		tags := []string{"b", "c", "a"}
+		nums := []int{3, 1, 2}
+		div {
+			upper(trim(title))
+			span {
+				slug(title)
+			}
+			span {
+				join(sort(tags), ",")
+			}
+			span {
+				join(reverse(split("a-b-c", "-")), "")
+			}
+			if len(tags) == 3 && first(nums) == 3 && last(sort(nums)) == 3 && contains(title, "World") {
+				"ok "
+			}
+			if min(2, 5) == 2 && max(1.5, 0.5) == 1.5 && round(2.5) == 3 && floor(2.7) == 2 {
+				"math "
+			}
+			replace("a.b.c", ".", "/")
+		}
+	`, expected)
+}
+
+func TestBuiltinError(t *testing.T) {
+	_, err := executeTemplate(t, `
+		div {
+			first(split("", ","))
+		}
+	`)
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("Expected *RuntimeError, instead got: %v", err)
+	}
+	if runtimeErr.Message != "Cannot call first() on an empty array." {
+		t.Errorf("Unexpected message: %s", runtimeErr.Message)
+	}
+}
+
+func TestMap(t *testing.T) {
+	expected := `<div>house.svg<span></span><a href="home">house.svg</a><a href="about">info.svg</a>deep</div>`
+	TemplateCheck(t, `
+		icons := map[string]string{
+			"home": "house.svg",
+			"about": "info.svg",
+		}
+		nested := map[string]map[string]string{"a": map[string]string{"b": "deep"}}
+		div {
+			icons["home"]
+			span {
+				icons["missing"]
+			}
+			for key, value := icons {
+				a(href=key) {
+					value
+				}
+			}
+			nested["a"]["b"]
+		}
+	`, expected)
+}
+
+func TestMapField(t *testing.T) {
+	expected := `<div>Home-Welcome</div>`
+	TemplateCheck(t, `
+		Post :: struct {
+			meta: map[string]string
+		}
+		post := Post{meta: map[string]string{"title": "Home"}}
+		div {
+			post.meta["title"]
+			post.meta["title"] = "Welcome"
+			"-"
+			post.meta["title"]
+		}
+	`, expected)
+}
+
+func TestArrayIndex(t *testing.T) {
+	expected := `<div>aB,cc,dFirsty</div>`
+	TemplateCheck(t, `
+		Post :: struct {
+			title: string
+			tags: []string
+		}
+		items := []string{"a", "b", "c", "d"}
+		posts := []Post{Post{title: "First", tags: []string{"x", "y"}}}
+		items[1] = "B"
+		div {
+			items[0]
+			join(items[1:3], ",")
+			sub := items[2:]
+			sub []= "z"
+			join(sub[:2], ",")
+			posts[0].title
+			posts[0].tags[len(items) - 3]
+		}
+	`, expected)
+}
+
+func TestArrayIndexField(t *testing.T) {
+	expected := `<div>yz,y</div>`
+	TemplateCheck(t, `
+		Post :: struct {
+			tags: []string
+		}
+		post := Post{tags: []string{"x", "y"}}
+		div {
+			post.tags[1]
+			post.tags[0] = "z"
+			join(post.tags[:], ",")
+		}
+	`, expected)
+}
+
+func TestArrayIndexOutOfRange(t *testing.T) {
+	_, err := executeTemplate(t, `
+		items := []string{"a", "b"}
+		div {
+			items[2]
+		}
+	`)
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("Expected *RuntimeError, instead got: %v", err)
+	}
+	if runtimeErr.Message != "Index 2 is out of range for array of length 2." {
+		t.Errorf("Unexpected message: %s", runtimeErr.Message)
+	}
+	if line := runtimeErr.Token().Line; line != 4 {
+		t.Errorf("Expected error on line 4, instead got %d", line)
+	}
+}
+
+func TestOptional(t *testing.T) {
+	expected := `<div><a class="btn">One</a><a href="/two" disabled class="btn">Two</a><input type="checkbox" checked>no subtitleOther</div>`
+	TemplateCheck(t, `
+		Post :: struct {
+			title: string
+			subtitle: ?string
+		}
+		Button :: html {
+			:: struct {
+				label: string
+				href: ?string
+				disabled := false
+			}
+			a(href=href, disabled=disabled, class="btn") {
+				label
+			}
+		}
+		post := Post{title: "First"}
+		other : ?Post = Post{title: "Other"}
+		div {
+			Button(label="One")
+			Button(label="Two", href="/two", disabled=true)
+			input(type="checkbox", checked=true, readonly=false, title=post.subtitle)
+			subtitle := post.subtitle
+			if subtitle != nil {
+				subtitle
+			} else {
+				"no subtitle"
+			}
+			if other != nil {
+				other.title
+			}
+		}
+	`, expected)
+}
+
+func TestOptionalNarrowing(t *testing.T) {
+	expected := `<div>no title<span>Home</span><b>Home</b>Home</div>`
+	TemplateCheck(t, `
+		title : ?string = nil
+		div {
+			if title == nil {
+				"no title"
+			} else {
+				title
+			}
+			title = "Home"
+			if title == nil {
+				"no title"
+			} else {
+				span {
+					title
+				}
+			}
+			b if title != nil {
+				title
+			}
+			i if title == nil || false {
+			} else {
+				title
+			}
+		}
+	`, expected)
+}
+
+func TestOptionalTypeErrors(t *testing.T) {
+	for _, test := range []struct {
+		template string
+		message  string
+	}{
+		{
+			`
+			title : ?string = nil
+			div {
+				title
+			}
+			`,
+			`Cannot output ?string as HTML as it can be nil.`,
+		},
+		{
+			`
+			title : ?string = nil
+			div {
+				if title != nil || true {
+					title
+				}
+			}
+			`,
+			`Cannot output ?string as HTML as it can be nil.`,
+		},
+		{
+			`
+			title : ?string = nil
+			div {
+				if title == nil && true {
+				} else {
+					title
+				}
+			}
+			`,
+			`Cannot output ?string as HTML as it can be nil.`,
+		},
+		{
+			`
+			title : ?string = "Home"
+			div {
+				if title != nil {
+					title
+					title = nil
+				}
+			}
+			`,
+			`Cannot set "title" to nil here as it was checked against nil.`,
+		},
+	} {
+		_, typer := typecheckTemplate(t, test.template)
+		diagnostics := typer.Diagnostics()
+		if len(diagnostics) != 1 {
+			typer.PrintErrors()
+			t.Fatalf("Expected 1 type error, instead got %d", len(diagnostics))
+		}
+		if message := diagnostics[0].Message; message != test.message {
+			t.Errorf("Expected message: %s\nGot: %s", test.message, message)
+		}
+	}
+}
+
+func TemplateCheck(t *testing.T, template string, expected string) {
+	result, err := executeTemplate(t, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, ok := result.(*data.HTMLElement)
+	if !ok {
+		t.Fatalf("Expected template to return *data.HTMLElement.")
+	}
+	if result := printer.MinifyHTML(node); result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func executeTemplate(t *testing.T, template string) (interface{}, error) {
+	emit, codeBlock := emitTemplate(t, template)
+	if emit.HasErrors() {
+		emit.PrintErrors()
+		t.Fatalf("Stopping due to emitter errors.")
+	}
+	return ExecuteNewProgram(codeBlock)
+}
+
+func emitTemplate(t *testing.T, template string) (*emitter.Emitter, *bytecode.Block) {
+	astFile, typer := typecheckTemplate(t, template)
+	if typer.HasErrors() {
+		typer.PrintErrors()
+		t.Fatalf("Stopping due to type errors.")
+	}
+	astFiles := []*ast.File{astFile}
+	emit := emitter.New()
+	emit.EmitGlobalScope(astFiles)
+	codeBlock := emit.EmitBytecode(astFile, emitter.FileOptions{
+		IsTemplateFile: true,
+	})
+	return emit, codeBlock
+}
+
+func typecheckTemplate(t *testing.T, template string) (*ast.File, *typer.Typer) {
+	p := parser.New()
+	astFile := p.Parse([]byte(template), "Layout.fel")
+	if astFile == nil {
+		t.Fatalf("p.Parse should not return nil.")
+	}
+	if p.HasErrors() {
+		p.PrintErrors()
+		t.Fatalf("Stopping due to scanning/parsing errors.")
+	}
+	astFiles := []*ast.File{astFile}
+	typer := typer.New()
+	typer.ApplyTypeInfoAndTypecheck(astFiles)
+	return astFile, typer
+}